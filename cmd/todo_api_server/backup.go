@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/backup"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+const backupUsage = `usage: todo_api_server backup [flags]
+
+Writes every tenant's todos, and the outbox events not yet published, from the backend DB_BACKEND names to a
+gzipped JSON lines snapshot that 'restore' can load into any backend. Descriptions are written decrypted, so keep
+the snapshot as safe as the database.
+
+flags:
+`
+
+const restoreUsage = `usage: todo_api_server restore [flags]
+
+Checks a snapshot written by 'backup' and loads it into the backend DB_BACKEND names, keeping todo IDs, tenants and
+timestamps. In merge mode todos missing from the snapshot are kept; in replace mode they're deleted. A todo whose
+name another todo in its tenant already has is skipped and reported. The database must be fully migrated first.
+
+flags:
+`
+
+func backupTodos(cfgs *configs.Settings, args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("o", "-", "file to write the snapshot to, or - for stdout")
+	timeout := fs.Duration("timeout", 30*time.Minute, "give up if the backup takes longer than this")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), backupUsage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	mongoDB, db := openForBackup(cfgs)
+	defer mongoDB.Close(context.Background())
+
+	exporter, ok := db.(storage.Exporter)
+	if !ok {
+		log.Fatalf("the %s backend can't be backed up", cfgs.DatabaseBackend)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			log.Fatalf("failed to create snapshot file: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	summary, err := backup.Write(ctx, w, exporter)
+	if err != nil {
+		log.Fatalf("failed to write backup: %v", err)
+	}
+
+	log.Printf("backed up %d todos and %d outbox events; sha256 %s", summary.Todos, summary.Events, summary.SHA256)
+}
+
+func restoreTodos(cfgs *configs.Settings, args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("i", "-", "file to read the snapshot from, or - for stdin")
+	mode := fs.String("mode", string(backup.Merge), "merge or replace")
+	dryRun := fs.Bool("dry-run", false, "only check the snapshot and report what restoring it would change")
+	timeout := fs.Duration("timeout", 30*time.Minute, "give up if the restore takes longer than this")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), restoreUsage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			log.Fatalf("failed to open snapshot file: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	snapshot, err := backup.Read(r)
+	if err != nil {
+		log.Fatalf("failed to read backup: %v", err)
+	}
+	fmt.Printf("read %d todos and %d outbox events backed up at %s\n",
+		len(snapshot.Todos), len(snapshot.Events), snapshot.Header.CreatedAt.Format(time.RFC3339))
+
+	mongoDB, db := openForBackup(cfgs)
+	defer mongoDB.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	statuses, err := mongoDB.MigrationStatus(ctx)
+	if err != nil {
+		log.Fatalf("failed to get migration status: %v", err)
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			log.Fatalf("migration %d (%s) hasn't been applied; run 'todo_api_server migrate' first", status.Version, status.Description)
+		}
+	}
+
+	plan, events, err := backup.Restore(ctx, db, snapshot, backup.Mode(*mode), *dryRun)
+	if err != nil {
+		log.Fatalf("failed to restore backup: %v", err)
+	}
+
+	verb := "restored"
+	if *dryRun {
+		verb = "would restore"
+	}
+	fmt.Printf("%s: %d created, %d updated, %d deleted, %d unchanged, %d outbox events\n",
+		verb, plan.Created, plan.Updated, len(plan.Deletes), plan.Unchanged, events)
+	for _, todo := range plan.Conflicts {
+		fmt.Printf("skipped todo %s: tenant %s already has a todo named %q\n", todo.ID, todo.Tenant, todo.Name)
+	}
+}
+
+// openForBackup connects to MongoDB and builds the configured backend on top of it
+func openForBackup(cfgs *configs.Settings) (*storage.MongoDB, storage.DB) {
+	mongoDB, err := openMongoDB(cfgs)
+	if err != nil {
+		log.Fatalf("failed to connect to DB: %v", err)
+	}
+
+	db, err := openBackend(cfgs, mongoDB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return mongoDB, db
+}
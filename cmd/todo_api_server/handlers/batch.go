@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/todo"
+)
+
+// Batch applies a list of create, update and delete operations in one request and answers 207 Multi-Status with a
+// result per operation, in order: its status and the todo written, or the problem that stopped it. Operations that
+// fail don't stop the others, unless ?atomic=true: then the batch is all-or-nothing, and if any operation fails,
+// nothing is written and the operations that would have succeeded get 424 Failed Dependency.
+func (h TodoListHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+
+	atomic := false
+	if param := r.URL.Query().Get("atomic"); param != "" {
+		var err error
+		if atomic, err = strconv.ParseBool(param); err != nil {
+			writeError(w, r, badRequest(CodeInvalidParameter, "'atomic' must be true or false"))
+			return
+		}
+	}
+
+	umBody := batchRequest{}
+	err := decodeJSON(r, &umBody)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = h.validate.Struct(umBody)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// invalid operations get their validation problem and are left out of the batch
+	results := make([]batchResult, len(umBody.Operations))
+	var ops []todo.BatchOp
+	var indexes []int
+	for i, operation := range umBody.Operations {
+		op, err := h.batchOp(operation)
+		if err != nil {
+			results[i] = batchError(err)
+			continue
+		}
+		ops = append(ops, op)
+		indexes = append(indexes, i)
+	}
+
+	if atomic && len(ops) < len(umBody.Operations) {
+		for _, i := range indexes {
+			results[i] = batchError(todo.ErrBatchAborted)
+		}
+	} else if len(ops) > 0 {
+		written, err := todo.Batch(ctx, h.db, ops, atomic)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		for j, result := range written {
+			i := indexes[j]
+			if result.Err != nil {
+				results[i] = batchError(result.Err)
+				continue
+			}
+
+			status := http.StatusOK
+			if ops[j].Kind == todo.OpCreate {
+				status = http.StatusCreated
+			}
+			results[i] = batchResult{Status: status, Todo: h.todoView(result.Todo)}
+			if ops[j].Kind != todo.OpDelete {
+				results[i].ETag = versionETag(result.Todo.Version)
+			}
+		}
+	}
+
+	data, err := json.Marshal(batchResponse{Results: results})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMultiStatus)
+	_, _ = w.Write(data)
+}
+
+// batchOp validates one operation of a batch like the endpoint that does the same write alone would
+func (h TodoListHandler) batchOp(operation batchOperation) (todo.BatchOp, error) {
+	op := todo.BatchOp{
+		Kind: todo.OpKind(operation.Op),
+		ID:   operation.ID,
+		Todo: todo.Todo{
+			Name:        operation.Name,
+			Description: operation.Description,
+			Completed:   operation.Completed,
+			Version:     operation.Version,
+		},
+	}
+
+	var err error
+	switch op.Kind {
+	case todo.OpCreate:
+		err = h.validate.Struct(createRequest{Name: operation.Name, Description: operation.Description})
+	case todo.OpUpdate:
+		err = h.validate.Struct(batchUpdate{ID: operation.ID, editRequest: editRequest{
+			Name:        operation.Name,
+			Description: operation.Description,
+		}, Completed: operation.Completed})
+	case todo.OpDelete:
+		err = h.validate.Struct(batchDelete{ID: operation.ID})
+	default:
+		err = badRequest(CodeValidationFailed, "'op' must be one of create, update and delete")
+	}
+
+	return op, err
+}
+
+// batchError is the result of an operation that failed. Unlike a response's problem, it has no instance or request
+// ID: those belong to the batch.
+func batchError(err error) batchResult {
+	p := problemFor(err)
+	if errors.Is(err, storage.ErrVersionMismatch) {
+		p.Detail = "the todo has changed since the version in 'version'"
+	}
+	return batchResult{Status: p.Status, Error: &p}
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func TestTodoListHandler_Batch(t *testing.T) {
+	testData := []struct {
+		testName         string
+		query            string
+		body             string
+		noTransactions   bool
+		expectedStatus   int
+		expectedCode     string
+		expectedStatuses []int
+		expectedCodes    []string
+		expectedTodos    int
+	}{
+		{
+			testName: "partial",
+			body: `{"operations": [
+				{"op": "create", "name": "dentist"},
+				{"op": "create", "name": "shopping"},
+				{"op": "update", "id": "%s", "name": "groceries", "description": "get milk", "version": 1},
+				{"op": "update", "id": "%s", "name": "groceries", "description": "get eggs", "version": 1},
+				{"op": "delete", "id": "missing"},
+				{"op": "create", "name": ""},
+				{"op": "archive", "id": "%s"}
+			]}`,
+			expectedStatus:   207,
+			expectedStatuses: []int{201, 409, 200, 412, 404, 400, 400},
+			expectedCodes:    []string{"", CodeAlreadyExists, "", CodePreconditionFailed, CodeNotFound, CodeValidationFailed, CodeValidationFailed},
+			expectedTodos:    2,
+		},
+		{
+			testName: "atomic",
+			query:    "?atomic=true",
+			body: `{"operations": [
+				{"op": "create", "name": "dentist"},
+				{"op": "delete", "id": "%s"},
+				{"op": "delete", "id": "%s"}
+			]}`,
+			expectedStatus:   207,
+			expectedStatuses: []int{424, 424, 404},
+			expectedCodes:    []string{CodeBatchAborted, CodeBatchAborted, CodeNotFound},
+			expectedTodos:    1,
+		},
+		{
+			testName: "atomic with an invalid operation",
+			query:    "?atomic=true",
+			body: `{"operations": [
+				{"op": "create", "name": "dentist"},
+				{"op": "update", "id": "%s", "name": "groceries"}
+			]}`,
+			expectedStatus:   207,
+			expectedStatuses: []int{424, 400},
+			expectedCodes:    []string{CodeBatchAborted, CodeValidationFailed},
+			expectedTodos:    1,
+		},
+		{
+			testName:         "atomic all succeed",
+			query:            "?atomic=true",
+			body:             `{"operations": [{"op": "create", "name": "dentist"}, {"op": "delete", "id": "%s"}]}`,
+			expectedStatus:   207,
+			expectedStatuses: []int{201, 200},
+			expectedCodes:    []string{"", ""},
+			expectedTodos:    1,
+		},
+		{
+			testName:       "failure: no operations",
+			body:           `{"operations": []}`,
+			expectedStatus: 400,
+			expectedTodos:  1,
+		},
+		{
+			testName:       "failure: atomic without transactions",
+			query:          "?atomic=true",
+			body:           `{"operations": [{"op": "create", "name": "dentist"}]}`,
+			noTransactions: true,
+			expectedStatus: 422,
+			expectedCode:   CodeAtomicUnsupported,
+			expectedTodos:  1,
+		},
+		{
+			testName:         "not atomic without transactions",
+			body:             `{"operations": [{"op": "create", "name": "dentist"}]}`,
+			noTransactions:   true,
+			expectedStatus:   207,
+			expectedStatuses: []int{201},
+			expectedCodes:    []string{""},
+			expectedTodos:    2,
+		},
+		{
+			testName:       "failure: invalid atomic",
+			query:          "?atomic=maybe",
+			body:           `{"operations": [{"op": "create", "name": "dentist"}]}`,
+			expectedStatus: 400,
+			expectedTodos:  1,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			db := storage.NewInMemoryDB()
+			var backend storage.DB = db
+			if td.noTransactions {
+				// like a standalone MongoDB server: no transactions, but an outbox
+				backend = struct {
+					storage.DB
+					storage.Outbox
+				}{db, db}
+			}
+			router := NewRouter(NewTodoListHandler(&configs.Settings{DatabaseCxnTimeoutSeconds: 5}, backend, tenant.StaticResolver(tenant.Default)))
+			ctx := tenant.WithID(context.Background(), tenant.Default)
+			shopping, _ := db.SaveTodo(ctx, "shopping", "")
+
+			body := strings.ReplaceAll(td.body, "%s", shopping.ID)
+			req := httptest.NewRequest("POST", "/todos:batch"+td.query, strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != td.expectedStatus {
+				t.Fatalf("expected status %d; got %d: %s", td.expectedStatus, rec.Code, rec.Body)
+			}
+			if list, _ := db.GetTodoList(ctx); len(list) != td.expectedTodos {
+				t.Errorf("expected %d todos; got %+v", td.expectedTodos, list)
+			}
+			if td.expectedCode != "" {
+				var p Problem
+				if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil || p.Code != td.expectedCode {
+					t.Errorf("expected a problem with code %s; got %s", td.expectedCode, rec.Body)
+				}
+			}
+			if td.expectedStatus != 207 {
+				return
+			}
+
+			var resp batchResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response %s: %v", rec.Body, err)
+			}
+			var statuses []int
+			var codes []string
+			for _, result := range resp.Results {
+				statuses = append(statuses, result.Status)
+				code := ""
+				if result.Error != nil {
+					code = result.Error.Code
+				} else if result.Todo == nil {
+					t.Errorf("expected a todo for status %d", result.Status)
+				}
+				codes = append(codes, code)
+			}
+			if diff := cmp.Diff(td.expectedStatuses, statuses); diff != "" {
+				t.Errorf("expected vs actual statuses don't match: %v", diff)
+			}
+			if diff := cmp.Diff(td.expectedCodes, codes); diff != "" {
+				t.Errorf("expected vs actual codes don't match: %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/todo"
+)
+
+// versionETag is the ETag of a todo: its version, which every write to it changes
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// contentETag is the ETag of a response that isn't a single todo, e.g. a page of the list: a hash of its body
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ifMatch turns the request's If-Match header into the precondition its write must meet: the todo's version must be
+// one of the listed ETags. Without the header, or with *, any version will do, since the todo has to exist anyway.
+func ifMatch(r *http.Request) todo.Precondition {
+	etags, any := parseETags(r.Header.Get("If-Match"))
+	if any {
+		return nil
+	}
+
+	return func(version int64) bool {
+		current := versionETag(version)
+		for _, etag := range etags {
+			// If-Match compares strongly, so a weak ETag never matches
+			if etag == current {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// notModified reports whether the request's If-None-Match header lists etag, in which case the client already has
+// the current representation. If-None-Match compares weakly.
+func notModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	etags, any := parseETags(header)
+	if any {
+		return true
+	}
+	for _, listed := range etags {
+		if strings.TrimPrefix(listed, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// formatETag is the ETag of f's representation of the response whose JSON one has etag, e.g. "3-csv" for "3"
+func formatETag(etag string, f format) string {
+	if f.etagSuffix == "" || !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + f.etagSuffix + `"`
+}
+
+// splitFormatETag undoes formatETag, returning the JSON ETag and the suffix of the format it was for, or "" for JSON
+func splitFormatETag(etag string) (string, string) {
+	for _, f := range formats {
+		if f.etagSuffix != "" && strings.HasSuffix(etag, "-"+f.etagSuffix+`"`) {
+			return strings.TrimSuffix(etag, "-"+f.etagSuffix+`"`) + `"`, f.etagSuffix
+		}
+	}
+	return etag, ""
+}
+
+// toHandlerETags rewrites the conditional headers of a request for a response in f to the JSON ETags the handlers
+// deal in. If-None-Match only keeps the ETags of f's representation: the client doesn't have the others. If-Match
+// keeps them all, since any representation's ETag names the version of the todo it was read at.
+func toHandlerETags(r *http.Request, f format) {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		etags, any := parseETags(header)
+		if !any {
+			var kept []string
+			for _, etag := range etags {
+				if base, suffix := splitFormatETag(etag); suffix == f.etagSuffix {
+					kept = append(kept, base)
+				}
+			}
+			// none left means the client has no representation in f, which a tag nothing matches says
+			if len(kept) == 0 {
+				kept = []string{`"-"`}
+			}
+			r.Header.Set("If-None-Match", strings.Join(kept, ", "))
+		}
+	}
+
+	if header := r.Header.Get("If-Match"); header != "" {
+		etags, any := parseETags(header)
+		if !any {
+			for i, etag := range etags {
+				etags[i], _ = splitFormatETag(etag)
+			}
+			r.Header.Set("If-Match", strings.Join(etags, ", "))
+		}
+	}
+}
+
+// parseETags splits a comma-separated list of ETags; any is true if the header is missing or *
+func parseETags(header string) (etags []string, any bool) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return nil, true
+	}
+
+	for _, etag := range strings.Split(header, ",") {
+		if etag = strings.TrimSpace(etag); etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+	return etags, false
+}
+
+// writeNotModified answers a conditional GET whose ETag the client already has
+func writeNotModified(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+}
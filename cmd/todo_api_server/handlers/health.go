@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Healthz is the liveness probe: it only reports that the server is up and serving requests
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// Drain marks the server as shutting down: from then on Readyz reports it unavailable, so the load balancer stops
+// sending it requests before it stops accepting them
+func (h TodoListHandler) Drain() {
+	atomic.StoreInt32(h.draining, 1)
+}
+
+// Readyz is the readiness probe: it reports unavailable while the server is draining or the DB can't be reached
+func (h TodoListHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(h.draining) == 1 {
+		writeHealth(w, http.StatusServiceUnavailable, healthResponse{Status: "draining"})
+		return
+	}
+
+	pinger, ok := h.db.(storage.Pinger)
+	if !ok {
+		writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+		return
+	}
+
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+
+	if err := pinger.Ping(ctx); err != nil {
+		log.Printf("readiness check failed: %v", err)
+		writeHealth(w, http.StatusServiceUnavailable, healthResponse{Status: "unavailable", Error: "database unreachable"})
+		return
+	}
+
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+func writeHealth(w http.ResponseWriter, status int, resp healthResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+// pingerDB is a DB whose Ping answers err
+type pingerDB struct {
+	*storage.InMemoryDB
+	err error
+}
+
+func (db pingerDB) Ping(ctx context.Context) error {
+	return db.err
+}
+
+func TestHealthProbes(t *testing.T) {
+	testData := []struct {
+		testName          string
+		pingErr           error
+		draining          bool
+		expectedReadiness int
+		expectedResponse  healthResponse
+	}{
+		{
+			testName:          "healthy",
+			expectedReadiness: 200,
+			expectedResponse:  healthResponse{Status: "ok"},
+		},
+		{
+			testName:          "database unreachable",
+			pingErr:           errors.New("server selection timeout"),
+			expectedReadiness: 503,
+			expectedResponse:  healthResponse{Status: "unavailable", Error: "database unreachable"},
+		},
+		{
+			testName:          "draining",
+			draining:          true,
+			expectedReadiness: 503,
+			expectedResponse:  healthResponse{Status: "draining"},
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			db := pingerDB{InMemoryDB: storage.NewInMemoryDB(), err: td.pingErr}
+			h := NewTodoListHandler(&configs.Settings{DatabaseCxnTimeoutSeconds: 5}, db, tenant.StaticResolver(tenant.Default))
+			router := NewRouter(h)
+			if td.draining {
+				h.Drain()
+			}
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+			if rec.Code != td.expectedReadiness {
+				t.Errorf("/readyz expected status %d; got %d", td.expectedReadiness, rec.Code)
+			}
+			var actual healthResponse
+			_ = json.Unmarshal(rec.Body.Bytes(), &actual)
+			if diff := cmp.Diff(td.expectedResponse, actual); diff != "" {
+				t.Errorf("/readyz expected vs actual response don't match: %v", diff)
+			}
+
+			// liveness doesn't depend on the database or on draining: restarting wouldn't fix either
+			rec = httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+			if rec.Code != 200 {
+				t.Errorf("/healthz expected status 200; got %d", rec.Code)
+			}
+		})
+	}
+}
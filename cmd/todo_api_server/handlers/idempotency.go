@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+// IdempotencyKeyHeader lets a client retry a request safely: retries with the same key get the first response again
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLen bounds the keys clients send, which are stored until they expire
+const maxIdempotencyKeyLen = 255
+
+// replayedHeaders are the response headers replayed with a stored response; the rest, like the request ID, belong to
+// the retry
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// Idempotent makes next safe to retry with an Idempotency-Key header. The first request with a key runs next and its
+// response is stored for the tenant until IDEMPOTENCY_TTL passes; retries get that response again, marked with an
+// Idempotent-Replayed header. A key can't be reused for a different request, nor while its first request is still
+// running. Server errors aren't stored, so a retry runs next again. Requests without a key, and backends that can't
+// store keys, go straight to next.
+func (h TodoListHandler) Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		store, ok := h.db.(storage.IdempotencyStore)
+		if key == "" || !ok {
+			next(w, r)
+			return
+		}
+		if !validToken(key, maxIdempotencyKeyLen) {
+			writeError(w, r, badRequest(CodeInvalidParameter, "'%s' must be up to %d printable characters without spaces", IdempotencyKeyHeader, maxIdempotencyKeyLen))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeError(w, r, badRequest(CodeMalformedJSON, "failed to read the request body"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx, cancel := h.dbContext(r)
+		defer cancel()
+
+		createdAt := time.Now().UTC()
+		record, claimed, err := store.ClaimIdempotencyKey(ctx, storage.IdempotencyRecord{
+			Key:         key,
+			RequestHash: requestHash(r, body),
+			CreatedAt:   createdAt,
+			ExpiresAt:   createdAt.Add(h.idempotencyTTL),
+		})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		if !claimed {
+			switch {
+			case record.RequestHash != requestHash(r, body):
+				writeError(w, r, &apiError{
+					status: http.StatusUnprocessableEntity,
+					code:   CodeIdempotencyKeyReused,
+					detail: "this Idempotency-Key was already used for a different request",
+				})
+			case record.Response == nil:
+				w.Header().Set("Retry-After", "1")
+				writeError(w, r, &apiError{
+					status: http.StatusConflict,
+					code:   CodeRequestInProgress,
+					detail: "the first request with this Idempotency-Key is still being processed",
+				})
+			default:
+				replay(w, *record.Response)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		// the request's own context may be done by now, but the key still has to be completed or released
+		ctx, cancel = h.dbContext(r.WithContext(detach(r.Context())))
+		defer cancel()
+
+		if rec.status >= http.StatusInternalServerError {
+			err = store.ReleaseIdempotencyKey(ctx, key)
+		} else {
+			err = store.CompleteIdempotencyKey(ctx, key, rec.response())
+		}
+		if err != nil {
+			log.Printf("request %s: failed to store the response for idempotency key %q: %v", RequestID(r.Context()), key, err)
+		}
+	}
+}
+
+// detachedContext keeps the values of a context, like its tenant, but not its deadline or cancellation
+type detachedContext struct {
+	context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// requestHash identifies a request by its method, path and body, so a retry can be told apart from a different
+// request reusing a key
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	_, _ = h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, response storage.IdempotentResponse) {
+	for name, values := range response.Header {
+		w.Header().Del(name)
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(response.Status)
+	_, _ = w.Write(response.Body)
+}
+
+// responseRecorder passes a response through to the client while keeping a copy to store
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+func (rec *responseRecorder) response() storage.IdempotentResponse {
+	header := map[string][]string{}
+	for _, name := range replayedHeaders {
+		if values := rec.Header().Values(name); len(values) > 0 {
+			header[name] = values
+		}
+	}
+
+	return storage.IdempotentResponse{
+		Status: rec.status,
+		Header: header,
+		Body:   rec.body.Bytes(),
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func TestTodoListHandler_Idempotent(t *testing.T) {
+	db := storage.NewInMemoryDB()
+	router := NewRouter(NewTodoListHandler(&configs.Settings{DatabaseCxnTimeoutSeconds: 5, IdempotencyTTLSeconds: 60}, db, tenant.StaticResolver(tenant.Default)))
+
+	send := func(body, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/todo", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := send(`{"name": "shopping"}`, "k-1")
+	if first.Code != 200 {
+		t.Fatalf("expected status 200; got %d: %s", first.Code, first.Body)
+	}
+
+	testData := []struct {
+		testName         string
+		body             string
+		key              string
+		expectedStatus   int
+		expectedCode     string
+		expectedReplayed bool
+	}{
+		{
+			testName:         "retry",
+			body:             `{"name": "shopping"}`,
+			key:              "k-1",
+			expectedStatus:   200,
+			expectedReplayed: true,
+		},
+		{
+			testName:       "failure: key reused for a different body",
+			body:           `{"name": "wash car"}`,
+			key:            "k-1",
+			expectedStatus: 422,
+			expectedCode:   CodeIdempotencyKeyReused,
+		},
+		{
+			testName:       "failure: invalid key",
+			body:           `{"name": "wash car"}`,
+			key:            "two words",
+			expectedStatus: 400,
+			expectedCode:   CodeInvalidParameter,
+		},
+		{
+			testName:       "failure: retry without a key",
+			body:           `{"name": "shopping"}`,
+			expectedStatus: 409,
+			expectedCode:   CodeAlreadyExists,
+		},
+		{
+			testName:       "new key",
+			body:           `{"name": "wash car"}`,
+			key:            "k-2",
+			expectedStatus: 200,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			rec := send(td.body, td.key)
+			if rec.Code != td.expectedStatus {
+				t.Fatalf("expected status %d; got %d: %s", td.expectedStatus, rec.Code, rec.Body)
+			}
+			if td.expectedCode != "" && !strings.Contains(rec.Body.String(), `"code":"`+td.expectedCode+`"`) {
+				t.Errorf("expected problem code %q; got %s", td.expectedCode, rec.Body)
+			}
+
+			replayed := rec.Header().Get("Idempotent-Replayed") == "true"
+			if replayed != td.expectedReplayed {
+				t.Errorf("expected Idempotent-Replayed %v; got %v", td.expectedReplayed, replayed)
+			}
+			if td.expectedReplayed {
+				if rec.Body.String() != first.Body.String() || rec.Header().Get("ETag") != first.Header().Get("ETag") {
+					t.Errorf("expected the first response %s, ETag %q; got %s, ETag %q", first.Body, first.Header().Get("ETag"), rec.Body, rec.Header().Get("ETag"))
+				}
+			}
+		})
+	}
+
+	// the retry replayed the first response rather than saving the todo again
+	todos, _ := db.GetTodoList(tenant.WithID(context.Background(), tenant.Default))
+	if len(todos) != 2 {
+		t.Errorf("expected 2 todos; got %d", len(todos))
+	}
+}
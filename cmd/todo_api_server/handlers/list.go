@@ -4,41 +4,111 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
 	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
 	"github.com/us-learn-and-devops/todoapi/internal/domain/todo"
 	"gopkg.in/go-playground/validator.v9"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type TodoListHandler struct {
-	db       storage.DB
-	validate *validator.Validate
+	db             storage.DB
+	tenants        tenant.Resolver
+	validate       *validator.Validate
+	dbTimeout      time.Duration
+	idempotencyTTL time.Duration
+	legacySunset   time.Time
+	// validateResponses checks responses against the OpenAPI document too, which is for tests
+	validateResponses bool
+	// apiVersion picks the response models: NewRouter serves each version of the API with its own copy of the handler
+	apiVersion int
+	// draining is set, atomically, once the server is shutting down; the copies of the handler share it
+	draining *int32
 }
 
-func NewTodoListHandler(cfgs *configs.Settings, dbCreds DBCredentials) (TodoListHandler, error) {
-	timeout := time.Duration(cfgs.DatabaseCxnTimeoutSeconds) * time.Second
+func NewTodoListHandler(cfgs *configs.Settings, db storage.DB, tenants tenant.Resolver) TodoListHandler {
+	return TodoListHandler{
+		db:                db,
+		tenants:           tenants,
+		validate:          newValidator(),
+		dbTimeout:         time.Duration(cfgs.DatabaseCxnTimeoutSeconds) * time.Second,
+		idempotencyTTL:    time.Duration(cfgs.IdempotencyTTLSeconds) * time.Second,
+		legacySunset:      parseSunset(cfgs.LegacyRoutesSunset),
+		validateResponses: cfgs.ValidateResponses,
+		apiVersion:        1,
+		draining:          new(int32),
+	}
+}
 
-	db, err := storage.NewMongoDB(
-		cfgs.DatabaseHostName,
-		cfgs.DatabaseDBName,
-		cfgs.DatabaseTodosCollection,
-		dbCreds.Username,
-		dbCreds.Password,
-		timeout,
-	)
+// newValidator reports the JSON names of the fields that fail validation, which is what clients know them by
+func newValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return validate
+}
 
-	if err != nil {
-		return TodoListHandler{}, err
+// Close releases the handler's DB connection, if the backend holds one
+func (h TodoListHandler) Close(ctx context.Context) error {
+	if closer, ok := h.db.(storage.Closer); ok {
+		return closer.Close(ctx)
 	}
+	return nil
+}
 
-	return TodoListHandler{
-		db:       db,
-		validate: validator.New(),
+// dbContext derives a context for DB calls that ends when the request is cancelled or the DB timeout expires
+func (h TodoListHandler) dbContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), h.dbTimeout)
+}
+
+// NewMongoConfig translates the app settings and DB credentials into a storage.MongoConfig
+func NewMongoConfig(cfgs *configs.Settings, dbCreds DBCredentials) (storage.MongoConfig, error) {
+	if cfgs.DatabaseMaxPoolSize < 0 || cfgs.DatabaseMinPoolSize < 0 {
+		return storage.MongoConfig{}, fmt.Errorf("DB pool sizes must not be negative; got max %d, min %d", cfgs.DatabaseMaxPoolSize, cfgs.DatabaseMinPoolSize)
+	}
+
+	var compressors []string
+	for _, compressor := range strings.Split(cfgs.DatabaseCompressors, ",") {
+		if compressor = strings.TrimSpace(compressor); compressor != "" {
+			compressors = append(compressors, compressor)
+		}
+	}
+
+	return storage.MongoConfig{
+		URI:                  cfgs.DatabaseURI,
+		Scheme:               cfgs.DatabaseScheme,
+		HostName:             cfgs.DatabaseHostName,
+		DatabaseName:         cfgs.DatabaseDBName,
+		CollectionName:       cfgs.DatabaseTodosCollection,
+		MigrationsCollection: cfgs.DatabaseMigrationsCollection,
+		UserName:             dbCreds.Username,
+		Password:             dbCreds.Password,
+		AuthSource:           cfgs.DatabaseAuthSource,
+		ReplicaSet:           cfgs.DatabaseReplicaSet,
+		ReadPreference:       cfgs.DatabaseReadPreference,
+		AppName:              cfgs.DatabaseAppName,
+		Compressors:          compressors,
+		MaxPoolSize:          uint64(cfgs.DatabaseMaxPoolSize),
+		MinPoolSize:          uint64(cfgs.DatabaseMinPoolSize),
+		TLSCAFile:            cfgs.DatabaseTLSCAFilePath,
+		TLSCertKeyFile:       cfgs.DatabaseTLSCertKeyPath,
+		Timeout:              time.Duration(cfgs.DatabaseCxnTimeoutSeconds) * time.Second,
 	}, nil
 }
 
@@ -46,31 +116,24 @@ func (h TodoListHandler) EchoPost(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	defer r.Body.Close()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	_, err = w.Write(body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	return
 }
 
 func (h TodoListHandler) EchoPut(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	defer r.Body.Close()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	umBody := echoRequest{}
-	err = json.Unmarshal(body, &umBody)
+	err := decodeJSON(r, &umBody)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -84,205 +147,455 @@ func (h TodoListHandler) EchoPut(w http.ResponseWriter, r *http.Request) {
 
 	data, err := json.Marshal(echoMsg)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	_, err = w.Write(data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	return
 }
 
 func (h TodoListHandler) Create(w http.ResponseWriter, r *http.Request) {
-	ctx := context.TODO()
-
-	body, err := io.ReadAll(r.Body)
-	defer r.Body.Close()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
 
 	umBody := createRequest{}
-	err = json.Unmarshal(body, &umBody)
+	err := decodeJSON(r, &umBody)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	err = h.validate.Struct(umBody)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
 	created, err := todo.Save(ctx, h.db, umBody.Name, umBody.Description)
 	if err != nil {
-		if errors.Is(err, storage.ErrAlreadyInList) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	data, err := json.Marshal(createResponse{
-		ID:          created.ID,
-		Name:        created.Name,
-		Description: created.Description,
-	})
+	data, err := json.Marshal(h.todoView(created))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(created.Version))
+	if h.apiVersion >= 2 {
+		w.Header().Set("Location", "/v2/todos/"+url.PathEscape(created.ID))
+		w.WriteHeader(http.StatusCreated)
+	}
 	_, err = w.Write(data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	return
 }
 
+// GetAll returns one page of the list, optionally filtered with ?q= (see the query package for the language). Clients
+// page through it with ?limit= and by following the "next" link, which carries an opaque cursor, until a response comes
+// back without one.
 func (h TodoListHandler) GetAll(w http.ResponseWriter, r *http.Request) {
-	ctx := context.TODO()
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+
+	params := r.URL.Query()
 
-	list, err := todo.GetAll(ctx, h.db)
+	limit, err := parseLimit(params, storage.MaxPageLimit)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
 
-	var resp getAllResponse
-	for _, item := range list {
-		resp.List = append(resp.List, Todo{
-			ID:          item.ID,
-			Name:        item.Name,
-			Description: item.Description,
-		})
+	page, err := todo.List(ctx, h.db, params.Get("q"), limit, params.Get("cursor"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var nextURI string
+	if page.NextCursor != "" {
+		next := *r.URL
+		nextQuery := next.Query()
+		nextQuery.Set("cursor", page.NextCursor)
+		next.RawQuery = nextQuery.Encode()
+		nextURI = next.RequestURI()
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURI))
+	}
+
+	data, err := json.Marshal(h.listView(page.Todos, nextURI))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	etag := contentETag(data)
+	if notModified(r, etag) {
+		writeNotModified(w, etag)
+		return
 	}
 
-	data, err := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	_, err = w.Write(data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+	}
+}
+
+// Get returns the todo with ID {id}, or 304 Not Modified if the client sends its current ETag in If-None-Match
+func (h TodoListHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+
+	found, err := todo.GetByID(ctx, h.db, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	etag := versionETag(found.Version)
+	if notModified(r, etag) {
+		writeNotModified(w, etag)
+		return
+	}
+
+	data, err := json.Marshal(h.todoView(found))
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
 	_, err = w.Write(data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 	}
 }
 
-func (h TodoListHandler) Edit(w http.ResponseWriter, r *http.Request) {
-	ctx := context.TODO()
+// Search returns the todos whose name or description contains any word of ?q=, best matches first, each with
+// highlighted snippets of the fields that matched. At most ?limit= results are returned; there are no further pages.
+func (h TodoListHandler) Search(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
 
-	vars := mux.Vars(r)
-	todoName, err := url.QueryUnescape(vars["name"])
+	params := r.URL.Query()
+
+	q := params.Get("q")
+	if strings.TrimSpace(q) == "" {
+		writeError(w, r, badRequest(CodeInvalidParameter, "missing 'q' parameter in request url"))
+		return
+	}
+
+	limit, err := parseLimit(params, storage.MaxSearchLimit)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	if todoName == "" {
-		http.Error(w, "missing 'name' parameter in request url", http.StatusBadRequest)
+	results, err := todo.Search(ctx, h.db, q, limit)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
-	defer r.Body.Close()
+	data, err := json.Marshal(h.searchView(results))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		writeError(w, r, err)
+	}
+}
+
+// Edit replaces the todo named {name}, or in v2 the one with ID {id}
+func (h TodoListHandler) Edit(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+
+	id, byID := mux.Vars(r)["id"]
+	var todoName string
+	var err error
+	if !byID {
+		if todoName, err = pathName(r); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
 	umBody := editRequest{}
-	err = json.Unmarshal(body, &umBody)
+	err = decodeJSON(r, &umBody)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	err = h.validate.Struct(umBody)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	updated, err := todo.Edit(ctx, h.db, todoName, todo.Todo{
+	edited := todo.Todo{
 		Name:        umBody.Name,
 		Description: umBody.Description,
-	})
+	}
+	var updated todo.Todo
+	if byID {
+		updated, err = todo.EditByID(ctx, h.db, id, edited, ifMatch(r))
+	} else {
+		updated, err = todo.Edit(ctx, h.db, todoName, edited, ifMatch(r))
+	}
 	if err != nil {
-		if errors.Is(err, storage.ErrNotFound) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	data, err := json.Marshal(createResponse{
-		ID:          updated.ID,
-		Name:        updated.Name,
-		Description: updated.Description,
-	})
+	data, err := json.Marshal(h.todoView(updated))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(updated.Version))
 	_, err = w.Write(data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	return
 }
 
-func (h TodoListHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	ctx := context.TODO()
+// Patch changes some fields of the todo with ID {id}. The body is a JSON Merge Patch or a JSON Patch of the todo's
+// document, picked by the Content-Type, and the patched todo must pass the same validation as a created one. Like
+// Edit and Delete, it honours If-Match, failing with 412 Precondition Failed if the todo has changed since the client
+// read the ETag it sends.
+func (h TodoListHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
 
-	vars := mux.Vars(r)
-	todoName, err := url.QueryUnescape(vars["name"])
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, badRequest(CodeMalformedJSON, "failed to read the request body"))
 		return
 	}
 
-	if todoName == "" {
-		http.Error(w, "missing 'name' parameter in request url", http.StatusBadRequest)
+	var patch todo.Patcher
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchContentType:
+		patch = todo.MergePatch(body)
+	case jsonPatchContentType:
+		patch = todo.JSONPatch(body)
+	default:
+		w.Header().Set("Accept-Patch", mergePatchContentType+", "+jsonPatchContentType)
+		writeError(w, r, &apiError{
+			status: http.StatusUnsupportedMediaType,
+			code:   CodeUnsupportedMediaType,
+			detail: fmt.Sprintf("the body must be %s or %s", mergePatchContentType, jsonPatchContentType),
+		})
 		return
 	}
 
-	err = todo.Delete(ctx, h.db, todoName)
+	patched, err := todo.Patch(ctx, h.db, mux.Vars(r)["id"], patch, func(patched todo.Todo) error {
+		return h.validate.Struct(createRequest{Name: patched.Name, Description: patched.Description})
+	}, ifMatch(r))
 	if err != nil {
-		if errors.Is(err, storage.ErrNotFound) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+		writeError(w, r, err)
+		return
+	}
+
+	data, err := json.Marshal(h.todoView(patched))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(patched.Version))
+	_, err = w.Write(data)
+	if err != nil {
+		writeError(w, r, err)
+	}
+}
+
+// Delete removes the todo named {name}, or in v2 the one with ID {id}, which answers 204 No Content
+func (h TodoListHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+
+	var err error
+	if id, byID := mux.Vars(r)["id"]; byID {
+		err = todo.DeleteByID(ctx, h.db, id, ifMatch(r))
+	} else {
+		var todoName string
+		if todoName, err = pathName(r); err == nil {
+			err = todo.Delete(ctx, h.db, todoName, ifMatch(r))
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
+	if h.apiVersion >= 2 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	return
 }
 
-func (h TodoListHandler) DeleteAll(w http.ResponseWriter, r *http.Request) {
-	ctx := context.TODO()
+// pathName is the todo name in the {name} path parameter
+func pathName(r *http.Request) (string, error) {
+	todoName, err := url.QueryUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return "", badRequest(CodeInvalidParameter, "'name' isn't a valid URL-encoded todo name")
+	}
+
+	if todoName == "" {
+		return "", badRequest(CodeInvalidParameter, "missing 'name' parameter in request url")
+	}
 
-	err := todo.DeleteAll(ctx, h.db)
+	return todoName, nil
+}
+
+// DeleteMatching deletes the todos matching ?status= (open or completed), ?older_than= (not updated for that long,
+// e.g. 30d or 12h) and ?q= (the /list filter language), which must all match, and reports how many it deleted.
+// Deleting every todo takes ?confirm= instead: the token from the 428 Precondition Required that a request without
+// criteria gets, which is only good until the list changes.
+func (h TodoListHandler) DeleteMatching(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+
+	filter, err := deleteFilter(r.URL.Query(), time.Now())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	var deleted int
+	if filter == nil {
+		deleted, err = todo.DeleteAll(ctx, h.db, r.URL.Query().Get("confirm"))
+	} else {
+		deleted, err = todo.DeleteMatching(ctx, h.db, filter)
+	}
+	if errors.Is(err, todo.ErrConfirmationRequired) {
+		token, err := todo.ConfirmationToken(ctx, h.db)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		p := problemFor(todo.ErrConfirmationRequired)
+		p.ConfirmationToken = token
+		writeProblem(w, r, p)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	data, err := json.Marshal(deleteResponse{Deleted: deleted})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	return
+	_, err = w.Write(data)
+	if err != nil {
+		writeError(w, r, err)
+	}
+}
+
+// deleteFilter turns the criteria of DeleteMatching into one filter; it's nil if there are none
+func deleteFilter(params url.Values, now time.Time) (query.Expr, error) {
+	var terms []query.Expr
+
+	switch status := params.Get("status"); status {
+	case "":
+	case "open", "completed":
+		terms = append(terms, query.Term{Field: query.FieldStatus, Op: query.OpEqual, Completed: status == "completed"})
+	default:
+		return nil, badRequest(CodeInvalidParameter, "'status' must be open or completed")
+	}
+
+	if param := params.Get("older_than"); param != "" {
+		age, err := parseAge(param)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, query.Term{Field: query.FieldUpdated, Op: query.OpLess, Time: now.Add(-age).UTC()})
+	}
+
+	if q := params.Get("q"); q != "" {
+		expr, err := query.Parse(q)
+		if err != nil {
+			return nil, err
+		}
+		if expr != nil {
+			terms = append(terms, expr)
+		}
+	}
+
+	switch len(terms) {
+	case 0:
+		return nil, nil
+	case 1:
+		return terms[0], nil
+	default:
+		return query.And{Exprs: terms}, nil
+	}
+}
+
+// parseAge reads a positive age in days, e.g. 30d, or as a Go duration, e.g. 12h
+func parseAge(param string) (time.Duration, error) {
+	var age time.Duration
+	if days := strings.TrimSuffix(param, "d"); days != param {
+		n, err := strconv.Atoi(days)
+		if err == nil {
+			age = time.Duration(n) * 24 * time.Hour
+		}
+	} else {
+		age, _ = time.ParseDuration(param)
+	}
+
+	if age <= 0 {
+		return 0, badRequest(CodeInvalidParameter, "'older_than' must be a positive age such as 30d or 12h")
+	}
+	return age, nil
+}
+
+// parseLimit reads the optional ?limit= parameter; zero means it wasn't given
+func parseLimit(params url.Values, max int) (int, error) {
+	rawLimit := params.Get("limit")
+	if rawLimit == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(rawLimit)
+	if err != nil || limit < 1 || limit > max {
+		return 0, badRequest(CodeInvalidParameter, "'limit' must be a number from 1 to %d", max)
+	}
+
+	return limit, nil
 }
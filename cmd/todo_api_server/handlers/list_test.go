@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func TestTodoListHandler_Patch(t *testing.T) {
+	testData := []struct {
+		testName       string
+		contentType    string
+		body           string
+		expectedStatus int
+		expectedResult Todo
+		expectedCode   string
+	}{
+		{
+			testName:       "merge patch",
+			contentType:    "application/merge-patch+json; charset=utf-8",
+			body:           `{"completed": true}`,
+			expectedStatus: 200,
+			expectedResult: Todo{Name: "shopping", Description: "get milk", Completed: true},
+		},
+		{
+			testName:       "json patch",
+			contentType:    "application/json-patch+json",
+			body:           `[{"op": "test", "path": "/name", "value": "shopping"}, {"op": "replace", "path": "/name", "value": "groceries"}]`,
+			expectedStatus: 200,
+			expectedResult: Todo{Name: "groceries", Description: "get milk"},
+		},
+		{
+			testName:       "failure: test fails",
+			contentType:    "application/json-patch+json",
+			body:           `[{"op": "test", "path": "/completed", "value": true}]`,
+			expectedStatus: 409,
+			expectedCode:   CodePatchTestFailed,
+		},
+		{
+			testName:       "failure: invalid patch",
+			contentType:    "application/json-patch+json",
+			body:           `[{"op": "remove", "path": "/priority"}]`,
+			expectedStatus: 400,
+			expectedCode:   CodeInvalidPatch,
+		},
+		{
+			testName:       "failure: patched todo fails validation",
+			contentType:    "application/merge-patch+json",
+			body:           `{"name": null}`,
+			expectedStatus: 400,
+			expectedCode:   CodeValidationFailed,
+		},
+		{
+			testName:       "failure: unsupported media type",
+			contentType:    "application/json",
+			body:           `{"completed": true}`,
+			expectedStatus: 415,
+			expectedCode:   CodeUnsupportedMediaType,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			db := storage.NewInMemoryDB()
+			shopping, _ := db.SaveTodo(context.Background(), "shopping", "get milk")
+			router := NewRouter(NewTodoListHandler(&configs.Settings{DatabaseCxnTimeoutSeconds: 5}, db, tenant.StaticResolver(tenant.Default)))
+
+			req := httptest.NewRequest("PATCH", "/todos/"+shopping.ID, strings.NewReader(td.body))
+			req.Header.Set("Content-Type", td.contentType)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != td.expectedStatus {
+				t.Fatalf("expected status %d; got %d: %s", td.expectedStatus, rec.Code, rec.Body)
+			}
+
+			if td.expectedCode != "" {
+				var problem Problem
+				_ = json.Unmarshal(rec.Body.Bytes(), &problem)
+				if problem.Code != td.expectedCode {
+					t.Errorf("expected error code %s; got %+v", td.expectedCode, problem)
+				}
+				return
+			}
+
+			var result Todo
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("failed to decode todo %s: %v", rec.Body, err)
+			}
+			expected := td.expectedResult
+			expected.ID = shopping.ID
+			if diff := cmp.Diff(expected, result); diff != "" {
+				t.Errorf("Patch expected vs actual results don't match: %v", diff)
+			}
+		})
+	}
+}
+
+func TestTodoListHandler_ETags(t *testing.T) {
+	db := storage.NewInMemoryDB()
+	router := NewRouter(NewTodoListHandler(&configs.Settings{DatabaseCxnTimeoutSeconds: 5}, db, tenant.StaticResolver(tenant.Default)))
+
+	send := func(method, path, body string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	created := send("POST", "/todo", `{"name": "shopping"}`)
+	if etag := created.Header().Get("ETag"); etag != `"1"` {
+		t.Fatalf("expected a created todo to have ETag \"1\"; got %q", etag)
+	}
+	var todo Todo
+	_ = json.Unmarshal(created.Body.Bytes(), &todo)
+
+	testData := []struct {
+		testName       string
+		method         string
+		path           string
+		body           string
+		headers        []string
+		expectedStatus int
+		expectedETag   string
+	}{
+		{
+			testName:       "get",
+			method:         "GET",
+			path:           "/todos/" + todo.ID,
+			expectedStatus: 200,
+			expectedETag:   `"1"`,
+		},
+		{
+			testName:       "get not modified",
+			method:         "GET",
+			path:           "/todos/" + todo.ID,
+			headers:        []string{"If-None-Match", `"7", W/"1"`},
+			expectedStatus: 304,
+			expectedETag:   `"1"`,
+		},
+		{
+			testName:       "edit with stale ETag",
+			method:         "PUT",
+			path:           "/todo/shopping",
+			body:           `{"name": "groceries", "description": "get milk"}`,
+			headers:        []string{"If-Match", `"0"`},
+			expectedStatus: 412,
+		},
+		{
+			testName:       "edit with weak ETag",
+			method:         "PUT",
+			path:           "/todo/shopping",
+			body:           `{"name": "groceries", "description": "get milk"}`,
+			headers:        []string{"If-Match", `W/"1"`},
+			expectedStatus: 412,
+		},
+		{
+			testName:       "edit with current ETag",
+			method:         "PUT",
+			path:           "/todo/shopping",
+			body:           `{"name": "groceries", "description": "get milk"}`,
+			headers:        []string{"If-Match", `"0", "1"`},
+			expectedStatus: 200,
+			expectedETag:   `"2"`,
+		},
+		{
+			testName:       "patch with stale ETag",
+			method:         "PATCH",
+			path:           "/todos/" + todo.ID,
+			body:           `{"completed": true}`,
+			headers:        []string{"Content-Type", "application/merge-patch+json", "If-Match", `"1"`},
+			expectedStatus: 412,
+		},
+		{
+			testName:       "patch with current ETag",
+			method:         "PATCH",
+			path:           "/todos/" + todo.ID,
+			body:           `{"completed": true}`,
+			headers:        []string{"Content-Type", "application/merge-patch+json", "If-Match", `"2"`},
+			expectedStatus: 200,
+			expectedETag:   `"3"`,
+		},
+		{
+			testName:       "delete with stale ETag",
+			method:         "DELETE",
+			path:           "/todo/groceries",
+			headers:        []string{"If-Match", `"2"`},
+			expectedStatus: 412,
+		},
+		{
+			testName:       "get after writes",
+			method:         "GET",
+			path:           "/todos/" + todo.ID,
+			headers:        []string{"If-None-Match", `"1"`},
+			expectedStatus: 200,
+			expectedETag:   `"3"`,
+		},
+		{
+			testName:       "delete with any ETag",
+			method:         "DELETE",
+			path:           "/todo/groceries",
+			headers:        []string{"If-Match", "*"},
+			expectedStatus: 200,
+		},
+		{
+			testName:       "get deleted",
+			method:         "GET",
+			path:           "/todos/" + todo.ID,
+			expectedStatus: 404,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			rec := send(td.method, td.path, td.body, td.headers...)
+			if rec.Code != td.expectedStatus {
+				t.Fatalf("expected status %d; got %d: %s", td.expectedStatus, rec.Code, rec.Body)
+			}
+			if etag := rec.Header().Get("ETag"); etag != td.expectedETag {
+				t.Errorf("expected ETag %q; got %q", td.expectedETag, etag)
+			}
+			if td.expectedStatus == 304 && rec.Body.Len() != 0 {
+				t.Errorf("expected no body with 304; got %s", rec.Body)
+			}
+		})
+	}
+
+	// the list's ETag changes with its content
+	list := send("GET", "/list", "")
+	etag := list.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected /list to have an ETag")
+	}
+	if rec := send("GET", "/list", "", "If-None-Match", etag); rec.Code != 304 {
+		t.Errorf("expected status 304 for an unchanged list; got %d", rec.Code)
+	}
+	send("POST", "/todo", `{"name": "wash car"}`)
+	if rec := send("GET", "/list", "", "If-None-Match", etag); rec.Code != 200 || rec.Header().Get("ETag") == etag {
+		t.Errorf("expected status 200 and a new ETag for a changed list; got %d, %q", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestTodoListHandler_DeleteMatching(t *testing.T) {
+	ctx := tenant.WithID(context.Background(), tenant.Default)
+	monthAgo := time.Now().AddDate(0, -1, -1).UTC()
+	db := storage.NewInMemoryDB()
+	_ = db.ImportTodos(ctx, []storage.Todo{
+		{ID: "1", Tenant: tenant.Default, Name: "shopping", Completed: true, UpdatedAt: monthAgo, Version: 1},
+		{ID: "2", Tenant: tenant.Default, Name: "wash car", Completed: true, UpdatedAt: time.Now().UTC(), Version: 1},
+		{ID: "3", Tenant: tenant.Default, Name: "dentist", UpdatedAt: monthAgo, Version: 1},
+		{ID: "4", Tenant: tenant.Default, Name: "gym", UpdatedAt: monthAgo, Version: 1},
+	}, nil)
+	router := NewRouter(NewTodoListHandler(&configs.Settings{DatabaseCxnTimeoutSeconds: 5}, db, tenant.StaticResolver(tenant.Default)))
+
+	send := func(path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("DELETE", path, nil))
+		var body map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec, body
+	}
+
+	var token string
+	testData := []struct {
+		testName        string
+		path            func() string
+		expectedStatus  int
+		expectedDeleted float64
+		expectedCode    string
+	}{
+		{
+			testName:        "completed and old",
+			path:            func() string { return "/todos?status=completed&older_than=30d" },
+			expectedStatus:  200,
+			expectedDeleted: 1,
+		},
+		{
+			testName:        "filter",
+			path:            func() string { return "/todos?status=open&q=name:gym" },
+			expectedStatus:  200,
+			expectedDeleted: 1,
+		},
+		{
+			testName:       "failure: invalid status",
+			path:           func() string { return "/todos?status=archived" },
+			expectedStatus: 400,
+			expectedCode:   CodeInvalidParameter,
+		},
+		{
+			testName:       "failure: invalid age",
+			path:           func() string { return "/todos?older_than=-3d" },
+			expectedStatus: 400,
+			expectedCode:   CodeInvalidParameter,
+		},
+		{
+			testName:       "failure: everything without a token",
+			path:           func() string { return "/todos" },
+			expectedStatus: 428,
+			expectedCode:   CodeConfirmationRequired,
+		},
+		{
+			testName:       "failure: everything with a wrong token",
+			path:           func() string { return "/todos?confirm=0123" },
+			expectedStatus: 428,
+			expectedCode:   CodeConfirmationRequired,
+		},
+		{
+			testName:        "everything",
+			path:            func() string { return "/todos?confirm=" + token },
+			expectedStatus:  200,
+			expectedDeleted: 2,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			rec, body := send(td.path())
+			if rec.Code != td.expectedStatus {
+				t.Fatalf("expected status %d; got %d: %s", td.expectedStatus, rec.Code, rec.Body)
+			}
+			if td.expectedCode != "" && body["code"] != td.expectedCode {
+				t.Errorf("expected problem code %q; got %s", td.expectedCode, rec.Body)
+			}
+			if td.expectedStatus == 200 && body["deleted"] != td.expectedDeleted {
+				t.Errorf("expected %v deleted; got %s", td.expectedDeleted, rec.Body)
+			}
+			if td.expectedStatus == 428 {
+				token, _ = body["confirmation_token"].(string)
+				if token == "" {
+					t.Errorf("expected a confirmation token; got %s", rec.Body)
+				}
+			}
+		})
+	}
+
+	if list, _ := db.GetTodoList(ctx); len(list) != 0 {
+		t.Errorf("expected an empty list; got %+v", list)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+// cacheStatsResponse reports the read cache's counters; they're all zero when the cache isn't enabled
+type cacheStatsResponse struct {
+	Enabled bool `json:"enabled"`
+	storage.CacheStats
+}
+
+// CacheStats serves the read cache's hit, miss and eviction counters. They're only counts, so unlike the whole expvar
+// dump, with the command line and memory stats, they're safe to serve without authentication.
+func (h TodoListHandler) CacheStats(w http.ResponseWriter, r *http.Request) {
+	var resp cacheStatsResponse
+	if cache, ok := h.db.(interface{ Stats() storage.CacheStats }); ok {
+		resp = cacheStatsResponse{Enabled: true, CacheStats: cache.Stats()}
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(data)
+}
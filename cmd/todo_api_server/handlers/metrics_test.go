@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func TestCacheStats(t *testing.T) {
+	testData := []struct {
+		testName string
+		db       storage.DB
+		expected cacheStatsResponse
+	}{
+		{
+			testName: "cache enabled",
+			db:       storage.NewCachedDB(storage.NewInMemoryDB(), storage.CacheConfig{Size: 10, TTL: time.Minute}),
+			expected: cacheStatsResponse{Enabled: true, CacheStats: storage.CacheStats{Hits: 1, Misses: 1, Entries: 1}},
+		},
+		{
+			testName: "cache disabled",
+			db:       storage.NewInMemoryDB(),
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			router := NewRouter(NewTodoListHandler(&configs.Settings{DatabaseCxnTimeoutSeconds: 5}, td.db, tenant.StaticResolver(tenant.Default)))
+			for i := 0; i < 2; i++ {
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/list", nil))
+			}
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics/cache", nil))
+			if rec.Code != 200 {
+				t.Fatalf("expected status 200; got %d: %s", rec.Code, rec.Body)
+			}
+			var actual cacheStatsResponse
+			_ = json.Unmarshal(rec.Body.Bytes(), &actual)
+			if diff := cmp.Diff(td.expected, actual); diff != "" {
+				t.Errorf("expected vs actual response don't match: %v", diff)
+			}
+		})
+	}
+}
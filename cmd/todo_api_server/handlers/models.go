@@ -1,9 +1,23 @@
 package handlers
 
+import "time"
+
 type Todo struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	Completed   bool   `json:"completed"`
+}
+
+// TodoV2 is a todo as v2 of the API returns it, which adds when it was created and last changed, and its version
+type TodoV2 struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Completed   bool      `json:"completed"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int64     `json:"version"`
 }
 
 type echoRequest struct {
@@ -20,10 +34,40 @@ type createRequest struct {
 	Description string `json:"description,omitempty" validate:"max=100"`
 }
 
-type createResponse Todo
-
 type getAllResponse struct {
 	List []Todo `json:"list,omitempty"`
+	Next string `json:"next,omitempty"`
+}
+
+type searchResult struct {
+	Todo
+	Score      float64    `json:"score"`
+	Highlights highlights `json:"highlights"`
+}
+
+type highlights struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type searchResponse struct {
+	Results []searchResult `json:"results"`
+}
+
+type listResponseV2 struct {
+	Todos []TodoV2 `json:"todos"`
+	Next  string   `json:"next,omitempty"`
+}
+
+// searchResultV2 nests the todo rather than flatten it next to the score, so its fields can't clash with the result's
+type searchResultV2 struct {
+	Todo       TodoV2     `json:"todo"`
+	Score      float64    `json:"score"`
+	Highlights highlights `json:"highlights"`
+}
+
+type searchResponseV2 struct {
+	Results []searchResultV2 `json:"results"`
 }
 
 type editRequest struct {
@@ -31,7 +75,63 @@ type editRequest struct {
 	Description string `json:"description" validate:"required,max=100"`
 }
 
-type EditResponse Todo
+// todoMergePatch documents the body of a JSON Merge Patch of a todo: the fields to change, with their new values, or
+// null to clear them
+type todoMergePatch struct {
+	Name        string `json:"name,omitempty" validate:"min=1,max=25" openapi:"nullable"`
+	Description string `json:"description,omitempty" validate:"max=100" openapi:"nullable"`
+	Completed   bool   `json:"completed,omitempty" openapi:"nullable"`
+}
+
+// jsonPatchOperation documents one operation of the body of a JSON Patch of a todo
+type jsonPatchOperation struct {
+	Op    string      `json:"op" validate:"required,oneof=add remove replace move copy test"`
+	Path  string      `json:"path" validate:"required"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type deleteResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+type batchRequest struct {
+	Operations []batchOperation `json:"operations" validate:"required,min=1,max=500"`
+}
+
+// batchOperation is one write of a batch; which fields it needs depends on the op
+type batchOperation struct {
+	Op          string `json:"op"`
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Completed   bool   `json:"completed,omitempty"`
+	// Version, if set, is the version the todo must have for an update or delete to apply
+	Version int64 `json:"version,omitempty"`
+}
+
+type batchUpdate struct {
+	ID string `json:"id" validate:"required"`
+	editRequest
+	Completed bool `json:"completed,omitempty"`
+}
+
+type batchDelete struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// batchResult is the outcome of one operation of a batch. Its todo is a Todo or a TodoV2, depending on the version of
+// the API.
+type batchResult struct {
+	Status int         `json:"status"`
+	Todo   interface{} `json:"todo,omitempty" openapi:"anyOf=Todo,TodoV2"`
+	ETag   string      `json:"etag,omitempty"`
+	Error  *Problem    `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
 
 type DBCredentials struct {
 	Username string
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/us-learn-and-devops/todoapi/pkg/codec"
+)
+
+// format is a representation the API reads or writes. The handlers only speak JSON, so responses are converted from
+// JSON by encode and request bodies to JSON by decode; JSON has neither, and a format without decode can't be sent.
+type format struct {
+	contentType string
+	// aliases are other media types clients know the format by
+	aliases []string
+	// etagSuffix tells the format's ETags from JSON's, which have none
+	etagSuffix string
+	encode     func([]byte) ([]byte, error)
+	decode     func([]byte) ([]byte, error)
+}
+
+// formats are in the server's order of preference, which breaks ties between equally acceptable ones
+var formats = []format{
+	{contentType: "application/json", aliases: []string{problemContentType}},
+	{contentType: "application/yaml", aliases: []string{"application/x-yaml", "text/yaml"}, etagSuffix: "yaml", encode: codec.JSONToYAML, decode: codec.YAMLToJSON},
+	{contentType: "text/csv", etagSuffix: "csv", encode: codec.JSONToCSV},
+	{contentType: "application/msgpack", aliases: []string{"application/x-msgpack", "application/vnd.msgpack"}, etagSuffix: "msgpack", encode: codec.JSONToMessagePack, decode: codec.MessagePackToJSON},
+}
+
+func (f format) is(mediaType string) bool {
+	if mediaType == f.contentType {
+		return true
+	}
+	for _, alias := range f.aliases {
+		if mediaType == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// decodableTypes are the media types of request bodies Negotiate converts to JSON
+func decodableTypes() []string {
+	var types []string
+	for _, f := range formats {
+		if f.decode != nil {
+			types = append(types, f.contentType)
+		}
+	}
+	return types
+}
+
+// negotiate picks the format for a response from the request's Accept header, or reports there's none acceptable.
+// Each format gets the quality of the most specific media range matching it, and the best quality above 0 wins.
+func negotiate(accept string) (format, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return formats[0], true
+	}
+
+	best, bestQuality := format{}, 0.0
+	for _, f := range formats {
+		quality, specificity := 0.0, -1
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil {
+				continue
+			}
+			rangeQuality := 1.0
+			if q, ok := params["q"]; ok {
+				if rangeQuality, err = strconv.ParseFloat(q, 64); err != nil || rangeQuality < 0 || rangeQuality > 1 {
+					continue
+				}
+			}
+
+			s := -1
+			switch {
+			case f.is(mediaType):
+				s = 2
+			case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(f.contentType, strings.TrimSuffix(mediaType, "*")):
+				s = 1
+			case mediaType == "*/*":
+				s = 0
+			}
+			if s > specificity {
+				quality, specificity = rangeQuality, s
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = f, quality
+		}
+	}
+	return best, bestQuality > 0
+}
+
+// Negotiate lets clients use YAML, CSV or MessagePack instead of JSON. A YAML or MessagePack request body is converted
+// to JSON before the handlers see it, and a JSON response, error or not, to the format the Accept header prefers. A
+// request that accepts none of them gets a 406, in JSON. Each format has its own ETags, so a client can't revalidate
+// one representation with the ETag of another.
+func Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		out, ok := negotiate(r.Header.Get("Accept"))
+		if !ok {
+			var types []string
+			for _, f := range formats {
+				types = append(types, f.contentType)
+			}
+			writeProblem(w, r, newProblem(http.StatusNotAcceptable, CodeNotAcceptable, "the response can be "+strings.Join(types, ", ")))
+			return
+		}
+
+		toHandlerETags(r, out)
+
+		// decoding happens inside, so a malformed body is reported in the format the client reads
+		decoded := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			for _, f := range formats {
+				if f.decode == nil || !f.is(mediaType) {
+					continue
+				}
+				body, err := io.ReadAll(r.Body)
+				r.Body.Close()
+				if err == nil {
+					body, err = f.decode(body)
+				}
+				if err != nil {
+					writeProblem(w, r, newProblem(http.StatusBadRequest, CodeMalformedBody, "the request body isn't valid "+f.contentType))
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				r.ContentLength = int64(len(body))
+				r.Header.Set("Content-Type", "application/json")
+			}
+			next.ServeHTTP(w, r)
+		})
+
+		if out.encode == nil {
+			decoded.ServeHTTP(w, r)
+			return
+		}
+
+		buffered := &bufferedResponse{header: w.Header()}
+		decoded.ServeHTTP(buffered, r)
+		buffered.WriteHeader(http.StatusOK)
+		if etag := buffered.header.Get("ETag"); etag != "" {
+			buffered.header.Set("ETag", formatETag(etag, out))
+		}
+		if err := encodeResponse(buffered, out); err != nil {
+			log.Printf("request %s: %s %s: the response can't be written as %s: %v", RequestID(r.Context()), r.Method, r.URL.Path, out.contentType, err)
+			w.Header().Del("Content-Length")
+			writeProblem(w, r, newProblem(http.StatusInternalServerError, CodeInternal, "the response can't be written as "+out.contentType))
+			return
+		}
+		buffered.flush(w)
+	})
+}
+
+// encodeResponse converts a buffered JSON body to f. Bodies that aren't JSON, like the empty one of a 204, are left be.
+func encodeResponse(resp *bufferedResponse, f format) error {
+	mediaType, _, _ := mime.ParseMediaType(resp.header.Get("Content-Type"))
+	if resp.body.Len() == 0 || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return nil
+	}
+
+	body, err := f.encode(resp.body.Bytes())
+	if err != nil {
+		return err
+	}
+	contentType := f.contentType
+	if strings.HasPrefix(contentType, "text/") {
+		contentType += "; charset=utf-8"
+	}
+	resp.header.Set("Content-Type", contentType)
+	resp.header.Del("Content-Length")
+	resp.body.Reset()
+	resp.body.Write(body)
+	return nil
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+	"github.com/us-learn-and-devops/todoapi/pkg/codec"
+)
+
+func TestNegotiate(t *testing.T) {
+	testData := []struct {
+		accept   string
+		expected string
+	}{
+		{accept: "", expected: "application/json"},
+		{accept: "*/*", expected: "application/json"},
+		{accept: "application/yaml", expected: "application/yaml"},
+		{accept: "text/yaml", expected: "application/yaml"},
+		{accept: "text/*", expected: "text/csv"},
+		{accept: "application/json;q=0.5, application/msgpack", expected: "application/msgpack"},
+		{accept: "text/csv;q=0.8, application/*;q=0.9", expected: "application/json"},
+		{accept: "*/*;q=0.1, application/json;q=0", expected: "application/yaml"},
+		{accept: "application/x-msgpack, application/yaml", expected: "application/yaml"},
+		{accept: "text/html, application/problem+json;q=0.2", expected: "application/json"},
+		{accept: "text/html", expected: ""},
+		{accept: "application/json;q=0", expected: ""},
+		{accept: "application/json;q=high", expected: ""},
+	}
+
+	for _, td := range testData {
+		t.Run(td.accept, func(t *testing.T) {
+			f, ok := negotiate(td.accept)
+			if !ok {
+				f.contentType = ""
+			}
+			if f.contentType != td.expected {
+				t.Errorf("expected %q; got %q", td.expected, f.contentType)
+			}
+		})
+	}
+}
+
+func TestNewRouter_Negotiation(t *testing.T) {
+	db := storage.NewInMemoryDB()
+	cfgs := &configs.Settings{DatabaseCxnTimeoutSeconds: 5, ValidateResponses: true}
+	router := NewRouter(NewTodoListHandler(cfgs, db, tenant.StaticResolver(tenant.Default)))
+
+	msgpack, _ := codec.JSONToMessagePack([]byte(`{"name": "dentist", "description": "book a check up"}`))
+	testData := []struct {
+		testName            string
+		method              string
+		path                string
+		accept              string
+		contentType         string
+		body                string
+		expectedStatus      int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			testName:            "YAML body",
+			method:              "POST",
+			path:                "/v1/todo",
+			contentType:         "application/yaml",
+			body:                "name: shopping\ndescription: get milk\n",
+			expectedStatus:      200,
+			expectedContentType: "application/json",
+		},
+		{
+			testName:            "MessagePack body",
+			method:              "POST",
+			path:                "/v1/todo",
+			contentType:         "application/msgpack",
+			body:                string(msgpack),
+			expectedStatus:      200,
+			expectedContentType: "application/json",
+		},
+		{
+			testName:            "CSV list",
+			method:              "GET",
+			path:                "/v1/list",
+			accept:              "text/csv",
+			expectedStatus:      200,
+			expectedContentType: "text/csv; charset=utf-8",
+			expectedBody:        "id,name,description,completed\n{id},shopping,get milk,false\n{id},dentist,book a check up,false\n",
+		},
+		{
+			testName:            "YAML error",
+			method:              "GET",
+			path:                "/v2/todos/missing",
+			accept:              "application/yaml, application/json;q=0.5",
+			expectedStatus:      404,
+			expectedContentType: "application/yaml",
+		},
+		{
+			testName:            "malformed YAML body",
+			method:              "POST",
+			path:                "/v2/todos",
+			accept:              "application/yaml",
+			contentType:         "application/yaml",
+			body:                "name: [dentist\n",
+			expectedStatus:      400,
+			expectedContentType: "application/yaml",
+		},
+		{
+			testName:            "unsupported body",
+			method:              "POST",
+			path:                "/v2/todos",
+			contentType:         "text/plain",
+			body:                "dentist",
+			expectedStatus:      415,
+			expectedContentType: problemContentType,
+		},
+		{
+			testName:            "not acceptable",
+			method:              "GET",
+			path:                "/v1/list",
+			accept:              "text/html",
+			expectedStatus:      406,
+			expectedContentType: problemContentType,
+		},
+		{
+			testName:            "unknown route",
+			method:              "GET",
+			path:                "/v1/nowhere",
+			accept:              "application/msgpack",
+			expectedStatus:      404,
+			expectedContentType: "application/msgpack",
+		},
+	}
+
+	var ids []string
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			req := httptest.NewRequest(td.method, td.path, strings.NewReader(td.body))
+			if td.accept != "" {
+				req.Header.Set("Accept", td.accept)
+			}
+			if td.contentType != "" {
+				req.Header.Set("Content-Type", td.contentType)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != td.expectedStatus {
+				t.Fatalf("expected status %d; got %d: %s", td.expectedStatus, rec.Code, rec.Body)
+			}
+			if actual := rec.Header().Get("Content-Type"); actual != td.expectedContentType {
+				t.Errorf("expected Content-Type %q; got %q", td.expectedContentType, actual)
+			}
+			if actual := rec.Header().Get("Vary"); actual != "Accept" {
+				t.Errorf("expected Vary Accept; got %q", actual)
+			}
+
+			if td.expectedContentType == "application/yaml" {
+				data, err := codec.YAMLToJSON(rec.Body.Bytes())
+				var p Problem
+				_ = json.Unmarshal(data, &p)
+				if err != nil || p.Status != td.expectedStatus {
+					t.Errorf("expected a YAML problem with status %d; got %s", td.expectedStatus, rec.Body)
+				}
+			}
+			if td.expectedContentType == "application/json" {
+				var created Todo
+				_ = json.Unmarshal(rec.Body.Bytes(), &created)
+				ids = append(ids, created.ID)
+			}
+			if td.expectedBody != "" {
+				expected := td.expectedBody
+				for _, id := range ids {
+					expected = strings.Replace(expected, "{id}", id, 1)
+				}
+				// the list is in ID order, which isn't the order the todos were created in
+				if diff := cmp.Diff(sortedLines(expected), sortedLines(rec.Body.String())); diff != "" {
+					t.Errorf("expected vs actual body don't match: %v", diff)
+				}
+			}
+		})
+	}
+}
+
+func sortedLines(s string) []string {
+	lines := strings.Split(s, "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestNewRouter_NegotiatedETags(t *testing.T) {
+	db := storage.NewInMemoryDB()
+	cfgs := &configs.Settings{DatabaseCxnTimeoutSeconds: 5, ValidateResponses: true}
+	router := NewRouter(NewTodoListHandler(cfgs, db, tenant.StaticResolver(tenant.Default)))
+
+	todo, err := db.SaveTodo(tenant.WithID(context.Background(), tenant.Default), "shopping", "get milk")
+	if err != nil {
+		t.Fatalf("SaveTodo got unexpected error: %+v", err)
+	}
+	path := "/v2/todos/" + todo.ID
+
+	testData := []struct {
+		testName       string
+		method         string
+		path           string
+		accept         string
+		header         string
+		etag           string
+		body           string
+		expectedStatus int
+		expectedETag   string
+	}{
+		{testName: "JSON", method: "GET", path: path, expectedStatus: 200, expectedETag: `"1"`},
+		{testName: "CSV", method: "GET", path: path, accept: "text/csv", expectedStatus: 200, expectedETag: `"1-csv"`},
+		{testName: "CSV revalidated as CSV", method: "GET", path: path, accept: "text/csv", header: "If-None-Match", etag: `"1-csv"`, expectedStatus: 304, expectedETag: `"1-csv"`},
+		{testName: "CSV revalidated as JSON", method: "GET", path: path, header: "If-None-Match", etag: `"1-csv"`, expectedStatus: 200, expectedETag: `"1"`},
+		{testName: "JSON revalidated as YAML", method: "GET", path: path, accept: "application/yaml", header: "If-None-Match", etag: `"1"`, expectedStatus: 200, expectedETag: `"1-yaml"`},
+		{testName: "CSV revalidated as YAML", method: "GET", path: path, accept: "application/yaml", header: "If-None-Match", etag: `"1-csv"`, expectedStatus: 200, expectedETag: `"1-yaml"`},
+		{testName: "either revalidated as YAML", method: "GET", path: path, accept: "application/yaml", header: "If-None-Match", etag: `"1-csv", W/"1-yaml"`, expectedStatus: 304, expectedETag: `"1-yaml"`},
+		{testName: "list revalidated as another format", method: "GET", path: "/v2/todos", accept: "application/msgpack", header: "If-None-Match", etag: "*", expectedStatus: 304},
+		{testName: "updated with a CSV ETag", method: "PATCH", path: path, accept: "application/yaml", header: "If-Match", etag: `"1-csv"`, body: `{"completed": true}`, expectedStatus: 200, expectedETag: `"2-yaml"`},
+		{testName: "updated with a stale YAML ETag", method: "PATCH", path: path, header: "If-Match", etag: `"1-yaml"`, body: `{"completed": false}`, expectedStatus: 412},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			req := httptest.NewRequest(td.method, td.path, strings.NewReader(td.body))
+			if td.accept != "" {
+				req.Header.Set("Accept", td.accept)
+			}
+			if td.body != "" {
+				req.Header.Set("Content-Type", "application/merge-patch+json")
+			}
+			if td.header != "" {
+				req.Header.Set(td.header, td.etag)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != td.expectedStatus {
+				t.Fatalf("expected status %d; got %d: %s", td.expectedStatus, rec.Code, rec.Body)
+			}
+			if td.expectedETag != "" {
+				if actual := rec.Header().Get("ETag"); actual != td.expectedETag {
+					t.Errorf("expected ETag %s; got %s", td.expectedETag, actual)
+				}
+			}
+			if actual := rec.Header().Get("Vary"); actual != "Accept" {
+				t.Errorf("expected Vary Accept; got %q", actual)
+			}
+		})
+	}
+}
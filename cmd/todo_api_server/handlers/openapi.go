@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The OpenAPI document is generated from the route table and the request and response models, so it can't fall behind
+// the handlers. Model fields become schema properties under their JSON names and validate constraints like max=25
+// become the matching JSON Schema keywords. Fields of request models are required if their validate tag says so, and
+// fields of response models unless they're omitempty.
+
+type openAPIDocument struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       openAPIInfo                `json:"info"`
+	Paths      map[string]openAPIPathItem `json:"paths"`
+	Components openAPIComponents          `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// openAPIPathItem holds the operations on a path by lower-case method
+type openAPIPathItem map[string]openAPIOperation
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Schema      *jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *jsonSchema `json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*jsonSchema `json:"schemas"`
+}
+
+// jsonSchema is the part of JSON Schema 2020-12, which OpenAPI 3.1 uses, that describes the API's models
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	Minimum              *int                   `json:"minimum,omitempty"`
+	Maximum              *int                   `json:"maximum,omitempty"`
+}
+
+// routeDoc describes a route in the OpenAPI document
+type routeDoc struct {
+	id         string
+	summary    string
+	tags       []string
+	deprecated bool
+	params     []param
+	// request has the zero value of the request body's model by content type; nil means the route takes no body
+	request map[string]interface{}
+	// status is the status of a successful response and response the zero value of its model, or nil if it has no body
+	status   int
+	response interface{}
+}
+
+// param is a query or header parameter; path parameters are documented from the route's path
+type param struct {
+	in       string
+	name     string
+	doc      string
+	required bool
+	schema   *jsonSchema
+}
+
+func queryParam(name string, schema *jsonSchema, doc string) param {
+	return param{in: "query", name: name, doc: doc, schema: schema}
+}
+
+func headerParam(name, doc string) param {
+	return param{in: "header", name: name, doc: doc, schema: &jsonSchema{Type: "string"}}
+}
+
+func jsonBody(model interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": model}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+var pathParamPattern = regexp.MustCompile(`{([^}/]+)}`)
+
+// newOpenAPIDocument documents the routes. Every route's errors are documented as problem details.
+func newOpenAPIDocument(routes []route) openAPIDocument {
+	responses := schemaGenerator{schemas: map[string]*jsonSchema{}}
+	requests := schemaGenerator{schemas: responses.schemas, request: true}
+	doc := openAPIDocument{
+		OpenAPI: "3.1.0",
+		Info:    openAPIInfo{Title: "Learn and DevOps ToDo API", Version: "2.0.0"},
+		Paths:   map[string]openAPIPathItem{},
+	}
+
+	problem := map[string]openAPIMediaType{problemContentType: {Schema: responses.schemaFor(reflect.TypeOf(Problem{}))}}
+	for _, rt := range routes {
+		op := openAPIOperation{
+			OperationID: rt.doc.id,
+			Summary:     rt.doc.summary,
+			Tags:        rt.doc.tags,
+			Deprecated:  rt.doc.deprecated,
+			Responses: map[string]openAPIResponse{
+				"default": {Description: "the request failed", Content: problem},
+			},
+		}
+
+		for _, match := range pathParamPattern.FindAllStringSubmatch(rt.path, -1) {
+			op.Parameters = append(op.Parameters, openAPIParameter{
+				Name:     match[1],
+				In:       "path",
+				Required: true,
+				Schema:   &jsonSchema{Type: "string"},
+			})
+		}
+		for _, p := range rt.doc.params {
+			op.Parameters = append(op.Parameters, openAPIParameter{
+				Name:        p.name,
+				In:          p.in,
+				Description: p.doc,
+				Required:    p.required,
+				Schema:      p.schema,
+			})
+		}
+
+		if rt.doc.request != nil {
+			op.RequestBody = &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{}}
+			for contentType, model := range rt.doc.request {
+				op.RequestBody.Content[contentType] = openAPIMediaType{Schema: requests.schemaFor(reflect.TypeOf(model))}
+			}
+		}
+
+		success := openAPIResponse{Description: http.StatusText(rt.doc.status)}
+		if rt.doc.response != nil {
+			success.Content = map[string]openAPIMediaType{
+				"application/json": {Schema: responses.schemaFor(reflect.TypeOf(rt.doc.response))},
+			}
+		}
+		op.Responses[strconv.Itoa(rt.doc.status)] = success
+		for _, p := range rt.doc.params {
+			if p.name == ifNoneMatchParam.name {
+				op.Responses["304"] = openAPIResponse{Description: http.StatusText(http.StatusNotModified)}
+			}
+		}
+
+		if doc.Paths[rt.path] == nil {
+			doc.Paths[rt.path] = openAPIPathItem{}
+		}
+		doc.Paths[rt.path][strings.ToLower(rt.method)] = op
+	}
+
+	doc.Components.Schemas = responses.schemas
+	return doc
+}
+
+// serveOpenAPI serves the document, which NewRouter fills in once it has every route, this one included
+func serveOpenAPI(doc *openAPIDocument) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(data)
+		if err != nil {
+			writeError(w, r, err)
+		}
+	}
+}
+
+// schemaGenerator turns Go types into schemas, adding each named struct to the components once and referring to it.
+// A model is either a request or a response model, since that decides which of its fields are required.
+type schemaGenerator struct {
+	schemas map[string]*jsonSchema
+	request bool
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g schemaGenerator) schemaFor(t reflect.Type) *jsonSchema {
+	switch {
+	case t == timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Ptr:
+		return g.schemaFor(t.Elem())
+	case t.Kind() == reflect.Struct:
+		name := componentName(t)
+		if _, ok := g.schemas[name]; !ok {
+			schema := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+			// registered before its fields, so a model that refers to itself doesn't recurse forever
+			g.schemas[name] = schema
+			g.addFields(schema, t)
+		}
+		return &jsonSchema{Ref: "#/components/schemas/" + name}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return &jsonSchema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case t.Kind() == reflect.String:
+		return &jsonSchema{Type: "string"}
+	case t.Kind() == reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		if t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64 {
+			return &jsonSchema{Type: "integer", Format: "int64"}
+		}
+		return &jsonSchema{Type: "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	default:
+		// an interface{} can hold any JSON value
+		return &jsonSchema{}
+	}
+}
+
+// addFields adds the fields of struct type t to schema, with those of embedded structs inlined as encoding/json does.
+// An interface{} field can name the models it may hold in an openapi:"anyOf=A,B" tag, and a field that may also be
+// null says so with openapi:"nullable".
+func (g schemaGenerator) addFields(schema *jsonSchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonTag := strings.Split(field.Tag.Get("json"), ",")
+		name := jsonTag[0]
+		if name == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			g.addFields(schema, field.Type)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		var property *jsonSchema
+		openAPITag := field.Tag.Get("openapi")
+		if strings.HasPrefix(openAPITag, "anyOf=") {
+			property = &jsonSchema{}
+			for _, model := range strings.Split(strings.TrimPrefix(openAPITag, "anyOf="), ",") {
+				property.AnyOf = append(property.AnyOf, &jsonSchema{Ref: "#/components/schemas/" + model})
+			}
+		} else {
+			property = g.schemaFor(field.Type)
+		}
+
+		required := !g.request && !hasOption(jsonTag[1:], "omitempty")
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "required" {
+				required = true
+			}
+			constrain(property, field.Type, rule)
+		}
+		if openAPITag == "nullable" {
+			property = &jsonSchema{AnyOf: []*jsonSchema{property, {Type: "null"}}}
+		}
+
+		schema.Properties[name] = property
+		if required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+}
+
+// constrain adds the JSON Schema keyword for a validate rule to a property of type t. Rules with no equivalent are
+// left out.
+func constrain(property *jsonSchema, t reflect.Type, rule string) {
+	parts := strings.SplitN(rule, "=", 2)
+	if len(parts) != 2 {
+		return
+	}
+	if parts[0] == "oneof" {
+		property.Enum = strings.Fields(parts[1])
+		return
+	}
+
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return
+	}
+	switch kind := t.Kind(); {
+	case kind == reflect.String:
+		if parts[0] == "min" {
+			property.MinLength = intPtr(n)
+		} else if parts[0] == "max" {
+			property.MaxLength = intPtr(n)
+		}
+	case kind == reflect.Slice || kind == reflect.Array:
+		if parts[0] == "min" {
+			property.MinItems = intPtr(n)
+		} else if parts[0] == "max" {
+			property.MaxItems = intPtr(n)
+		}
+	case kind >= reflect.Int && kind <= reflect.Float64:
+		if parts[0] == "min" {
+			property.Minimum = intPtr(n)
+		} else if parts[0] == "max" {
+			property.Maximum = intPtr(n)
+		}
+	}
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// componentName is the name of a model in the components: its type name, capitalised like a schema name
+func componentName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return "Object"
+	}
+	return upperFirst(name)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/mux"
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+// specPath is the OpenAPI document checked in for client generators; make openapi rewrites it
+const specPath = "../../../api/openapi.json"
+
+var update = flag.Bool("update", false, "rewrite "+specPath+" from the routes")
+
+func TestOpenAPI(t *testing.T) {
+	router := NewRouter(NewTodoListHandler(&configs.Settings{DatabaseCxnTimeoutSeconds: 5}, storage.NewInMemoryDB(), tenant.StaticResolver(tenant.Default)))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rec.Code != 200 {
+		t.Fatalf("GET /openapi.json expected status 200; got %d", rec.Code)
+	}
+	var served bytes.Buffer
+	if err := json.Indent(&served, rec.Body.Bytes(), "", "  "); err != nil {
+		t.Fatalf("GET /openapi.json expected JSON; got %v", err)
+	}
+	served.WriteString("\n")
+
+	var spec struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	_ = json.Unmarshal(served.Bytes(), &spec)
+
+	t.Run("documents every route", func(t *testing.T) {
+		var routed, documented []string
+		_ = router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+			path, _ := route.GetPathTemplate()
+			methods, _ := route.GetMethods()
+			for _, method := range methods {
+				routed = append(routed, method+" "+path)
+			}
+			return nil
+		})
+		for path, item := range spec.Paths {
+			for method := range item {
+				documented = append(documented, strings.ToUpper(method)+" "+path)
+			}
+		}
+		sort.Strings(routed)
+		sort.Strings(documented)
+		if diff := cmp.Diff(routed, documented); diff != "" {
+			t.Errorf("routes vs documented operations don't match: %v", diff)
+		}
+	})
+
+	t.Run("refers only to defined schemas", func(t *testing.T) {
+		for _, ref := range regexp.MustCompile(`"#/components/schemas/([^"]+)"`).FindAllStringSubmatch(served.String(), -1) {
+			if _, ok := spec.Components.Schemas[ref[1]]; !ok {
+				t.Errorf("expected a schema for %s", ref[0])
+			}
+		}
+	})
+
+	t.Run("carries validation constraints", func(t *testing.T) {
+		var createRequest struct {
+			Properties map[string]struct {
+				MinLength int `json:"minLength"`
+				MaxLength int `json:"maxLength"`
+			} `json:"properties"`
+			Required []string `json:"required"`
+		}
+		_ = json.Unmarshal(spec.Components.Schemas["CreateRequest"], &createRequest)
+		if name := createRequest.Properties["name"]; name.MinLength != 1 || name.MaxLength != 25 {
+			t.Errorf("expected CreateRequest.name to be 1 to 25 long; got %+v", name)
+		}
+		if diff := cmp.Diff([]string{"name"}, createRequest.Required); diff != "" {
+			t.Errorf("CreateRequest expected vs actual required fields don't match: %v", diff)
+		}
+	})
+
+	t.Run("matches the checked-in document", func(t *testing.T) {
+		if *update {
+			if err := os.WriteFile(specPath, served.Bytes(), 0644); err != nil {
+				t.Fatalf("failed to write %s: %v", specPath, err)
+			}
+		}
+		checkedIn, err := os.ReadFile(specPath)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", specPath, err)
+		}
+		if diff := cmp.Diff(string(checkedIn), served.String()); diff != "" {
+			t.Errorf("%s is out of date; run make openapi to regenerate it: %v", specPath, diff)
+		}
+	})
+}
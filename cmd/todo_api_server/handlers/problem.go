@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/todo"
+	"github.com/us-learn-and-devops/todoapi/pkg/jsonpatch"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// problemContentType is the media type of error responses (RFC 7807)
+const problemContentType = "application/problem+json"
+
+// the media types of the two kinds of patch PATCH accepts
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// Error codes are stable: clients may branch on them, so only add new ones
+const (
+	CodeMalformedJSON        = "malformed_json"
+	CodeValidationFailed     = "validation_failed"
+	CodeInvalidParameter     = "invalid_parameter"
+	CodeInvalidQuery         = "invalid_query"
+	CodeInvalidCursor        = "invalid_cursor"
+	CodeEmptySearch          = "empty_search"
+	CodeInvalidTenant        = "invalid_tenant"
+	CodeUnauthenticated      = "unauthenticated"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeAlreadyExists        = "already_exists"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeRequestInProgress    = "request_in_progress"
+	CodeInvalidPatch         = "invalid_patch"
+	CodePatchTestFailed      = "patch_test_failed"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeMalformedBody        = "malformed_body"
+	CodeNotAcceptable        = "not_acceptable"
+	CodeBatchAborted         = "batch_aborted"
+	CodeAtomicUnsupported    = "atomic_unsupported"
+	CodeConfirmationRequired = "confirmation_required"
+	CodeUnavailable          = "unavailable"
+	CodeInternal             = "internal_error"
+)
+
+// Problem is the body of every error response. Type is derived from Code, so a client can use either.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	// ConfirmationToken is the token that confirms a request to delete every todo, if that's what was refused
+	ConfirmationToken string `json:"confirmation_token,omitempty"`
+}
+
+// FieldError says why one field of a request body was rejected. Field is the JSON name and Rule the validation rule
+// that failed, e.g. required or max.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// apiError is an error the handlers raise themselves, already carrying the status and code it's reported with
+type apiError struct {
+	status int
+	code   string
+	detail string
+}
+
+func (e *apiError) Error() string {
+	return e.detail
+}
+
+func badRequest(code, format string, args ...interface{}) error {
+	return &apiError{status: http.StatusBadRequest, code: code, detail: fmt.Sprintf(format, args...)}
+}
+
+// problemFor maps an error returned by a handler, the domain or storage to the problem reported to the client.
+// Errors it doesn't recognise become a 500 whose detail doesn't leak the internal message.
+func problemFor(err error) Problem {
+	var (
+		apiErr        *apiError
+		validationErr validator.ValidationErrors
+		fieldsErr     *fieldsError
+		syntaxErr     *query.SyntaxError
+	)
+
+	switch {
+	case errors.As(err, &apiErr):
+		return newProblem(apiErr.status, apiErr.code, apiErr.detail)
+	case errors.As(err, &validationErr):
+		p := newProblem(http.StatusBadRequest, CodeValidationFailed, "the request body has invalid fields")
+		for _, fieldErr := range validationErr {
+			p.Errors = append(p.Errors, fieldError(fieldErr))
+		}
+		return p
+	case errors.As(err, &fieldsErr):
+		p := newProblem(http.StatusBadRequest, CodeValidationFailed, fieldsErr.Error())
+		p.Errors = fieldsErr.errors
+		return p
+	case errors.As(err, &syntaxErr):
+		return newProblem(http.StatusBadRequest, CodeInvalidQuery, "invalid 'q' parameter: "+syntaxErr.Error())
+	case errors.Is(err, storage.ErrInvalidCursor):
+		return newProblem(http.StatusBadRequest, CodeInvalidCursor, "the cursor is invalid or has expired")
+	case errors.Is(err, storage.ErrEmptySearch):
+		return newProblem(http.StatusBadRequest, CodeEmptySearch, "the search query has no searchable words")
+	case errors.Is(err, tenant.ErrUnauthenticated):
+		return newProblem(http.StatusUnauthorized, CodeUnauthenticated, "a valid tenant token is required")
+	case errors.Is(err, tenant.ErrMissing), errors.Is(err, tenant.ErrInvalid):
+		return newProblem(http.StatusBadRequest, CodeInvalidTenant, err.Error())
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		return newProblem(http.StatusConflict, CodePatchTestFailed, err.Error())
+	case errors.Is(err, jsonpatch.ErrInvalidPatch):
+		return newProblem(http.StatusBadRequest, CodeInvalidPatch, err.Error())
+	case errors.Is(err, todo.ErrConfirmationRequired):
+		return newProblem(http.StatusPreconditionRequired, CodeConfirmationRequired, "deleting every todo needs ?confirm= with the confirmation_token of this problem")
+	case errors.Is(err, todo.ErrBatchAborted):
+		return newProblem(http.StatusFailedDependency, CodeBatchAborted, err.Error())
+	case errors.Is(err, todo.ErrAtomicUnsupported):
+		return newProblem(http.StatusUnprocessableEntity, CodeAtomicUnsupported, "this server's database has no transactions, so batches can't be atomic; retry without ?atomic=true")
+	case errors.Is(err, storage.ErrVersionMismatch):
+		return newProblem(http.StatusPreconditionFailed, CodePreconditionFailed, "the todo has changed since the ETag in If-Match was read")
+	case errors.Is(err, storage.ErrNotFound):
+		return newProblem(http.StatusNotFound, CodeNotFound, "the todo doesn't exist")
+	case errors.Is(err, storage.ErrAlreadyInList):
+		return newProblem(http.StatusConflict, CodeAlreadyExists, "a todo with this name already exists")
+	case errors.Is(err, context.DeadlineExceeded):
+		return newProblem(http.StatusServiceUnavailable, CodeUnavailable, "the database didn't respond in time")
+	default:
+		return newProblem(http.StatusInternalServerError, CodeInternal, "an unexpected error occurred")
+	}
+}
+
+func newProblem(status int, code, detail string) Problem {
+	return Problem{
+		Type:   "urn:todoapi:problem:" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// fieldError translates a validator failure into client terms: the JSON field name and a readable message
+func fieldError(fe validator.FieldError) FieldError {
+	// min and max count characters of strings and items of lists
+	unit := "characters long"
+	if fe.Kind() == reflect.Slice {
+		unit = "items"
+	}
+
+	var msg string
+	switch fe.Tag() {
+	case "required":
+		msg = "is required"
+	case "min":
+		msg = fmt.Sprintf("must be at least %s %s", fe.Param(), unit)
+	case "max":
+		msg = fmt.Sprintf("must be at most %s %s", fe.Param(), unit)
+	default:
+		msg = fmt.Sprintf("must satisfy %s", strings.TrimSuffix(fe.Tag()+"="+fe.Param(), "="))
+	}
+
+	return FieldError{Field: fe.Field(), Rule: fe.Tag(), Message: fe.Field() + " " + msg}
+}
+
+// writeError reports err to the client as a problem. Server errors are logged with the request ID so the response
+// can be traced back to the internal message it hides.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	p := problemFor(err)
+	if p.Status >= http.StatusInternalServerError {
+		log.Printf("request %s: %s %s failed: %v", RequestID(r.Context()), r.Method, r.URL.Path, err)
+	}
+	writeProblem(w, r, p)
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	p.Instance = r.URL.Path
+	p.RequestID = RequestID(r.Context())
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(data)
+}
+
+// decodeJSON reads the request body into v, reporting a body that isn't the JSON v expects as malformed_json
+func decodeJSON(r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return badRequest(CodeMalformedJSON, "failed to read the request body")
+	}
+
+	if err = json.Unmarshal(body, v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return badRequest(CodeMalformedJSON, "field '%s' has the wrong type: got a JSON %s", typeErr.Field, typeErr.Value)
+		}
+		return badRequest(CodeMalformedJSON, "the request body isn't valid JSON")
+	}
+
+	return nil
+}
+
+// notFound and methodNotAllowed answer requests the router has no route for
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, newProblem(http.StatusNotFound, CodeNotFound, "no such endpoint"))
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, newProblem(http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" isn't allowed on this endpoint"))
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func TestProblems(t *testing.T) {
+	db := storage.NewInMemoryDB()
+	router := NewRouter(NewTodoListHandler(&configs.Settings{DatabaseCxnTimeoutSeconds: 5}, db, tenant.HeaderResolver{Header: "X-Tenant-ID"}))
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		expected Problem
+	}{
+		{
+			name:   "malformed json",
+			method: "POST", path: "/todo", body: `{"name": `,
+			expected: Problem{Status: 400, Code: CodeMalformedJSON, Detail: "the request body isn't valid JSON"},
+		},
+		{
+			name:   "wrong type",
+			method: "POST", path: "/todo", body: `{"name": 1}`,
+			expected: Problem{Status: 400, Code: CodeMalformedJSON, Detail: "field 'name' has the wrong type: got a JSON number"},
+		},
+		{
+			name:   "validation",
+			method: "PUT", path: "/todo/shopping", body: `{"name": ""}`,
+			expected: Problem{Status: 400, Code: CodeValidationFailed, Detail: "the request body has invalid fields", Errors: []FieldError{
+				{Field: "description", Rule: "required", Message: "description is required"},
+				{Field: "name", Rule: "min", Message: "name must be at least 1 characters long"},
+			}},
+		},
+		{
+			name:   "already exists",
+			method: "POST", path: "/todo", body: `{"name": "shopping"}`,
+			expected: Problem{Status: 409, Code: CodeAlreadyExists, Detail: "a todo with this name already exists"},
+		},
+		{
+			name:   "not found",
+			method: "DELETE", path: "/todo/missing",
+			expected: Problem{Status: 404, Code: CodeNotFound, Detail: "the todo doesn't exist"},
+		},
+		{
+			name:   "bad limit",
+			method: "GET", path: "/list?limit=0",
+			expected: Problem{Status: 400, Code: CodeInvalidParameter, Detail: "'limit' must be a number from 1 to 500"},
+		},
+		{
+			name:   "no route",
+			method: "GET", path: "/nowhere",
+			expected: Problem{Status: 404, Code: CodeNotFound, Detail: "no such endpoint"},
+		},
+		{
+			name:   "wrong method",
+			method: "PATCH", path: "/list",
+			expected: Problem{Status: 405, Code: CodeMethodNotAllowed, Detail: "PATCH isn't allowed on this endpoint"},
+		},
+	}
+
+	if _, err := db.SaveTodo(tenant.WithID(httptest.NewRequest("GET", "/", nil).Context(), "team-a"), "shopping", ""); err != nil {
+		t.Fatalf("SaveTodo got unexpected error: %+v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("X-Tenant-ID", "team-a")
+			req.Header.Set(RequestIDHeader, "req-"+strings.ReplaceAll(tt.name, " ", "-"))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if ct := rec.Header().Get("Content-Type"); ct != problemContentType {
+				t.Errorf("expected Content-Type %s; got %s", problemContentType, ct)
+			}
+
+			var actual Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &actual); err != nil {
+				t.Fatalf("failed to decode problem %s: %v", rec.Body, err)
+			}
+
+			expected := tt.expected
+			expected.Type = "urn:todoapi:problem:" + expected.Code
+			expected.Title = http.StatusText(expected.Status)
+			expected.Instance = req.URL.Path
+			expected.RequestID = req.Header.Get(RequestIDHeader)
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Errorf("expected vs actual problem don't match: %v", diff)
+			}
+			if rec.Code != expected.Status {
+				t.Errorf("expected status %d; got %d", expected.Status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		generated bool
+	}{
+		{name: "client id", header: "abc-123"},
+		{name: "missing", generated: true},
+		{name: "forged log line", header: "abc\nother", generated: true},
+		{name: "too long", header: strings.Repeat("a", maxRequestIDLen+1), generated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := WithRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestID(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if seen == "" || seen != rec.Header().Get(RequestIDHeader) {
+				t.Errorf("expected the context and response to carry the same ID; got %q and %q", seen, rec.Header().Get(RequestIDHeader))
+			}
+			if (seen != tt.header) != tt.generated {
+				t.Errorf("expected generated %v; got ID %q", tt.generated, seen)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID: a client or proxy may set it, and every response echoes it
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds the IDs taken from clients, which end up in logs
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// WithRequestID gives every request an ID, taken from the X-Request-ID header when it's a sensible one and generated
+// otherwise, and returns it in the response header. Error responses and logs carry it too.
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validToken(id, maxRequestIDLen) {
+			id = uuid.NewString()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestID returns the ID WithRequestID gave the request ctx belongs to, or "" outside of one
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validToken accepts up to maxLen printable ASCII characters without spaces, so a client can't forge log lines with
+// the IDs and keys it sends
+func validToken(token string, maxLen int) bool {
+	if token == "" || len(token) > maxLen {
+		return false
+	}
+	for i := 0; i < len(token); i++ {
+		if token[i] <= ' ' || token[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -3,20 +3,206 @@ package handlers
 import (
 	"encoding/json"
 	"github.com/gorilla/mux"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
 	"net/http"
 )
 
+// route is one endpoint: what NewRouter serves and what the OpenAPI document says about it
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	doc     routeDoc
+}
+
+var (
+	ifMatchParam        = headerParam("If-Match", "ETags of the versions of the todo the write may apply to; fails with 412 if it has changed since")
+	ifNoneMatchParam    = headerParam("If-None-Match", "ETags the client already has; answers 304 Not Modified if the current one is among them")
+	idempotencyKeyParam = headerParam(IdempotencyKeyHeader, "makes the request safe to retry: retries with the same key get the first response replayed")
+	atomicParam         = queryParam("atomic", &jsonSchema{Type: "boolean"}, "apply every operation or none")
+	cursorParam         = queryParam("cursor", &jsonSchema{Type: "string"}, "where the page starts, from the previous page's next link")
+	filterParam         = queryParam("q", &jsonSchema{Type: "string"}, "a filter in the query language, e.g. status:open")
+	searchParam         = param{in: "query", name: "q", doc: "the words to search for", required: true, schema: &jsonSchema{Type: "string"}}
+	statusParam         = queryParam("status", &jsonSchema{Type: "string", Enum: []string{"open", "completed"}}, "only delete open or completed todos")
+	olderThanParam      = queryParam("older_than", &jsonSchema{Type: "string"}, "only delete todos not updated for that long, e.g. 30d or 12h")
+	confirmParam        = queryParam("confirm", &jsonSchema{Type: "string"}, "confirms deleting every todo, with the token from a 428 response")
+)
+
+func limitParam(max int) param {
+	return queryParam("limit", &jsonSchema{Type: "integer", Minimum: intPtr(1), Maximum: intPtr(max)}, "the most todos to return")
+}
+
+// infraRoutes are the endpoints that aren't part of a version of the API: probes, metrics, echoes and the API's docs
+func infraRoutes(tl TodoListHandler, spec *openAPIDocument) []route {
+	return []route{
+		{"GET", "/", Home, routeDoc{id: "home", summary: "Welcome message", status: 200, response: ""}},
+		{"GET", "/healthz", Healthz, routeDoc{id: "healthz", summary: "Liveness probe", status: 200, response: healthResponse{}}},
+		{"GET", "/readyz", tl.Readyz, routeDoc{id: "readyz", summary: "Readiness probe", status: 200, response: healthResponse{}}},
+		{"GET", "/metrics/cache", tl.CacheStats, routeDoc{
+			id: "cacheStats", summary: "Read cache counters", status: 200, response: cacheStatsResponse{},
+		}},
+		{"GET", "/openapi.json", serveOpenAPI(spec), routeDoc{
+			id: "openAPI", summary: "This OpenAPI document", status: 200, response: map[string]interface{}{},
+		}},
+		{"POST", "/echo", tl.EchoPost, routeDoc{
+			id: "echoPost", summary: "Echo the request body", request: jsonBody(map[string]interface{}{}),
+			status: 200, response: map[string]interface{}{},
+		}},
+		{"PUT", "/echo/{param}", tl.EchoPut, routeDoc{
+			id: "echoPut", summary: "Echo the path parameter and request body", request: jsonBody(echoRequest{}),
+			status: 200, response: echoPutResponse{},
+		}},
+	}
+}
+
+// v1Routes are the endpoints of v1 of the API, which are also served without the /v1 prefix until the legacy sunset
+func v1Routes(tl TodoListHandler) []route {
+	return []route{
+		{"POST", "/todo", tl.Idempotent(tl.Create), routeDoc{
+			id: "createTodo", summary: "Create a todo", params: []param{idempotencyKeyParam},
+			request: jsonBody(createRequest{}), status: 200, response: Todo{},
+		}},
+		{"GET", "/list", tl.GetAll, routeDoc{
+			id: "listTodos", summary: "List todos a page at a time",
+			params: []param{filterParam, limitParam(storage.MaxPageLimit), cursorParam, ifNoneMatchParam},
+			status: 200, response: getAllResponse{},
+		}},
+		{"GET", "/search", tl.Search, routeDoc{
+			id: "searchTodos", summary: "Search todo names and descriptions",
+			params: []param{searchParam, limitParam(storage.MaxSearchLimit)}, status: 200, response: searchResponse{},
+		}},
+		{"PUT", "/todo/{name}", tl.Edit, routeDoc{
+			id: "editTodo", summary: "Replace the todo with a name", params: []param{ifMatchParam},
+			request: jsonBody(editRequest{}), status: 200, response: Todo{},
+		}},
+		{"DELETE", "/todo/{name}", tl.Delete, routeDoc{
+			id: "deleteTodo", summary: "Delete the todo with a name", params: []param{ifMatchParam}, status: 200,
+		}},
+		{"DELETE", "/todos", tl.DeleteMatching, routeDoc{
+			id: "deleteTodos", summary: "Delete the todos matching criteria, or all of them once confirmed",
+			params: []param{statusParam, olderThanParam, filterParam, confirmParam}, status: 200, response: deleteResponse{},
+		}},
+		{"POST", "/todos:batch", tl.Idempotent(tl.Batch), routeDoc{
+			id: "batchTodos", summary: "Create, update and delete todos in one request",
+			params: []param{atomicParam, idempotencyKeyParam}, request: jsonBody(batchRequest{}),
+			status: 207, response: batchResponse{},
+		}},
+		{"GET", "/todos/{id}", tl.Get, routeDoc{
+			id: "getTodo", summary: "Get a todo", params: []param{ifNoneMatchParam}, status: 200, response: Todo{},
+		}},
+		{"PATCH", "/todos/{id}", tl.Patch, routeDoc{
+			id: "patchTodo", summary: "Change some fields of a todo", params: []param{ifMatchParam},
+			request: map[string]interface{}{
+				mergePatchContentType: todoMergePatch{},
+				jsonPatchContentType:  []jsonPatchOperation{},
+			},
+			status: 200, response: Todo{},
+		}},
+	}
+}
+
+// v2Routes are the endpoints of v2 of the API, which addresses every todo by ID under /todos and returns TodoV2s
+func v2Routes(tl TodoListHandler) []route {
+	return []route{
+		{"POST", "/todos", tl.Idempotent(tl.Create), routeDoc{
+			id: "createTodo", summary: "Create a todo", params: []param{idempotencyKeyParam},
+			request: jsonBody(createRequest{}), status: 201, response: TodoV2{},
+		}},
+		{"GET", "/todos", tl.GetAll, routeDoc{
+			id: "listTodos", summary: "List todos a page at a time",
+			params: []param{filterParam, limitParam(storage.MaxPageLimit), cursorParam, ifNoneMatchParam},
+			status: 200, response: listResponseV2{},
+		}},
+		{"DELETE", "/todos", tl.DeleteMatching, routeDoc{
+			id: "deleteTodos", summary: "Delete the todos matching criteria, or all of them once confirmed",
+			params: []param{statusParam, olderThanParam, filterParam, confirmParam}, status: 200, response: deleteResponse{},
+		}},
+		{"POST", "/todos:batch", tl.Idempotent(tl.Batch), routeDoc{
+			id: "batchTodos", summary: "Create, update and delete todos in one request",
+			params: []param{atomicParam, idempotencyKeyParam}, request: jsonBody(batchRequest{}),
+			status: 207, response: batchResponse{},
+		}},
+		{"GET", "/search", tl.Search, routeDoc{
+			id: "searchTodos", summary: "Search todo names and descriptions",
+			params: []param{searchParam, limitParam(storage.MaxSearchLimit)}, status: 200, response: searchResponseV2{},
+		}},
+		{"GET", "/todos/{id}", tl.Get, routeDoc{
+			id: "getTodo", summary: "Get a todo", params: []param{ifNoneMatchParam}, status: 200, response: TodoV2{},
+		}},
+		{"PUT", "/todos/{id}", tl.Edit, routeDoc{
+			id: "editTodo", summary: "Replace a todo", params: []param{ifMatchParam},
+			request: jsonBody(editRequest{}), status: 200, response: TodoV2{},
+		}},
+		{"PATCH", "/todos/{id}", tl.Patch, routeDoc{
+			id: "patchTodo", summary: "Change some fields of a todo", params: []param{ifMatchParam},
+			request: map[string]interface{}{
+				mergePatchContentType: todoMergePatch{},
+				jsonPatchContentType:  []jsonPatchOperation{},
+			},
+			status: 200, response: TodoV2{},
+		}},
+		{"DELETE", "/todos/{id}", tl.Delete, routeDoc{
+			id: "deleteTodo", summary: "Delete a todo", params: []param{ifMatchParam}, status: 204,
+		}},
+	}
+}
+
+// apiRoutes are the routes of every version of the API under its prefix, and of v1 also under the legacy
+// unversioned paths, which say they're deprecated in their headers
+func apiRoutes(tl TodoListHandler) []route {
+	var routes []route
+
+	v1 := tl.withAPIVersion(1)
+	for _, rt := range v1Routes(v1) {
+		legacy := rt
+		legacy.handler = v1.deprecated(rt.handler)
+		legacy.doc.id = "legacy" + upperFirst(rt.doc.id)
+		legacy.doc.tags = []string{"legacy"}
+		legacy.doc.deprecated = true
+
+		rt.path = "/v1" + rt.path
+		rt.doc.id = "v1" + upperFirst(rt.doc.id)
+		rt.doc.tags = []string{"v1"}
+		routes = append(routes, rt, legacy)
+	}
+	for _, rt := range v2Routes(tl.withAPIVersion(2)) {
+		rt.path = "/v2" + rt.path
+		rt.doc.id = "v2" + upperFirst(rt.doc.id)
+		rt.doc.tags = []string{"v2"}
+		routes = append(routes, rt)
+	}
+
+	return routes
+}
+
+// upperFirst upper-cases the first letter of an ASCII identifier, so "getTodo" can follow a prefix as "GetTodo"
+func upperFirst(id string) string {
+	if id == "" || id[0] < 'a' || id[0] > 'z' {
+		return id
+	}
+	return string(id[0]-'a'+'A') + id[1:]
+}
+
+// NewRouter serves the API under /v1 and /v2, v1 also under its legacy unversioned paths, and the infrastructure
+// endpoints, which aren't versioned. /openapi.json documents every route, and API requests are checked against it.
 func NewRouter(tl TodoListHandler) *mux.Router {
 	r := mux.NewRouter()
+	r.Use(WithRequestID, Negotiate)
+	// unmatched requests skip the middleware, so their handlers need their own request ID and negotiation
+	r.NotFoundHandler = WithRequestID(Negotiate(http.HandlerFunc(notFound)))
+	r.MethodNotAllowedHandler = WithRequestID(Negotiate(http.HandlerFunc(methodNotAllowed)))
 
-	r.HandleFunc("/", Home).Methods("GET")
-	r.HandleFunc("/echo", tl.EchoPost).Methods("POST")
-	r.HandleFunc("/echo/{param}", tl.EchoPut).Methods("PUT")
-	r.HandleFunc("/todo", tl.Create).Methods("POST")
-	r.HandleFunc("/list", tl.GetAll).Methods("GET")
-	r.HandleFunc("/todo/{name}", tl.Edit).Methods("PUT")
-	r.HandleFunc("/todo/{name}", tl.Delete).Methods("DELETE")
-	r.HandleFunc("/clear", tl.DeleteAll).Methods("DELETE")
+	spec := &openAPIDocument{}
+	infra, api := infraRoutes(tl, spec), apiRoutes(tl)
+	*spec = newOpenAPIDocument(append(append([]route{}, infra...), api...))
+
+	for _, rt := range infra {
+		r.HandleFunc(rt.path, rt.handler).Methods(rt.method)
+	}
+	// the tenant comes first, so a request that isn't authenticated gets a 401 rather than details of the spec
+	for _, rt := range api {
+		r.HandleFunc(rt.path, tl.WithTenant(tl.withSpecValidation(spec, rt.method, rt.path, rt.handler))).Methods(rt.method)
+	}
 
 	return r
 }
@@ -26,7 +212,7 @@ func Home(w http.ResponseWriter, r *http.Request) {
 	data, err := json.Marshal(msg)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -34,7 +220,7 @@ func Home(w http.ResponseWriter, r *http.Request) {
 	_, err = w.Write(data)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 }
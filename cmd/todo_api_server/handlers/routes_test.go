@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func TestNewRouter_Versions(t *testing.T) {
+	db := storage.NewInMemoryDB()
+	cfgs := &configs.Settings{DatabaseCxnTimeoutSeconds: 5, LegacyRoutesSunset: "2027-04-30", ValidateResponses: true}
+	router := NewRouter(NewTodoListHandler(cfgs, db, tenant.StaticResolver(tenant.Default)))
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// v1 and the legacy routes answer alike; only the legacy ones say they're deprecated
+	legacy := send("POST", "/todo", `{"name": "shopping", "description": "get milk"}`)
+	v1 := send("POST", "/v1/todo", `{"name": "wash car"}`)
+	if legacy.Code != 200 || v1.Code != 200 {
+		t.Fatalf("expected v1 creates to answer 200; got %d and %d", legacy.Code, v1.Code)
+	}
+	expectedHeaders := map[string]string{
+		"Deprecation": "@1792368000",
+		"Sunset":      "Fri, 30 Apr 2027 00:00:00 GMT",
+		"Link":        `</v1/todo>; rel="successor-version"`,
+	}
+	for name, expected := range expectedHeaders {
+		if actual := legacy.Header().Get(name); actual != expected {
+			t.Errorf("legacy route expected %s %q; got %q", name, expected, actual)
+		}
+		if actual := v1.Header().Get(name); actual != "" {
+			t.Errorf("/v1 route expected no %s header; got %q", name, actual)
+		}
+	}
+	var shopping Todo
+	_ = json.Unmarshal(legacy.Body.Bytes(), &shopping)
+
+	created := send("POST", "/v2/todos", `{"name": "invoice"}`)
+	var invoice TodoV2
+	_ = json.Unmarshal(created.Body.Bytes(), &invoice)
+	if created.Code != 201 || created.Header().Get("Location") != "/v2/todos/"+invoice.ID {
+		t.Errorf("v2 create expected 201 with a Location; got %d, %q", created.Code, created.Header().Get("Location"))
+	}
+	if invoice.Version != 1 || invoice.CreatedAt.IsZero() || !invoice.UpdatedAt.Equal(invoice.CreatedAt) {
+		t.Errorf("v2 create expected a version and timestamps; got %+v", invoice)
+	}
+
+	testData := []struct {
+		testName       string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			testName:       "v1 get",
+			method:         "GET",
+			path:           "/v1/todos/" + shopping.ID,
+			expectedStatus: 200,
+			expectedBody:   map[string]interface{}{"id": shopping.ID, "name": "shopping", "description": "get milk", "completed": false},
+		},
+		{
+			testName:       "v2 edit by ID",
+			method:         "PUT",
+			path:           "/v2/todos/" + shopping.ID,
+			body:           `{"name": "groceries", "description": "get milk"}`,
+			expectedStatus: 200,
+		},
+		{
+			testName:       "v2 search nests the todo",
+			method:         "GET",
+			path:           "/v2/search?q=milk",
+			expectedStatus: 200,
+		},
+		{
+			testName:       "v2 delete by ID",
+			method:         "DELETE",
+			path:           "/v2/todos/" + invoice.ID,
+			expectedStatus: 204,
+		},
+		{
+			testName:       "v2 has no name routes",
+			method:         "PUT",
+			path:           "/v2/todo/groceries",
+			body:           `{"name": "groceries", "description": "get milk"}`,
+			expectedStatus: 404,
+		},
+		{
+			testName:       "v2 list",
+			method:         "GET",
+			path:           "/v2/todos?q=status:open",
+			expectedStatus: 200,
+		},
+	}
+
+	for _, tt := range testData {
+		t.Run(tt.testName, func(t *testing.T) {
+			rec := send(tt.method, tt.path, tt.body)
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d; got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if tt.expectedBody != nil {
+				var body map[string]interface{}
+				_ = json.Unmarshal(rec.Body.Bytes(), &body)
+				if diff := cmp.Diff(tt.expectedBody, body); diff != "" {
+					t.Errorf("expected vs actual body don't match: %v", diff)
+				}
+			}
+		})
+	}
+
+	var search searchResponseV2
+	_ = json.Unmarshal(send("GET", "/v2/search?q=milk", "").Body.Bytes(), &search)
+	if len(search.Results) != 1 || search.Results[0].Todo.Name != "groceries" || search.Results[0].Todo.Version != 2 {
+		t.Errorf("v2 search expected the edited todo nested in its result; got %+v", search)
+	}
+
+	var list listResponseV2
+	_ = json.Unmarshal(send("GET", "/v2/todos", "").Body.Bytes(), &list)
+	if len(list.Todos) != 2 {
+		t.Errorf("v2 list expected 2 todos; got %+v", list)
+	}
+	if body := send("GET", "/v2/todos?q=name:nothing", "").Body.String(); !strings.Contains(body, `"todos":[]`) {
+		t.Errorf("v2 list expected an empty todos array; got %s", body)
+	}
+}
+
+func TestUpperFirst(t *testing.T) {
+	testData := []struct {
+		id       string
+		expected string
+	}{
+		{id: "getTodo", expected: "GetTodo"},
+		{id: "GetTodo", expected: "GetTodo"},
+		{id: "v2", expected: "V2"},
+		{id: "", expected: ""},
+		{id: "1st", expected: "1st"},
+	}
+
+	for _, td := range testData {
+		if actual := upperFirst(td.id); actual != td.expected {
+			t.Errorf("upperFirst(%q) expected %q; got %q", td.id, td.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+// NewTenantResolver translates the app settings into the tenant.Resolver picked by TENANT_SOURCE. secret is the
+// token signing secret, only needed for the token source.
+func NewTenantResolver(cfgs *configs.Settings, secret string) (tenant.Resolver, error) {
+	switch cfgs.TenantSource {
+	case "none":
+		return tenant.StaticResolver(tenant.Default), nil
+	case "header":
+		if cfgs.TenantHeader == "" {
+			return nil, errors.New("TENANT_HEADER is required for the header tenant source")
+		}
+		return tenant.HeaderResolver{Header: cfgs.TenantHeader}, nil
+	case "subdomain":
+		if cfgs.TenantBaseDomain == "" {
+			return nil, errors.New("TENANT_BASE_DOMAIN is required for the subdomain tenant source")
+		}
+		return tenant.SubdomainResolver{BaseDomain: cfgs.TenantBaseDomain}, nil
+	case "token":
+		if secret == "" {
+			return nil, errors.New("a token secret is required for the token tenant source")
+		}
+		return tenant.TokenResolver{Secret: []byte(secret), Claim: cfgs.TenantTokenClaim}, nil
+	default:
+		return nil, fmt.Errorf("unknown TENANT_SOURCE %q; want none, header, subdomain or token", cfgs.TenantSource)
+	}
+}
+
+// WithTenant resolves the tenant a request acts for and carries it in the request context, which the DB context of
+// every handler derives from. Requests that don't name a valid tenant are rejected before they reach next.
+func (h TodoListHandler) WithTenant(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := h.tenants.Resolve(r)
+		if err != nil {
+			if errors.Is(err, tenant.ErrUnauthenticated) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="todoapi"`)
+			}
+			writeError(w, r, err)
+			return
+		}
+
+		next(w, r.WithContext(tenant.WithID(r.Context(), id)))
+	}
+}
@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// withSpecValidation checks a request against the operation the OpenAPI document has for its route before it reaches
+// next: its path, query and header parameters, its Content-Type and its body. A request that doesn't conform gets the
+// problem the handler would report for the same mistake. With VALIDATE_RESPONSES, meant for tests, the response is
+// held back and checked too; one that doesn't conform is replaced by a 500, so a handler regression can't go unseen.
+func (h TodoListHandler) withSpecValidation(spec *openAPIDocument, method, path string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		op := spec.Paths[path][strings.ToLower(method)]
+		schemas := schemaValidator{schemas: spec.Components.Schemas}
+
+		if err := schemas.checkParameters(r, op.Parameters); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if op.RequestBody != nil {
+			if err := schemas.checkBody(w, r, op.RequestBody.Content); err != nil {
+				writeError(w, r, err)
+				return
+			}
+		}
+
+		if !h.validateResponses {
+			next(w, r)
+			return
+		}
+
+		// a handler that writes nothing answers 200, as it would without the buffer
+		buffered := &bufferedResponse{header: http.Header{}}
+		next(buffered, r)
+		buffered.WriteHeader(http.StatusOK)
+		if err := schemas.checkResponse(buffered, op.Responses); err != nil {
+			log.Printf("request %s: %s %s: the response doesn't match the API spec: %v", RequestID(r.Context()), r.Method, r.URL.Path, err)
+			writeProblem(w, r, newProblem(http.StatusInternalServerError, CodeInternal, "the response doesn't match the API spec: "+err.Error()))
+			return
+		}
+		buffered.flush(w)
+	}
+}
+
+// schemaValidator checks values against the schemas of the OpenAPI document
+type schemaValidator struct {
+	schemas map[string]*jsonSchema
+}
+
+// schemaError is a value that breaks a schema: where the value is, e.g. operations[0].op, the keyword it breaks and
+// why, in words that follow the field
+type schemaError struct {
+	field   string
+	keyword string
+	message string
+	// got is the JSON type of a value of the wrong type
+	got string
+}
+
+func (e schemaError) Error() string {
+	if e.field == "" {
+		return "the value " + e.message
+	}
+	return e.field + " " + e.message
+}
+
+func (v schemaValidator) checkParameters(r *http.Request, params []openAPIParameter) error {
+	for _, p := range params {
+		var raw string
+		var present bool
+		switch p.In {
+		case "path":
+			raw, present = mux.Vars(r)[p.Name]
+		case "query":
+			var values []string
+			values, present = r.URL.Query()[p.Name]
+			if present {
+				raw = values[0]
+			}
+		case "header":
+			raw, present = r.Header.Get(p.Name), r.Header.Get(p.Name) != ""
+		}
+		if !present || raw == "" {
+			if p.Required {
+				return badRequest(CodeInvalidParameter, "missing '%s' parameter in request url", p.Name)
+			}
+			continue
+		}
+
+		value, ok := parameterValue(raw, p.Schema)
+		if !ok || len(v.check(p.Schema, value, "")) > 0 {
+			return badRequest(CodeInvalidParameter, "'%s' %s", p.Name, describe(p.Schema))
+		}
+	}
+	return nil
+}
+
+// parameterValue converts a parameter to the JSON value its schema expects
+func parameterValue(raw string, schema *jsonSchema) (interface{}, bool) {
+	switch schema.Type {
+	case "integer", "number":
+		n, err := strconv.ParseFloat(raw, 64)
+		return n, err == nil
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		return b, err == nil
+	default:
+		return raw, true
+	}
+}
+
+// describe says what a parameter must be, in the words the handlers use
+func describe(schema *jsonSchema) string {
+	switch {
+	case schema.Type == "boolean":
+		return "must be true or false"
+	case len(schema.Enum) > 0:
+		return "must be " + strings.Join(schema.Enum[:len(schema.Enum)-1], ", ") + " or " + schema.Enum[len(schema.Enum)-1]
+	case schema.Type == "integer" && schema.Minimum != nil && schema.Maximum != nil:
+		return fmt.Sprintf("must be a number from %d to %d", *schema.Minimum, *schema.Maximum)
+	case schema.Type == "integer" || schema.Type == "number":
+		return "must be a number"
+	default:
+		return "is invalid"
+	}
+}
+
+// checkBody checks the request's Content-Type is one the operation takes and its body matches the schema for it. A
+// request without a Content-Type is taken to be JSON, as it always has been. The body is put back for the handler.
+func (v schemaValidator) checkBody(w http.ResponseWriter, r *http.Request, content map[string]openAPIMediaType) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, ok := content[mediaType]
+	if !ok {
+		var accepted []string
+		for accept := range content {
+			accepted = append(accepted, accept)
+		}
+		// Negotiate turns these into JSON before they get here
+		if _, ok := content["application/json"]; ok {
+			accepted = append(accepted, decodableTypes()...)
+		}
+		sort.Strings(accepted)
+		if r.Method == "PATCH" {
+			w.Header().Set("Accept-Patch", strings.Join(accepted, ", "))
+		}
+		return &apiError{
+			status: http.StatusUnsupportedMediaType,
+			code:   CodeUnsupportedMediaType,
+			detail: "the body must be " + strings.Join(accepted, " or "),
+		}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return badRequest(CodeMalformedJSON, "failed to read the request body")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var value interface{}
+	if err = json.Unmarshal(body, &value); err != nil {
+		return badRequest(CodeMalformedJSON, "the request body isn't valid JSON")
+	}
+
+	errs := v.check(media.Schema, value, "")
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, e := range errs {
+		if e.keyword == "type" {
+			if e.field == "" {
+				return badRequest(CodeMalformedJSON, "the request body isn't valid JSON")
+			}
+			// named like encoding/json names them, as decodeJSON reports them
+			got := map[string]string{"integer": "number", "boolean": "bool"}[e.got]
+			if got == "" {
+				got = e.got
+			}
+			return badRequest(CodeMalformedJSON, "field '%s' has the wrong type: got a JSON %s", e.field, got)
+		}
+	}
+
+	invalid := &fieldsError{}
+	for _, e := range errs {
+		invalid.errors = append(invalid.errors, FieldError{Field: e.field, Rule: validateRule(e.keyword), Message: e.Error()})
+	}
+	return invalid
+}
+
+// fieldsError is a request body with fields that break the spec
+type fieldsError struct {
+	errors []FieldError
+}
+
+func (e *fieldsError) Error() string {
+	return "the request body has invalid fields"
+}
+
+// validateRule names a JSON Schema keyword after the validate rule it comes from, which is what clients know it by
+func validateRule(keyword string) string {
+	switch keyword {
+	case "minLength", "minItems", "minimum":
+		return "min"
+	case "maxLength", "maxItems", "maximum":
+		return "max"
+	case "enum":
+		return "oneof"
+	default:
+		return keyword
+	}
+}
+
+// check returns how value breaks schema; field is where the value is in the body
+func (v schemaValidator) check(schema *jsonSchema, value interface{}, field string) []schemaError {
+	if schema.Ref != "" {
+		return v.check(v.schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")], value, field)
+	}
+
+	// a value that matches none of the options is reported against the first, which for a nullable field is its type
+	if len(schema.AnyOf) > 0 {
+		for _, option := range schema.AnyOf[1:] {
+			if len(v.check(option, value, field)) == 0 {
+				return nil
+			}
+		}
+		return v.check(schema.AnyOf[0], value, field)
+	}
+
+	if got := jsonType(value); schema.Type != "" && got != schema.Type && !(schema.Type == "number" && got == "integer") {
+		return []schemaError{{field: field, keyword: "type", message: "must be a JSON " + schema.Type, got: got}}
+	}
+
+	var errs []schemaError
+	switch value := value.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := value[name]
+			if !ok {
+				if contains(schema.Required, name) {
+					errs = append(errs, schemaError{field: join(field, name), keyword: "required", message: "is required"})
+				}
+				continue
+			}
+			errs = append(errs, v.check(schema.Properties[name], property, join(field, name))...)
+		}
+		if schema.AdditionalProperties != nil {
+			for name, property := range value {
+				errs = append(errs, v.check(schema.AdditionalProperties, property, join(field, name))...)
+			}
+		}
+	case []interface{}:
+		if schema.MinItems != nil && len(value) < *schema.MinItems {
+			errs = append(errs, schemaError{field: field, keyword: "minItems", message: fmt.Sprintf("must be at least %d items", *schema.MinItems)})
+		}
+		if schema.MaxItems != nil && len(value) > *schema.MaxItems {
+			errs = append(errs, schemaError{field: field, keyword: "maxItems", message: fmt.Sprintf("must be at most %d items", *schema.MaxItems)})
+		}
+		if schema.Items != nil {
+			for i, item := range value {
+				errs = append(errs, v.check(schema.Items, item, fmt.Sprintf("%s[%d]", field, i))...)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(value)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			errs = append(errs, schemaError{field: field, keyword: "minLength", message: fmt.Sprintf("must be at least %d characters long", *schema.MinLength)})
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			errs = append(errs, schemaError{field: field, keyword: "maxLength", message: fmt.Sprintf("must be at most %d characters long", *schema.MaxLength)})
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, value) {
+			errs = append(errs, schemaError{field: field, keyword: "enum", message: "must be one of " + strings.Join(schema.Enum, ", ")})
+		}
+	case float64:
+		if schema.Minimum != nil && value < float64(*schema.Minimum) {
+			errs = append(errs, schemaError{field: field, keyword: "minimum", message: fmt.Sprintf("must be at least %d", *schema.Minimum)})
+		}
+		if schema.Maximum != nil && value > float64(*schema.Maximum) {
+			errs = append(errs, schemaError{field: field, keyword: "maximum", message: fmt.Sprintf("must be at most %d", *schema.Maximum)})
+		}
+	}
+	return errs
+}
+
+// jsonType is the JSON Schema type of a decoded JSON value; whole numbers are integers
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkResponse checks a response's status is documented and its body matches the schema for it
+func (v schemaValidator) checkResponse(resp *bufferedResponse, responses map[string]openAPIResponse) error {
+	documented, ok := responses[strconv.Itoa(resp.status)]
+	if !ok {
+		if documented, ok = responses["default"]; !ok || resp.status < http.StatusBadRequest {
+			return fmt.Errorf("status %d isn't documented", resp.status)
+		}
+	}
+
+	if resp.body.Len() == 0 {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.header.Get("Content-Type"))
+	media, ok := documented.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("a %d response can't have a %q body", resp.status, mediaType)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(resp.body.Bytes(), &value); err != nil {
+		return fmt.Errorf("the body isn't valid JSON: %v", err)
+	}
+	if errs := v.check(media.Schema, value, ""); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// bufferedResponse holds a response back, so it can be checked before it's sent
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(data)
+}
+
+func (b *bufferedResponse) flush(w http.ResponseWriter) {
+	for name, values := range b.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/mux"
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func TestWithSpecValidation(t *testing.T) {
+	db := storage.NewInMemoryDB()
+	shopping, _ := db.SaveTodo(tenant.WithID(httptest.NewRequest("GET", "/", nil).Context(), tenant.Default), "shopping", "get milk")
+	cfgs := &configs.Settings{DatabaseCxnTimeoutSeconds: 5, ValidateResponses: true}
+	router := NewRouter(NewTodoListHandler(cfgs, db, tenant.StaticResolver(tenant.Default)))
+
+	testData := []struct {
+		testName       string
+		method         string
+		path           string
+		contentType    string
+		body           string
+		expectedStatus int
+		expected       Problem
+	}{
+		{
+			testName:       "enum query parameter",
+			method:         "DELETE",
+			path:           "/v2/todos?status=done",
+			expectedStatus: 400,
+			expected:       Problem{Code: CodeInvalidParameter, Detail: "'status' must be open or completed"},
+		},
+		{
+			testName:       "boolean query parameter",
+			method:         "POST",
+			path:           "/v1/todos:batch?atomic=maybe",
+			body:           `{"operations": [{"op": "create", "name": "dentist"}]}`,
+			expectedStatus: 400,
+			expected:       Problem{Code: CodeInvalidParameter, Detail: "'atomic' must be true or false"},
+		},
+		{
+			testName:       "integer query parameter",
+			method:         "GET",
+			path:           "/v2/search?q=milk&limit=lots",
+			expectedStatus: 400,
+			expected:       Problem{Code: CodeInvalidParameter, Detail: "'limit' must be a number from 1 to 100"},
+		},
+		{
+			testName:       "required query parameter",
+			method:         "GET",
+			path:           "/v2/search",
+			expectedStatus: 400,
+			expected:       Problem{Code: CodeInvalidParameter, Detail: "missing 'q' parameter in request url"},
+		},
+		{
+			testName:       "unsupported content type",
+			method:         "POST",
+			path:           "/v2/todos",
+			contentType:    "application/x-www-form-urlencoded",
+			body:           "name=dentist",
+			expectedStatus: 415,
+			expected:       Problem{Code: CodeUnsupportedMediaType, Detail: "the body must be application/json or application/msgpack or application/yaml"},
+		},
+		{
+			testName:       "nested fields",
+			method:         "PATCH",
+			path:           "/v2/todos/" + shopping.ID,
+			contentType:    "application/json-patch+json",
+			body:           `[{"op": "replace", "path": "/name", "value": "groceries"}, {"op": "rename"}]`,
+			expectedStatus: 400,
+			expected: Problem{Code: CodeValidationFailed, Detail: "the request body has invalid fields", Errors: []FieldError{
+				{Field: "[1].op", Rule: "oneof", Message: "[1].op must be one of add, remove, replace, move, copy, test"},
+				{Field: "[1].path", Rule: "required", Message: "[1].path is required"},
+			}},
+		},
+		{
+			testName:       "list bounds",
+			method:         "POST",
+			path:           "/v2/todos:batch",
+			body:           `{"operations": []}`,
+			expectedStatus: 400,
+			expected: Problem{Code: CodeValidationFailed, Detail: "the request body has invalid fields", Errors: []FieldError{
+				{Field: "operations", Rule: "min", Message: "operations must be at least 1 items"},
+			}},
+		},
+		{
+			testName:       "wrong type",
+			method:         "PUT",
+			path:           "/v2/todos/" + shopping.ID,
+			body:           `{"name": 5, "description": "get milk"}`,
+			expectedStatus: 400,
+			expected:       Problem{Code: CodeMalformedJSON, Detail: "field 'name' has the wrong type: got a JSON number"},
+		},
+		{
+			testName:       "null in a merge patch",
+			method:         "PATCH",
+			path:           "/v2/todos/" + shopping.ID,
+			contentType:    "application/merge-patch+json",
+			body:           `{"description": null, "completed": true}`,
+			expectedStatus: 200,
+		},
+		{
+			testName:       "no content type is JSON",
+			method:         "POST",
+			path:           "/v2/todos",
+			body:           `{"name": "dentist"}`,
+			expectedStatus: 201,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			req := httptest.NewRequest(td.method, td.path, strings.NewReader(td.body))
+			if td.contentType != "" {
+				req.Header.Set("Content-Type", td.contentType)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != td.expectedStatus {
+				t.Fatalf("expected status %d; got %d: %s", td.expectedStatus, rec.Code, rec.Body)
+			}
+			if td.expected.Code == "" {
+				return
+			}
+
+			var actual Problem
+			_ = json.Unmarshal(rec.Body.Bytes(), &actual)
+			expected := td.expected
+			expected.Type = "urn:todoapi:problem:" + expected.Code
+			expected.Title = http.StatusText(td.expectedStatus)
+			expected.Status = td.expectedStatus
+			expected.Instance = req.URL.Path
+			expected.RequestID = actual.RequestID
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Errorf("expected vs actual problem don't match: %v", diff)
+			}
+		})
+	}
+}
+
+func TestWithSpecValidation_Responses(t *testing.T) {
+	testData := []struct {
+		testName       string
+		handler        http.HandlerFunc
+		expectedStatus int
+	}{
+		{
+			testName: "conforming",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"id": "1", "name": "shopping", "description": "", "completed": false}`))
+			},
+			expectedStatus: 200,
+		},
+		{
+			testName: "missing field",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"id": "1", "name": "shopping"}`))
+			},
+			expectedStatus: 500,
+		},
+		{
+			testName: "undocumented status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+			},
+			expectedStatus: 500,
+		},
+		{
+			testName: "problem",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, r, storage.ErrNotFound)
+			},
+			expectedStatus: 404,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			h := NewTodoListHandler(&configs.Settings{ValidateResponses: true}, storage.NewInMemoryDB(), tenant.StaticResolver(tenant.Default))
+			spec := newOpenAPIDocument(apiRoutes(h))
+			handler := h.withSpecValidation(&spec, "GET", "/v1/todos/{id}", td.handler)
+
+			rec := httptest.NewRecorder()
+			handler(rec, mux.SetURLVars(httptest.NewRequest("GET", "/v1/todos/1", nil), map[string]string{"id": "1"}))
+			if rec.Code != td.expectedStatus {
+				t.Errorf("expected status %d; got %d: %s", td.expectedStatus, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestWithSpecValidation_Unauthenticated(t *testing.T) {
+	cfgs := &configs.Settings{DatabaseCxnTimeoutSeconds: 5}
+	tenants := tenant.TokenResolver{Secret: []byte("secret"), Claim: "tenant"}
+	router := NewRouter(NewTodoListHandler(cfgs, storage.NewInMemoryDB(), tenants))
+
+	// requests that would fail validation are turned away for want of a token first, without details of the spec
+	testData := []struct {
+		testName    string
+		method      string
+		path        string
+		contentType string
+		body        string
+	}{
+		{testName: "invalid body", method: "POST", path: "/v2/todos", body: `{"name": 5}`},
+		{testName: "unsupported body", method: "POST", path: "/v2/todos", contentType: "text/plain", body: "dentist"},
+		{testName: "invalid query parameter", method: "DELETE", path: "/v2/todos?status=done"},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			req := httptest.NewRequest(td.method, td.path, strings.NewReader(td.body))
+			if td.contentType != "" {
+				req.Header.Set("Content-Type", td.contentType)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("expected status 401; got %d: %s", rec.Code, rec.Body)
+			}
+			var actual Problem
+			_ = json.Unmarshal(rec.Body.Bytes(), &actual)
+			if actual.Code != CodeUnauthenticated || rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("expected an unauthenticated problem with a WWW-Authenticate challenge; got %+v, %v", actual, rec.Header())
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/todo"
+)
+
+// legacyDeprecation is when the unversioned routes were deprecated in favour of the same routes under /v1
+var legacyDeprecation = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// parseSunset parses LEGACY_ROUTES_SUNSET, a date like 2027-04-30. A bad date is logged and leaves out the Sunset
+// header rather than stop the server.
+func parseSunset(param string) time.Time {
+	if param == "" {
+		return time.Time{}
+	}
+	sunset, err := time.Parse("2006-01-02", param)
+	if err != nil {
+		log.Printf("ignoring LEGACY_ROUTES_SUNSET %q: %v", param, err)
+		return time.Time{}
+	}
+	return sunset
+}
+
+// withAPIVersion returns a copy of the handler that writes the models of the given version of the API
+func (h TodoListHandler) withAPIVersion(version int) TodoListHandler {
+	h.apiVersion = version
+	return h
+}
+
+// deprecated serves a legacy unversioned route, telling clients that it's deprecated, when it goes away, and that
+// the same route under /v1 replaces it
+func (h TodoListHandler) deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyDeprecation.Unix()))
+		if !h.legacySunset.IsZero() {
+			w.Header().Set("Sunset", h.legacySunset.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Add("Link", fmt.Sprintf("</v1%s>; rel=\"successor-version\"", r.URL.EscapedPath()))
+		next(w, r)
+	}
+}
+
+// todoView is the response model of a todo in the handler's version of the API
+func (h TodoListHandler) todoView(item todo.Todo) interface{} {
+	if h.apiVersion >= 2 {
+		return todoV2(item)
+	}
+	return Todo{
+		ID:          item.ID,
+		Name:        item.Name,
+		Description: item.Description,
+		Completed:   item.Completed,
+	}
+}
+
+func todoV2(item todo.Todo) TodoV2 {
+	return TodoV2{
+		ID:          item.ID,
+		Name:        item.Name,
+		Description: item.Description,
+		Completed:   item.Completed,
+		CreatedAt:   item.CreatedAt,
+		UpdatedAt:   item.UpdatedAt,
+		Version:     item.Version,
+	}
+}
+
+// listView is the response model of a page of the list. v1 leaves out an empty list; v2 always has one.
+func (h TodoListHandler) listView(items []todo.Todo, next string) interface{} {
+	if h.apiVersion >= 2 {
+		resp := listResponseV2{Todos: []TodoV2{}, Next: next}
+		for _, item := range items {
+			resp.Todos = append(resp.Todos, todoV2(item))
+		}
+		return resp
+	}
+
+	resp := getAllResponse{Next: next}
+	for _, item := range items {
+		resp.List = append(resp.List, h.todoView(item).(Todo))
+	}
+	return resp
+}
+
+// searchView is the response model of search results
+func (h TodoListHandler) searchView(results []todo.SearchResult) interface{} {
+	if h.apiVersion >= 2 {
+		resp := searchResponseV2{Results: []searchResultV2{}}
+		for _, result := range results {
+			resp.Results = append(resp.Results, searchResultV2{
+				Todo:  todoV2(result.Todo),
+				Score: result.Score,
+				Highlights: highlights{
+					Name:        result.NameSnippet,
+					Description: result.DescriptionSnippet,
+				},
+			})
+		}
+		return resp
+	}
+
+	resp := searchResponse{Results: []searchResult{}}
+	for _, result := range results {
+		resp.Results = append(resp.Results, searchResult{
+			Todo:  h.todoView(result.Todo).(Todo),
+			Score: result.Score,
+			Highlights: highlights{
+				Name:        result.NameSnippet,
+				Description: result.DescriptionSnippet,
+			},
+		})
+	}
+	return resp
+}
@@ -1,15 +1,37 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/us-learn-and-devops/todoapi/cmd/todo_api_server/handlers"
 	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+	"github.com/us-learn-and-devops/todoapi/internal/outbox"
 	envcfg "github.com/us-learn-and-devops/todoapi/pkg/envconfig"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
+const usage = `usage: todo_api_server [command] [flags]
+
+commands:
+  serve     run the API server (default)
+  migrate   show, apply or revert todo schema migrations; see 'todo_api_server migrate -h'
+  rebuild-projection
+            replay the todo event stream into a fresh snapshot and the todos collection
+  reencrypt encrypt every todo description with the primary encryption key
+  backup    write every tenant's todos to a compressed snapshot file
+  restore   load a snapshot written by backup into the configured backend
+`
+
 func main() {
 	cfgs := &configs.Settings{}
 	err := envcfg.Unmarshal(cfgs)
@@ -17,29 +39,262 @@ func main() {
 		log.Fatalf("failed to get configs: %s", err)
 	}
 
-	var dbCreds handlers.DBCredentials
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve(cfgs)
+	case "migrate":
+		migrate(cfgs, args)
+	case "rebuild-projection":
+		rebuildProjection(cfgs, args)
+	case "reencrypt":
+		reencrypt(cfgs, args)
+	case "backup":
+		backupTodos(cfgs, args)
+	case "restore":
+		restoreTodos(cfgs, args)
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		log.Fatalf("unknown command %q", command)
+	}
+}
+
+func serve(cfgs *configs.Settings) {
+	mongoDB, err := openMongoDB(cfgs)
+	if err != nil {
+		log.Fatalf("failed to connect to DB: %v", err)
+	}
+
+	if cfgs.DatabaseMigrateOnStartup {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		steps, err := mongoDB.Migrate(ctx, storage.MigrateLatest, false)
+		cancel()
+		if err != nil {
+			log.Fatalf("failed to migrate DB: %v", err)
+		}
+		log.Printf("applied %d DB migrations", len(steps))
+	}
 
-	dbUsername, err := ioutil.ReadFile(cfgs.DatabaseUserNameFilePath)
+	db, err := openBackend(cfgs, mongoDB)
 	if err != nil {
-		log.Fatalf("failed to get DB username: %v", err)
+		log.Fatal(err)
+	}
+
+	// the relay reads the outbox of the backend, which is the MongoDB outbox collection or the event-sourced
+	// backend's commits, decrypting the events if they're encrypted
+	outboxDB, ok := db.(storage.Outbox)
+	if !ok {
+		log.Fatalf("DB_BACKEND %q has no outbox to relay", cfgs.DatabaseBackend)
+	}
+
+	if cfgs.CacheEnabled {
+		db = storage.NewCachedDB(db, storage.CacheConfig{
+			Size: int(cfgs.CacheSize),
+			TTL:  time.Duration(cfgs.CacheTTLSeconds) * time.Second,
+		})
 	}
-	dbCreds.Username = string(dbUsername)
 
-	dbPswd, err := ioutil.ReadFile(cfgs.DatabasePswdFilePath)
+	sink, err := newOutboxSink(cfgs)
 	if err != nil {
-		log.Fatalf("failed to get DB password: %v", err)
+		log.Fatalf("failed to set up outbox relay: %v", err)
 	}
-	dbCreds.Password = string(dbPswd)
 
-	tl, err:= handlers.NewTodoListHandler(cfgs, dbCreds)
+	tenants, err := newTenantResolver(cfgs)
 	if err != nil {
-		log.Fatalf("failed to create TodoListHandler: %v", err)
+		log.Fatalf("failed to set up tenant resolution: %v", err)
 	}
 
+	tl := handlers.NewTodoListHandler(cfgs, db, tenants)
+
 	r := handlers.NewRouter(tl)
 
 	host := fmt.Sprintf("%s:%s", cfgs.ServerAddr, cfgs.ServerPort)
+	srv := &http.Server{
+		Addr:    host,
+		Handler: r,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	relayDone := make(chan struct{})
+	if sink != nil {
+		relay := outbox.NewRelay(outboxDB, sink, outbox.RelayConfig{
+			BatchSize:    int(cfgs.OutboxBatchSize),
+			PollInterval: time.Duration(cfgs.OutboxPollIntervalSeconds) * time.Second,
+			Lease:        mongoDB,
+			LeaseTTL:     time.Duration(cfgs.OutboxLeaseTTLSeconds) * time.Second,
+		})
+		go func() {
+			defer close(relayDone)
+			relay.Run(ctx)
+		}()
+	} else {
+		close(relayDone)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("serving todo-api on %s\n", host)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	shutdownTimeout := time.Duration(cfgs.ServerShutdownTimeoutSeconds) * time.Second
+
+	select {
+	case err = <-serveErr:
+		closeCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if closeErr := tl.Close(closeCtx); closeErr != nil {
+			log.Printf("failed to close DB connection: %v", closeErr)
+		}
+		log.Fatalf("server stopped: %v", err)
+	case <-ctx.Done():
+		log.Print("shutting down todo-api")
+	}
+
+	tl.Drain()
+	select {
+	case <-time.After(time.Duration(cfgs.ServerDrainDelaySeconds) * time.Second):
+	case err = <-serveErr:
+		log.Printf("server stopped while draining: %v", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// stop accepting requests and let in-flight ones finish before the DB connection goes away
+	if err = srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to shut down server gracefully: %v", err)
+	}
+
+	// the relay stops with ctx; let it finish its batch before the DB connection goes away
+	<-relayDone
+
+	if err = tl.Close(shutdownCtx); err != nil {
+		log.Printf("failed to close DB connection: %v", err)
+	}
+}
+
+// openBackend builds the storage.DB that DB_BACKEND and the encryption settings describe on top of mongoDB
+func openBackend(cfgs *configs.Settings, mongoDB *storage.MongoDB) (db storage.DB, err error) {
+	switch cfgs.DatabaseBackend {
+	case "mongodb":
+		db = mongoDB
+	case "eventsourced":
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		db, err = storage.NewEventSourcedDB(ctx, storage.NewMongoEventStore(mongoDB), storage.EventSourcedConfig{
+			SnapshotEvery: int(cfgs.DatabaseSnapshotEvery),
+		})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load todo events: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown DB_BACKEND %q; want mongodb or eventsourced", cfgs.DatabaseBackend)
+	}
+
+	if cfgs.EncryptionEnabled {
+		keys, err := storage.LoadKeyRing(cfgs.EncryptionKeysDir, cfgs.EncryptionPrimaryKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load encryption keys: %v", err)
+		}
+		db = storage.NewEncryptedDB(db, keys)
+	}
+
+	return db, nil
+}
+
+// newOutboxSink builds the sink the outbox relay publishes to, or returns nil if this instance shouldn't relay
+func newOutboxSink(cfgs *configs.Settings) (outbox.Sink, error) {
+	switch cfgs.OutboxSink {
+	case "none":
+		return nil, nil
+	case "log":
+		return outbox.LogSink{}, nil
+	case "webhook":
+		if cfgs.OutboxWebhookURL == "" {
+			return nil, errors.New("OUTBOX_WEBHOOK_URL is required for the webhook sink")
+		}
+		return outbox.NewWebhookSink(cfgs.OutboxWebhookURL, time.Duration(cfgs.OutboxWebhookTimeoutSeconds)*time.Second), nil
+	case "file":
+		if cfgs.OutboxFilePath == "" {
+			return nil, errors.New("OUTBOX_FPATH is required for the file sink")
+		}
+		return &outbox.FileSink{Path: cfgs.OutboxFilePath}, nil
+	default:
+		return nil, fmt.Errorf("unknown OUTBOX_SINK %q; want log, webhook, file or none", cfgs.OutboxSink)
+	}
+}
+
+// newTenantResolver reads the token secret if the token source needs one and builds the tenant resolver
+func newTenantResolver(cfgs *configs.Settings) (tenant.Resolver, error) {
+	var secret string
+	if cfgs.TenantSource == "token" {
+		var err error
+		if secret, err = readSecret(cfgs.TenantTokenSecretFilePath, true); err != nil {
+			return nil, fmt.Errorf("failed to get tenant token secret: %v", err)
+		}
+	}
+
+	return handlers.NewTenantResolver(cfgs, strings.TrimSpace(secret))
+}
+
+// openMongoDB reads the DB credentials and connects to the MongoDB described by cfgs
+func openMongoDB(cfgs *configs.Settings) (*storage.MongoDB, error) {
+	// credentials may instead be embedded in a full DB_URI, in which case the secret files are optional, and only
+	// read, replacing the URI's credentials, if their paths are set explicitly
+	credsRequired := cfgs.DatabaseURI == ""
+	_, usernameFileSet := os.LookupEnv("DB_USERNAME_FPATH")
+	_, pswdFileSet := os.LookupEnv("DB_PSWD_FPATH")
+
+	var dbCreds handlers.DBCredentials
+	if !storage.URIHasCredentials(cfgs.DatabaseURI) || usernameFileSet || pswdFileSet {
+		dbUsername, err := readSecret(cfgs.DatabaseUserNameFilePath, credsRequired)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get DB username: %v", err)
+		}
+		dbCreds.Username = dbUsername
+
+		dbPswd, err := readSecret(cfgs.DatabasePswdFilePath, credsRequired)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get DB password: %v", err)
+		}
+		dbCreds.Password = dbPswd
+	}
+
+	switch {
+	case dbCreds.Username != "":
+		log.Printf("using DB credentials from %s", cfgs.DatabaseUserNameFilePath)
+	case storage.URIHasCredentials(cfgs.DatabaseURI):
+		log.Print("using DB credentials from DB_URI")
+	default:
+		log.Print("connecting to the DB without credentials")
+	}
+
+	mongoCfg, err := handlers.NewMongoConfig(cfgs, dbCreds)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.NewMongoDB(mongoCfg)
+}
+
+// readSecret returns the contents of the secret file at fpath. A missing file is only an error if the secret is required.
+func readSecret(fpath string, required bool) (string, error) {
+	secret, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		if !required && errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
 
-	log.Printf("serving todo-api on %s\n", host)
-	log.Fatal(http.ListenAndServe(host, r))
+	return string(secret), nil
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+const migrateUsage = `usage: todo_api_server migrate [flags] [status|up|down]
+
+  status   list registered migrations and whether each is applied (default)
+  up       apply migrations up to -to (default: all)
+  down     revert migrations above -to (default: revert only the newest applied migration)
+
+flags:
+`
+
+func migrate(cfgs *configs.Settings, args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	target := fs.Int("to", storage.MigrateLatest, "target schema version")
+	dryRun := fs.Bool("dry-run", false, "print the migration plan without applying it")
+	timeout := fs.Duration("timeout", 10*time.Minute, "give up if migrations (including waiting for the lock) take longer than this")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	action := "status"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+
+	db, err := openMongoDB(cfgs)
+	if err != nil {
+		log.Fatalf("failed to connect to DB: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	defer db.Close(context.Background())
+
+	switch action {
+	case "status":
+		statuses, err := db.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatalf("failed to get migration status: %v", err)
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied"
+			}
+			fmt.Printf("%04d %-7s %s\n", status.Version, applied, status.Description)
+		}
+		return
+	case "up":
+	case "down":
+		if *target == storage.MigrateLatest {
+			*target, err = previousVersion(ctx, db)
+			if err != nil {
+				log.Fatalf("failed to get migration status: %v", err)
+			}
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	steps, err := db.Migrate(ctx, *target, *dryRun)
+	for _, step := range steps {
+		if *dryRun {
+			fmt.Printf("would apply %s\n", step)
+		} else {
+			fmt.Printf("applied %s\n", step)
+		}
+	}
+	if err != nil {
+		log.Fatalf("migration failed: %v", err)
+	}
+	if len(steps) == 0 {
+		fmt.Println("schema is already at the target version")
+	}
+}
+
+// previousVersion returns the version just below the newest applied migration
+func previousVersion(ctx context.Context, db *storage.MongoDB) (int, error) {
+	statuses, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	previous, newest := 0, 0
+	for _, status := range statuses {
+		if status.Applied {
+			previous, newest = newest, status.Version
+		}
+	}
+	if newest == 0 {
+		return 0, nil
+	}
+
+	return previous, nil
+}
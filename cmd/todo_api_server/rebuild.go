@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+const rebuildUsage = `usage: todo_api_server rebuild-projection [flags]
+
+Replays the whole todo event stream, ignoring snapshots, then saves the result as a fresh snapshot and copies it
+into the todos collection, so the eventsourced and mongodb backends serve the same list.
+
+flags:
+`
+
+func rebuildProjection(cfgs *configs.Settings, args []string) {
+	fs := flag.NewFlagSet("rebuild-projection", flag.ExitOnError)
+	snapshot := fs.Bool("snapshot", true, "save the replayed list as a snapshot")
+	todos := fs.Bool("todos", true, "replace the contents of the todos collection with the replayed list")
+	dryRun := fs.Bool("dry-run", false, "only replay the events and report the result")
+	timeout := fs.Duration("timeout", 10*time.Minute, "give up if the rebuild takes longer than this")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), rebuildUsage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	db, err := openMongoDB(cfgs)
+	if err != nil {
+		log.Fatalf("failed to connect to DB: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	defer db.Close(context.Background())
+
+	store := storage.NewMongoEventStore(db)
+
+	list, seq, err := storage.ReplayEvents(ctx, store)
+	if err != nil {
+		log.Fatalf("failed to replay todo events: %v", err)
+	}
+	fmt.Printf("replayed %d commits into %d todos\n", seq, len(list))
+
+	if *dryRun {
+		return
+	}
+
+	if *snapshot {
+		if err = store.SaveSnapshot(ctx, storage.Snapshot{Seq: seq, Todos: list, TakenAt: time.Now().UTC()}); err != nil {
+			log.Fatalf("failed to save snapshot: %v", err)
+		}
+		fmt.Printf("saved snapshot at commit %d\n", seq)
+	}
+
+	if *todos {
+		if err = db.ReplaceTodos(ctx, list); err != nil {
+			log.Fatalf("failed to write todos collection: %v", err)
+		}
+		fmt.Println("replaced the todos collection")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/configs"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+const reencryptUsage = `usage: todo_api_server reencrypt [flags]
+
+Encrypts every todo description that is still plaintext, or encrypted with a key other than ENCRYPTION_PRIMARY_KEY,
+with the primary key, across all tenants. Once it has run, keys other than the primary can be removed from
+ENCRYPTION_KEYS_DIR, except while the eventsourced backend's events or unpublished outbox events still use them.
+
+To rotate keys: add the new key file, make it ENCRYPTION_PRIMARY_KEY, restart every instance, then run this.
+
+flags:
+`
+
+func reencrypt(cfgs *configs.Settings, args []string) {
+	fs := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only count the descriptions that would be rewritten")
+	timeout := fs.Duration("timeout", 30*time.Minute, "give up if re-encrypting takes longer than this")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), reencryptUsage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	keys, err := storage.LoadKeyRing(cfgs.EncryptionKeysDir, cfgs.EncryptionPrimaryKey)
+	if err != nil {
+		log.Fatalf("failed to load encryption keys: %v", err)
+	}
+
+	db, err := openMongoDB(cfgs)
+	if err != nil {
+		log.Fatalf("failed to connect to DB: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	defer db.Close(context.Background())
+
+	stats, err := storage.NewEncryptedDB(db, keys).Reencrypt(ctx, *dryRun)
+	if err != nil {
+		log.Fatalf("failed to re-encrypt descriptions: %v", err)
+	}
+
+	verb := "re-encrypted"
+	if *dryRun {
+		verb = "would re-encrypt"
+	}
+	fmt.Printf("%s %d of %d todo descriptions with key %s\n", verb, stats.Rewritten, stats.Scanned, keys.Primary())
+}
@@ -5,10 +5,82 @@ type Settings struct {
 	ServerAddr string `envcfg:"SERVER_ADDR" envcfgDefault:""`
 	ServerPort string `envcfg:"SERVER_PORT" envcfgDefault:"8080"`
 
+	ServerShutdownTimeoutSeconds int64 `envcfg:"SERVER_SHUTDOWN_TIMEOUT" envcfgDefault:"15"`
+	// ServerDrainDelaySeconds is how long the server keeps serving after a shutdown signal with /readyz failing, so the
+	// load balancer has taken it out of rotation by the time it stops accepting requests
+	ServerDrainDelaySeconds int64 `envcfg:"SERVER_DRAIN_DELAY" envcfgDefault:"5"`
+
+	// DatabaseURI is a complete mongodb:// or mongodb+srv:// connection string; when set it takes precedence over
+	// DatabaseScheme and DatabaseHostName. Credentials in it are used unless DB_USERNAME_FPATH or DB_PSWD_FPATH is set.
+	DatabaseURI               string `envcfg:"DB_URI" envcfgDefault:""`
+	DatabaseScheme            string `envcfg:"DB_SCHEME" envcfgDefault:"mongodb+srv"`
 	DatabaseHostName          string `envcfg:"DB_HOSTNAME" envcfgDefault:""`
 	DatabaseDBName            string `envcfg:"DB_DBNAME" envcfgDefault:""`
 	DatabaseTodosCollection   string `envcfg:"DB_TODOS_COLLECTION" envcfgDefault:""`
 	DatabaseUserNameFilePath  string `envcfg:"DB_USERNAME_FPATH" envcfgDefault:"/etc/db/secrets/dbusername"`
 	DatabasePswdFilePath      string `envcfg:"DB_PSWD_FPATH" envcfgDefault:"/etc/db/secrets/dbpswd"`
+	DatabaseAuthSource        string `envcfg:"DB_AUTH_SOURCE" envcfgDefault:""`
 	DatabaseCxnTimeoutSeconds int64  `envcfg:"DB_TIMEOUT" envcfgDefault:"10"`
+
+	DatabaseReplicaSet     string `envcfg:"DB_REPLICA_SET" envcfgDefault:""`
+	DatabaseReadPreference string `envcfg:"DB_READ_PREFERENCE" envcfgDefault:"primary"`
+	DatabaseAppName        string `envcfg:"DB_APP_NAME" envcfgDefault:"todoapi"`
+	DatabaseCompressors    string `envcfg:"DB_COMPRESSORS" envcfgDefault:""`
+	DatabaseMaxPoolSize    int64  `envcfg:"DB_MAX_POOL_SIZE" envcfgDefault:"0"`
+	DatabaseMinPoolSize    int64  `envcfg:"DB_MIN_POOL_SIZE" envcfgDefault:"0"`
+	DatabaseTLSCAFilePath  string `envcfg:"DB_TLS_CA_FPATH" envcfgDefault:""`
+	DatabaseTLSCertKeyPath string `envcfg:"DB_TLS_CERT_KEY_FPATH" envcfgDefault:""`
+
+	// DatabaseBackend picks the storage model: mongodb keeps the current state of each todo, eventsourced keeps the
+	// stream of changes in the same database and projects the state from it
+	DatabaseBackend       string `envcfg:"DB_BACKEND" envcfgDefault:"mongodb"`
+	DatabaseSnapshotEvery int64  `envcfg:"DB_SNAPSHOT_EVERY" envcfgDefault:"100"`
+
+	DatabaseMigrationsCollection string `envcfg:"DB_MIGRATIONS_COLLECTION" envcfgDefault:"schema_migrations"`
+	DatabaseMigrateOnStartup     bool   `envcfg:"DB_MIGRATE_ON_STARTUP" envcfgDefault:"true"`
+
+	// CacheEnabled puts a read-through cache in front of the DB. Each instance only drops its cache on its own writes,
+	// so with several replicas a read can be up to CACHE_TTL seconds stale.
+	CacheEnabled    bool  `envcfg:"CACHE_ENABLED" envcfgDefault:"false"`
+	CacheSize       int64 `envcfg:"CACHE_SIZE" envcfgDefault:"1000"`
+	CacheTTLSeconds int64 `envcfg:"CACHE_TTL" envcfgDefault:"30"`
+
+	// OutboxSink is where domain events are relayed: log, webhook, file, or none to leave them in the outbox for
+	// another instance to relay. Instances with a sink take turns through a lease, so only one relays at a time, and
+	// one that dies is taken over once its lease expires after OUTBOX_LEASE_TTL. Events no instance relays expire
+	// after 30 days.
+	OutboxSink                  string `envcfg:"OUTBOX_SINK" envcfgDefault:"none"`
+	OutboxWebhookURL            string `envcfg:"OUTBOX_WEBHOOK_URL" envcfgDefault:""`
+	OutboxWebhookTimeoutSeconds int64  `envcfg:"OUTBOX_WEBHOOK_TIMEOUT" envcfgDefault:"10"`
+	OutboxFilePath              string `envcfg:"OUTBOX_FPATH" envcfgDefault:""`
+	OutboxBatchSize             int64  `envcfg:"OUTBOX_BATCH_SIZE" envcfgDefault:"100"`
+	OutboxPollIntervalSeconds   int64  `envcfg:"OUTBOX_POLL_INTERVAL" envcfgDefault:"1"`
+	OutboxLeaseTTLSeconds       int64  `envcfg:"OUTBOX_LEASE_TTL" envcfgDefault:"30"`
+
+	// EncryptionEnabled encrypts todo descriptions at rest with the keys in ENCRYPTION_KEYS_DIR, one base64 AES key
+	// per file named by its ID. New descriptions use ENCRYPTION_PRIMARY_KEY; the other keys only decrypt. Filters and
+	// searches can't match words in encrypted descriptions.
+	EncryptionEnabled    bool   `envcfg:"ENCRYPTION_ENABLED" envcfgDefault:"false"`
+	EncryptionKeysDir    string `envcfg:"ENCRYPTION_KEYS_DIR" envcfgDefault:"/etc/todo/secrets/encryption-keys"`
+	EncryptionPrimaryKey string `envcfg:"ENCRYPTION_PRIMARY_KEY" envcfgDefault:""`
+
+	// IdempotencyTTLSeconds is how long the response to a POST /todo with an Idempotency-Key is replayed to retries
+	IdempotencyTTLSeconds int64 `envcfg:"IDEMPOTENCY_TTL" envcfgDefault:"86400"`
+
+	// LegacyRoutesSunset is the date, like 2027-04-30, from which the deprecated unversioned routes may stop working in
+	// favour of the same routes under /v1. It's sent in their Sunset header; empty leaves the header out.
+	LegacyRoutesSunset string `envcfg:"LEGACY_ROUTES_SUNSET" envcfgDefault:"2027-04-30"`
+
+	// ValidateResponses checks every API response against the OpenAPI document, replacing one that doesn't match with
+	// a 500. It's for test environments, where a handler regression should fail loudly; it holds back every response.
+	ValidateResponses bool `envcfg:"VALIDATE_RESPONSES" envcfgDefault:"false"`
+
+	// TenantSource is how a request names the team whose todos it works on: none puts everything in the default
+	// tenant, header trusts TENANT_HEADER and so needs a proxy that sets it, subdomain takes the label in front of
+	// TENANT_BASE_DOMAIN, and token takes TENANT_TOKEN_CLAIM from an HS256 bearer token
+	TenantSource              string `envcfg:"TENANT_SOURCE" envcfgDefault:"none"`
+	TenantHeader              string `envcfg:"TENANT_HEADER" envcfgDefault:"X-Tenant-ID"`
+	TenantBaseDomain          string `envcfg:"TENANT_BASE_DOMAIN" envcfgDefault:""`
+	TenantTokenSecretFilePath string `envcfg:"TENANT_TOKEN_SECRET_FPATH" envcfgDefault:"/etc/tenant/secrets/token-secret"`
+	TenantTokenClaim          string `envcfg:"TENANT_TOKEN_CLAIM" envcfgDefault:"tenant"`
 }
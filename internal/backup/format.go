@@ -0,0 +1,298 @@
+// Package backup reads and writes portable snapshots of the todo list: gzipped JSON lines that any storage backend
+// can be restored from.
+//
+// A snapshot starts with a header line, then has one line per todo and per pending outbox event, and ends with an end
+// line carrying the counts and the SHA-256 of every line before it, so a truncated or altered snapshot is rejected
+// rather than half restored.
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+const (
+	Format = "todoapi-backup"
+	// Version is the format version written; Read accepts this and older versions
+	Version = 1
+
+	// maxLineSize bounds a line Read accepts
+	maxLineSize = 16 << 20
+)
+
+var (
+	ErrChecksum  = errors.New("backup checksum doesn't match its contents")
+	ErrTruncated = errors.New("backup is truncated")
+)
+
+const (
+	kindTodo  = "todo"
+	kindEvent = "event"
+	kindEnd   = "end"
+)
+
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Todo struct {
+	ID          string    `json:"id"`
+	Tenant      string    `json:"tenant"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Completed   bool      `json:"completed"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// Version is missing from snapshots of todos written before versions, which are restored at version 1
+	Version int64 `json:"version,omitempty"`
+}
+
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Tenant     string    `json:"tenant"`
+	TodoID     string    `json:"todo_id,omitempty"`
+	Todo       *Todo     `json:"todo,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// record is a line after the header
+type record struct {
+	Kind  string `json:"kind"`
+	Todo  *Todo  `json:"todo,omitempty"`
+	Event *Event `json:"event,omitempty"`
+
+	// the end line's counts and checksum
+	Todos  int    `json:"todos,omitempty"`
+	Events int    `json:"events,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Snapshot is the content of a backup
+type Snapshot struct {
+	Header Header
+	Todos  []storage.Todo
+	Events []storage.OutboxEvent
+}
+
+// Summary describes a written backup
+type Summary struct {
+	Todos  int
+	Events int
+	SHA256 string
+}
+
+// Write streams every tenant's todos from src to w and, if src has an outbox, the events still waiting to be
+// published. Descriptions are written as src returns them, so a backup of an EncryptedDB holds them in plaintext.
+func Write(ctx context.Context, w io.Writer, src storage.Exporter) (Summary, error) {
+	zw := gzip.NewWriter(w)
+	bw := &lineWriter{w: zw, hash: sha256.New()}
+
+	var summary Summary
+
+	bw.write(Header{Format: Format, Version: Version, CreatedAt: time.Now().UTC()})
+
+	err := src.EachTodo(ctx, func(todo storage.Todo) error {
+		summary.Todos++
+		bw.write(record{Kind: kindTodo, Todo: fromTodo(todo)})
+		return bw.err
+	})
+	if err != nil {
+		return summary, fmt.Errorf("backup.Write failed to export todos: %v", err)
+	}
+
+	if outbox, ok := src.(storage.Outbox); ok {
+		events, err := outbox.PendingEvents(ctx, 0)
+		if err != nil {
+			return summary, fmt.Errorf("backup.Write failed to export outbox events: %v", err)
+		}
+		for _, event := range events {
+			summary.Events++
+			bw.write(record{Kind: kindEvent, Event: fromEvent(event)})
+		}
+	}
+
+	summary.SHA256 = hex.EncodeToString(bw.hash.Sum(nil))
+	bw.hash = nil
+	bw.write(record{Kind: kindEnd, Todos: summary.Todos, Events: summary.Events, SHA256: summary.SHA256})
+
+	if bw.err != nil {
+		return summary, fmt.Errorf("backup.Write failed to write: %v", bw.err)
+	}
+	if err = zw.Close(); err != nil {
+		return summary, fmt.Errorf("backup.Write failed to finish compressing: %v", err)
+	}
+
+	return summary, nil
+}
+
+// Read decompresses and checks a backup written by Write. Nothing is returned unless the whole backup is intact.
+func Read(r io.Reader) (Snapshot, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("backup.Read failed to decompress: %v", err)
+	}
+	defer zr.Close()
+
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	hash := sha256.New()
+
+	var snapshot Snapshot
+	var end *record
+
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Bytes()
+
+		if end != nil {
+			return Snapshot{}, fmt.Errorf("backup.Read found line %d after the end line", line)
+		}
+
+		if line == 1 {
+			if err = json.Unmarshal(data, &snapshot.Header); err != nil {
+				return Snapshot{}, fmt.Errorf("backup.Read got invalid header: %v", err)
+			}
+			if snapshot.Header.Format != Format {
+				return Snapshot{}, fmt.Errorf("backup.Read found format %q; want %q", snapshot.Header.Format, Format)
+			}
+			if snapshot.Header.Version < 1 || snapshot.Header.Version > Version {
+				return Snapshot{}, fmt.Errorf("backup.Read can't read version %d; want up to %d", snapshot.Header.Version, Version)
+			}
+			hash.Write(data)
+			hash.Write([]byte("\n"))
+			continue
+		}
+
+		var rec record
+		if err = json.Unmarshal(data, &rec); err != nil {
+			return Snapshot{}, fmt.Errorf("backup.Read got invalid line %d: %v", line, err)
+		}
+
+		switch {
+		case rec.Kind == kindTodo && rec.Todo != nil:
+			snapshot.Todos = append(snapshot.Todos, rec.Todo.toStorage())
+		case rec.Kind == kindEvent && rec.Event != nil:
+			snapshot.Events = append(snapshot.Events, rec.Event.toStorage())
+		case rec.Kind == kindEnd:
+			end = &rec
+			continue
+		default:
+			return Snapshot{}, fmt.Errorf("backup.Read got unknown record %q on line %d", rec.Kind, line)
+		}
+		hash.Write(data)
+		hash.Write([]byte("\n"))
+	}
+
+	if err = scanner.Err(); err != nil {
+		return Snapshot{}, fmt.Errorf("backup.Read failed to read: %v", err)
+	}
+	if end == nil {
+		return Snapshot{}, ErrTruncated
+	}
+	if end.Todos != len(snapshot.Todos) || end.Events != len(snapshot.Events) {
+		return Snapshot{}, fmt.Errorf("%w: end line counts %d todos and %d events; found %d and %d",
+			ErrChecksum, end.Todos, end.Events, len(snapshot.Todos), len(snapshot.Events))
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != end.SHA256 {
+		return Snapshot{}, fmt.Errorf("%w: got %s; want %s", ErrChecksum, sum, end.SHA256)
+	}
+
+	return snapshot, nil
+}
+
+// lineWriter writes JSON lines, hashing them while hash is set, and keeps the first error
+type lineWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	err  error
+}
+
+func (lw *lineWriter) write(v interface{}) {
+	if lw.err != nil {
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		lw.err = err
+		return
+	}
+	data = append(data, '\n')
+
+	if lw.hash != nil {
+		lw.hash.Write(data)
+	}
+	_, lw.err = lw.w.Write(data)
+}
+
+func fromTodo(todo storage.Todo) *Todo {
+	return &Todo{
+		ID:          todo.ID,
+		Tenant:      todo.Tenant,
+		Name:        todo.Name,
+		Description: todo.Description,
+		Completed:   todo.Completed,
+		CreatedAt:   todo.CreatedAt,
+		UpdatedAt:   todo.UpdatedAt,
+		Version:     todo.Version,
+	}
+}
+
+func (t Todo) toStorage() storage.Todo {
+	version := t.Version
+	if version == 0 {
+		version = 1
+	}
+
+	return storage.Todo{
+		ID:          t.ID,
+		Tenant:      t.Tenant,
+		Name:        t.Name,
+		Description: t.Description,
+		Completed:   t.Completed,
+		CreatedAt:   t.CreatedAt,
+		UpdatedAt:   t.UpdatedAt,
+		Version:     version,
+	}
+}
+
+func fromEvent(event storage.OutboxEvent) *Event {
+	e := &Event{
+		ID:         event.ID,
+		Type:       event.Type,
+		Tenant:     event.Tenant,
+		TodoID:     event.TodoID,
+		OccurredAt: event.OccurredAt,
+	}
+	if event.Todo.ID != "" {
+		e.Todo = fromTodo(event.Todo)
+	}
+	return e
+}
+
+func (e Event) toStorage() storage.OutboxEvent {
+	event := storage.OutboxEvent{
+		ID:         e.ID,
+		Type:       e.Type,
+		Tenant:     e.Tenant,
+		TodoID:     e.TodoID,
+		OccurredAt: e.OccurredAt,
+	}
+	if e.Todo != nil {
+		event.Todo = e.Todo.toStorage()
+	}
+	return event
+}
@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func newTestDB(t *testing.T) *storage.InMemoryDB {
+	t.Helper()
+	db := storage.NewInMemoryDB()
+	for _, id := range []string{"team-a", "team-b"} {
+		ctx := tenant.WithID(context.Background(), id)
+		todo, err := db.SaveTodo(ctx, "shopping", "get milk for "+id)
+		if err != nil {
+			t.Fatalf("SaveTodo got unexpected error: %+v", err)
+		}
+		if err = db.AppendEvents(ctx, storage.OutboxEvent{Type: "todo.created", TodoID: todo.ID, Todo: todo}); err != nil {
+			t.Fatalf("AppendEvents got unexpected error: %+v", err)
+		}
+	}
+	return db
+}
+
+// rewrite decompresses a backup, lets edit change its lines and compresses it again
+func rewrite(t *testing.T, data []byte, edit func(lines []string) []string) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("gzip.NewReader got unexpected error: %v", err)
+	}
+	plain, _ := ioutil.ReadAll(zr)
+	lines := edit(strings.Split(strings.TrimSuffix(string(plain), "\n"), "\n"))
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(strings.Join(lines, "\n") + "\n"))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestWriteRead(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	var buf bytes.Buffer
+	summary, err := Write(ctx, &buf, db)
+	if err != nil {
+		t.Fatalf("Write got unexpected error: %v", err)
+	}
+	if summary.Todos != 2 || summary.Events != 2 || summary.SHA256 == "" {
+		t.Errorf("Write expected 2 todos and 2 events with a checksum; got %+v", summary)
+	}
+
+	snapshot, err := Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Read got unexpected error: %v", err)
+	}
+
+	var expected []storage.Todo
+	_ = db.EachTodo(ctx, func(todo storage.Todo) error {
+		expected = append(expected, todo)
+		return nil
+	})
+	if diff := cmp.Diff(expected, snapshot.Todos); diff != "" {
+		t.Errorf("Read expected vs actual todos don't match: %v", diff)
+	}
+	pending, _ := db.PendingEvents(ctx, 0)
+	if diff := cmp.Diff(pending, snapshot.Events); diff != "" {
+		t.Errorf("Read expected vs actual events don't match: %v", diff)
+	}
+
+	testData := []struct {
+		testName    string
+		edit        func(lines []string) []string
+		expectedErr error
+		expectedMsg string
+	}{
+		{
+			testName: "failure: altered todo",
+			edit: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], "milk", "beer", 1)
+				return lines
+			},
+			expectedErr: ErrChecksum,
+		},
+		{
+			testName: "failure: dropped todo",
+			edit: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			expectedErr: ErrChecksum,
+		},
+		{
+			testName: "failure: no end line",
+			edit: func(lines []string) []string {
+				return lines[:len(lines)-1]
+			},
+			expectedErr: ErrTruncated,
+		},
+		{
+			testName: "failure: newer version",
+			edit: func(lines []string) []string {
+				lines[0] = strings.Replace(lines[0], `"version":1`, `"version":2`, 1)
+				return lines
+			},
+			expectedMsg: "can't read version 2",
+		},
+		{
+			testName: "failure: lines after the end",
+			edit: func(lines []string) []string {
+				return append(lines, lines[1])
+			},
+			expectedMsg: "after the end line",
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			_, err := Read(bytes.NewReader(rewrite(t, buf.Bytes(), td.edit)))
+
+			if td.expectedErr != nil && !errors.Is(err, td.expectedErr) {
+				t.Errorf("Read expected error '%v'; got %v", td.expectedErr, err)
+			}
+			if td.expectedMsg != "" && (err == nil || !strings.Contains(err.Error(), td.expectedMsg)) {
+				t.Errorf("Read expected an error containing %q; got %v", td.expectedMsg, err)
+			}
+		})
+	}
+}
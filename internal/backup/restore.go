@@ -0,0 +1,192 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+// Mode says what happens to the todos already in the DB a backup is restored into
+type Mode string
+
+const (
+	// Merge keeps existing todos; backed up todos replace the ones with the same ID
+	Merge Mode = "merge"
+	// Replace removes every existing todo that isn't in the backup
+	Replace Mode = "replace"
+)
+
+// Plan is what restoring a backup will do: remove Deletes, then store Upserts in order
+type Plan struct {
+	Upserts []storage.Todo
+	Deletes []storage.Todo
+	// Conflicts are backed up todos left out because another todo in their tenant has their name
+	Conflicts []storage.Todo
+
+	Created, Updated, Unchanged int
+}
+
+// NewPlan works out how to bring existing in line with the backed up todos in the given mode. A todo's name only
+// becomes free for others once the todo has been renamed or deleted earlier in the plan, so storing the upserts in
+// order never makes two todos in a tenant share a name, but the order of the backup can decide a conflict.
+func NewPlan(existing, backedUp []storage.Todo, mode Mode) (Plan, error) {
+	if mode != Merge && mode != Replace {
+		return Plan{}, fmt.Errorf("backup.NewPlan got unknown mode %q; want merge or replace", mode)
+	}
+
+	var plan Plan
+
+	byID := make(map[string]storage.Todo, len(existing))
+	for _, todo := range existing {
+		todo.Tenant = tenantOf(todo)
+		byID[todo.ID] = todo
+	}
+
+	inBackup := make(map[string]bool, len(backedUp))
+	for _, todo := range backedUp {
+		inBackup[todo.ID] = true
+	}
+
+	// names maps each tenant's names to the ID of the todo holding them once the restore is done
+	names := make(map[string]string)
+	nameKey := func(todo storage.Todo) string {
+		return todo.Tenant + "/" + todo.Name
+	}
+
+	for _, todo := range existing {
+		todo = byID[todo.ID]
+		switch {
+		case mode == Replace && !inBackup[todo.ID]:
+			plan.Deletes = append(plan.Deletes, todo)
+		case mode == Merge || inBackup[todo.ID]:
+			names[nameKey(todo)] = todo.ID
+		}
+	}
+
+	seen := make(map[string]bool, len(backedUp))
+	for _, todo := range backedUp {
+		todo.Tenant = tenantOf(todo)
+		if seen[todo.ID] {
+			plan.Conflicts = append(plan.Conflicts, todo)
+			continue
+		}
+		seen[todo.ID] = true
+
+		current, exists := byID[todo.ID]
+
+		if holder, taken := names[nameKey(todo)]; taken && holder != todo.ID {
+			plan.Conflicts = append(plan.Conflicts, todo)
+			continue
+		}
+
+		switch {
+		case !exists:
+			plan.Created++
+		case current.Tenant != todo.Tenant:
+			// moving a todo between tenants is a delete from the old one and a create in the new one
+			plan.Deletes = append(plan.Deletes, current)
+			plan.Created++
+			delete(names, nameKey(current))
+		case sameTodo(current, todo):
+			plan.Unchanged++
+			continue
+		default:
+			plan.Updated++
+			delete(names, nameKey(current))
+		}
+		plan.Upserts = append(plan.Upserts, todo)
+		names[nameKey(todo)] = todo.ID
+	}
+
+	return plan, nil
+}
+
+// Restore applies a backup to dst, which has to be able to export and import todos. Pending outbox events in the
+// backup are appended to dst's outbox unless they're already pending there; consumers drop any that were already
+// published, since event IDs are kept. With dryRun nothing is written and the plan only reports what would happen.
+func Restore(ctx context.Context, dst storage.DB, snapshot Snapshot, mode Mode, dryRun bool) (Plan, int, error) {
+	exporter, canExport := dst.(storage.Exporter)
+	importer, canImport := dst.(storage.Importer)
+	if !canExport || !canImport {
+		return Plan{}, 0, errors.New("backup.Restore can't restore into a backend that can't export and import todos")
+	}
+
+	var existing []storage.Todo
+	err := exporter.EachTodo(ctx, func(todo storage.Todo) error {
+		existing = append(existing, todo)
+		return nil
+	})
+	if err != nil {
+		return Plan{}, 0, fmt.Errorf("backup.Restore failed to read existing todos: %v", err)
+	}
+
+	plan, err := NewPlan(existing, snapshot.Todos, mode)
+	if err != nil {
+		return Plan{}, 0, err
+	}
+
+	events, err := newEvents(ctx, dst, snapshot.Events)
+	if err != nil {
+		return plan, 0, err
+	}
+
+	if dryRun {
+		return plan, len(events), nil
+	}
+
+	if err = importer.ImportTodos(ctx, plan.Upserts, plan.Deletes); err != nil {
+		return plan, 0, fmt.Errorf("backup.Restore failed to import todos: %v", err)
+	}
+
+	if len(events) > 0 {
+		if err = dst.(storage.Outbox).AppendEvents(ctx, events...); err != nil {
+			return plan, 0, fmt.Errorf("backup.Restore failed to append outbox events: %v", err)
+		}
+	}
+
+	return plan, len(events), nil
+}
+
+// newEvents returns the backed up events that aren't pending in dst's outbox, or none if dst has no outbox
+func newEvents(ctx context.Context, dst storage.DB, events []storage.OutboxEvent) ([]storage.OutboxEvent, error) {
+	outbox, ok := dst.(storage.Outbox)
+	if !ok || len(events) == 0 {
+		return nil, nil
+	}
+
+	pending, err := outbox.PendingEvents(ctx, 0)
+	if err != nil {
+		return nil, fmt.Errorf("backup.Restore failed to read pending outbox events: %v", err)
+	}
+	isPending := make(map[string]bool, len(pending))
+	for _, event := range pending {
+		isPending[event.ID] = true
+	}
+
+	var fresh []storage.OutboxEvent
+	for _, event := range events {
+		if !isPending[event.ID] {
+			fresh = append(fresh, event)
+		}
+	}
+	return fresh, nil
+}
+
+func tenantOf(todo storage.Todo) string {
+	if todo.Tenant == "" {
+		return tenant.Default
+	}
+	return todo.Tenant
+}
+
+func sameTodo(a, b storage.Todo) bool {
+	return a.Name == b.Name &&
+		a.Description == b.Description &&
+		a.Completed == b.Completed &&
+		a.CreatedAt.Equal(b.CreatedAt) &&
+		a.UpdatedAt.Equal(b.UpdatedAt) &&
+		a.Version == b.Version
+}
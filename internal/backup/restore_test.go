@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func TestNewPlan(t *testing.T) {
+	at := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	todo := func(id, tenant, name string) storage.Todo {
+		return storage.Todo{ID: id, Tenant: tenant, Name: name, CreatedAt: at, UpdatedAt: at}
+	}
+
+	existing := []storage.Todo{
+		todo("1", "team-a", "shopping"),
+		todo("2", "team-a", "wash car"),
+		todo("3", "", "walk dog"),
+	}
+
+	renamed := todo("1", "team-a", "groceries")
+	moved := todo("2", "team-b", "wash car")
+
+	testData := []struct {
+		testName       string
+		backedUp       []storage.Todo
+		mode           Mode
+		expectedResult Plan
+	}{
+		{
+			testName: "success: merge",
+			backedUp: []storage.Todo{
+				renamed,
+				todo("3", tenant.Default, "walk dog"),
+				todo("4", "team-a", "pay bills"),
+				todo("5", "team-a", "wash car"),
+				todo("6", "team-b", "shopping"),
+			},
+			mode: Merge,
+			expectedResult: Plan{
+				Upserts:   []storage.Todo{renamed, todo("4", "team-a", "pay bills"), todo("6", "team-b", "shopping")},
+				Conflicts: []storage.Todo{todo("5", "team-a", "wash car")},
+				Created:   2,
+				Updated:   1,
+				Unchanged: 1,
+			},
+		},
+		{
+			testName: "success: replace frees the names of deleted todos",
+			backedUp: []storage.Todo{
+				moved,
+				todo("5", "team-a", "wash car"),
+				todo("5", "team-a", "duplicate"),
+			},
+			mode: Replace,
+			expectedResult: Plan{
+				Upserts:   []storage.Todo{moved, todo("5", "team-a", "wash car")},
+				Deletes:   []storage.Todo{todo("1", "team-a", "shopping"), todo("3", tenant.Default, "walk dog"), todo("2", "team-a", "wash car")},
+				Conflicts: []storage.Todo{todo("5", "team-a", "duplicate")},
+				Created:   2,
+			},
+		},
+		{
+			testName: "success: a name is only freed once its holder is processed",
+			backedUp: []storage.Todo{
+				todo("5", "team-a", "wash car"),
+				moved,
+			},
+			mode: Replace,
+			expectedResult: Plan{
+				Upserts:   []storage.Todo{moved},
+				Deletes:   []storage.Todo{todo("1", "team-a", "shopping"), todo("3", tenant.Default, "walk dog"), todo("2", "team-a", "wash car")},
+				Conflicts: []storage.Todo{todo("5", "team-a", "wash car")},
+				Created:   1,
+			},
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			result, err := NewPlan(existing, td.backedUp, td.mode)
+			if err != nil {
+				t.Fatalf("NewPlan got unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(td.expectedResult, result); diff != "" {
+				t.Errorf("NewPlan expected vs actual results don't match: %v", diff)
+			}
+		})
+	}
+
+	if _, err := NewPlan(existing, nil, "overwrite"); err == nil {
+		t.Error("NewPlan expected an error for an unknown mode")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	teamA := tenant.WithID(ctx, "team-a")
+
+	var buf bytes.Buffer
+	if _, err := Write(ctx, &buf, newTestDB(t)); err != nil {
+		t.Fatalf("Write got unexpected error: %v", err)
+	}
+	snapshot, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read got unexpected error: %v", err)
+	}
+
+	// restoring moves the data to another backend, IDs, tenants and timestamps included
+	es, err := storage.NewEventSourcedDB(ctx, storage.NewInMemoryEventStore(), storage.EventSourcedConfig{})
+	if err != nil {
+		t.Fatalf("NewEventSourcedDB got unexpected error: %+v", err)
+	}
+	stale, _ := es.SaveTodo(teamA, "stale", "")
+
+	plan, events, err := Restore(ctx, es, snapshot, Replace, true)
+	if err != nil || plan.Created != 2 || len(plan.Deletes) != 1 || events != 2 {
+		t.Fatalf("Restore dry run expected 2 creates, 1 delete and 2 events; got %+v, %d, %v", plan, events, err)
+	}
+	if list, _ := es.GetTodoList(teamA); len(list) != 1 || list[0].ID != stale.ID {
+		t.Fatalf("Restore dry run changed the list: %+v", list)
+	}
+
+	if _, _, err = Restore(ctx, es, snapshot, Replace, false); err != nil {
+		t.Fatalf("Restore got unexpected error: %v", err)
+	}
+	if pending, _ := es.PendingEvents(ctx, 0); len(pending) != 2 {
+		t.Errorf("Restore expected the 2 backed up events in the event store's outbox; got %+v", pending)
+	}
+
+	list, _ := es.GetTodoList(teamA)
+	if diff := cmp.Diff([]storage.Todo{snapshot.Todos[0]}, list); diff != "" {
+		t.Errorf("Restore expected vs actual todos don't match: %v", diff)
+	}
+
+	// restoring the same backup again changes nothing
+	plan, _, err = Restore(ctx, es, snapshot, Merge, false)
+	if err != nil || plan.Unchanged != 2 || len(plan.Upserts)+len(plan.Deletes) != 0 {
+		t.Errorf("Restore expected nothing to change; got %+v, %v", plan, err)
+	}
+
+	// events go to backends with an outbox, once
+	db := storage.NewInMemoryDB()
+	if _, events, err = Restore(ctx, db, snapshot, Merge, false); err != nil || events != 2 {
+		t.Fatalf("Restore expected 2 events; got %d, %v", events, err)
+	}
+	if _, events, _ = Restore(ctx, db, snapshot, Merge, false); events != 0 {
+		t.Errorf("Restore expected pending events not to be appended again; got %d", events)
+	}
+}
@@ -0,0 +1,367 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// MaxLength caps the length of a query, in characters
+const MaxLength = 1000
+
+var fieldAliases = map[string]Field{
+	"name":        FieldName,
+	"description": FieldDescription,
+	"desc":        FieldDescription,
+	"id":          FieldID,
+	"status":      FieldStatus,
+	"created":     FieldCreated,
+	"updated":     FieldUpdated,
+}
+
+// unsupportedFields are fields clients may expect from other todo apps, with why a query can't use them. Naming them
+// beats an "unknown field" that reads like a typo.
+var unsupportedFields = map[string]string{
+	"tag":  "todos have no tags",
+	"tags": "todos have no tags",
+	"due":  "todos have no due date; use created or updated",
+}
+
+// Parse turns a query string into an AST. An empty or all-whitespace query returns a nil Expr, which matches every
+// todo. Errors are always *SyntaxError.
+func Parse(q string) (Expr, error) {
+	p := &parser{src: []rune(q)}
+
+	if len(p.src) > MaxLength {
+		return nil, &SyntaxError{Pos: MaxLength + 1, Msg: fmt.Sprintf("query is longer than %d characters", MaxLength)}
+	}
+
+	p.skipSpace()
+	if p.eof() {
+		return nil, nil
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.eof() {
+		// parseAnd only stops early on a ')' it can't match
+		return nil, p.errorf(p.pos, "unexpected %q", p.peek())
+	}
+
+	return expr, nil
+}
+
+type parser struct {
+	src []rune
+	pos int
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// errorf builds a SyntaxError for the 0-based offset at
+func (p *parser) errorf(at int, format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Pos: at + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+// keyword reports whether the upper-case keyword kw is next, as a whole word
+func (p *parser) keyword(kw string) bool {
+	end := p.pos + len(kw)
+	if end > len(p.src) || string(p.src[p.pos:end]) != kw {
+		return false
+	}
+	return end == len(p.src) || unicode.IsSpace(p.src[end]) || p.src[end] == '('
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := []Expr{first}
+	for p.keyword("OR") {
+		orPos := p.pos
+		p.pos += len("OR")
+		p.skipSpace()
+		if p.eof() || p.peek() == ')' {
+			return nil, p.errorf(orPos, "expected a term after OR")
+		}
+
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, next)
+	}
+
+	if len(exprs) == 1 {
+		return first, nil
+	}
+	return Or{Exprs: exprs}, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	var exprs []Expr
+
+	for {
+		p.skipSpace()
+		if p.eof() || p.peek() == ')' || p.keyword("OR") {
+			break
+		}
+
+		if p.keyword("AND") {
+			andPos := p.pos
+			p.pos += len("AND")
+			p.skipSpace()
+			if len(exprs) == 0 || p.eof() || p.peek() == ')' || p.keyword("OR") {
+				return nil, p.errorf(andPos, "AND needs a term on both sides")
+			}
+		}
+
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+
+	switch len(exprs) {
+	case 0:
+		if p.eof() {
+			return nil, p.errorf(p.pos, "expected a term")
+		}
+		if p.keyword("OR") {
+			return nil, p.errorf(p.pos, "expected a term before OR")
+		}
+		return nil, p.errorf(p.pos, "expected a term before %q", p.peek())
+	case 1:
+		return exprs[0], nil
+	default:
+		return And{Exprs: exprs}, nil
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	start := p.pos
+
+	negated := false
+	if p.peek() == '-' {
+		p.pos++
+		negated = true
+	} else if p.keyword("NOT") {
+		p.pos += len("NOT")
+		p.skipSpace()
+		negated = true
+	}
+
+	if negated {
+		if p.eof() || unicode.IsSpace(p.peek()) || p.peek() == ')' {
+			return nil, p.errorf(start, "expected a term to negate")
+		}
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	start := p.pos
+
+	switch p.peek() {
+	case '(':
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf(start, "unclosed parenthesis")
+		}
+		p.pos++
+		return expr, nil
+	case ')':
+		return nil, p.errorf(start, "unexpected \")\"")
+	case '"':
+		text, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return Term{Field: FieldText, Op: OpContains, Text: text}, nil
+	case ':', '=', '<', '>':
+		return nil, p.errorf(start, "expected a field name before %q", p.peek())
+	}
+
+	word := p.parseWord()
+
+	op, ok := p.parseOp()
+	if !ok {
+		return Term{Field: FieldText, Op: OpContains, Text: word}, nil
+	}
+
+	field, ok := fieldAliases[strings.ToLower(word)]
+	if reason, unsupported := unsupportedFields[strings.ToLower(word)]; unsupported {
+		return nil, p.errorf(start, "field %q isn't supported: %s", word, reason)
+	}
+	if !ok {
+		return nil, p.errorf(start, "unknown field %q; want one of name, description, id, status, created, updated", word)
+	}
+
+	valuePos := p.pos
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, p.errorf(valuePos, "missing value for %s", word)
+	}
+
+	return p.newTerm(field, op, value, start, valuePos)
+}
+
+// parseWord reads a bare word, which ends at whitespace, parentheses, quotes or an operator
+func (p *parser) parseWord() string {
+	start := p.pos
+	for !p.eof() && !unicode.IsSpace(p.peek()) && !strings.ContainsRune(`()":=<>`, p.peek()) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// parseValue reads a field's value: a quoted string, or everything up to whitespace or a closing parenthesis, so
+// values like timestamps may contain ':'
+func (p *parser) parseValue() (string, error) {
+	if p.peek() == '"' {
+		return p.parseQuoted()
+	}
+
+	start := p.pos
+	for !p.eof() && !unicode.IsSpace(p.peek()) && p.peek() != ')' {
+		p.pos++
+	}
+	return string(p.src[start:p.pos]), nil
+}
+
+func (p *parser) parseQuoted() (string, error) {
+	start := p.pos
+	p.pos++ // opening quote
+
+	var sb strings.Builder
+	for !p.eof() {
+		r := p.src[p.pos]
+		p.pos++
+		switch r {
+		case '"':
+			return sb.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf(start, "unterminated string")
+			}
+			sb.WriteRune(p.src[p.pos])
+			p.pos++
+		default:
+			sb.WriteRune(r)
+		}
+	}
+
+	return "", p.errorf(start, "unterminated string")
+}
+
+func (p *parser) parseOp() (Op, bool) {
+	switch p.peek() {
+	case ':':
+		p.pos++
+		return OpContains, true
+	case '=':
+		p.pos++
+		return OpEqual, true
+	case '<', '>':
+		op := string(p.peek())
+		p.pos++
+		if p.peek() == '=' {
+			op += "="
+			p.pos++
+		}
+		return Op(op), true
+	}
+	return "", false
+}
+
+func (p *parser) newTerm(field Field, op Op, value string, fieldPos, valuePos int) (Expr, error) {
+	switch field {
+	case FieldName, FieldDescription, FieldID:
+		if op != OpContains && op != OpEqual {
+			return nil, p.errorf(fieldPos, "%s only supports ':' and '='", field)
+		}
+		if field == FieldID {
+			// IDs are only ever matched exactly
+			op = OpEqual
+		}
+		return Term{Field: field, Op: op, Text: value}, nil
+
+	case FieldStatus:
+		if op != OpContains && op != OpEqual {
+			return nil, p.errorf(fieldPos, "status only supports ':' and '='")
+		}
+		switch strings.ToLower(value) {
+		case "open":
+			return Term{Field: field, Op: OpEqual, Completed: false}, nil
+		case "completed", "done":
+			return Term{Field: field, Op: OpEqual, Completed: true}, nil
+		}
+		return nil, p.errorf(valuePos, "unknown status %q; want open or completed", value)
+
+	default: // FieldCreated, FieldUpdated
+		t, day, err := parseTime(value)
+		if err != nil {
+			return nil, p.errorf(valuePos, "invalid date %q; want YYYY-MM-DD or an RFC 3339 timestamp", value)
+		}
+		if op != OpContains {
+			return Term{Field: field, Op: op, Time: t}, nil
+		}
+		if !day {
+			return Term{Field: field, Op: OpEqual, Time: t}, nil
+		}
+		// created:2026-10-19 means at any time on that day
+		return And{Exprs: []Expr{
+			Term{Field: field, Op: OpGreaterOrEqual, Time: t},
+			Term{Field: field, Op: OpLess, Time: t.AddDate(0, 0, 1)},
+		}}, nil
+	}
+}
+
+// parseTime accepts a date or a timestamp and reports whether it was a whole day
+func parseTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	return t.UTC(), false, nil
+}
@@ -0,0 +1,184 @@
+package query
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testData := []struct {
+		testName       string
+		query          string
+		expectedResult string
+	}{
+		{
+			testName:       "success: empty query",
+			query:          "   ",
+			expectedResult: "<nil>",
+		},
+		{
+			testName:       "success: bare word",
+			query:          "milk",
+			expectedResult: `text:"milk"`,
+		},
+		{
+			testName:       "success: quoted phrase with escaped quote",
+			query:          `"say \"hi\""`,
+			expectedResult: `text:"say \"hi\""`,
+		},
+		{
+			testName:       "success: implicit and explicit AND",
+			query:          `name:shop AND desc:milk status:open`,
+			expectedResult: `(name:"shop" AND description:"milk" AND status=open)`,
+		},
+		{
+			testName:       "success: OR binds looser than AND",
+			query:          `a b OR c`,
+			expectedResult: `((text:"a" AND text:"b") OR text:"c")`,
+		},
+		{
+			testName:       "success: grouping and negation",
+			query:          `-status:done (name:shop OR NOT name="wash car")`,
+			expectedResult: `((NOT status=completed) AND (name:"shop" OR (NOT name="wash car")))`,
+		},
+		{
+			testName:       "success: id is always exact",
+			query:          `id:11111aaa-aaaa-1111-a1aa-111aa1a11a1a`,
+			expectedResult: `id="11111aaa-aaaa-1111-a1aa-111aa1a11a1a"`,
+		},
+		{
+			testName:       "success: date comparison",
+			query:          `created<2026-11-01`,
+			expectedResult: `created<2026-11-01T00:00:00Z`,
+		},
+		{
+			testName:       "success: whole day",
+			query:          `updated:2026-10-19`,
+			expectedResult: `(updated>=2026-10-19T00:00:00Z AND updated<2026-10-20T00:00:00Z)`,
+		},
+		{
+			testName:       "success: timestamp with colons",
+			query:          `(updated>=2026-10-19T10:30:00+02:00)`,
+			expectedResult: `updated>=2026-10-19T08:30:00Z`,
+		},
+		{
+			testName:       "success: hyphen inside a word",
+			query:          `follow-up`,
+			expectedResult: `text:"follow-up"`,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			result, err := Parse(td.query)
+			if err != nil {
+				t.Fatalf("Parse got unexpected error: %v", err)
+			}
+
+			actual := "<nil>"
+			if result != nil {
+				actual = result.String()
+			}
+
+			if actual != td.expectedResult {
+				t.Errorf("Parse expected %s; got %s", td.expectedResult, actual)
+			}
+		})
+	}
+}
+
+func TestParse_SyntaxErrors(t *testing.T) {
+	testData := []struct {
+		testName    string
+		query       string
+		expectedPos int
+		// expectedMsg, if set, is the message the error must have
+		expectedMsg string
+	}{
+		{
+			testName:    "unknown field",
+			query:       `status:open colour:red`,
+			expectedPos: 13,
+		},
+		{
+			testName:    "unsupported tag field",
+			query:       `status:open tag:work`,
+			expectedPos: 13,
+			expectedMsg: `field "tag" isn't supported: todos have no tags`,
+		},
+		{
+			testName:    "unsupported due field",
+			query:       `due<2026-11-01`,
+			expectedPos: 1,
+			expectedMsg: `field "due" isn't supported: todos have no due date; use created or updated`,
+		},
+		{
+			testName:    "unclosed parenthesis",
+			query:       `a (b OR c`,
+			expectedPos: 3,
+		},
+		{
+			testName:    "unmatched closing parenthesis",
+			query:       `a b)`,
+			expectedPos: 4,
+		},
+		{
+			testName:    "unterminated string",
+			query:       `name:"wash car`,
+			expectedPos: 6,
+		},
+		{
+			testName:    "dangling OR",
+			query:       `a OR`,
+			expectedPos: 3,
+		},
+		{
+			testName:    "missing value",
+			query:       `name: milk`,
+			expectedPos: 6,
+		},
+		{
+			testName:    "bad status",
+			query:       `status:maybe`,
+			expectedPos: 8,
+		},
+		{
+			testName:    "malformed date value",
+			query:       `created<next-week`,
+			expectedPos: 9,
+		},
+		{
+			testName:    "comparison on a text field",
+			query:       `name>m`,
+			expectedPos: 1,
+		},
+		{
+			testName:    "nothing to negate",
+			query:       `a - b`,
+			expectedPos: 3,
+		},
+		{
+			testName:    "operator without a field",
+			query:       `:milk`,
+			expectedPos: 1,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			_, err := Parse(td.query)
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Parse expected a *SyntaxError; got %v", err)
+			}
+
+			if syntaxErr.Pos != td.expectedPos {
+				t.Errorf("Parse expected error at position %d; got %v", td.expectedPos, syntaxErr)
+			}
+			if td.expectedMsg != "" && syntaxErr.Msg != td.expectedMsg {
+				t.Errorf("Parse expected error %q; got %q", td.expectedMsg, syntaxErr.Msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Package query parses the filter language accepted by /list into an AST that each storage backend translates into
+// its own native filter.
+//
+// A query is a sequence of terms that must all match. Terms can be grouped with parentheses, joined with OR and
+// negated with a leading "-" or NOT:
+//
+//	milk                      name or description contains "milk"
+//	"get milk"                name or description contains the phrase
+//	name:shop                 name contains "shop"; description:/desc: work the same way
+//	name="wash car"           name is exactly "wash car"
+//	id=1111aaaa-...           todo has exactly this ID
+//	status:open               todo isn't completed; status:completed (or status:done) for completed ones
+//	created<2026-11-01        created before the date; updated works the same way
+//	created:2026-10-19        created on that (UTC) day; <, <=, >, >= and = are all supported
+//	-status:completed (name:shop OR name:milk)
+//
+// Text matches are case-insensitive. Dates are YYYY-MM-DD (midnight UTC) or RFC 3339 timestamps.
+package query
+
+import (
+	"fmt"
+	"time"
+)
+
+// Field is the todo attribute a Term matches against
+type Field string
+
+const (
+	// FieldText matches either the name or the description
+	FieldText        Field = "text"
+	FieldName        Field = "name"
+	FieldDescription Field = "description"
+	FieldID          Field = "id"
+	FieldStatus      Field = "status"
+	FieldCreated     Field = "created"
+	FieldUpdated     Field = "updated"
+)
+
+// Op is how a Term compares its field with its value
+type Op string
+
+const (
+	// OpContains is a case-insensitive substring match on text fields
+	OpContains       Op = ":"
+	OpEqual          Op = "="
+	OpLess           Op = "<"
+	OpLessOrEqual    Op = "<="
+	OpGreater        Op = ">"
+	OpGreaterOrEqual Op = ">="
+)
+
+// Expr is a node of the query AST: one of And, Or, Not or Term
+type Expr interface {
+	fmt.Stringer
+	expr()
+}
+
+// And matches todos that match every one of Exprs
+type And struct {
+	Exprs []Expr
+}
+
+// Or matches todos that match at least one of Exprs
+type Or struct {
+	Exprs []Expr
+}
+
+// Not matches todos that don't match Expr
+type Not struct {
+	Expr Expr
+}
+
+// Term compares one field with a value. Only the value matching the field's type is set: Text for the text fields
+// and id, Completed for status, and Time for created and updated.
+type Term struct {
+	Field     Field
+	Op        Op
+	Text      string
+	Completed bool
+	Time      time.Time
+}
+
+func (And) expr()  {}
+func (Or) expr()   {}
+func (Not) expr()  {}
+func (Term) expr() {}
+
+func (e And) String() string {
+	return joinExprs("AND", e.Exprs)
+}
+
+func (e Or) String() string {
+	return joinExprs("OR", e.Exprs)
+}
+
+func (e Not) String() string {
+	return fmt.Sprintf("(NOT %s)", e.Expr)
+}
+
+func (t Term) String() string {
+	switch t.Field {
+	case FieldStatus:
+		if t.Completed {
+			return "status=completed"
+		}
+		return "status=open"
+	case FieldCreated, FieldUpdated:
+		return fmt.Sprintf("%s%s%s", t.Field, t.Op, t.Time.Format(time.RFC3339))
+	default:
+		return fmt.Sprintf("%s%s%q", t.Field, t.Op, t.Text)
+	}
+}
+
+func joinExprs(op string, exprs []Expr) string {
+	s := "("
+	for i, e := range exprs {
+		if i > 0 {
+			s += " " + op + " "
+		}
+		s += e.String()
+	}
+	return s + ")"
+}
+
+// SyntaxError reports where in the query parsing failed. Pos is the 1-based character position.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
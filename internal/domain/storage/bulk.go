@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+// BulkOpKind says what a BulkOp does
+type BulkOpKind string
+
+const (
+	BulkCreate BulkOpKind = "create"
+	BulkUpdate BulkOpKind = "update"
+	BulkDelete BulkOpKind = "delete"
+)
+
+// BulkOp is one write of a bulk write. A create saves Todo's name and description. An update replaces the name,
+// description and completion of the todo with ID, and a delete removes it; both only apply if the todo still has
+// Todo.Version, unless that is zero.
+type BulkOp struct {
+	Kind BulkOpKind
+	ID   string
+	Todo Todo
+}
+
+// BulkResult is the outcome of one BulkOp: the todo as written, or as it was for a delete, or the error that stopped
+// the op, which is one of ErrNotFound, ErrAlreadyInList and ErrVersionMismatch
+type BulkResult struct {
+	Todo Todo
+	Err  error
+}
+
+// BulkWriter is implemented by DB backends that can apply many writes in fewer round trips than one per write.
+// BulkWrite applies ops in order, each seeing the writes before it, and returns one result per op; an op that fails
+// doesn't stop the ones after it. The error is for failures of the bulk write as a whole.
+type BulkWriter interface {
+	BulkWrite(ctx context.Context, ops []BulkOp) ([]BulkResult, error)
+}
+
+// BulkWrite applies ops with db's own BulkWrite if it has one and one op at a time otherwise
+func BulkWrite(ctx context.Context, db DB, ops []BulkOp) ([]BulkResult, error) {
+	if writer, ok := db.(BulkWriter); ok {
+		return writer.BulkWrite(ctx, ops)
+	}
+
+	results := make([]BulkResult, len(ops))
+	for i, op := range ops {
+		todo, err := applyBulkOp(ctx, db, op)
+		if err != nil && !isBulkOpError(err) {
+			return nil, err
+		}
+		results[i] = BulkResult{Todo: todo, Err: err}
+	}
+
+	return results, nil
+}
+
+func applyBulkOp(ctx context.Context, db DB, op BulkOp) (Todo, error) {
+	switch op.Kind {
+	case BulkCreate:
+		return db.SaveTodo(ctx, op.Todo.Name, op.Todo.Description)
+	case BulkUpdate:
+		return db.EditTodo(ctx, op.ID, op.Todo)
+	case BulkDelete:
+		current, err := db.GetTodoByID(ctx, op.ID)
+		if err != nil {
+			return Todo{}, err
+		}
+		if op.Todo.Version != 0 && op.Todo.Version != current.Version {
+			return Todo{}, ErrVersionMismatch
+		}
+		if err = db.DeleteTodo(ctx, op.ID); err != nil {
+			return Todo{}, err
+		}
+		return current, nil
+	default:
+		return Todo{}, errors.New("storage.BulkWrite got unknown op " + string(op.Kind))
+	}
+}
+
+// isBulkOpError reports whether err only stops its own op, rather than the whole bulk write
+func isBulkOpError(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrAlreadyInList) || errors.Is(err, ErrVersionMismatch)
+}
+
+// bulkPlan works out the results of bulk ops against the todos they touch, as read before any of them is written,
+// so a backend can send the writes that will succeed in one go
+type bulkPlan struct {
+	byID   map[string]Todo
+	byName map[string]string
+}
+
+func newBulkPlan(todos []Todo) *bulkPlan {
+	plan := &bulkPlan{byID: map[string]Todo{}, byName: map[string]string{}}
+	for _, todo := range todos {
+		plan.byID[todo.ID] = todo
+		plan.byName[todo.Name] = todo.ID
+	}
+	return plan
+}
+
+// apply returns the result of op and, if it succeeds, the todo as it was before, which is zero for a create
+func (p *bulkPlan) apply(ctx context.Context, op BulkOp) (result BulkResult, before Todo) {
+	switch op.Kind {
+	case BulkCreate:
+		if _, taken := p.byName[op.Todo.Name]; taken {
+			return BulkResult{Err: ErrAlreadyInList}, Todo{}
+		}
+
+		createdAt := now()
+		todo := Todo{
+			ID:          createID(),
+			Tenant:      tenant.ID(ctx),
+			Name:        op.Todo.Name,
+			Description: op.Todo.Description,
+			CreatedAt:   createdAt,
+			UpdatedAt:   createdAt,
+			Version:     1,
+		}
+		p.byID[todo.ID] = todo
+		p.byName[todo.Name] = todo.ID
+		return BulkResult{Todo: todo}, Todo{}
+	case BulkUpdate, BulkDelete:
+		current, ok := p.byID[op.ID]
+		if !ok {
+			return BulkResult{Err: ErrNotFound}, Todo{}
+		}
+		if op.Todo.Version != 0 && op.Todo.Version != current.Version {
+			return BulkResult{Err: ErrVersionMismatch}, Todo{}
+		}
+
+		delete(p.byName, current.Name)
+		if op.Kind == BulkDelete {
+			delete(p.byID, op.ID)
+			return BulkResult{Todo: current}, current
+		}
+
+		if id, taken := p.byName[op.Todo.Name]; taken && id != op.ID {
+			p.byName[current.Name] = current.ID
+			return BulkResult{Err: ErrAlreadyInList}, Todo{}
+		}
+
+		updated := current
+		updated.Name = op.Todo.Name
+		updated.Description = op.Todo.Description
+		updated.Completed = op.Todo.Completed
+		updated.UpdatedAt = now()
+		updated.Version++
+		p.byID[op.ID] = updated
+		p.byName[updated.Name] = op.ID
+		return BulkResult{Todo: updated}, current
+	default:
+		return BulkResult{Err: errors.New("storage.BulkWrite got unknown op " + string(op.Kind))}, Todo{}
+	}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func TestBulkWrite(t *testing.T) {
+	ctx := tenant.WithID(context.Background(), "team-a")
+	db := NewInMemoryDB()
+	shopping, _ := db.SaveTodo(ctx, "shopping", "get milk")
+	washCar, _ := db.SaveTodo(ctx, "wash car", "")
+	existing, _ := db.GetTodoList(ctx)
+
+	ops := []BulkOp{
+		{Kind: BulkCreate, Todo: Todo{Name: "groceries", Description: "eggs"}},
+		{Kind: BulkCreate, Todo: Todo{Name: "shopping"}},
+		{Kind: BulkUpdate, ID: shopping.ID, Todo: Todo{Name: "groceries"}},
+		{Kind: BulkUpdate, ID: shopping.ID, Todo: Todo{Name: "food", Description: "get milk", Version: 1}},
+		{Kind: BulkUpdate, ID: shopping.ID, Todo: Todo{Name: "food", Completed: true, Version: 1}},
+		{Kind: BulkCreate, Todo: Todo{Name: "shopping", Description: "bread"}},
+		{Kind: BulkDelete, ID: washCar.ID, Todo: Todo{Version: 2}},
+		{Kind: BulkDelete, ID: washCar.ID},
+		{Kind: BulkDelete, ID: washCar.ID},
+		{Kind: BulkUpdate, ID: "missing", Todo: Todo{Name: "missing"}},
+	}
+	expected := []BulkResult{
+		{Todo: Todo{Name: "groceries", Description: "eggs", Version: 1}},
+		{Err: ErrAlreadyInList},
+		{Err: ErrAlreadyInList},
+		{Todo: Todo{Name: "food", Description: "get milk", Version: 2}},
+		{Err: ErrVersionMismatch},
+		{Todo: Todo{Name: "shopping", Description: "bread", Version: 1}},
+		{Err: ErrVersionMismatch},
+		{Todo: Todo{Name: "wash car", Version: 1}},
+		{Err: ErrNotFound},
+		{Err: ErrNotFound},
+	}
+
+	// IDs and timestamps are made up by the backends
+	resultsCmp := cmp.Comparer(func(expected, actual BulkResult) bool {
+		return expected.Err == actual.Err && expected.Todo.Name == actual.Todo.Name &&
+			expected.Todo.Description == actual.Todo.Description && expected.Todo.Completed == actual.Todo.Completed &&
+			expected.Todo.Version == actual.Todo.Version
+	})
+
+	// backends that write in bulk plan the results up front; they must come out as if the ops ran one at a time
+	plan := newBulkPlan(existing)
+	planned := make([]BulkResult, len(ops))
+	for i, op := range ops {
+		planned[i], _ = plan.apply(ctx, op)
+	}
+	if diff := cmp.Diff(expected, planned, resultsCmp); diff != "" {
+		t.Errorf("bulkPlan expected vs actual results don't match: %v", diff)
+	}
+
+	results, err := BulkWrite(ctx, db, ops)
+	if err != nil {
+		t.Fatalf("BulkWrite got unexpected error: %+v", err)
+	}
+	if diff := cmp.Diff(expected, results, resultsCmp); diff != "" {
+		t.Errorf("BulkWrite expected vs actual results don't match: %v", diff)
+	}
+
+	list, _ := db.GetTodoList(ctx)
+	if len(list) != 3 {
+		t.Errorf("expected 3 todos after the bulk write; got %+v", list)
+	}
+}
+
+func TestEncryptedDB_BulkWrite(t *testing.T) {
+	ctx := tenant.WithID(context.Background(), "team-a")
+	backend := NewInMemoryDB()
+	db := NewEncryptedDB(backend, newTestKeyRing(t, "k1", "k1"))
+	saved, _ := db.SaveTodo(ctx, "call customer", "Jane, 555-0100")
+
+	results, err := db.BulkWrite(ctx, []BulkOp{
+		{Kind: BulkCreate, Todo: Todo{Name: "invoice", Description: "ACME Ltd"}},
+		{Kind: BulkDelete, ID: saved.ID},
+	})
+	if err != nil {
+		t.Fatalf("BulkWrite got unexpected error: %+v", err)
+	}
+	if results[0].Todo.Description != "ACME Ltd" || results[1].Todo.Description != "Jane, 555-0100" {
+		t.Errorf("BulkWrite expected plaintext descriptions back; got %+v", results)
+	}
+
+	if stored, _ := backend.GetTodoByName(ctx, "invoice"); !strings.HasPrefix(stored.Description, "enc:v1:k1:") {
+		t.Errorf("backend expected an encrypted description; got %q", stored.Description)
+	}
+}
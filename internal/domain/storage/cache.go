@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache is a fixed-size least-recently-used cache whose entries also expire after a TTL. Purging it starts a new
+// generation, and adds carrying an older generation are dropped, so a load that raced with a purge can't repopulate
+// the cache with what the purge was meant to remove.
+type lruCache struct {
+	mu         sync.Mutex
+	size       int
+	ttl        time.Duration
+	order      *list.List // front is most recently used
+	entries    map[string]*list.Element
+	generation uint64
+
+	evictions, invalidations uint64
+
+	// now is swapped out in tests
+	now func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// get returns the live value for key, if any, and the current generation
+func (c *lruCache) get(key string) (interface{}, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, c.generation, false
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if !c.now().Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, c.generation, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.value, c.generation, true
+}
+
+// add caches value under key unless the cache has been purged since generation
+func (c *lruCache) add(generation uint64, key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	entry := &lruEntry{key: key, value: value, expires: c.now().Add(c.ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+		c.evictions++
+	}
+}
+
+func (c *lruCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.generation++
+	c.invalidations++
+}
+
+func (c *lruCache) stats() (evictions, invalidations uint64, entries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.evictions, c.invalidations, c.order.Len()
+}
+
+// flightGroup collapses concurrent calls for the same key into one
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+	// dups counts the callers waiting on this flight besides the one running it
+	dups int
+}
+
+// do runs fn unless a call for key is already running, in which case it waits for that call and shares its result.
+// shared reports whether the result came from another caller's call.
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (value interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	if f, ok := g.calls[key]; ok {
+		f.dups++
+		g.mu.Unlock()
+		<-f.done
+		return f.value, f.err, true
+	}
+
+	f := &flight{done: make(chan struct{})}
+	g.calls[key] = f
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+
+	f.value, f.err = fn()
+
+	return f.value, f.err, false
+}
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+const (
+	DefaultCacheSize = 1000
+	DefaultCacheTTL  = 30 * time.Second
+)
+
+// CacheConfig sizes a CachedDB; zero values mean the defaults
+type CacheConfig struct {
+	// Size is the maximum number of cached reads
+	Size int
+	// TTL is how long a cached read is served before it's fetched again
+	TTL time.Duration
+}
+
+// CacheStats counts what a CachedDB has done since it was created
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// SharedLoads are misses that waited for another caller's load of the same key instead of loading it again
+	SharedLoads   uint64 `json:"shared_loads"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+}
+
+// CachedDB is a read-through cache in front of any DB. Reads are cached in an LRU for up to the TTL, concurrent misses
+// for the same read share one load, and every write through the CachedDB drops everything cached, since a write can
+// change any list or search result.
+//
+// Writes made elsewhere, e.g. by another replica, are only seen once the TTL expires. Reads inside a transaction go
+// straight to the backend, so the transaction sees its own writes and a consistent snapshot.
+type CachedDB struct {
+	hits, misses, sharedLoads uint64
+
+	db      DB
+	cache   *lruCache
+	flights flightGroup
+}
+
+func NewCachedDB(db DB, cfg CacheConfig) *CachedDB {
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultCacheSize
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultCacheTTL
+	}
+
+	return &CachedDB{
+		db:    db,
+		cache: newLRUCache(cfg.Size, cfg.TTL),
+	}
+}
+
+func (c *CachedDB) SaveTodo(ctx context.Context, name, description string) (Todo, error) {
+	defer c.cache.purge()
+	return c.db.SaveTodo(ctx, name, description)
+}
+
+func (c *CachedDB) GetTodoList(ctx context.Context) ([]Todo, error) {
+	v, err := c.read(ctx, "all", func() (interface{}, error) {
+		return c.db.GetTodoList(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]Todo(nil), v.([]Todo)...), nil
+}
+
+func (c *CachedDB) ListTodos(ctx context.Context, opts ListOptions) (TodoPage, error) {
+	filter := ""
+	if opts.Filter != nil {
+		filter = opts.Filter.String()
+	}
+	key := fmt.Sprintf("list/%d/%s/%s", opts.Limit, opts.Cursor, filter)
+
+	v, err := c.read(ctx, key, func() (interface{}, error) {
+		return c.db.ListTodos(ctx, opts)
+	})
+	if err != nil {
+		return TodoPage{}, err
+	}
+
+	page := v.(TodoPage)
+	page.Todos = append([]Todo(nil), page.Todos...)
+	return page, nil
+}
+
+func (c *CachedDB) SearchTodos(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	key := fmt.Sprintf("search/%d/%s", opts.Limit, opts.Query)
+
+	v, err := c.read(ctx, key, func() (interface{}, error) {
+		return c.db.SearchTodos(ctx, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]SearchHit(nil), v.([]SearchHit)...), nil
+}
+
+func (c *CachedDB) GetTodoByName(ctx context.Context, name string) (Todo, error) {
+	v, err := c.read(ctx, "name/"+name, func() (interface{}, error) {
+		return c.db.GetTodoByName(ctx, name)
+	})
+	if err != nil {
+		return Todo{}, err
+	}
+
+	return v.(Todo), nil
+}
+
+func (c *CachedDB) GetTodoByID(ctx context.Context, id string) (Todo, error) {
+	v, err := c.read(ctx, "id/"+id, func() (interface{}, error) {
+		return c.db.GetTodoByID(ctx, id)
+	})
+	if err != nil {
+		return Todo{}, err
+	}
+
+	return v.(Todo), nil
+}
+
+func (c *CachedDB) EditTodo(ctx context.Context, id string, todo Todo) (Todo, error) {
+	defer c.cache.purge()
+	return c.db.EditTodo(ctx, id, todo)
+}
+
+func (c *CachedDB) DeleteTodo(ctx context.Context, id string) error {
+	defer c.cache.purge()
+	return c.db.DeleteTodo(ctx, id)
+}
+
+func (c *CachedDB) DeleteTodos(ctx context.Context, filter query.Expr) ([]Todo, error) {
+	defer c.cache.purge()
+	return c.db.DeleteTodos(ctx, filter)
+}
+
+func (c *CachedDB) ClearTodoList(ctx context.Context) error {
+	defer c.cache.purge()
+	return c.db.ClearTodoList(ctx)
+}
+
+// BulkWrite goes through the backend's own bulk write, if it has one
+func (c *CachedDB) BulkWrite(ctx context.Context, ops []BulkOp) ([]BulkResult, error) {
+	defer c.cache.purge()
+	return BulkWrite(ctx, c.db, ops)
+}
+
+// WithTransaction runs fn against the backend's own transaction, uncached, and drops the cache once it's over
+func (c *CachedDB) WithTransaction(ctx context.Context, fn func(tx DB) error) error {
+	defer c.cache.purge()
+	return RunInTransaction(ctx, c.db, fn)
+}
+
+// SupportsTransactions reports whether the backend's transactions are all-or-nothing
+func (c *CachedDB) SupportsTransactions() bool {
+	return SupportsTransactions(c.db)
+}
+
+// EachTodo walks the backend's todos, uncached
+func (c *CachedDB) EachTodo(ctx context.Context, fn func(todo Todo) error) error {
+	if exporter, ok := c.db.(Exporter); ok {
+		return exporter.EachTodo(ctx, fn)
+	}
+	return errors.New("storage.EachTodo isn't supported by the cached DB's backend")
+}
+
+func (c *CachedDB) ImportTodos(ctx context.Context, upserts, deletes []Todo) error {
+	importer, ok := c.db.(Importer)
+	if !ok {
+		return errors.New("storage.ImportTodos isn't supported by the cached DB's backend")
+	}
+	defer c.cache.purge()
+	return importer.ImportTodos(ctx, upserts, deletes)
+}
+
+// Watch follows the backend's change feed, if it has one
+func (c *CachedDB) Watch(ctx context.Context, opts WatchOptions) (<-chan ChangeEvent, error) {
+	if watcher, ok := c.db.(Watcher); ok {
+		return watcher.Watch(ctx, opts)
+	}
+	return nil, errors.New("storage.Watch isn't supported by the cached DB")
+}
+
+// Ping pings the backend if it can be pinged
+func (c *CachedDB) Ping(ctx context.Context) error {
+	if pinger, ok := c.db.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Close closes the backend if it needs closing
+func (c *CachedDB) Close(ctx context.Context) error {
+	if closer, ok := c.db.(Closer); ok {
+		return closer.Close(ctx)
+	}
+	return nil
+}
+
+// ClaimIdempotencyKey and the other idempotency methods go straight to the backend; without an IdempotencyStore
+// every key can be claimed and nothing is remembered
+func (c *CachedDB) ClaimIdempotencyKey(ctx context.Context, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	if store, ok := c.db.(IdempotencyStore); ok {
+		return store.ClaimIdempotencyKey(ctx, record)
+	}
+	return record, true, nil
+}
+
+func (c *CachedDB) CompleteIdempotencyKey(ctx context.Context, key string, response IdempotentResponse) error {
+	if store, ok := c.db.(IdempotencyStore); ok {
+		return store.CompleteIdempotencyKey(ctx, key, response)
+	}
+	return nil
+}
+
+func (c *CachedDB) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	if store, ok := c.db.(IdempotencyStore); ok {
+		return store.ReleaseIdempotencyKey(ctx, key)
+	}
+	return nil
+}
+
+func (c *CachedDB) Stats() CacheStats {
+	evictions, invalidations, entries := c.cache.stats()
+
+	return CacheStats{
+		Hits:          atomic.LoadUint64(&c.hits),
+		Misses:        atomic.LoadUint64(&c.misses),
+		SharedLoads:   atomic.LoadUint64(&c.sharedLoads),
+		Evictions:     evictions,
+		Invalidations: invalidations,
+		Entries:       entries,
+	}
+}
+
+// canceledLoad is the error of a load that failed once the ctx of the caller running it was done
+type canceledLoad struct {
+	err error
+}
+
+func (e canceledLoad) Error() string {
+	return e.err.Error()
+}
+
+// read serves key from the cache or loads it. Keys are per tenant, so tenants never get each other's reads. Only
+// successful loads are cached, and only if no write happened while loading: the flight key includes the cache
+// generation, so callers arriving after a write never join a load that started before it.
+//
+// A shared load runs under the ctx of the caller running it. If that ctx is canceled or times out, the callers
+// waiting on it whose own ctx is still live load again rather than fail with someone else's error.
+func (c *CachedDB) read(ctx context.Context, key string, load func() (interface{}, error)) (interface{}, error) {
+	key = tenant.ID(ctx) + "/" + key
+
+	for attempt := 0; ; attempt++ {
+		v, generation, ok := c.cache.get(key)
+		if ok {
+			atomic.AddUint64(&c.hits, 1)
+			return v, nil
+		}
+		if attempt == 0 {
+			atomic.AddUint64(&c.misses, 1)
+		}
+
+		v, err, shared := c.flights.do(fmt.Sprintf("%d/%s", generation, key), func() (interface{}, error) {
+			v, err := load()
+			if err == nil {
+				c.cache.add(generation, key, v)
+			} else if ctx.Err() != nil {
+				err = canceledLoad{err: err}
+			}
+			return v, err
+		})
+		if shared {
+			atomic.AddUint64(&c.sharedLoads, 1)
+		}
+
+		if canceled, ok := err.(canceledLoad); ok {
+			if shared && ctx.Err() == nil {
+				continue
+			}
+			err = canceled.err
+		}
+
+		return v, err
+	}
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingDB counts the lookups that reach the backend and can hold them until release is closed
+type countingDB struct {
+	*InMemoryDB
+	lookups uint64
+	release chan struct{}
+}
+
+func (db *countingDB) GetTodoByName(ctx context.Context, name string) (Todo, error) {
+	atomic.AddUint64(&db.lookups, 1)
+	if db.release != nil {
+		select {
+		case <-db.release:
+		case <-ctx.Done():
+			return Todo{}, fmt.Errorf("storage.GetTodoByName failed: %v", ctx.Err())
+		}
+	}
+	return db.InMemoryDB.GetTodoByName(ctx, name)
+}
+
+func TestCachedDB_ReadThrough(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingDB{InMemoryDB: NewInMemoryDB()}
+	db := NewCachedDB(backend, CacheConfig{})
+
+	saved, err := db.SaveTodo(ctx, "shopping", "get milk and eggs")
+	if err != nil {
+		t.Fatalf("SaveTodo got unexpected error: %+v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := db.GetTodoByName(ctx, "shopping"); err != nil {
+			t.Fatalf("GetTodoByName got unexpected error: %+v", err)
+		}
+	}
+	if backend.lookups != 1 {
+		t.Errorf("expected 1 backend lookup for 3 reads; got %d", backend.lookups)
+	}
+
+	// a write drops the cache, so the next read sees it
+	if _, err := db.EditTodo(ctx, saved.ID, Todo{Name: "shopping", Description: "bread"}); err != nil {
+		t.Fatalf("EditTodo got unexpected error: %+v", err)
+	}
+	todo, err := db.GetTodoByName(ctx, "shopping")
+	if err != nil || todo.Description != "bread" {
+		t.Errorf("GetTodoByName expected the edited todo; got %+v, %v", todo, err)
+	}
+
+	// errors aren't cached
+	for i := 0; i < 2; i++ {
+		if _, err := db.GetTodoByName(ctx, "missing"); err != ErrNotFound {
+			t.Fatalf("GetTodoByName expected error %v; got %v", ErrNotFound, err)
+		}
+	}
+
+	stats := db.Stats()
+	expected := CacheStats{Hits: 2, Misses: 4, Invalidations: 2, Entries: 1}
+	if stats != expected {
+		t.Errorf("Stats expected %+v; got %+v", expected, stats)
+	}
+}
+
+func TestCachedDB_ExpiryAndEviction(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingDB{InMemoryDB: NewInMemoryDB()}
+	db := NewCachedDB(backend, CacheConfig{Size: 2, TTL: time.Minute})
+
+	clock := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	db.cache.now = func() time.Time { return clock }
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := backend.SaveTodo(ctx, name, ""); err != nil {
+			t.Fatalf("SaveTodo got unexpected error: %+v", err)
+		}
+		_, _ = db.GetTodoByName(ctx, name)
+	}
+
+	// "a" was evicted to make room for "c"
+	_, _ = db.GetTodoByName(ctx, "c")
+	_, _ = db.GetTodoByName(ctx, "a")
+	if backend.lookups != 4 {
+		t.Errorf("expected 4 backend lookups; got %d", backend.lookups)
+	}
+
+	clock = clock.Add(time.Minute)
+	_, _ = db.GetTodoByName(ctx, "a")
+	if backend.lookups != 5 {
+		t.Errorf("expected an expired entry to be looked up again; got %d lookups", backend.lookups)
+	}
+
+	if evictions := db.Stats().Evictions; evictions != 2 {
+		t.Errorf("expected 2 evictions; got %d", evictions)
+	}
+}
+
+func TestCachedDB_ConcurrentMisses(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingDB{InMemoryDB: NewInMemoryDB(), release: make(chan struct{})}
+	db := NewCachedDB(backend, CacheConfig{})
+
+	if _, err := backend.InMemoryDB.SaveTodo(ctx, "shopping", ""); err != nil {
+		t.Fatalf("SaveTodo got unexpected error: %+v", err)
+	}
+
+	const readers = 5
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := db.GetTodoByName(ctx, "shopping"); err != nil {
+				t.Errorf("GetTodoByName got unexpected error: %+v", err)
+			}
+		}()
+	}
+
+	// wait until every reader is either loading or waiting for the load
+	deadline := time.Now().Add(5 * time.Second)
+	for waiting(&db.flights) != readers-1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("readers didn't join the same load")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(backend.release)
+	wg.Wait()
+
+	if backend.lookups != 1 {
+		t.Errorf("expected 1 backend lookup for %d concurrent reads; got %d", readers, backend.lookups)
+	}
+	if shared := db.Stats().SharedLoads; shared != readers-1 {
+		t.Errorf("expected %d shared loads; got %d", readers-1, shared)
+	}
+}
+
+func TestCachedDB_CanceledSharedLoad(t *testing.T) {
+	backend := &countingDB{InMemoryDB: NewInMemoryDB(), release: make(chan struct{})}
+	db := NewCachedDB(backend, CacheConfig{})
+
+	if _, err := backend.InMemoryDB.SaveTodo(context.Background(), "shopping", ""); err != nil {
+		t.Fatalf("SaveTodo got unexpected error: %+v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	loaderErr := make(chan error, 1)
+	go func() {
+		_, err := db.GetTodoByName(ctx, "shopping")
+		loaderErr <- err
+	}()
+	for atomic.LoadUint64(&backend.lookups) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	waiterErr := make(chan error, 1)
+	go func() {
+		_, err := db.GetTodoByName(context.Background(), "shopping")
+		waiterErr <- err
+	}()
+	for waiting(&db.flights) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the caller running the load gives up; the one waiting on it still wants the todo
+	cancel()
+	if err := <-loaderErr; err == nil || errors.As(err, &canceledLoad{}) {
+		t.Errorf("expected the canceled caller to get its own ctx error; got %v", err)
+	}
+	close(backend.release)
+	if err := <-waiterErr; err != nil {
+		t.Errorf("expected the waiting caller to load the todo itself; got %v", err)
+	}
+	if lookups := atomic.LoadUint64(&backend.lookups); lookups != 2 {
+		t.Errorf("expected 2 backend lookups; got %d", lookups)
+	}
+}
+
+func TestCachedDB_LoadRacingWrite(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingDB{InMemoryDB: NewInMemoryDB(), release: make(chan struct{})}
+	db := NewCachedDB(backend, CacheConfig{})
+
+	saved, _ := backend.InMemoryDB.SaveTodo(ctx, "shopping", "milk")
+
+	loaded := make(chan struct{})
+	go func() {
+		defer close(loaded)
+		_, _ = db.GetTodoByName(ctx, "shopping")
+	}()
+
+	for atomic.LoadUint64(&backend.lookups) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := db.EditTodo(ctx, saved.ID, Todo{Name: "shopping", Description: "bread"}); err != nil {
+		t.Fatalf("EditTodo got unexpected error: %+v", err)
+	}
+	close(backend.release)
+	<-loaded
+
+	// the load started before the edit, so what it read mustn't have been cached
+	todo, err := db.GetTodoByName(ctx, "shopping")
+	if err != nil || todo.Description != "bread" {
+		t.Errorf("GetTodoByName expected the edited todo; got %+v, %v", todo, err)
+	}
+}
+
+func waiting(g *flightGroup) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	n := 0
+	for _, f := range g.calls {
+		n += f.dups
+	}
+	return n
+}
@@ -0,0 +1,445 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+// EncryptedDB encrypts todo descriptions before they reach the DB behind it and decrypts them on the way back, so
+// descriptions are only ever stored as ciphertext. Each description is bound to its tenant, so it can't be decrypted
+// as part of another tenant's list.
+//
+// The backend only sees ciphertext, so filters and searches can't match words in descriptions, only in names.
+// Descriptions stored before encryption was turned on are read as they are until Reencrypt encrypts them.
+type EncryptedDB struct {
+	db   DB
+	keys *KeyRing
+}
+
+func NewEncryptedDB(db DB, keys *KeyRing) *EncryptedDB {
+	return &EncryptedDB{db: db, keys: keys}
+}
+
+func (e *EncryptedDB) SaveTodo(ctx context.Context, name, description string) (Todo, error) {
+	sealed, err := e.keys.encrypt(description, tenant.ID(ctx))
+	if err != nil {
+		return Todo{}, fmt.Errorf("storage.SaveTodo failed to encrypt description: %v", err)
+	}
+
+	todo, err := e.db.SaveTodo(ctx, name, sealed)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	todo.Description = description
+	return todo, nil
+}
+
+func (e *EncryptedDB) GetTodoList(ctx context.Context) ([]Todo, error) {
+	todos, err := e.db.GetTodoList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = e.openAll(todos); err != nil {
+		return nil, err
+	}
+	return todos, nil
+}
+
+func (e *EncryptedDB) ListTodos(ctx context.Context, opts ListOptions) (TodoPage, error) {
+	page, err := e.db.ListTodos(ctx, opts)
+	if err != nil {
+		return TodoPage{}, err
+	}
+	if err = e.openAll(page.Todos); err != nil {
+		return TodoPage{}, err
+	}
+	return page, nil
+}
+
+func (e *EncryptedDB) SearchTodos(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	hits, err := e.db.SearchTodos(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hits {
+		if err = e.open(&hits[i].Todo); err != nil {
+			return nil, err
+		}
+	}
+	return hits, nil
+}
+
+func (e *EncryptedDB) GetTodoByName(ctx context.Context, name string) (Todo, error) {
+	todo, err := e.db.GetTodoByName(ctx, name)
+	if err != nil {
+		return Todo{}, err
+	}
+	if err = e.open(&todo); err != nil {
+		return Todo{}, err
+	}
+	return todo, nil
+}
+
+func (e *EncryptedDB) GetTodoByID(ctx context.Context, id string) (Todo, error) {
+	todo, err := e.db.GetTodoByID(ctx, id)
+	if err != nil {
+		return Todo{}, err
+	}
+	if err = e.open(&todo); err != nil {
+		return Todo{}, err
+	}
+	return todo, nil
+}
+
+func (e *EncryptedDB) EditTodo(ctx context.Context, id string, todo Todo) (Todo, error) {
+	description := todo.Description
+
+	sealed, err := e.sealEdit(ctx, id, description)
+	if err != nil {
+		return Todo{}, fmt.Errorf("storage.EditTodo failed to encrypt description: %v", err)
+	}
+	todo.Description = sealed
+
+	edited, err := e.db.EditTodo(ctx, id, todo)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	edited.Description = description
+	return edited, nil
+}
+
+func (e *EncryptedDB) DeleteTodo(ctx context.Context, id string) error {
+	return e.db.DeleteTodo(ctx, id)
+}
+
+func (e *EncryptedDB) DeleteTodos(ctx context.Context, filter query.Expr) ([]Todo, error) {
+	deleted, err := e.db.DeleteTodos(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err = e.openAll(deleted); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func (e *EncryptedDB) ClearTodoList(ctx context.Context) error {
+	return e.db.ClearTodoList(ctx)
+}
+
+// BulkWrite encrypts the descriptions of creates and updates on their way to the backend's bulk write, and
+// decrypts the todos that deletes return
+func (e *EncryptedDB) BulkWrite(ctx context.Context, ops []BulkOp) ([]BulkResult, error) {
+	sealed := make([]BulkOp, len(ops))
+	for i, op := range ops {
+		var err error
+		switch op.Kind {
+		case BulkCreate:
+			op.Todo.Description, err = e.keys.encrypt(op.Todo.Description, tenant.ID(ctx))
+		case BulkUpdate:
+			op.Todo.Description, err = e.sealEdit(ctx, op.ID, op.Todo.Description)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("storage.BulkWrite failed to encrypt description: %v", err)
+		}
+		sealed[i] = op
+	}
+
+	results, err := BulkWrite(ctx, e.db, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		if ops[i].Kind == BulkDelete {
+			if err = e.open(&results[i].Todo); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		results[i].Todo.Description = ops[i].Todo.Description
+	}
+
+	return results, nil
+}
+
+// WithTransaction runs fn in the backend's transaction, with tx encrypting like e does
+func (e *EncryptedDB) WithTransaction(ctx context.Context, fn func(tx DB) error) error {
+	return RunInTransaction(ctx, e.db, func(tx DB) error {
+		return fn(&EncryptedDB{db: tx, keys: e.keys})
+	})
+}
+
+// SupportsTransactions reports whether the backend's transactions are all-or-nothing
+func (e *EncryptedDB) SupportsTransactions() bool {
+	return SupportsTransactions(e.db)
+}
+
+// Watch follows the backend's change feed, decrypting the todos in its events. An event whose todo can't be
+// decrypted is passed on without the description rather than held back, so watchers still see the write.
+func (e *EncryptedDB) Watch(ctx context.Context, opts WatchOptions) (<-chan ChangeEvent, error) {
+	watcher, ok := e.db.(Watcher)
+	if !ok {
+		return nil, errors.New("storage.Watch isn't supported by the encrypted DB's backend")
+	}
+
+	sealed, err := watcher.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan ChangeEvent)
+	go func() {
+		defer close(events)
+		for event := range sealed {
+			if err := e.open(&event.Todo); err != nil {
+				log.Printf("storage.Watch failed to decrypt todo %s: %v", event.TodoID, err)
+				event.Todo.Description = ""
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// AppendEvents encrypts the descriptions of the todos in events, so the outbox doesn't keep them in plaintext
+func (e *EncryptedDB) AppendEvents(ctx context.Context, events ...OutboxEvent) error {
+	outbox, ok := e.db.(Outbox)
+	if !ok {
+		return ErrNoOutbox
+	}
+
+	sealed := make([]OutboxEvent, len(events))
+	for i, event := range events {
+		var err error
+		if event.Todo.Description, err = e.keys.encrypt(event.Todo.Description, partitionKey(event.Todo.Tenant)); err != nil {
+			return fmt.Errorf("storage.AppendEvents failed to encrypt description: %v", err)
+		}
+		sealed[i] = event
+	}
+
+	return outbox.AppendEvents(ctx, sealed...)
+}
+
+func (e *EncryptedDB) PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	outbox, ok := e.db.(Outbox)
+	if !ok {
+		return nil, errors.New("storage.PendingEvents isn't supported by the encrypted DB's backend")
+	}
+
+	events, err := outbox.PendingEvents(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range events {
+		if err = e.open(&events[i].Todo); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (e *EncryptedDB) MarkPublished(ctx context.Context, ids ...string) error {
+	outbox, ok := e.db.(Outbox)
+	if !ok {
+		return errors.New("storage.MarkPublished isn't supported by the encrypted DB's backend")
+	}
+	return outbox.MarkPublished(ctx, ids...)
+}
+
+// EachTodo walks the backend's todos with their descriptions decrypted
+func (e *EncryptedDB) EachTodo(ctx context.Context, fn func(todo Todo) error) error {
+	exporter, ok := e.db.(Exporter)
+	if !ok {
+		return errors.New("storage.EachTodo isn't supported by the encrypted DB's backend")
+	}
+	return exporter.EachTodo(ctx, func(todo Todo) error {
+		if err := e.open(&todo); err != nil {
+			return err
+		}
+		return fn(todo)
+	})
+}
+
+// ImportTodos encrypts the descriptions of upserts before the backend stores them
+func (e *EncryptedDB) ImportTodos(ctx context.Context, upserts, deletes []Todo) error {
+	importer, ok := e.db.(Importer)
+	if !ok {
+		return errors.New("storage.ImportTodos isn't supported by the encrypted DB's backend")
+	}
+
+	sealed := make([]Todo, len(upserts))
+	for i, todo := range upserts {
+		var err error
+		if todo.Description, err = e.keys.encrypt(todo.Description, partitionKey(todo.Tenant)); err != nil {
+			return fmt.Errorf("storage.ImportTodos failed to encrypt description: %v", err)
+		}
+		sealed[i] = todo
+	}
+
+	return importer.ImportTodos(ctx, sealed, deletes)
+}
+
+// Ping pings the backend if it can be pinged
+func (e *EncryptedDB) Ping(ctx context.Context) error {
+	if pinger, ok := e.db.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Close closes the backend if it needs closing
+func (e *EncryptedDB) Close(ctx context.Context) error {
+	if closer, ok := e.db.(Closer); ok {
+		return closer.Close(ctx)
+	}
+	return nil
+}
+
+// ClaimIdempotencyKey and CompleteIdempotencyKey encrypt the stored response bodies, which hold descriptions, and
+// decrypt the ones they return. Without an IdempotencyStore every key can be claimed and nothing is remembered.
+func (e *EncryptedDB) ClaimIdempotencyKey(ctx context.Context, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	store, ok := e.db.(IdempotencyStore)
+	if !ok {
+		return record, true, nil
+	}
+
+	existing, claimed, err := store.ClaimIdempotencyKey(ctx, record)
+	if err != nil || claimed || existing.Response == nil {
+		return existing, claimed, err
+	}
+
+	body, err := e.keys.decrypt(string(existing.Response.Body), partitionKey(existing.Tenant))
+	if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("storage.EncryptedDB failed to decrypt response for idempotency key %s: %v", existing.Key, err)
+	}
+	response := *existing.Response
+	response.Body = []byte(body)
+	existing.Response = &response
+
+	return existing, false, nil
+}
+
+func (e *EncryptedDB) CompleteIdempotencyKey(ctx context.Context, key string, response IdempotentResponse) error {
+	store, ok := e.db.(IdempotencyStore)
+	if !ok {
+		return nil
+	}
+
+	body, err := e.keys.encrypt(string(response.Body), tenant.ID(ctx))
+	if err != nil {
+		return fmt.Errorf("storage.CompleteIdempotencyKey failed to encrypt response: %v", err)
+	}
+	response.Body = []byte(body)
+
+	return store.CompleteIdempotencyKey(ctx, key, response)
+}
+
+func (e *EncryptedDB) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	if store, ok := e.db.(IdempotencyStore); ok {
+		return store.ReleaseIdempotencyKey(ctx, key)
+	}
+	return nil
+}
+
+// DescriptionRewriter is implemented by DB backends that can rewrite every stored description, across all tenants.
+// rewrite gets each todo as stored and returns its new description, or false to leave it alone; a description that
+// changed since it was read is left alone too.
+type DescriptionRewriter interface {
+	RewriteDescriptions(ctx context.Context, rewrite func(todo Todo) (string, bool, error)) (int, error)
+}
+
+// ReencryptStats counts what Reencrypt did
+type ReencryptStats struct {
+	Scanned   int
+	Rewritten int
+}
+
+// Reencrypt encrypts every description that's still plaintext or encrypted with an old key with the primary key,
+// after which the old keys can be retired. With dryRun it only counts the descriptions it would rewrite.
+func (e *EncryptedDB) Reencrypt(ctx context.Context, dryRun bool) (ReencryptStats, error) {
+	rewriter, ok := e.db.(DescriptionRewriter)
+	if !ok {
+		return ReencryptStats{}, errors.New("storage.Reencrypt isn't supported by the encrypted DB's backend")
+	}
+
+	var stats ReencryptStats
+	rewritten, err := rewriter.RewriteDescriptions(ctx, func(todo Todo) (string, bool, error) {
+		stats.Scanned++
+		if e.keys.current(todo.Description) {
+			return "", false, nil
+		}
+
+		aad := partitionKey(todo.Tenant)
+		plaintext, err := e.keys.decrypt(todo.Description, aad)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to decrypt todo %s: %v", todo.ID, err)
+		}
+		if dryRun {
+			stats.Rewritten++
+			return "", false, nil
+		}
+
+		sealed, err := e.keys.encrypt(plaintext, aad)
+		return sealed, err == nil, err
+	})
+	if !dryRun {
+		stats.Rewritten = rewritten
+	}
+	if err != nil {
+		return stats, fmt.Errorf("storage.Reencrypt stopped after %d of %d todos: %v", stats.Rewritten, stats.Scanned, err)
+	}
+
+	return stats, nil
+}
+
+func (e *EncryptedDB) openAll(todos []Todo) error {
+	for i := range todos {
+		if err := e.open(&todos[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealEdit encrypts the description an edit gives the todo with the given ID. Each encryption has a fresh nonce, so
+// if the description is the one already stored, its ciphertext is kept instead: otherwise the backend would see a
+// change, and the event-sourced one record a description_changed, for every edit.
+func (e *EncryptedDB) sealEdit(ctx context.Context, id, description string) (string, error) {
+	current, err := e.db.GetTodoByID(ctx, id)
+	if err != nil && err != ErrNotFound {
+		return "", err
+	}
+	if _, _, encrypted := splitEncrypted(current.Description); encrypted {
+		opened := current
+		if e.open(&opened) == nil && opened.Description == description {
+			return current.Description, nil
+		}
+	}
+	return e.keys.encrypt(description, tenant.ID(ctx))
+}
+
+// open decrypts todo's description in place
+func (e *EncryptedDB) open(todo *Todo) error {
+	plaintext, err := e.keys.decrypt(todo.Description, partitionKey(todo.Tenant))
+	if err != nil {
+		return fmt.Errorf("storage.EncryptedDB failed to decrypt description of todo %s: %v", todo.ID, err)
+	}
+	todo.Description = plaintext
+	return nil
+}
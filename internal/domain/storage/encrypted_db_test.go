@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func TestEncryptedDB(t *testing.T) {
+	ctx := tenant.WithID(context.Background(), "team-a")
+	backend := NewInMemoryDB()
+	db := NewEncryptedDB(backend, newTestKeyRing(t, "k1", "k1"))
+
+	saved, err := db.SaveTodo(ctx, "call customer", "Jane, 555-0100")
+	if err != nil {
+		t.Fatalf("SaveTodo got unexpected error: %+v", err)
+	}
+	if saved.Description != "Jane, 555-0100" {
+		t.Errorf("SaveTodo expected the plaintext description back; got %q", saved.Description)
+	}
+
+	stored, _ := backend.GetTodoByName(ctx, "call customer")
+	if !strings.HasPrefix(stored.Description, "enc:v1:k1:") {
+		t.Errorf("backend expected an encrypted description; got %q", stored.Description)
+	}
+
+	edited, err := db.EditTodo(ctx, saved.ID, Todo{Name: "call customer", Description: "Jane, 555-0199"})
+	if err != nil || edited.Description != "Jane, 555-0199" {
+		t.Fatalf("EditTodo expected the new plaintext description; got %+v, %v", edited, err)
+	}
+
+	list, err := db.GetTodoList(ctx)
+	if err != nil || len(list) != 1 || list[0].Description != "Jane, 555-0199" {
+		t.Errorf("GetTodoList expected the decrypted todo; got %+v, %v", list, err)
+	}
+
+	err = db.WithTransaction(ctx, func(tx DB) error {
+		todo, err := tx.SaveTodo(ctx, "invoice", "ACME Ltd")
+		if err != nil {
+			return err
+		}
+		return tx.(Outbox).AppendEvents(ctx, OutboxEvent{Type: "todo.created", TodoID: todo.ID, Todo: todo})
+	})
+	if err != nil {
+		t.Fatalf("WithTransaction got unexpected error: %+v", err)
+	}
+
+	if stored, _ = backend.GetTodoByName(ctx, "invoice"); !strings.HasPrefix(stored.Description, "enc:v1:") {
+		t.Errorf("backend expected an encrypted description from the transaction; got %q", stored.Description)
+	}
+	if pending, _ := backend.PendingEvents(ctx, 0); len(pending) != 1 || strings.Contains(pending[0].Todo.Description, "ACME") {
+		t.Errorf("outbox expected an encrypted description; got %+v", pending)
+	}
+	if pending, _ := db.PendingEvents(ctx, 0); len(pending) != 1 || pending[0].Todo.Description != "ACME Ltd" {
+		t.Errorf("PendingEvents expected the decrypted description; got %+v", pending)
+	}
+}
+
+func TestEncryptedDB_Reencrypt(t *testing.T) {
+	ctx := context.Background()
+	backend := NewInMemoryDB()
+
+	// one todo from before encryption, one under the old key and one under the new
+	if _, err := backend.SaveTodo(ctx, "legacy", "plain"); err != nil {
+		t.Fatalf("SaveTodo got unexpected error: %+v", err)
+	}
+	if _, err := NewEncryptedDB(backend, newTestKeyRing(t, "k1", "k1")).SaveTodo(ctx, "old", "under k1"); err != nil {
+		t.Fatalf("SaveTodo got unexpected error: %+v", err)
+	}
+	rotated := NewEncryptedDB(backend, newTestKeyRing(t, "k2", "k1", "k2"))
+	if _, err := rotated.SaveTodo(ctx, "new", "under k2"); err != nil {
+		t.Fatalf("SaveTodo got unexpected error: %+v", err)
+	}
+
+	stats, err := rotated.Reencrypt(ctx, true)
+	if err != nil || stats != (ReencryptStats{Scanned: 3, Rewritten: 2}) {
+		t.Fatalf("Reencrypt dry run expected 2 of 3 to rewrite; got %+v, %v", stats, err)
+	}
+	if legacy, _ := backend.GetTodoByName(ctx, "legacy"); legacy.Description != "plain" {
+		t.Errorf("Reencrypt dry run changed a description: %q", legacy.Description)
+	}
+
+	if stats, err = rotated.Reencrypt(ctx, false); err != nil || stats.Rewritten != 2 {
+		t.Fatalf("Reencrypt expected 2 rewrites; got %+v, %v", stats, err)
+	}
+
+	// with everything under k2, k1 can be retired
+	retired := NewEncryptedDB(backend, newTestKeyRing(t, "k2", "k2"))
+	list, err := retired.GetTodoList(ctx)
+	if err != nil {
+		t.Fatalf("GetTodoList after retiring k1 got unexpected error: %+v", err)
+	}
+	for _, todo := range list {
+		if !strings.HasPrefix(todo.Description, "under") && todo.Description != "plain" {
+			t.Errorf("GetTodoList expected decrypted descriptions; got %q", todo.Description)
+		}
+	}
+	if raw, _ := backend.GetTodoList(ctx); !strings.HasPrefix(raw[0].Description, "enc:v1:k2:") {
+		t.Errorf("Reencrypt expected the legacy description under k2; got %q", raw[0].Description)
+	}
+}
+
+func TestEncryptedDB_UnchangedDescription(t *testing.T) {
+	ctx := tenant.WithID(context.Background(), "team-a")
+	store := NewInMemoryEventStore()
+	db := NewEncryptedDB(newTestEventSourcedDB(t, store, EventSourcedConfig{}), newTestKeyRing(t, "k1", "k1"))
+
+	saved, err := db.SaveTodo(ctx, "call customer", "Jane, 555-0100")
+	if err != nil {
+		t.Fatalf("SaveTodo got unexpected error: %+v", err)
+	}
+
+	testData := []struct {
+		testName       string
+		edit           Todo
+		expectedEvents []TodoEventType
+	}{
+		{
+			testName:       "rename keeps the ciphertext",
+			edit:           Todo{Name: "call Jane", Description: "Jane, 555-0100"},
+			expectedEvents: []TodoEventType{TodoRenamed},
+		},
+		{
+			testName:       "new description is encrypted",
+			edit:           Todo{Name: "call Jane", Description: "Jane, 555-0199"},
+			expectedEvents: []TodoEventType{TodoDescriptionChanged},
+		},
+		{
+			testName: "nothing changed",
+			edit:     Todo{Name: "call Jane", Description: "Jane, 555-0199"},
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			before, _ := store.Commits(ctx, 0)
+			edited, err := db.EditTodo(ctx, saved.ID, td.edit)
+			if err != nil || edited.Description != td.edit.Description {
+				t.Fatalf("EditTodo expected the plaintext description back; got %+v, %v", edited, err)
+			}
+
+			commits, _ := store.Commits(ctx, int64(len(before)))
+			var actual []TodoEventType
+			for _, commit := range commits {
+				for _, event := range commit.Events {
+					actual = append(actual, event.Type)
+				}
+			}
+			if diff := cmp.Diff(td.expectedEvents, actual); diff != "" {
+				t.Errorf("EditTodo expected vs actual events don't match: %v", diff)
+			}
+		})
+	}
+}
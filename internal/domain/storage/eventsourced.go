@@ -0,0 +1,464 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+)
+
+// TodoEventType is what a TodoEvent did to its todo
+type TodoEventType string
+
+const (
+	TodoCreated            TodoEventType = "created"
+	TodoRenamed            TodoEventType = "renamed"
+	TodoDescriptionChanged TodoEventType = "description_changed"
+	TodoCompleted          TodoEventType = "completed"
+	TodoReopened           TodoEventType = "reopened"
+	TodoDeleted            TodoEventType = "deleted"
+	// TodoImported sets the whole todo, timestamps included, as restored from a backup
+	TodoImported TodoEventType = "imported"
+)
+
+const (
+	DefaultSnapshotEvery = 100
+
+	// maxCommitAttempts bounds how often a write is retried when other instances keep committing first
+	maxCommitAttempts = 5
+)
+
+var ErrCommitConflict = errors.New("another write was committed first")
+
+// TodoEvent is one change to one todo. Only the fields the change sets are filled in: Name and Description for
+// created, Name for renamed, Description for description_changed, and all of them for imported, whose At is the
+// todo's UpdatedAt. Events recorded before tenants have no Tenant and belong to the default one.
+type TodoEvent struct {
+	Type        TodoEventType `bson:"type"`
+	Tenant      string        `bson:"tenant,omitempty"`
+	TodoID      string        `bson:"todo_id"`
+	Name        string        `bson:"name,omitempty"`
+	Description string        `bson:"description,omitempty"`
+	Completed   bool          `bson:"completed,omitempty"`
+	CreatedAt   time.Time     `bson:"created_at,omitempty"`
+	At          time.Time     `bson:"at"`
+	// Version is the todo's version after the event. The events of one edit share it; events recorded before
+	// versions have none and count as one write each.
+	Version int64 `bson:"version,omitempty"`
+}
+
+// Commit is the unit of the event stream: the events of one write, stored atomically. Seq numbers the commits from 1
+// without gaps. Outbox holds the outbox events of the write, so they're stored with it or not at all.
+type Commit struct {
+	Seq    int64         `bson:"_id"`
+	Events []TodoEvent   `bson:"events"`
+	Outbox []OutboxEvent `bson:"outbox,omitempty"`
+}
+
+// Snapshot is the projected lists of all tenants as of commit Seq, so a restart only replays the commits after it
+type Snapshot struct {
+	Seq     int64     `bson:"_id"`
+	Todos   []Todo    `bson:"todos"`
+	TakenAt time.Time `bson:"taken_at"`
+}
+
+// EventStore is an append-only stream of commits
+type EventStore interface {
+	// Append stores commit only if its Seq directly follows the last stored commit, and fails with
+	// ErrCommitConflict otherwise
+	Append(ctx context.Context, commit Commit) error
+	// Commits returns the commits after seq, oldest first
+	Commits(ctx context.Context, afterSeq int64) ([]Commit, error)
+	SaveSnapshot(ctx context.Context, snapshot Snapshot) error
+	// LatestSnapshot returns the snapshot with the highest Seq, or a zero Snapshot if there are none
+	LatestSnapshot(ctx context.Context) (Snapshot, error)
+}
+
+// commitOutbox is implemented by event stores that relay the outbox events stored in their commits. PendingEvents
+// returns them in commit order.
+type commitOutbox interface {
+	PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error)
+	MarkPublished(ctx context.Context, ids ...string) error
+}
+
+// EventSourcedConfig tunes an EventSourcedDB; zero values mean the defaults
+type EventSourcedConfig struct {
+	// SnapshotEvery is how many commits are made between snapshots
+	SnapshotEvery int
+}
+
+// EventSourcedDB is a DB whose source of truth is an EventStore: every write appends a commit of TodoEvents, and
+// reads are served from an in-memory projection of the stream. Before each read or write the projection catches up
+// with commits made by other instances, and writes are checked against it and only committed if nothing else was
+// committed in the meantime, so every instance sees the same history.
+type EventSourcedDB struct {
+	store EventStore
+	cfg   EventSourcedConfig
+
+	// mu serialises catching up and committing
+	mu          sync.Mutex
+	projection  *InMemoryDB
+	seq         int64
+	snapshotSeq int64
+}
+
+// NewEventSourcedDB loads the latest snapshot and replays the commits after it
+func NewEventSourcedDB(ctx context.Context, store EventStore, cfg EventSourcedConfig) (*EventSourcedDB, error) {
+	if cfg.SnapshotEvery <= 0 {
+		cfg.SnapshotEvery = DefaultSnapshotEvery
+	}
+
+	snapshot, err := store.LatestSnapshot(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewEventSourcedDB failed to load snapshot: %v", err)
+	}
+
+	es := &EventSourcedDB{
+		store:       store,
+		cfg:         cfg,
+		projection:  NewInMemoryDB(),
+		seq:         snapshot.Seq,
+		snapshotSeq: snapshot.Seq,
+	}
+	for _, todo := range snapshot.Todos {
+		es.projection.put(todo)
+	}
+
+	if err = es.catchUp(ctx); err != nil {
+		return nil, err
+	}
+
+	return es, nil
+}
+
+// ReplayEvents rebuilds every tenant's list from the very first commit, ignoring snapshots, and returns the todos
+// with the Seq of the last commit
+func ReplayEvents(ctx context.Context, store EventStore) ([]Todo, int64, error) {
+	commits, err := store.Commits(ctx, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("storage.ReplayEvents failed to load commits: %v", err)
+	}
+
+	projection := &InMemoryDB{}
+	var seq int64
+	for _, commit := range commits {
+		if commit.Seq != seq+1 {
+			return nil, 0, fmt.Errorf("storage.ReplayEvents found commit %d after %d", commit.Seq, seq)
+		}
+		applyCommit(projection, commit)
+		seq = commit.Seq
+	}
+
+	return projection.allTodos(), seq, nil
+}
+
+func (es *EventSourcedDB) SaveTodo(ctx context.Context, name, description string) (Todo, error) {
+	var saved Todo
+	err := es.WithTransaction(ctx, func(tx DB) error {
+		var err error
+		saved, err = tx.SaveTodo(ctx, name, description)
+		return err
+	})
+	return saved, err
+}
+
+func (es *EventSourcedDB) GetTodoList(ctx context.Context) ([]Todo, error) {
+	if err := es.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return es.projection.GetTodoList(ctx)
+}
+
+func (es *EventSourcedDB) ListTodos(ctx context.Context, opts ListOptions) (TodoPage, error) {
+	if err := es.refresh(ctx); err != nil {
+		return TodoPage{}, err
+	}
+	return es.projection.ListTodos(ctx, opts)
+}
+
+func (es *EventSourcedDB) SearchTodos(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	if err := es.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return es.projection.SearchTodos(ctx, opts)
+}
+
+func (es *EventSourcedDB) GetTodoByName(ctx context.Context, name string) (Todo, error) {
+	if err := es.refresh(ctx); err != nil {
+		return Todo{}, err
+	}
+	return es.projection.GetTodoByName(ctx, name)
+}
+
+func (es *EventSourcedDB) GetTodoByID(ctx context.Context, id string) (Todo, error) {
+	if err := es.refresh(ctx); err != nil {
+		return Todo{}, err
+	}
+	return es.projection.GetTodoByID(ctx, id)
+}
+
+func (es *EventSourcedDB) EditTodo(ctx context.Context, id string, todo Todo) (Todo, error) {
+	var edited Todo
+	err := es.WithTransaction(ctx, func(tx DB) error {
+		var err error
+		edited, err = tx.EditTodo(ctx, id, todo)
+		return err
+	})
+	return edited, err
+}
+
+func (es *EventSourcedDB) DeleteTodo(ctx context.Context, id string) error {
+	return es.WithTransaction(ctx, func(tx DB) error {
+		return tx.DeleteTodo(ctx, id)
+	})
+}
+
+func (es *EventSourcedDB) DeleteTodos(ctx context.Context, filter query.Expr) ([]Todo, error) {
+	var deleted []Todo
+	err := es.WithTransaction(ctx, func(tx DB) error {
+		var err error
+		deleted, err = tx.DeleteTodos(ctx, filter)
+		return err
+	})
+	return deleted, err
+}
+
+func (es *EventSourcedDB) ClearTodoList(ctx context.Context) error {
+	return es.WithTransaction(ctx, func(tx DB) error {
+		return tx.ClearTodoList(ctx)
+	})
+}
+
+// WithTransaction runs fn against a copy of the caught-up projection and appends everything fn wrote, outbox events
+// included, as one commit. If another instance committed first, fn is run again on the new state. Like InMemoryDB,
+// the DB is locked while fn runs, so fn must only use tx.
+func (es *EventSourcedDB) WithTransaction(ctx context.Context, fn func(tx DB) error) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	for attempt := 0; attempt < maxCommitAttempts; attempt++ {
+		if err := es.catchUp(ctx); err != nil {
+			return err
+		}
+
+		tx := &eventSourcedTx{
+			state: es.projection.clone(),
+			at:    now(),
+			store: es.store,
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if len(tx.events) == 0 && len(tx.outbox) == 0 {
+			return nil
+		}
+
+		commit := Commit{Seq: es.seq + 1, Events: tx.events, Outbox: tx.outbox}
+		err := es.store.Append(ctx, commit)
+		if err == ErrCommitConflict {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("storage.WithTransaction failed to append commit: %v", err)
+		}
+
+		applyCommit(es.projection, commit)
+		es.seq = commit.Seq
+		es.maybeSnapshot(ctx)
+
+		return nil
+	}
+
+	return ErrCommitConflict
+}
+
+// EachTodo walks the caught-up projection of every tenant's todos
+func (es *EventSourcedDB) EachTodo(ctx context.Context, fn func(todo Todo) error) error {
+	if err := es.refresh(ctx); err != nil {
+		return err
+	}
+	return es.projection.EachTodo(ctx, fn)
+}
+
+// ImportTodos commits a deleted event for each of deletes and an imported event for each of upserts
+func (es *EventSourcedDB) ImportTodos(ctx context.Context, upserts, deletes []Todo) error {
+	return es.WithTransaction(ctx, func(tx DB) error {
+		tx.(*eventSourcedTx).importTodos(upserts, deletes)
+		return nil
+	})
+}
+
+// ClaimIdempotencyKey and the other idempotency methods use the event store, if it can store idempotency keys; the
+// events themselves don't record them. Otherwise every key can be claimed and nothing is remembered.
+func (es *EventSourcedDB) ClaimIdempotencyKey(ctx context.Context, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	if store, ok := es.store.(IdempotencyStore); ok {
+		return store.ClaimIdempotencyKey(ctx, record)
+	}
+	return record, true, nil
+}
+
+func (es *EventSourcedDB) CompleteIdempotencyKey(ctx context.Context, key string, response IdempotentResponse) error {
+	if store, ok := es.store.(IdempotencyStore); ok {
+		return store.CompleteIdempotencyKey(ctx, key, response)
+	}
+	return nil
+}
+
+func (es *EventSourcedDB) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	if store, ok := es.store.(IdempotencyStore); ok {
+		return store.ReleaseIdempotencyKey(ctx, key)
+	}
+	return nil
+}
+
+// AppendEvents stores events in a commit of their own. The events of a write are stored in its commit by appending
+// them through the transaction's tx instead.
+func (es *EventSourcedDB) AppendEvents(ctx context.Context, events ...OutboxEvent) error {
+	return es.WithTransaction(ctx, func(tx DB) error {
+		return tx.(Outbox).AppendEvents(ctx, events...)
+	})
+}
+
+// PendingEvents and MarkPublished relay the outbox events in the event store's commits
+func (es *EventSourcedDB) PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	if outbox, ok := es.store.(commitOutbox); ok {
+		return outbox.PendingEvents(ctx, limit)
+	}
+	return nil, ErrNoOutbox
+}
+
+func (es *EventSourcedDB) MarkPublished(ctx context.Context, ids ...string) error {
+	if outbox, ok := es.store.(commitOutbox); ok {
+		return outbox.MarkPublished(ctx, ids...)
+	}
+	return ErrNoOutbox
+}
+
+// Ping pings the event store if it can be pinged
+func (es *EventSourcedDB) Ping(ctx context.Context) error {
+	if pinger, ok := es.store.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Close closes the event store if it needs closing
+func (es *EventSourcedDB) Close(ctx context.Context) error {
+	if closer, ok := es.store.(Closer); ok {
+		return closer.Close(ctx)
+	}
+	return nil
+}
+
+func (es *EventSourcedDB) refresh(ctx context.Context) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	return es.catchUp(ctx)
+}
+
+// catchUp applies the commits made since the projection was last updated; the caller must hold mu
+func (es *EventSourcedDB) catchUp(ctx context.Context) error {
+	commits, err := es.store.Commits(ctx, es.seq)
+	if err != nil {
+		return fmt.Errorf("storage.EventSourcedDB failed to load new commits: %v", err)
+	}
+
+	for _, commit := range commits {
+		if commit.Seq != es.seq+1 {
+			return fmt.Errorf("storage.EventSourcedDB found commit %d after %d", commit.Seq, es.seq)
+		}
+		applyCommit(es.projection, commit)
+		es.seq = commit.Seq
+	}
+
+	return nil
+}
+
+// maybeSnapshot saves a snapshot when enough commits have piled up since the last one. A failed snapshot only costs
+// a longer replay on the next start, so it's logged rather than failing the write.
+func (es *EventSourcedDB) maybeSnapshot(ctx context.Context) {
+	if es.seq-es.snapshotSeq < int64(es.cfg.SnapshotEvery) {
+		return
+	}
+
+	todos := es.projection.allTodos()
+	snapshot := Snapshot{Seq: es.seq, Todos: todos, TakenAt: time.Now().UTC()}
+	if err := es.store.SaveSnapshot(ctx, snapshot); err != nil {
+		log.Printf("storage.EventSourcedDB failed to save snapshot at commit %d: %v", es.seq, err)
+		return
+	}
+
+	es.snapshotSeq = es.seq
+}
+
+func applyCommit(projection *InMemoryDB, commit Commit) {
+	for _, event := range commit.Events {
+		applyTodoEvent(projection, event)
+	}
+}
+
+// applyTodoEvent is the projection: the only place events turn into state
+func applyTodoEvent(projection *InMemoryDB, event TodoEvent) {
+	switch event.Type {
+	case TodoCreated:
+		projection.put(Todo{
+			ID:          event.TodoID,
+			Tenant:      event.Tenant,
+			Name:        event.Name,
+			Description: event.Description,
+			CreatedAt:   event.At,
+			UpdatedAt:   event.At,
+			Version:     versionAfter(event, 0),
+		})
+		return
+	case TodoDeleted:
+		projection.drop(event.Tenant, event.TodoID)
+		return
+	case TodoImported:
+		projection.put(Todo{
+			ID:          event.TodoID,
+			Tenant:      event.Tenant,
+			Name:        event.Name,
+			Description: event.Description,
+			Completed:   event.Completed,
+			CreatedAt:   event.CreatedAt,
+			UpdatedAt:   event.At,
+			Version:     versionAfter(event, 0),
+		})
+		return
+	}
+
+	todo, ok := projection.get(event.Tenant, event.TodoID)
+	if !ok {
+		// a valid stream never changes a todo that doesn't exist
+		return
+	}
+
+	switch event.Type {
+	case TodoRenamed:
+		todo.Name = event.Name
+	case TodoDescriptionChanged:
+		todo.Description = event.Description
+	case TodoCompleted:
+		todo.Completed = true
+	case TodoReopened:
+		todo.Completed = false
+	}
+	todo.UpdatedAt = event.At
+	todo.Version = versionAfter(event, todo.Version)
+
+	projection.put(todo)
+}
+
+// versionAfter is the version of a todo at version current after event
+func versionAfter(event TodoEvent, current int64) int64 {
+	if event.Version != 0 {
+		return event.Version
+	}
+	return current + 1
+}
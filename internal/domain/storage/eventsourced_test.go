@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestEventSourcedDB(t *testing.T, store EventStore, cfg EventSourcedConfig) *EventSourcedDB {
+	t.Helper()
+	es, err := NewEventSourcedDB(context.Background(), store, cfg)
+	if err != nil {
+		t.Fatalf("NewEventSourcedDB got unexpected error: %+v", err)
+	}
+	return es
+}
+
+func TestEventSourcedDB_Writes(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryEventStore()
+	es := newTestEventSourcedDB(t, store, EventSourcedConfig{})
+
+	shopping, err := es.SaveTodo(ctx, "shopping", "get milk")
+	if err != nil {
+		t.Fatalf("SaveTodo got unexpected error: %+v", err)
+	}
+	carWash, _ := es.SaveTodo(ctx, "wash car", "")
+
+	if _, err = es.SaveTodo(ctx, "shopping", ""); err != ErrAlreadyInList {
+		t.Errorf("SaveTodo expected error %v; got %v", ErrAlreadyInList, err)
+	}
+	if _, err = es.EditTodo(ctx, carWash.ID, Todo{Name: "shopping"}); err != ErrAlreadyInList {
+		t.Errorf("EditTodo expected error %v; got %v", ErrAlreadyInList, err)
+	}
+	if _, err = es.EditTodo(ctx, "missing", Todo{Name: "x"}); err != ErrNotFound {
+		t.Errorf("EditTodo expected error %v; got %v", ErrNotFound, err)
+	}
+	if err = es.DeleteTodo(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("DeleteTodo expected error %v; got %v", ErrNotFound, err)
+	}
+
+	edited, err := es.EditTodo(ctx, shopping.ID, Todo{Name: "groceries", Description: "get milk", Completed: true})
+	if err != nil {
+		t.Fatalf("EditTodo got unexpected error: %+v", err)
+	}
+	if edited.Name != "groceries" || !edited.Completed || edited.CreatedAt != shopping.CreatedAt {
+		t.Errorf("EditTodo returned %+v", edited)
+	}
+
+	// a no-op edit commits nothing
+	if _, err = es.EditTodo(ctx, shopping.ID, Todo{Name: "groceries", Description: "get milk", Completed: true}); err != nil {
+		t.Fatalf("EditTodo got unexpected error: %+v", err)
+	}
+
+	if err = es.DeleteTodo(ctx, carWash.ID); err != nil {
+		t.Fatalf("DeleteTodo got unexpected error: %+v", err)
+	}
+
+	commits, _ := store.Commits(ctx, 0)
+	var types [][]TodoEventType
+	for _, commit := range commits {
+		var commitTypes []TodoEventType
+		for _, event := range commit.Events {
+			commitTypes = append(commitTypes, event.Type)
+		}
+		types = append(types, commitTypes)
+	}
+	expected := [][]TodoEventType{
+		{TodoCreated},
+		{TodoCreated},
+		{TodoRenamed, TodoCompleted},
+		{TodoDeleted},
+	}
+	if diff := cmp.Diff(expected, types); diff != "" {
+		t.Errorf("commits expected vs actual don't match: %v", diff)
+	}
+
+	list, _ := es.GetTodoList(ctx)
+	if diff := cmp.Diff([]Todo{edited}, list); diff != "" {
+		t.Errorf("GetTodoList expected vs actual results don't match: %v", diff)
+	}
+}
+
+func TestEventSourcedDB_Rebuild(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryEventStore()
+	es := newTestEventSourcedDB(t, store, EventSourcedConfig{SnapshotEvery: 3})
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if _, err := es.SaveTodo(ctx, name, ""); err != nil {
+			t.Fatalf("SaveTodo got unexpected error: %+v", err)
+		}
+	}
+	b, _ := es.GetTodoByName(ctx, "b")
+	_ = es.DeleteTodo(ctx, b.ID)
+
+	expected, _ := es.GetTodoList(ctx)
+
+	snapshot, _ := store.LatestSnapshot(ctx)
+	if snapshot.Seq != 3 || len(snapshot.Todos) != 3 {
+		t.Errorf("expected a snapshot of 3 todos at commit 3; got %+v", snapshot)
+	}
+
+	// a restart loads the snapshot and replays commits 4 and 5
+	restarted := newTestEventSourcedDB(t, store, EventSourcedConfig{SnapshotEvery: 3})
+	actual, _ := restarted.GetTodoList(ctx)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("restarted list expected vs actual don't match: %v", diff)
+	}
+
+	replayed, seq, err := ReplayEvents(ctx, store)
+	if err != nil || seq != 5 {
+		t.Fatalf("ReplayEvents expected commit 5; got %d, %v", seq, err)
+	}
+	if diff := cmp.Diff(expected, replayed); diff != "" {
+		t.Errorf("replayed list expected vs actual don't match: %v", diff)
+	}
+}
+
+func TestEventSourcedDB_ConcurrentInstances(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryEventStore()
+	first := newTestEventSourcedDB(t, store, EventSourcedConfig{})
+	second := newTestEventSourcedDB(t, store, EventSourcedConfig{})
+
+	if _, err := first.SaveTodo(ctx, "shopping", ""); err != nil {
+		t.Fatalf("SaveTodo got unexpected error: %+v", err)
+	}
+
+	// second hasn't seen first's commit yet, but catches up before validating
+	if _, err := second.SaveTodo(ctx, "shopping", ""); err != ErrAlreadyInList {
+		t.Errorf("SaveTodo expected error %v; got %v", ErrAlreadyInList, err)
+	}
+
+	// a transaction whose commit loses the race is run again on the new state
+	attempts := 0
+	err := second.WithTransaction(ctx, func(tx DB) error {
+		attempts++
+		if attempts == 1 {
+			if _, err := first.SaveTodo(ctx, "wash car", ""); err != nil {
+				t.Fatalf("SaveTodo got unexpected error: %+v", err)
+			}
+		}
+		_, err := tx.SaveTodo(ctx, "dentist", "")
+		return err
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("WithTransaction expected to succeed on attempt 2; got attempt %d, %v", attempts, err)
+	}
+
+	list, _ := first.GetTodoList(ctx)
+	if len(list) != 3 {
+		t.Errorf("expected 3 todos; got %+v", list)
+	}
+}
+
+func TestEventSourcedDB_WithTransaction(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryEventStore()
+	es := newTestEventSourcedDB(t, store, EventSourcedConfig{})
+
+	rollback := errors.New("rollback")
+	err := es.WithTransaction(ctx, func(tx DB) error {
+		if _, err := tx.SaveTodo(ctx, "shopping", ""); err != nil {
+			return err
+		}
+		if _, err := tx.GetTodoByName(ctx, "shopping"); err != nil {
+			t.Errorf("transaction expected to see its own write; got %v", err)
+		}
+		return rollback
+	})
+	if err != rollback {
+		t.Fatalf("WithTransaction expected error %v; got %v", rollback, err)
+	}
+
+	if commits, _ := store.Commits(ctx, 0); len(commits) != 0 {
+		t.Errorf("expected nothing committed; got %+v", commits)
+	}
+	if _, err = es.GetTodoByName(ctx, "shopping"); err != ErrNotFound {
+		t.Errorf("GetTodoByName expected error %v; got %v", ErrNotFound, err)
+	}
+}
+
+func TestEventSourcedDB_Outbox(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryEventStore()
+	es := newTestEventSourcedDB(t, store, EventSourcedConfig{})
+
+	appendEvent := func(tx DB) error {
+		todo, err := tx.SaveTodo(ctx, "shopping", "")
+		if err != nil {
+			return err
+		}
+		return tx.(Outbox).AppendEvents(ctx, OutboxEvent{Type: "todo.created", TodoID: todo.ID, Todo: todo})
+	}
+
+	rollback := errors.New("rollback")
+	err := es.WithTransaction(ctx, func(tx DB) error {
+		if err := appendEvent(tx); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if err != rollback {
+		t.Fatalf("WithTransaction expected error %v; got %v", rollback, err)
+	}
+	if pending, _ := es.PendingEvents(ctx, 0); len(pending) != 0 {
+		t.Errorf("expected no events from a rolled back transaction; got %+v", pending)
+	}
+
+	if err = es.WithTransaction(ctx, appendEvent); err != nil {
+		t.Fatalf("WithTransaction got unexpected error: %+v", err)
+	}
+	pending, _ := store.PendingEvents(ctx, 0)
+	if len(pending) != 1 || pending[0].Todo.Name != "shopping" {
+		t.Errorf("expected the event in the event store's outbox; got %+v", pending)
+	}
+	commits, _ := store.Commits(ctx, 0)
+	if len(commits) != 1 || len(commits[0].Events) != 1 || len(commits[0].Outbox) != 1 {
+		t.Errorf("expected the todo event and the outbox event in one commit; got %+v", commits)
+	}
+
+	// events appended outside a transaction get a commit of their own
+	if err = es.AppendEvents(ctx, OutboxEvent{Type: "todo.cleared"}); err != nil {
+		t.Fatalf("AppendEvents got unexpected error: %+v", err)
+	}
+	if err = es.MarkPublished(ctx, pending[0].ID); err != nil {
+		t.Fatalf("MarkPublished got unexpected error: %+v", err)
+	}
+	pending, _ = es.PendingEvents(ctx, 0)
+	if len(pending) != 1 || pending[0].Type != "todo.cleared" {
+		t.Errorf("expected only the unpublished event to be pending; got %+v", pending)
+	}
+
+	// embedding the interface hides the store's outbox
+	commitsOnly := newTestEventSourcedDB(t, struct{ EventStore }{NewInMemoryEventStore()}, EventSourcedConfig{})
+	if err = commitsOnly.WithTransaction(ctx, appendEvent); err != ErrNoOutbox {
+		t.Errorf("WithTransaction expected error %v; got %v", ErrNoOutbox, err)
+	}
+	if list, _ := commitsOnly.GetTodoList(ctx); len(list) != 0 {
+		t.Errorf("expected nothing committed without an outbox; got %+v", list)
+	}
+}
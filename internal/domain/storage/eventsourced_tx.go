@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+// eventSourcedTx is the DB handed to an EventSourcedDB transaction. Writes are checked against its copy of the
+// projection, turned into events of the tenant in ctx, and applied to the copy, so later operations in the
+// transaction see them. Outbox events are stored in the commit too.
+type eventSourcedTx struct {
+	state  *InMemoryDB
+	at     time.Time
+	events []TodoEvent
+	store  EventStore
+	outbox []OutboxEvent
+}
+
+func (tx *eventSourcedTx) record(events ...TodoEvent) {
+	for _, event := range events {
+		if event.At.IsZero() {
+			event.At = tx.at
+		}
+		applyTodoEvent(tx.state, event)
+		tx.events = append(tx.events, event)
+	}
+}
+
+func (tx *eventSourcedTx) SaveTodo(ctx context.Context, name, description string) (Todo, error) {
+	if _, err := tx.state.GetTodoByName(ctx, name); err != ErrNotFound {
+		return Todo{}, ErrAlreadyInList
+	}
+
+	t, id := tenant.ID(ctx), createID()
+	tx.record(TodoEvent{Type: TodoCreated, Tenant: t, TodoID: id, Name: name, Description: description, Version: 1})
+
+	todo, _ := tx.state.get(t, id)
+	return todo, nil
+}
+
+func (tx *eventSourcedTx) GetTodoList(ctx context.Context) ([]Todo, error) {
+	return tx.state.GetTodoList(ctx)
+}
+
+func (tx *eventSourcedTx) ListTodos(ctx context.Context, opts ListOptions) (TodoPage, error) {
+	return tx.state.ListTodos(ctx, opts)
+}
+
+func (tx *eventSourcedTx) SearchTodos(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	return tx.state.SearchTodos(ctx, opts)
+}
+
+func (tx *eventSourcedTx) GetTodoByName(ctx context.Context, name string) (Todo, error) {
+	return tx.state.GetTodoByName(ctx, name)
+}
+
+func (tx *eventSourcedTx) GetTodoByID(ctx context.Context, id string) (Todo, error) {
+	return tx.state.GetTodoByID(ctx, id)
+}
+
+// EditTodo records an event for each field that changes; an edit that changes nothing records nothing
+func (tx *eventSourcedTx) EditTodo(ctx context.Context, id string, todo Todo) (Todo, error) {
+	t := tenant.ID(ctx)
+	current, ok := tx.state.get(t, id)
+	if !ok {
+		return Todo{}, ErrNotFound
+	}
+	if todo.Version != 0 && current.Version != todo.Version {
+		return Todo{}, ErrVersionMismatch
+	}
+
+	if match, err := tx.state.GetTodoByName(ctx, todo.Name); err == nil && match.ID != id {
+		return Todo{}, ErrAlreadyInList
+	}
+
+	version := current.Version + 1
+	if todo.Name != current.Name {
+		tx.record(TodoEvent{Type: TodoRenamed, Tenant: t, TodoID: id, Name: todo.Name, Version: version})
+	}
+	if todo.Description != current.Description {
+		tx.record(TodoEvent{Type: TodoDescriptionChanged, Tenant: t, TodoID: id, Description: todo.Description, Version: version})
+	}
+	if todo.Completed && !current.Completed {
+		tx.record(TodoEvent{Type: TodoCompleted, Tenant: t, TodoID: id, Version: version})
+	}
+	if !todo.Completed && current.Completed {
+		tx.record(TodoEvent{Type: TodoReopened, Tenant: t, TodoID: id, Version: version})
+	}
+
+	edited, _ := tx.state.get(t, id)
+	return edited, nil
+}
+
+func (tx *eventSourcedTx) DeleteTodo(ctx context.Context, id string) error {
+	t := tenant.ID(ctx)
+	if _, ok := tx.state.get(t, id); !ok {
+		return ErrNotFound
+	}
+
+	tx.record(TodoEvent{Type: TodoDeleted, Tenant: t, TodoID: id})
+
+	return nil
+}
+
+func (tx *eventSourcedTx) DeleteTodos(ctx context.Context, filter query.Expr) ([]Todo, error) {
+	todos, _ := tx.state.GetTodoList(ctx)
+
+	var deleted []Todo
+	for _, todo := range todos {
+		if matches(filter, todo) {
+			tx.record(TodoEvent{Type: TodoDeleted, Tenant: tenant.ID(ctx), TodoID: todo.ID})
+			deleted = append(deleted, todo)
+		}
+	}
+
+	return deleted, nil
+}
+
+func (tx *eventSourcedTx) ClearTodoList(ctx context.Context) error {
+	todos, _ := tx.state.GetTodoList(ctx)
+	for _, todo := range todos {
+		tx.record(TodoEvent{Type: TodoDeleted, Tenant: tenant.ID(ctx), TodoID: todo.ID})
+	}
+
+	return nil
+}
+
+func (tx *eventSourcedTx) importTodos(upserts, deletes []Todo) {
+	for _, todo := range deletes {
+		tx.record(TodoEvent{Type: TodoDeleted, Tenant: partitionKey(todo.Tenant), TodoID: todo.ID})
+	}
+	for _, todo := range upserts {
+		tx.record(TodoEvent{
+			Type:        TodoImported,
+			Tenant:      partitionKey(todo.Tenant),
+			TodoID:      todo.ID,
+			Name:        todo.Name,
+			Description: todo.Description,
+			Completed:   todo.Completed,
+			CreatedAt:   todo.CreatedAt,
+			At:          todo.UpdatedAt,
+			Version:     todo.Version,
+		})
+	}
+}
+
+// AppendEvents adds events to the transaction's commit, if the event store can relay them
+func (tx *eventSourcedTx) AppendEvents(ctx context.Context, events ...OutboxEvent) error {
+	if _, ok := tx.store.(commitOutbox); !ok {
+		return ErrNoOutbox
+	}
+	tx.outbox = append(tx.outbox, newOutboxEvents(ctx, events)...)
+	return nil
+}
+
+func (tx *eventSourcedTx) PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	outbox, ok := tx.store.(commitOutbox)
+	if !ok {
+		return nil, ErrNoOutbox
+	}
+	return outbox.PendingEvents(ctx, limit)
+}
+
+func (tx *eventSourcedTx) MarkPublished(ctx context.Context, ids ...string) error {
+	outbox, ok := tx.store.(commitOutbox)
+	if !ok {
+		return ErrNoOutbox
+	}
+	return outbox.MarkPublished(ctx, ids...)
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyRecord remembers a request made with an idempotency key and, once it has finished, its response.
+// RequestHash identifies the request, so a key reused for a different one can be told apart from a retry.
+type IdempotencyRecord struct {
+	Tenant      string              `bson:"tenant"`
+	Key         string              `bson:"key"`
+	RequestHash string              `bson:"request_hash"`
+	Response    *IdempotentResponse `bson:"response,omitempty"`
+	CreatedAt   time.Time           `bson:"created_at"`
+	ExpiresAt   time.Time           `bson:"expires_at"`
+}
+
+// IdempotentResponse is the response replayed to the retries of a request
+type IdempotentResponse struct {
+	Status int                 `bson:"status"`
+	Header map[string][]string `bson:"header,omitempty"`
+	Body   []byte              `bson:"body"`
+}
+
+// IdempotencyStore is implemented by DB backends that can remember the responses to requests made with an
+// idempotency key. Keys belong to the tenant in ctx and are forgotten once their record expires.
+type IdempotencyStore interface {
+	// ClaimIdempotencyKey stores record for the tenant in ctx and returns it with true, unless an unexpired record
+	// already holds its key, in which case it returns that record and false
+	ClaimIdempotencyKey(ctx context.Context, record IdempotencyRecord) (IdempotencyRecord, bool, error)
+	// CompleteIdempotencyKey stores the response to the request that claimed key
+	CompleteIdempotencyKey(ctx context.Context, key string, response IdempotentResponse) error
+	// ReleaseIdempotencyKey forgets key if its request never completed, so a retry can claim it again
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	testData := []struct {
+		testName string
+		newStore func(t *testing.T) IdempotencyStore
+	}{
+		{
+			testName: "in-memory",
+			newStore: func(t *testing.T) IdempotencyStore { return NewInMemoryDB() },
+		},
+		{
+			testName: "cached",
+			newStore: func(t *testing.T) IdempotencyStore { return NewCachedDB(NewInMemoryDB(), CacheConfig{}) },
+		},
+		{
+			testName: "encrypted",
+			newStore: func(t *testing.T) IdempotencyStore {
+				return NewEncryptedDB(NewInMemoryDB(), newTestKeyRing(t, "k1", "k1"))
+			},
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			ctx := tenant.WithID(context.Background(), "team-a")
+			store := td.newStore(t)
+			createdAt := now()
+			record := IdempotencyRecord{Key: "k-1", RequestHash: "abc", CreatedAt: createdAt, ExpiresAt: createdAt.Add(time.Hour)}
+
+			claimed, ok, err := store.ClaimIdempotencyKey(ctx, record)
+			if err != nil || !ok || claimed.Tenant != "team-a" {
+				t.Fatalf("ClaimIdempotencyKey expected to claim the key for team-a; got %+v, %v, %v", claimed, ok, err)
+			}
+
+			// a retry finds the first request still running
+			existing, ok, _ := store.ClaimIdempotencyKey(ctx, IdempotencyRecord{Key: "k-1", RequestHash: "def", ExpiresAt: record.ExpiresAt})
+			if ok || existing.RequestHash != "abc" || existing.Response != nil {
+				t.Errorf("ClaimIdempotencyKey expected the running request's record; got %+v, %v", existing, ok)
+			}
+
+			// keys belong to a tenant
+			if _, ok, _ = store.ClaimIdempotencyKey(context.Background(), record); !ok {
+				t.Error("ClaimIdempotencyKey expected another tenant to claim the same key")
+			}
+
+			response := IdempotentResponse{Status: 200, Header: map[string][]string{"Content-Type": {"application/json"}}, Body: []byte(`{"name":"shopping"}`)}
+			if err = store.CompleteIdempotencyKey(ctx, "k-1", response); err != nil {
+				t.Fatalf("CompleteIdempotencyKey got unexpected error: %+v", err)
+			}
+
+			// a completed key isn't released, so retries keep getting the response
+			_ = store.ReleaseIdempotencyKey(ctx, "k-1")
+			existing, ok, err = store.ClaimIdempotencyKey(ctx, record)
+			if err != nil || ok || existing.Response == nil {
+				t.Fatalf("ClaimIdempotencyKey expected the completed record; got %+v, %v, %v", existing, ok, err)
+			}
+			if diff := cmp.Diff(response, *existing.Response); diff != "" {
+				t.Errorf("stored vs replayed response don't match: %v", diff)
+			}
+
+			// a request that failed releases its key for the next retry
+			failed := IdempotencyRecord{Key: "k-2", RequestHash: "abc", ExpiresAt: record.ExpiresAt}
+			_, _, _ = store.ClaimIdempotencyKey(ctx, failed)
+			_ = store.ReleaseIdempotencyKey(ctx, "k-2")
+			if _, ok, _ = store.ClaimIdempotencyKey(ctx, failed); !ok {
+				t.Error("ClaimIdempotencyKey expected to claim a released key")
+			}
+
+			// an expired key can be claimed again
+			expired := IdempotencyRecord{Key: "k-3", RequestHash: "abc", ExpiresAt: createdAt.Add(-time.Second)}
+			_, _, _ = store.ClaimIdempotencyKey(ctx, expired)
+			if _, ok, _ = store.ClaimIdempotencyKey(ctx, IdempotencyRecord{Key: "k-3", RequestHash: "def", ExpiresAt: record.ExpiresAt}); !ok {
+				t.Error("ClaimIdempotencyKey expected to claim an expired key")
+			}
+		})
+	}
+}
+
+func TestEncryptedDB_IdempotentResponses(t *testing.T) {
+	ctx := context.Background()
+	backend := NewInMemoryDB()
+	db := NewEncryptedDB(backend, newTestKeyRing(t, "k1", "k1"))
+
+	record := IdempotencyRecord{Key: "k-1", RequestHash: "abc", ExpiresAt: now().Add(time.Hour)}
+	_, _, _ = db.ClaimIdempotencyKey(ctx, record)
+	if err := db.CompleteIdempotencyKey(ctx, "k-1", IdempotentResponse{Status: 200, Body: []byte("get milk")}); err != nil {
+		t.Fatalf("CompleteIdempotencyKey got unexpected error: %+v", err)
+	}
+
+	stored, _, _ := backend.ClaimIdempotencyKey(ctx, record)
+	if bytes.Contains(stored.Response.Body, []byte("milk")) {
+		t.Errorf("expected the stored response to be encrypted; got %s", stored.Response.Body)
+	}
+}
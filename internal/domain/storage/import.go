@@ -0,0 +1,17 @@
+package storage
+
+import "context"
+
+// Exporter is implemented by DB backends that can walk every tenant's todos, for backups and moving data between
+// backends. Todos come with their tenants filled in.
+type Exporter interface {
+	EachTodo(ctx context.Context, fn func(todo Todo) error) error
+}
+
+// Importer is implemented by DB backends that can store todos as they are, keeping their IDs, tenants and
+// timestamps. ImportTodos removes deletes, then stores upserts, replacing any todo with the same ID; backends that
+// have transactions do both in one. Nothing is validated: callers must not upsert two todos with the same name in a
+// tenant, or one with the name of a todo that stays.
+type Importer interface {
+	ImportTodos(ctx context.Context, upserts, deletes []Todo) error
+}
@@ -2,31 +2,69 @@ package storage
 
 import (
 	"context"
+	"sort"
+	"sync"
 
 	"github.com/google/uuid"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
 )
 
 type InMemoryDB struct {
-	todoList []Todo
+	mu sync.RWMutex
+	// lists holds each tenant's todos apart; every operation only ever touches the partition of the tenant in its ctx
+	lists map[string][]Todo
+	// indexes are kept in step with lists on every write; when nil, as in a DB built as a literal, searches build a
+	// throwaway index instead
+	indexes map[string]*searchIndex
+	// feed is created on the first Watch, if NewInMemoryDB didn't
+	feed *changeFeed
+
+	// outbox holds the events not yet published
+	outbox []OutboxEvent
+
+	// idempotency holds the idempotency records by tenant and key
+	idempotency map[string]IdempotencyRecord
+
+	// inTx marks the snapshot a transaction works on, whose events are held in uncommitted until it commits
+	inTx        bool
+	uncommitted []ChangeEvent
 }
 
 func NewInMemoryDB() *InMemoryDB {
-	return &InMemoryDB{}
+	return &InMemoryDB{
+		lists:   make(map[string][]Todo),
+		indexes: make(map[string]*searchIndex),
+		feed:    newChangeFeed(),
+	}
 }
 
 func (db *InMemoryDB) SaveTodo(ctx context.Context, name, description string) (Todo, error) {
-	if _, err := db.GetTodoByName(ctx, name); err != ErrNotFound {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t := tenant.ID(ctx)
+	if _, err := findByName(db.lists[t], name); err != ErrNotFound {
 		return Todo{}, ErrAlreadyInList
 	}
 
+	createdAt := now()
 	todo := Todo{
 		ID:          createID(),
+		Tenant:      t,
 		Name:        name,
 		Description: description,
+		CreatedAt:   createdAt,
+		UpdatedAt:   createdAt,
+		Version:     1,
 	}
 
 	// save to memory
-	db.todoList = append(db.todoList, todo)
+	db.setList(t, append(db.lists[t], todo))
+	if index := db.index(t); index != nil {
+		index.add(todo)
+	}
+	db.emit(ChangeEvent{Type: ChangeCreated, Tenant: t, TodoID: todo.ID, Todo: todo, Time: createdAt})
 
 	// in-memory DB never returns an error on save
 	var err error = nil
@@ -35,8 +73,11 @@ func (db *InMemoryDB) SaveTodo(ctx context.Context, name, description string) (T
 }
 
 func (db *InMemoryDB) GetTodoList(ctx context.Context) ([]Todo, error) {
-	// get list from memory
-	list := db.todoList
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// get a copy of the list from memory, so callers can't change it behind the lock's back
+	list := append([]Todo(nil), db.lists[tenant.ID(ctx)]...)
 
 	// in-memory DB never returns an error on get
 	var err error = nil
@@ -44,43 +85,133 @@ func (db *InMemoryDB) GetTodoList(ctx context.Context) ([]Todo, error) {
 	return list, err
 }
 
-func (db *InMemoryDB) GetTodoByName(ctx context.Context, name string) (Todo, error) {
-	for _, todo := range db.todoList {
-		if todo.Name == name {
-			return todo, nil
+func (db *InMemoryDB) ListTodos(ctx context.Context, opts ListOptions) (TodoPage, error) {
+	afterID, err := opts.afterID()
+	if err != nil {
+		return TodoPage{}, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// collect the matching Todos after the cursor in ID order, plus one more to tell whether there's a next page
+	var page []Todo
+	for _, todo := range db.lists[tenant.ID(ctx)] {
+		if todo.ID > afterID && matches(opts.Filter, todo) {
+			page = append(page, todo)
 		}
 	}
-	return Todo{}, ErrNotFound
+	sort.Slice(page, func(i, j int) bool {
+		return page[i].ID < page[j].ID
+	})
+
+	limit := opts.limit()
+	if len(page) > limit+1 {
+		page = page[:limit+1]
+	}
+
+	return newPage(page, limit), nil
+}
+
+func (db *InMemoryDB) SearchTodos(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	terms, err := opts.terms()
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	list := db.lists[tenant.ID(ctx)]
+
+	index := db.indexes[tenant.ID(ctx)]
+	if index == nil {
+		index = newSearchIndex(list)
+	}
+
+	ids := index.search(terms)
+	candidates := make([]Todo, 0, len(ids))
+	for _, todo := range list {
+		if ids[todo.ID] {
+			candidates = append(candidates, todo)
+		}
+	}
+
+	return rankHits(candidates, terms, opts.limit()), nil
+}
+
+func (db *InMemoryDB) GetTodoByName(ctx context.Context, name string) (Todo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return findByName(db.lists[tenant.ID(ctx)], name)
+}
+
+func (db *InMemoryDB) GetTodoByID(ctx context.Context, id string) (Todo, error) {
+	todo, ok := db.get(tenant.ID(ctx), id)
+	if !ok {
+		return Todo{}, ErrNotFound
+	}
+	return todo, nil
 }
 
 func (db *InMemoryDB) EditTodo(ctx context.Context, id string, todo Todo) (Todo, error) {
-	// find and edit matching Todo in memory
-	var editedTodo Todo
-	for i := range db.todoList {
-		item := db.todoList[i]
-		if item.ID == id {
-			item.Name = todo.Name
-			item.Description = todo.Description
-			editedTodo = item
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t := tenant.ID(ctx)
+	list := db.lists[t]
+
+	// reject renames that collide with another Todo's name
+	for _, item := range list {
+		if item.ID != id && item.Name == todo.Name {
+			return Todo{}, ErrAlreadyInList
 		}
 	}
 
-	// in-memory DB never returns an error on edit
-	var err error = nil
+	// find and edit matching Todo in memory
+	for i := range list {
+		if list[i].ID == id {
+			if todo.Version != 0 && list[i].Version != todo.Version {
+				return Todo{}, ErrVersionMismatch
+			}
+			list[i].Name = todo.Name
+			list[i].Description = todo.Description
+			list[i].Completed = todo.Completed
+			list[i].UpdatedAt = now()
+			list[i].Version++
+			if index := db.index(t); index != nil {
+				index.remove(id)
+				index.add(list[i])
+			}
+			db.emit(ChangeEvent{Type: ChangeUpdated, Tenant: t, TodoID: id, Todo: list[i], Time: list[i].UpdatedAt})
+			return list[i], nil
+		}
+	}
 
-	return editedTodo, err
+	return Todo{}, ErrNotFound
 }
 
 func (db *InMemoryDB) DeleteTodo(ctx context.Context, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t := tenant.ID(ctx)
+	list := db.lists[t]
+
 	// find and delete matching Todo in memory
-	for i := range db.todoList {
-		item := db.todoList[i]
+	for i := range list {
+		item := list[i]
 		if item.ID == id {
-			if i == len(db.todoList)-1 {
-				db.todoList = db.todoList[:i]
+			if i == len(list)-1 {
+				db.setList(t, list[:i])
 			} else {
-				db.todoList = append(db.todoList[:i], db.todoList[i+1:]...)
+				db.setList(t, append(list[:i], list[i+1:]...))
 			}
+			if index := db.index(t); index != nil {
+				index.remove(id)
+			}
+			db.emit(ChangeEvent{Type: ChangeDeleted, Tenant: t, TodoID: id})
 			return nil
 		}
 	}
@@ -88,9 +219,47 @@ func (db *InMemoryDB) DeleteTodo(ctx context.Context, id string) error {
 	return ErrNotFound
 }
 
+func (db *InMemoryDB) DeleteTodos(ctx context.Context, filter query.Expr) ([]Todo, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t := tenant.ID(ctx)
+	var kept, deleted []Todo
+	for _, todo := range db.lists[t] {
+		if matches(filter, todo) {
+			deleted = append(deleted, todo)
+		} else {
+			kept = append(kept, todo)
+		}
+	}
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+
+	db.setList(t, kept)
+	index := db.index(t)
+	for _, todo := range deleted {
+		if index != nil {
+			index.remove(todo.ID)
+		}
+		db.emit(ChangeEvent{Type: ChangeDeleted, Tenant: t, TodoID: todo.ID})
+	}
+
+	// in-memory DB never returns an error on delete
+	return deleted, nil
+}
+
 func (db *InMemoryDB) ClearTodoList(ctx context.Context) error {
-	// clear the list in memory
-	db.todoList = make([]Todo, 0)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// clear the tenant's list in memory
+	t := tenant.ID(ctx)
+	db.setList(t, make([]Todo, 0))
+	if db.indexes != nil {
+		db.indexes[t] = newSearchIndex(nil)
+	}
+	db.emit(ChangeEvent{Type: ChangeCleared, Tenant: t})
 
 	// in-memory DB never returns an error on clear-list
 	var err error = nil
@@ -98,6 +267,214 @@ func (db *InMemoryDB) ClearTodoList(ctx context.Context) error {
 	return err
 }
 
+// WithTransaction runs fn against a snapshot of the lists and only swaps the result in if fn succeeds. The DB is
+// locked for the whole transaction, so fn must only use tx: calling back into db from fn would deadlock.
+func (db *InMemoryDB) WithTransaction(ctx context.Context, fn func(tx DB) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tx := &InMemoryDB{
+		lists:  copyLists(db.lists),
+		outbox: append([]OutboxEvent(nil), db.outbox...),
+		inTx:   true,
+	}
+
+	if err := fn(tx); err != nil {
+		// rollback: the snapshot is simply dropped
+		return err
+	}
+
+	db.lists = tx.lists
+	db.outbox = tx.outbox
+	if db.indexes != nil {
+		for t, list := range db.lists {
+			db.indexes[t] = newSearchIndex(list)
+		}
+	}
+	for _, event := range tx.uncommitted {
+		db.emit(event)
+	}
+
+	return nil
+}
+
+// EachTodo calls fn with every tenant's todos, tenant by tenant
+func (db *InMemoryDB) EachTodo(ctx context.Context, fn func(todo Todo) error) error {
+	for _, todo := range db.allTodos() {
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportTodos never returns an error, like the other writes
+func (db *InMemoryDB) ImportTodos(ctx context.Context, upserts, deletes []Todo) error {
+	for _, todo := range deletes {
+		db.drop(todo.Tenant, todo.ID)
+	}
+	for _, todo := range upserts {
+		db.put(todo)
+	}
+	return nil
+}
+
+// RewriteDescriptions stores the descriptions rewrite returns for every tenant's todos
+func (db *InMemoryDB) RewriteDescriptions(ctx context.Context, rewrite func(todo Todo) (string, bool, error)) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	rewritten := 0
+	for t, list := range db.lists {
+		for i := range list {
+			description, ok, err := rewrite(list[i])
+			if err != nil {
+				return rewritten, err
+			}
+			if !ok {
+				continue
+			}
+
+			list[i].Description = description
+			if index := db.index(t); index != nil {
+				index.remove(list[i].ID)
+				index.add(list[i])
+			}
+			rewritten++
+		}
+	}
+
+	return rewritten, nil
+}
+
+// put, drop, get, clone and allTodos let EventSourcedDB use an InMemoryDB as its projection. put and drop change the
+// lists directly: nothing is validated, stamped or reported to watchers.
+
+// put adds todo to its tenant's list, or replaces the todo with the same ID there
+func (db *InMemoryDB) put(todo Todo) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	todo.Tenant = partitionKey(todo.Tenant)
+	list := db.lists[todo.Tenant]
+
+	if index := db.index(todo.Tenant); index != nil {
+		index.remove(todo.ID)
+		index.add(todo)
+	}
+
+	for i := range list {
+		if list[i].ID == todo.ID {
+			list[i] = todo
+			return
+		}
+	}
+	db.setList(todo.Tenant, append(list, todo))
+}
+
+func (db *InMemoryDB) drop(t, id string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t = partitionKey(t)
+	list := db.lists[t]
+
+	if index := db.index(t); index != nil {
+		index.remove(id)
+	}
+
+	for i := range list {
+		if list[i].ID == id {
+			db.setList(t, append(list[:i], list[i+1:]...))
+			return
+		}
+	}
+}
+
+func (db *InMemoryDB) get(t, id string) (Todo, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, todo := range db.lists[partitionKey(t)] {
+		if todo.ID == id {
+			return todo, true
+		}
+	}
+	return Todo{}, false
+}
+
+// clone copies the lists into a new DB without indexes or watchers
+func (db *InMemoryDB) clone() *InMemoryDB {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return &InMemoryDB{
+		lists: copyLists(db.lists),
+	}
+}
+
+// allTodos returns every tenant's todos, tenant by tenant
+func (db *InMemoryDB) allTodos() []Todo {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tenants := make([]string, 0, len(db.lists))
+	for t := range db.lists {
+		tenants = append(tenants, t)
+	}
+	sort.Strings(tenants)
+
+	todos := []Todo{}
+	for _, t := range tenants {
+		todos = append(todos, db.lists[t]...)
+	}
+	return todos
+}
+
+// setList replaces a tenant's list; the caller must hold the write lock
+func (db *InMemoryDB) setList(t string, list []Todo) {
+	if db.lists == nil {
+		db.lists = make(map[string][]Todo)
+	}
+	db.lists[t] = list
+}
+
+// index returns the tenant's search index, or nil if db doesn't keep indexes; the caller must hold the write lock
+func (db *InMemoryDB) index(t string) *searchIndex {
+	if db.indexes == nil {
+		return nil
+	}
+	if db.indexes[t] == nil {
+		db.indexes[t] = newSearchIndex(nil)
+	}
+	return db.indexes[t]
+}
+
+func copyLists(lists map[string][]Todo) map[string][]Todo {
+	copied := make(map[string][]Todo, len(lists))
+	for t, list := range lists {
+		copied[t] = append([]Todo(nil), list...)
+	}
+	return copied
+}
+
+// partitionKey is the tenant of todos stored without one, which predate tenants
+func partitionKey(t string) string {
+	if t == "" {
+		return tenant.Default
+	}
+	return t
+}
+
+func findByName(list []Todo, name string) (Todo, error) {
+	for _, todo := range list {
+		if todo.Name == name {
+			return todo, nil
+		}
+	}
+	return Todo{}, ErrNotFound
+}
+
 func createID() string {
 	return uuid.NewString()
 }
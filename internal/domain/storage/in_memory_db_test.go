@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestInMemoryDB_SaveTodo(t *testing.T) {
@@ -33,13 +36,13 @@ func TestInMemoryDB_SaveTodo(t *testing.T) {
 			todoName:        "shopping",
 			todoDescription: "get milk and eggs",
 			db: &InMemoryDB{
-				todoList: []Todo{
+				lists: map[string][]Todo{tenant.Default: {
 					{
 						ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
 						Name:        "shopping",
 						Description: "get milk and eggs",
 					},
-				},
+				}},
 			},
 			expectedResult: Todo{},
 			wantErr:        true,
@@ -87,7 +90,7 @@ func TestInMemoryDB_GetTodoList(t *testing.T) {
 		{
 			testName: "success",
 			db: &InMemoryDB{
-				todoList: []Todo{
+				lists: map[string][]Todo{tenant.Default: {
 					{
 						ID:   "22222bbb-bbbb-2222-b2bb-111aa1a11a1a",
 						Name: "wash car",
@@ -102,7 +105,7 @@ func TestInMemoryDB_GetTodoList(t *testing.T) {
 						Name:        "walk dog",
 						Description: "take dog to park",
 					},
-				},
+				}},
 			},
 			expectedResult: []Todo{
 				{
@@ -149,6 +152,213 @@ func TestInMemoryDB_GetTodoList(t *testing.T) {
 	}
 }
 
+func TestInMemoryDB_ListTodos(t *testing.T) {
+	db := &InMemoryDB{
+		lists: map[string][]Todo{tenant.Default: {
+			{ID: "33333ccc-cccc-3333-c3cc-111aa1a11a1a", Name: "walk dog"},
+			{ID: "11111aaa-aaaa-1111-a1aa-111aa1a11a1a", Name: "shopping"},
+			{ID: "55555eee-eeee-5555-e5ee-111aa1a11a1a", Name: "pay bills"},
+			{ID: "22222bbb-bbbb-2222-b2bb-111aa1a11a1a", Name: "wash car"},
+			{ID: "44444ddd-dddd-4444-d4dd-111aa1a11a1a", Name: "call mum"},
+		}},
+	}
+
+	testData := []struct {
+		testName       string
+		limit          int
+		expectedResult [][]string
+	}{
+		{
+			testName: "success: pages of two",
+			limit:    2,
+			expectedResult: [][]string{
+				{"shopping", "wash car"},
+				{"walk dog", "call mum"},
+				{"pay bills"},
+			},
+		},
+		{
+			testName: "success: exact fit leaves no next page",
+			limit:    5,
+			expectedResult: [][]string{
+				{"shopping", "wash car", "walk dog", "call mum", "pay bills"},
+			},
+		},
+		{
+			testName: "success: default limit",
+			expectedResult: [][]string{
+				{"shopping", "wash car", "walk dog", "call mum", "pay bills"},
+			},
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			var result [][]string
+			cursor := ""
+
+			for i := 0; i <= len(td.expectedResult); i++ {
+				page, err := db.ListTodos(context.Background(), ListOptions{Limit: td.limit, Cursor: cursor})
+				if err != nil {
+					t.Fatalf("ListTodos got unexpected error: %+v", err)
+				}
+
+				var names []string
+				for _, todo := range page.Todos {
+					names = append(names, todo.Name)
+				}
+				result = append(result, names)
+
+				if page.NextCursor == "" {
+					break
+				}
+				cursor = page.NextCursor
+			}
+
+			if diff := cmp.Diff(td.expectedResult, result); diff != "" {
+				t.Errorf("ListTodos expected vs actual pages don't match: %v", diff)
+			}
+		})
+	}
+
+	t.Run("success: filtered", func(t *testing.T) {
+		db := &InMemoryDB{
+			lists: map[string][]Todo{tenant.Default: {
+				{
+					ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+					Name:        "shopping",
+					Description: "get MILK and eggs",
+					CreatedAt:   time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC),
+				},
+				{
+					ID:        "22222bbb-bbbb-2222-b2bb-111aa1a11a1a",
+					Name:      "wash car",
+					Completed: true,
+					CreatedAt: time.Date(2026, 10, 2, 9, 0, 0, 0, time.UTC),
+				},
+				{
+					ID:          "33333ccc-cccc-3333-c3cc-111aa1a11a1a",
+					Name:        "buy milk",
+					Description: "semi-skimmed",
+					CreatedAt:   time.Date(2026, 11, 2, 9, 0, 0, 0, time.UTC),
+				},
+			}},
+		}
+
+		filters := map[string][]string{
+			`milk`:                            {"shopping", "buy milk"},
+			`milk created<2026-11-01`:         {"shopping"},
+			`status:completed OR name:"shop"`: {"shopping", "wash car"},
+			`-status:open`:                    {"wash car"},
+			`name="buy milk"`:                 {"buy milk"},
+			`created:2026-10-02`:              {"wash car"},
+		}
+
+		for filter, expected := range filters {
+			expr, err := query.Parse(filter)
+			if err != nil {
+				t.Fatalf("query.Parse(%q) got unexpected error: %v", filter, err)
+			}
+
+			page, err := db.ListTodos(context.Background(), ListOptions{Filter: expr})
+			if err != nil {
+				t.Fatalf("ListTodos got unexpected error: %+v", err)
+			}
+
+			var names []string
+			for _, todo := range page.Todos {
+				names = append(names, todo.Name)
+			}
+
+			if diff := cmp.Diff(expected, names); diff != "" {
+				t.Errorf("ListTodos with filter %q expected vs actual results don't match: %v", filter, diff)
+			}
+		}
+	})
+
+	t.Run("failure: invalid cursor", func(t *testing.T) {
+		_, err := db.ListTodos(context.Background(), ListOptions{Cursor: "not-a-cursor"})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("ListTodos expected error '%v'; got %v", ErrInvalidCursor, err)
+		}
+	})
+}
+
+func TestInMemoryDB_SearchTodos(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewInMemoryDB()
+	for _, todo := range []Todo{
+		{Name: "shopping", Description: "get milk and eggs"},
+		{Name: "wash car", Description: "before the shops close"},
+		{Name: "milk run", Description: "semi-skimmed milk"},
+		{Name: "dentist", Description: "check-up"},
+	} {
+		if _, err := db.SaveTodo(ctx, todo.Name, todo.Description); err != nil {
+			t.Fatalf("SaveTodo got unexpected error: %+v", err)
+		}
+	}
+
+	search := func(q string) []string {
+		t.Helper()
+		hits, err := db.SearchTodos(ctx, SearchOptions{Query: q})
+		if err != nil {
+			t.Fatalf("SearchTodos(%q) got unexpected error: %+v", q, err)
+		}
+		var names []string
+		for _, hit := range hits {
+			names = append(names, hit.Todo.Name)
+		}
+		return names
+	}
+
+	t.Run("success: ranked by weighted matches", func(t *testing.T) {
+		// "milk run" has milk in its name and description, "shopping" only in its description
+		if diff := cmp.Diff([]string{"milk run", "shopping"}, search("Milk")); diff != "" {
+			t.Errorf("SearchTodos expected vs actual results don't match: %v", diff)
+		}
+	})
+
+	t.Run("success: any word matches, however inflected", func(t *testing.T) {
+		if diff := cmp.Diff([]string{"shopping", "wash car"}, search("shop")); diff != "" {
+			t.Errorf("SearchTodos expected vs actual results don't match: %v", diff)
+		}
+	})
+
+	t.Run("success: index follows edits and deletes", func(t *testing.T) {
+		shopping, _ := db.GetTodoByName(ctx, "shopping")
+		if _, err := db.EditTodo(ctx, shopping.ID, Todo{Name: "groceries", Description: "bread"}); err != nil {
+			t.Fatalf("EditTodo got unexpected error: %+v", err)
+		}
+		milkRun, _ := db.GetTodoByName(ctx, "milk run")
+		if err := db.DeleteTodo(ctx, milkRun.ID); err != nil {
+			t.Fatalf("DeleteTodo got unexpected error: %+v", err)
+		}
+
+		if names := search("milk"); len(names) != 0 {
+			t.Errorf("SearchTodos expected no results; got %v", names)
+		}
+		if diff := cmp.Diff([]string{"groceries"}, search("bread")); diff != "" {
+			t.Errorf("SearchTodos expected vs actual results don't match: %v", diff)
+		}
+	})
+
+	t.Run("success: DB without an index", func(t *testing.T) {
+		literal := &InMemoryDB{lists: map[string][]Todo{tenant.Default: {{ID: "1", Name: "dentist"}}}}
+		hits, err := literal.SearchTodos(ctx, SearchOptions{Query: "dentist"})
+		if err != nil || len(hits) != 1 {
+			t.Errorf("SearchTodos expected one hit; got %v, %v", hits, err)
+		}
+	})
+
+	t.Run("failure: only stop words", func(t *testing.T) {
+		_, err := db.SearchTodos(ctx, SearchOptions{Query: "the and"})
+		if !errors.Is(err, ErrEmptySearch) {
+			t.Errorf("SearchTodos expected error %v; got %v", ErrEmptySearch, err)
+		}
+	})
+}
+
 func TestInMemoryDB_GetTodoByName(t *testing.T) {
 	testData := []struct {
 		testName       string
@@ -162,7 +372,7 @@ func TestInMemoryDB_GetTodoByName(t *testing.T) {
 			testName: "success",
 			todoName: "shopping",
 			db: &InMemoryDB{
-				todoList: []Todo{
+				lists: map[string][]Todo{tenant.Default: {
 					{
 						ID:          "33333ccc-cccc-3333-c3cc-111aa1a11a1a",
 						Name:        "walk dog",
@@ -173,7 +383,7 @@ func TestInMemoryDB_GetTodoByName(t *testing.T) {
 						Name:        "shopping",
 						Description: "get milk and eggs",
 					},
-				},
+				}},
 			},
 			expectedResult: Todo{
 				ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
@@ -185,7 +395,7 @@ func TestInMemoryDB_GetTodoByName(t *testing.T) {
 			testName: "failure: todo not found",
 			todoName: "wash car",
 			db: &InMemoryDB{
-				todoList: []Todo{
+				lists: map[string][]Todo{tenant.Default: {
 					{
 						ID:          "33333ccc-cccc-3333-c3cc-111aa1a11a1a",
 						Name:        "walk dog",
@@ -196,7 +406,7 @@ func TestInMemoryDB_GetTodoByName(t *testing.T) {
 						Name:        "shopping",
 						Description: "get milk and eggs",
 					},
-				},
+				}},
 			},
 			expectedResult: Todo{},
 			wantErr:        true,
@@ -230,6 +440,71 @@ func TestInMemoryDB_GetTodoByName(t *testing.T) {
 	}
 }
 
+func TestInMemoryDB_GetTodoByID(t *testing.T) {
+	db := &InMemoryDB{
+		lists: map[string][]Todo{
+			tenant.Default: {
+				{
+					ID:          "33333ccc-cccc-3333-c3cc-111aa1a11a1a",
+					Name:        "walk dog",
+					Description: "take dog to park",
+				},
+				{
+					ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+					Name:        "shopping",
+					Description: "get milk and eggs",
+				},
+			},
+			"team-a": {
+				{
+					ID:   "22222bbb-bbbb-2222-b2bb-111aa1a11a1a",
+					Name: "wash car",
+				},
+			},
+		},
+	}
+
+	testData := []struct {
+		testName       string
+		id             string
+		expectedResult Todo
+		expectedErr    error
+	}{
+		{
+			testName: "success",
+			id:       "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+			expectedResult: Todo{
+				ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+				Name:        "shopping",
+				Description: "get milk and eggs",
+			},
+		},
+		{
+			testName:    "failure: todo not found",
+			id:          "44444ddd-dddd-4444-d4dd-111aa1a11a1a",
+			expectedErr: ErrNotFound,
+		},
+		{
+			testName:    "failure: todo in another tenant",
+			id:          "22222bbb-bbbb-2222-b2bb-111aa1a11a1a",
+			expectedErr: ErrNotFound,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			result, err := db.GetTodoByID(context.Background(), td.id)
+			if !errors.Is(err, td.expectedErr) {
+				t.Fatalf("GetTodoByID expected error '%v'; got %v", td.expectedErr, err)
+			}
+
+			if diff := cmp.Diff(td.expectedResult, result); diff != "" {
+				t.Errorf("GetTodoByID expected vs actual results don't match: %v", diff)
+			}
+		})
+	}
+}
+
 func TestInMemoryDB_EditTodo(t *testing.T) {
 	testData := []struct {
 		testName       string
@@ -238,17 +513,18 @@ func TestInMemoryDB_EditTodo(t *testing.T) {
 		todoEdit       Todo
 		expectedResult Todo
 		wantErr        bool
+		expectedErr    error
 	}{
 		{
 			testName: "success",
 			db: &InMemoryDB{
-				todoList: []Todo{
+				lists: map[string][]Todo{tenant.Default: {
 					{
 						ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
 						Name:        "shopping",
 						Description: "get milk and eggs",
 					},
-				},
+				}},
 			},
 			todoID: "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
 			todoEdit: Todo{
@@ -261,6 +537,50 @@ func TestInMemoryDB_EditTodo(t *testing.T) {
 				Description: "milk, eggs",
 			},
 		},
+		{
+			testName: "failure: todo not found",
+			db: &InMemoryDB{
+				lists: map[string][]Todo{tenant.Default: {
+					{
+						ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+						Name:        "shopping",
+						Description: "get milk and eggs",
+					},
+				}},
+			},
+			todoID: "22222bbb-bbbb-2222-b2bb-111aa1a11a1a",
+			todoEdit: Todo{
+				Name:        "wash car",
+				Description: "inside and out",
+			},
+			expectedResult: Todo{},
+			wantErr:        true,
+			expectedErr:    ErrNotFound,
+		},
+		{
+			testName: "failure: new name collides with another todo",
+			db: &InMemoryDB{
+				lists: map[string][]Todo{tenant.Default: {
+					{
+						ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+						Name:        "shopping",
+						Description: "get milk and eggs",
+					},
+					{
+						ID:   "22222bbb-bbbb-2222-b2bb-111aa1a11a1a",
+						Name: "wash car",
+					},
+				}},
+			},
+			todoID: "22222bbb-bbbb-2222-b2bb-111aa1a11a1a",
+			todoEdit: Todo{
+				Name:        "shopping",
+				Description: "inside and out",
+			},
+			expectedResult: Todo{},
+			wantErr:        true,
+			expectedErr:    ErrAlreadyInList,
+		},
 	}
 
 	for _, td := range testData {
@@ -272,8 +592,8 @@ func TestInMemoryDB_EditTodo(t *testing.T) {
 				t.Fatalf("EditTodo got unexpected error: %+v", err)
 			}
 
-			if td.wantErr && err == nil {
-				t.Fatal("EditTodo expected an error; got none")
+			if td.wantErr && !errors.Is(err, td.expectedErr) {
+				t.Fatalf("EditTodo expected error '%v'; got %v", td.expectedErr, err)
 			}
 
 			resultsCmp := cmp.Comparer(func(expected, actual Todo) bool {
@@ -285,6 +605,19 @@ func TestInMemoryDB_EditTodo(t *testing.T) {
 			if diff := cmp.Diff(td.expectedResult, result, resultsCmp); diff != "" {
 				t.Errorf("EditTodo expected vs actual results don't match: %v", diff)
 			}
+
+			if td.wantErr {
+				return
+			}
+
+			stored, err := db.GetTodoByName(context.Background(), td.todoEdit.Name)
+			if err != nil {
+				t.Fatalf("GetTodoByName could not find edited todo: %+v", err)
+			}
+
+			if diff := cmp.Diff(td.expectedResult, stored, resultsCmp); diff != "" {
+				t.Errorf("EditTodo did not persist the edit: %v", diff)
+			}
 		})
 	}
 }
@@ -300,20 +633,20 @@ func TestInMemoryDB_DeleteTodo(t *testing.T) {
 		{
 			testName: "success",
 			db: &InMemoryDB{
-				todoList: []Todo{
+				lists: map[string][]Todo{tenant.Default: {
 					{
 						ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
 						Name:        "shopping",
 						Description: "get milk and eggs",
 					},
-				},
+				}},
 			},
 			todoID: "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
 		},
 		{
 			testName: "failure: todo not found",
 			db: &InMemoryDB{
-				todoList: []Todo{},
+				lists: map[string][]Todo{tenant.Default: {}},
 			},
 			todoID:  "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
 			wantErr: true,
@@ -346,13 +679,13 @@ func TestInMemoryDB_ClearTodoList(t *testing.T) {
 		{
 			testName: "success",
 			db: &InMemoryDB{
-				todoList: []Todo{
+				lists: map[string][]Todo{tenant.Default: {
 					{
 						ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
 						Name:        "shopping",
 						Description: "get milk and eggs",
 					},
-				},
+				}},
 			},
 		},
 	}
@@ -373,6 +706,146 @@ func TestInMemoryDB_ClearTodoList(t *testing.T) {
 	}
 }
 
+func TestInMemoryDB_DeleteTodos(t *testing.T) {
+	monthAgo := time.Now().AddDate(0, -1, 0)
+	list := []Todo{
+		{ID: "1", Name: "shopping", Completed: true, UpdatedAt: monthAgo},
+		{ID: "2", Name: "wash car", Completed: true, UpdatedAt: time.Now()},
+		{ID: "3", Name: "dentist", UpdatedAt: monthAgo},
+	}
+
+	testData := []struct {
+		testName        string
+		filter          string
+		expectedDeleted []string
+		expectedLeft    []string
+	}{
+		{
+			testName:        "completed",
+			filter:          "status:completed",
+			expectedDeleted: []string{"shopping", "wash car"},
+			expectedLeft:    []string{"dentist"},
+		},
+		{
+			testName:        "completed and old",
+			filter:          "status:completed updated<" + time.Now().AddDate(0, 0, -7).Format("2006-01-02"),
+			expectedDeleted: []string{"shopping"},
+			expectedLeft:    []string{"wash car", "dentist"},
+		},
+		{
+			testName:     "no match",
+			filter:       "name=gym",
+			expectedLeft: []string{"shopping", "wash car", "dentist"},
+		},
+		{
+			testName:        "everything",
+			expectedDeleted: []string{"shopping", "wash car", "dentist"},
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			db := &InMemoryDB{lists: map[string][]Todo{tenant.Default: append([]Todo(nil), list...)}}
+			filter, err := query.Parse(td.filter)
+			if err != nil {
+				t.Fatalf("query.Parse got unexpected error: %+v", err)
+			}
+
+			deleted, err := db.DeleteTodos(context.Background(), filter)
+			if err != nil {
+				t.Fatalf("DeleteTodos got unexpected error: %+v", err)
+			}
+
+			names := func(todos []Todo) []string {
+				var names []string
+				for _, todo := range todos {
+					names = append(names, todo.Name)
+				}
+				return names
+			}
+			if diff := cmp.Diff(td.expectedDeleted, names(deleted)); diff != "" {
+				t.Errorf("DeleteTodos expected vs actual deleted todos don't match: %v", diff)
+			}
+			left, _ := db.GetTodoList(context.Background())
+			if diff := cmp.Diff(td.expectedLeft, names(left)); diff != "" {
+				t.Errorf("expected vs actual todos left don't match: %v", diff)
+			}
+		})
+	}
+}
+
+func TestInMemoryDB_WithTransaction(t *testing.T) {
+	errRollback := errors.New("simulated failure")
+
+	testData := []struct {
+		testName       string
+		fn             func(tx DB) error
+		expectedResult []string
+		wantErr        bool
+		expectedErr    error
+	}{
+		{
+			testName: "success: all changes committed",
+			fn: func(tx DB) error {
+				if _, err := tx.SaveTodo(context.Background(), "wash car", ""); err != nil {
+					return err
+				}
+				return tx.DeleteTodo(context.Background(), "11111aaa-aaaa-1111-a1aa-111aa1a11a1a")
+			},
+			expectedResult: []string{"wash car"},
+		},
+		{
+			testName: "failure: all changes rolled back",
+			fn: func(tx DB) error {
+				if _, err := tx.SaveTodo(context.Background(), "wash car", ""); err != nil {
+					return err
+				}
+				if err := tx.DeleteTodo(context.Background(), "11111aaa-aaaa-1111-a1aa-111aa1a11a1a"); err != nil {
+					return err
+				}
+				return errRollback
+			},
+			expectedResult: []string{"shopping"},
+			wantErr:        true,
+			expectedErr:    errRollback,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			db := &InMemoryDB{
+				lists: map[string][]Todo{tenant.Default: {
+					{
+						ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+						Name:        "shopping",
+						Description: "get milk and eggs",
+					},
+				}},
+			}
+
+			err := db.WithTransaction(context.Background(), td.fn)
+
+			if !td.wantErr && err != nil {
+				t.Fatalf("WithTransaction got unexpected error: %+v", err)
+			}
+
+			if td.wantErr && !errors.Is(err, td.expectedErr) {
+				t.Fatalf("WithTransaction expected error '%v'; got %v", td.expectedErr, err)
+			}
+
+			list, _ := db.GetTodoList(context.Background())
+			var names []string
+			for _, todo := range list {
+				names = append(names, todo.Name)
+			}
+
+			if diff := cmp.Diff(td.expectedResult, names); diff != "" {
+				t.Errorf("WithTransaction expected vs actual list doesn't match: %v", diff)
+			}
+		})
+	}
+}
+
 // listContains returns true if the given []Todo list contains a Todo item matching the one given as the 2nd argument
 func listContains(list []Todo, match Todo) bool {
 	for _, todo := range list {
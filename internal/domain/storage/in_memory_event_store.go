@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// InMemoryEventStore is an EventStore for tests and local development. Like MongoEventStore, it relays the outbox
+// events in its commits.
+type InMemoryEventStore struct {
+	mu        sync.RWMutex
+	commits   []Commit
+	snapshots []Snapshot
+	// outbox is the outbox events of the commits that haven't been published yet
+	outbox []OutboxEvent
+}
+
+func NewInMemoryEventStore() *InMemoryEventStore {
+	return &InMemoryEventStore{}
+}
+
+func (s *InMemoryEventStore) Append(ctx context.Context, commit Commit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if commit.Seq != int64(len(s.commits))+1 {
+		return ErrCommitConflict
+	}
+
+	s.commits = append(s.commits, commit)
+	s.outbox = append(s.outbox, commit.Outbox...)
+
+	return nil
+}
+
+func (s *InMemoryEventStore) Commits(ctx context.Context, afterSeq int64) ([]Commit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if afterSeq >= int64(len(s.commits)) {
+		return nil, nil
+	}
+	if afterSeq < 0 {
+		afterSeq = 0
+	}
+
+	return append([]Commit(nil), s.commits[afterSeq:]...), nil
+}
+
+func (s *InMemoryEventStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshots = append(s.snapshots, snapshot)
+
+	return nil
+}
+
+func (s *InMemoryEventStore) LatestSnapshot(ctx context.Context) (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var latest Snapshot
+	for _, snapshot := range s.snapshots {
+		if snapshot.Seq > latest.Seq {
+			latest = snapshot
+		}
+	}
+
+	return latest, nil
+}
+
+func (s *InMemoryEventStore) PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return pendingEvents(s.outbox, limit), nil
+}
+
+func (s *InMemoryEventStore) MarkPublished(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outbox = unpublishedEvents(s.outbox, ids)
+
+	return nil
+}
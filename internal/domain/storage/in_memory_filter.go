@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+)
+
+// matches evaluates a query AST against one Todo; a nil expr matches everything
+func matches(expr query.Expr, todo Todo) bool {
+	switch e := expr.(type) {
+	case nil:
+		return true
+	case query.And:
+		for _, sub := range e.Exprs {
+			if !matches(sub, todo) {
+				return false
+			}
+		}
+		return true
+	case query.Or:
+		for _, sub := range e.Exprs {
+			if matches(sub, todo) {
+				return true
+			}
+		}
+		return false
+	case query.Not:
+		return !matches(e.Expr, todo)
+	case query.Term:
+		return matchesTerm(e, todo)
+	default:
+		return false
+	}
+}
+
+func matchesTerm(t query.Term, todo Todo) bool {
+	switch t.Field {
+	case query.FieldText:
+		return matchesText(todo.Name, t.Op, t.Text) || matchesText(todo.Description, t.Op, t.Text)
+	case query.FieldName:
+		return matchesText(todo.Name, t.Op, t.Text)
+	case query.FieldDescription:
+		return matchesText(todo.Description, t.Op, t.Text)
+	case query.FieldID:
+		return todo.ID == t.Text
+	case query.FieldStatus:
+		return todo.Completed == t.Completed
+	case query.FieldCreated:
+		return compareTime(todo.CreatedAt, t.Op, t.Time)
+	case query.FieldUpdated:
+		return compareTime(todo.UpdatedAt, t.Op, t.Time)
+	default:
+		return false
+	}
+}
+
+func matchesText(value string, op query.Op, text string) bool {
+	if op == query.OpEqual {
+		return value == text
+	}
+	return strings.Contains(strings.ToLower(value), strings.ToLower(text))
+}
+
+func compareTime(value time.Time, op query.Op, t time.Time) bool {
+	switch op {
+	case query.OpEqual:
+		return value.Equal(t)
+	case query.OpLess:
+		return value.Before(t)
+	case query.OpLessOrEqual:
+		return !value.After(t)
+	case query.OpGreater:
+		return value.After(t)
+	case query.OpGreaterOrEqual:
+		return !value.Before(t)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+func (db *InMemoryDB) ClaimIdempotencyKey(ctx context.Context, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	record.Tenant = tenant.ID(ctx)
+	id := record.Tenant + "/" + record.Key
+	if existing, ok := db.idempotency[id]; ok && now().Before(existing.ExpiresAt) {
+		return existing, false, nil
+	}
+
+	if db.idempotency == nil {
+		db.idempotency = map[string]IdempotencyRecord{}
+	}
+	db.idempotency[id] = record
+
+	return record, true, nil
+}
+
+func (db *InMemoryDB) CompleteIdempotencyKey(ctx context.Context, key string, response IdempotentResponse) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	id := tenant.ID(ctx) + "/" + key
+	record, ok := db.idempotency[id]
+	if !ok {
+		return ErrNotFound
+	}
+	record.Response = &response
+	db.idempotency[id] = record
+
+	return nil
+}
+
+func (db *InMemoryDB) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	id := tenant.ID(ctx) + "/" + key
+	if record, ok := db.idempotency[id]; ok && record.Response == nil {
+		delete(db.idempotency, id)
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package storage
+
+import "context"
+
+func (db *InMemoryDB) AppendEvents(ctx context.Context, events ...OutboxEvent) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.outbox = append(db.outbox, newOutboxEvents(ctx, events)...)
+
+	return nil
+}
+
+func (db *InMemoryDB) PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return pendingEvents(db.outbox, limit), nil
+}
+
+// MarkPublished drops the events: nothing needs them once they're published
+func (db *InMemoryDB) MarkPublished(ctx context.Context, ids ...string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.outbox = unpublishedEvents(db.outbox, ids)
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package storage
+
+// searchIndex is InMemoryDB's inverted index from stems to the todos containing them. It only finds the candidates of
+// a search; rankHits scores them, as it does for MongoDB.
+type searchIndex struct {
+	// postings maps a stem to the IDs of the todos containing it
+	postings map[string]map[string]bool
+	// terms maps a todo ID to the stems it's posted under, so it can be taken out again
+	terms map[string][]string
+}
+
+func newSearchIndex(todos []Todo) *searchIndex {
+	idx := &searchIndex{
+		postings: make(map[string]map[string]bool),
+		terms:    make(map[string][]string),
+	}
+	for _, todo := range todos {
+		idx.add(todo)
+	}
+	return idx
+}
+
+func (idx *searchIndex) add(todo Todo) {
+	for _, term := range searchTerms(todo) {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]bool)
+		}
+		idx.postings[term][todo.ID] = true
+		idx.terms[todo.ID] = append(idx.terms[todo.ID], term)
+	}
+}
+
+func (idx *searchIndex) remove(id string) {
+	for _, term := range idx.terms[id] {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.terms, id)
+}
+
+// search returns the IDs of the todos containing at least one of terms
+func (idx *searchIndex) search(terms []string) map[string]bool {
+	ids := make(map[string]bool)
+	for _, term := range terms {
+		for id := range idx.postings[term] {
+			ids[id] = true
+		}
+	}
+	return ids
+}
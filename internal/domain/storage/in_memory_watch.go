@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+const (
+	// changeFeedHistory is how many past events an in-memory watch can resume from
+	changeFeedHistory = 1000
+	// changeFeedBuffer is how many events a watcher can fall behind before it's dropped
+	changeFeedBuffer = 256
+)
+
+// changeFeed fans InMemoryDB's writes out to its watchers. Publishing never blocks, so a slow watcher can't hold up
+// writes: its channel is closed instead and it has to resume.
+type changeFeed struct {
+	mu       sync.Mutex
+	seq      uint64
+	history  []ChangeEvent
+	watchers map[chan ChangeEvent]struct{}
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{
+		watchers: make(map[chan ChangeEvent]struct{}),
+	}
+}
+
+func (f *changeFeed) publish(event ChangeEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	event.ResumeToken = strconv.FormatUint(f.seq, 10)
+	if event.Time.IsZero() {
+		event.Time = now()
+	}
+
+	f.history = append(f.history, event)
+	if len(f.history) > changeFeedHistory {
+		f.history = f.history[len(f.history)-changeFeedHistory:]
+	}
+
+	for ch := range f.watchers {
+		select {
+		case ch <- event:
+		default:
+			delete(f.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe opens a channel that first replays the events after resumeAfter, then gets every new one
+func (f *changeFeed) subscribe(resumeAfter string) (chan ChangeEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var missed []ChangeEvent
+	if resumeAfter != "" {
+		after, err := strconv.ParseUint(resumeAfter, 10, 64)
+		// the history must reach back to the event right after the token
+		oldest := f.seq - uint64(len(f.history))
+		if err != nil || after > f.seq || after < oldest {
+			return nil, ErrInvalidResumeToken
+		}
+		missed = f.history[after-oldest:]
+	}
+
+	ch := make(chan ChangeEvent, len(missed)+changeFeedBuffer)
+	for _, event := range missed {
+		ch <- event
+	}
+	f.watchers[ch] = struct{}{}
+
+	return ch, nil
+}
+
+func (f *changeFeed) unsubscribe(ch chan ChangeEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.watchers[ch]; ok {
+		delete(f.watchers, ch)
+		close(ch)
+	}
+}
+
+// Watch reports every write to the DB. Resume tokens reach back over the last 1000 events.
+func (db *InMemoryDB) Watch(ctx context.Context, opts WatchOptions) (<-chan ChangeEvent, error) {
+	db.mu.Lock()
+	if db.feed == nil {
+		db.feed = newChangeFeed()
+	}
+	feed := db.feed
+	db.mu.Unlock()
+
+	ch, err := feed.subscribe(opts.ResumeAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		feed.unsubscribe(ch)
+	}()
+
+	return ch, nil
+}
+
+// emit reports a write, or holds it back until commit if db is a transaction. The caller must hold the write lock.
+func (db *InMemoryDB) emit(event ChangeEvent) {
+	if db.inTx {
+		db.uncommitted = append(db.uncommitted, event)
+		return
+	}
+	if db.feed != nil {
+		db.feed.publish(event)
+	}
+}
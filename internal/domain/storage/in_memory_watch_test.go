@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInMemoryDB_Watch(t *testing.T) {
+	ctx := context.Background()
+
+	// receive takes the next n events off ch, leaving out the parts that vary between runs
+	receive := func(t *testing.T, ch <-chan ChangeEvent, n int) []ChangeEvent {
+		t.Helper()
+		var events []ChangeEvent
+		for len(events) < n {
+			select {
+			case event, ok := <-ch:
+				if !ok {
+					t.Fatalf("watch channel closed after %d events", len(events))
+				}
+				if event.Time.IsZero() {
+					t.Errorf("event %+v has no time", event)
+				}
+				events = append(events, ChangeEvent{Type: event.Type, TodoID: event.TodoID, Todo: Todo{Name: event.Todo.Name}, ResumeToken: event.ResumeToken})
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out after %d events", len(events))
+			}
+		}
+		return events
+	}
+
+	t.Run("success: every write is reported", func(t *testing.T) {
+		db := NewInMemoryDB()
+		watchCtx, cancel := context.WithCancel(ctx)
+		events, err := db.Watch(watchCtx, WatchOptions{})
+		if err != nil {
+			t.Fatalf("Watch got unexpected error: %+v", err)
+		}
+
+		saved, _ := db.SaveTodo(ctx, "shopping", "get milk")
+		_, _ = db.EditTodo(ctx, saved.ID, Todo{Name: "groceries"})
+		_ = db.DeleteTodo(ctx, saved.ID)
+		_ = db.ClearTodoList(ctx)
+
+		expected := []ChangeEvent{
+			{Type: ChangeCreated, TodoID: saved.ID, Todo: Todo{Name: "shopping"}, ResumeToken: "1"},
+			{Type: ChangeUpdated, TodoID: saved.ID, Todo: Todo{Name: "groceries"}, ResumeToken: "2"},
+			{Type: ChangeDeleted, TodoID: saved.ID, ResumeToken: "3"},
+			{Type: ChangeCleared, ResumeToken: "4"},
+		}
+		if diff := cmp.Diff(expected, receive(t, events, 4)); diff != "" {
+			t.Errorf("Watch expected vs actual events don't match: %v", diff)
+		}
+
+		cancel()
+		for range events {
+		}
+	})
+
+	t.Run("success: resume after a token", func(t *testing.T) {
+		db := NewInMemoryDB()
+		_, _ = db.SaveTodo(ctx, "a", "")
+		_, _ = db.SaveTodo(ctx, "b", "")
+		_, _ = db.SaveTodo(ctx, "c", "")
+
+		events, err := db.Watch(ctx, WatchOptions{ResumeAfter: "1"})
+		if err != nil {
+			t.Fatalf("Watch got unexpected error: %+v", err)
+		}
+		_, _ = db.SaveTodo(ctx, "d", "")
+
+		var names []string
+		for _, event := range receive(t, events, 3) {
+			names = append(names, event.Todo.Name)
+		}
+		if diff := cmp.Diff([]string{"b", "c", "d"}, names); diff != "" {
+			t.Errorf("Watch expected vs actual events don't match: %v", diff)
+		}
+	})
+
+	t.Run("success: transactions are reported on commit only", func(t *testing.T) {
+		db := NewInMemoryDB()
+		events, _ := db.Watch(ctx, WatchOptions{})
+
+		_ = db.WithTransaction(ctx, func(tx DB) error {
+			_, _ = tx.SaveTodo(ctx, "rolled back", "")
+			return errors.New("rollback")
+		})
+		_ = db.WithTransaction(ctx, func(tx DB) error {
+			_, err := tx.SaveTodo(ctx, "committed", "")
+			return err
+		})
+
+		if event := receive(t, events, 1)[0]; event.Todo.Name != "committed" {
+			t.Errorf("Watch expected only the committed todo; got %+v", event)
+		}
+		select {
+		case event := <-events:
+			t.Errorf("Watch got unexpected event %+v", event)
+		default:
+		}
+	})
+
+	t.Run("failure: slow watcher is dropped", func(t *testing.T) {
+		db := NewInMemoryDB()
+		events, _ := db.Watch(ctx, WatchOptions{})
+
+		for i := 0; i <= changeFeedBuffer; i++ {
+			_ = db.ClearTodoList(ctx)
+		}
+
+		received := 0
+		for range events {
+			received++
+		}
+		if received != changeFeedBuffer {
+			t.Errorf("expected %d buffered events before the channel closed; got %d", changeFeedBuffer, received)
+		}
+	})
+
+	t.Run("failure: invalid resume tokens", func(t *testing.T) {
+		db := NewInMemoryDB()
+		for i := 0; i < changeFeedHistory+1; i++ {
+			_ = db.ClearTodoList(ctx)
+		}
+
+		for _, token := range []string{"not a token", "99999", "0"} {
+			if _, err := db.Watch(ctx, WatchOptions{ResumeAfter: token}); !errors.Is(err, ErrInvalidResumeToken) {
+				t.Errorf("Watch with token %q expected error %v; got %v", token, ErrInvalidResumeToken, err)
+			}
+		}
+	})
+}
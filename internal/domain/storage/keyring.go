@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// encryptedPrefix marks an encrypted value, which is encryptedPrefix, the key ID, a colon, then the base64 nonce and
+// ciphertext. Values without it are plaintext written before encryption was turned on.
+const encryptedPrefix = "enc:v1:"
+
+var (
+	ErrUnknownKey      = errors.New("encrypted with a key that isn't in the key ring")
+	ErrMalformedCipher = errors.New("malformed encrypted value")
+	validKeyID         = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// KeyRing holds the AES-GCM keys descriptions are encrypted with. Everything is encrypted with the primary key;
+// the others are kept to decrypt what was written before the primary was rotated, until Reencrypt has moved it all
+// onto the primary.
+type KeyRing struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyRing builds a key ring from AES-128, -192 or -256 keys by ID; primary must be one of them
+func NewKeyRing(primary string, keys map[string][]byte) (*KeyRing, error) {
+	ring := &KeyRing{primary: primary, aeads: make(map[string]cipher.AEAD, len(keys))}
+
+	for id, key := range keys {
+		if !validKeyID.MatchString(id) {
+			return nil, fmt.Errorf("storage.NewKeyRing got invalid key ID %q; want letters, digits, '_' and '-'", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("storage.NewKeyRing got invalid key %s: %v", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("storage.NewKeyRing failed to set up GCM for key %s: %v", id, err)
+		}
+		ring.aeads[id] = aead
+	}
+
+	if ring.aeads[primary] == nil {
+		return nil, fmt.Errorf("storage.NewKeyRing has no primary key %q", primary)
+	}
+
+	return ring, nil
+}
+
+// LoadKeyRing reads every file in dir as a base64 encoded key named after the file, the way Kubernetes mounts a
+// secret. Hidden files, such as the ..data links of a mounted secret, are skipped.
+func LoadKeyRing(dir, primary string) (*KeyRing, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("storage.LoadKeyRing failed to list keys: %v", err)
+	}
+
+	keys := make(map[string][]byte)
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") || file.IsDir() {
+			continue
+		}
+		encoded, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("storage.LoadKeyRing failed to read key %s: %v", file.Name(), err)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+		if err != nil {
+			return nil, fmt.Errorf("storage.LoadKeyRing failed to decode key %s: %v", file.Name(), err)
+		}
+		keys[file.Name()] = key
+	}
+
+	return NewKeyRing(primary, keys)
+}
+
+// Primary is the ID of the key new values are encrypted with
+func (k *KeyRing) Primary() string {
+	return k.primary
+}
+
+// encrypt seals plaintext with the primary key. aad binds the value to what it belongs to, so it can't be copied
+// somewhere else and still decrypt. Empty values stay empty.
+func (k *KeyRing) encrypt(plaintext, aad string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	aead := k.aeads[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(aad))
+
+	return encryptedPrefix + k.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a value encrypt sealed with any key in the ring; plaintext values are returned as they are
+func (k *KeyRing) decrypt(value, aad string) (string, error) {
+	id, sealed, encrypted := splitEncrypted(value)
+	if !encrypted {
+		return value, nil
+	}
+
+	aead := k.aeads[id]
+	if aead == nil {
+		return "", fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", ErrMalformedCipher
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(aad))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrMalformedCipher, err)
+	}
+
+	return string(plaintext), nil
+}
+
+// current reports whether value is already encrypted with the primary key, or empty
+func (k *KeyRing) current(value string) bool {
+	id, _, encrypted := splitEncrypted(value)
+	return value == "" || (encrypted && id == k.primary)
+}
+
+func splitEncrypted(value string) (id, sealed string, encrypted bool) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestKeyRing(t *testing.T, primary string, ids ...string) *KeyRing {
+	t.Helper()
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		keys[id] = []byte(fmt.Sprintf("%-32s", id))
+	}
+	ring, err := NewKeyRing(primary, keys)
+	if err != nil {
+		t.Fatalf("NewKeyRing got unexpected error: %v", err)
+	}
+	return ring
+}
+
+func TestKeyRing(t *testing.T) {
+	ring := newTestKeyRing(t, "k1", "k1")
+
+	sealed, err := ring.encrypt("call Jane on 555-0100", "team-a")
+	if err != nil {
+		t.Fatalf("encrypt got unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(sealed, "enc:v1:k1:") || strings.Contains(sealed, "Jane") {
+		t.Errorf("encrypt expected an opaque value under k1; got %q", sealed)
+	}
+
+	again, _ := ring.encrypt("call Jane on 555-0100", "team-a")
+	if again == sealed {
+		t.Error("encrypt expected a fresh nonce for every value")
+	}
+
+	testData := []struct {
+		testName       string
+		ring           *KeyRing
+		value          string
+		aad            string
+		expectedResult string
+		expectedErr    error
+	}{
+		{
+			testName:       "success: round trip",
+			ring:           ring,
+			value:          sealed,
+			aad:            "team-a",
+			expectedResult: "call Jane on 555-0100",
+		},
+		{
+			testName:       "success: rotated ring still opens old values",
+			ring:           newTestKeyRing(t, "k2", "k1", "k2"),
+			value:          sealed,
+			aad:            "team-a",
+			expectedResult: "call Jane on 555-0100",
+		},
+		{
+			testName:       "success: plaintext from before encryption",
+			ring:           ring,
+			value:          "get milk",
+			expectedResult: "get milk",
+		},
+		{
+			testName:    "failure: bound to another tenant",
+			ring:        ring,
+			value:       sealed,
+			aad:         "team-b",
+			expectedErr: ErrMalformedCipher,
+		},
+		{
+			testName:    "failure: retired key",
+			ring:        newTestKeyRing(t, "k2", "k2"),
+			value:       sealed,
+			aad:         "team-a",
+			expectedErr: ErrUnknownKey,
+		},
+		{
+			testName:    "failure: tampered",
+			ring:        ring,
+			value:       sealed[:len(sealed)-4] + "AAA=",
+			aad:         "team-a",
+			expectedErr: ErrMalformedCipher,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			result, err := td.ring.decrypt(td.value, td.aad)
+
+			if td.expectedErr == nil && err != nil {
+				t.Fatalf("decrypt got unexpected error: %v", err)
+			}
+			if td.expectedErr != nil && !errors.Is(err, td.expectedErr) {
+				t.Fatalf("decrypt expected error '%v'; got %v", td.expectedErr, err)
+			}
+
+			if result != td.expectedResult {
+				t.Errorf("decrypt expected %q; got %q", td.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestLoadKeyRing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatalf("TempDir got unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 32)))
+	for name, content := range map[string]string{
+		"2026-10": key + "\n",
+		".hidden": "not a key",
+	} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("WriteFile got unexpected error: %v", err)
+		}
+	}
+
+	ring, err := LoadKeyRing(dir, "2026-10")
+	if err != nil {
+		t.Fatalf("LoadKeyRing got unexpected error: %v", err)
+	}
+	if ring.Primary() != "2026-10" || len(ring.aeads) != 1 {
+		t.Errorf("LoadKeyRing expected only key 2026-10; got %v", ring.aeads)
+	}
+
+	if _, err = LoadKeyRing(dir, "2026-11"); err == nil {
+		t.Error("LoadKeyRing expected an error for a missing primary key")
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MigrateLatest is the migration target meaning "every registered migration"
+const MigrateLatest = -1
+
+var ErrMigrationLocked = errors.New("migrations are locked by another process")
+
+// MigrationStep is one migration the runner will apply (Up) or revert (Down)
+type MigrationStep struct {
+	Version     int
+	Description string
+	Down        bool
+}
+
+func (s MigrationStep) String() string {
+	direction := "up"
+	if s.Down {
+		direction = "down"
+	}
+	return fmt.Sprintf("%04d %-4s %s", s.Version, direction, s.Description)
+}
+
+// MigrationStatus reports whether a registered migration has been applied
+type MigrationStatus struct {
+	Version     int
+	Description string
+	Applied     bool
+}
+
+// planMigrations works out the steps needed to move from the applied set of versions to target. Versions must be
+// sorted ascending; the plan applies missing versions up to target in order and reverts applied versions above target
+// newest-first.
+func planMigrations(versions []int, descriptions map[int]string, applied map[int]bool, target int) ([]MigrationStep, error) {
+	if target == MigrateLatest {
+		target = 0
+		if len(versions) > 0 {
+			target = versions[len(versions)-1]
+		}
+	}
+
+	if target < 0 {
+		return nil, fmt.Errorf("invalid migration target %d", target)
+	}
+
+	if _, ok := descriptions[target]; target != 0 && !ok {
+		return nil, fmt.Errorf("unknown migration version %d", target)
+	}
+
+	for version := range applied {
+		if _, ok := descriptions[version]; !ok {
+			return nil, fmt.Errorf("database has migration %d applied, which this build doesn't know about", version)
+		}
+	}
+
+	var steps []MigrationStep
+
+	for _, version := range versions {
+		if version <= target && !applied[version] {
+			steps = append(steps, MigrationStep{Version: version, Description: descriptions[version]})
+		}
+	}
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+		if version > target && applied[version] {
+			steps = append(steps, MigrationStep{Version: version, Description: descriptions[version], Down: true})
+		}
+	}
+
+	return steps, nil
+}
+
+// checkMigrationVersions makes sure a migration registry has unique, positive versions and returns them sorted
+func checkMigrationVersions(versions []int) ([]int, error) {
+	sorted := append([]int(nil), versions...)
+	sort.Ints(sorted)
+
+	for i, version := range sorted {
+		if version <= 0 {
+			return nil, fmt.Errorf("migration version %d must be positive", version)
+		}
+		if i > 0 && sorted[i-1] == version {
+			return nil, fmt.Errorf("migration version %d is registered twice", version)
+		}
+	}
+
+	return sorted, nil
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlanMigrations(t *testing.T) {
+	versions := []int{1, 2, 3}
+	descriptions := map[int]string{
+		1: "first",
+		2: "second",
+		3: "third",
+	}
+
+	testData := []struct {
+		testName       string
+		applied        map[int]bool
+		target         int
+		expectedResult []MigrationStep
+		wantErr        bool
+	}{
+		{
+			testName: "success: fresh database to latest",
+			applied:  map[int]bool{},
+			target:   MigrateLatest,
+			expectedResult: []MigrationStep{
+				{Version: 1, Description: "first"},
+				{Version: 2, Description: "second"},
+				{Version: 3, Description: "third"},
+			},
+		},
+		{
+			testName: "success: partially migrated database up to a target",
+			applied:  map[int]bool{1: true},
+			target:   2,
+			expectedResult: []MigrationStep{
+				{Version: 2, Description: "second"},
+			},
+		},
+		{
+			testName:       "success: already at latest",
+			applied:        map[int]bool{1: true, 2: true, 3: true},
+			target:         MigrateLatest,
+			expectedResult: nil,
+		},
+		{
+			testName: "success: revert newest first",
+			applied:  map[int]bool{1: true, 2: true, 3: true},
+			target:   1,
+			expectedResult: []MigrationStep{
+				{Version: 3, Description: "third", Down: true},
+				{Version: 2, Description: "second", Down: true},
+			},
+		},
+		{
+			testName: "success: revert everything",
+			applied:  map[int]bool{1: true, 2: true},
+			target:   0,
+			expectedResult: []MigrationStep{
+				{Version: 2, Description: "second", Down: true},
+				{Version: 1, Description: "first", Down: true},
+			},
+		},
+		{
+			testName: "failure: unknown target",
+			applied:  map[int]bool{},
+			target:   7,
+			wantErr:  true,
+		},
+		{
+			testName: "failure: database is ahead of this build",
+			applied:  map[int]bool{1: true, 4: true},
+			target:   MigrateLatest,
+			wantErr:  true,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			result, err := planMigrations(versions, descriptions, td.applied, td.target)
+
+			if !td.wantErr && err != nil {
+				t.Fatalf("planMigrations got unexpected error: %v", err)
+			}
+
+			if td.wantErr && err == nil {
+				t.Fatal("planMigrations expected an error; got none")
+			}
+
+			if diff := cmp.Diff(td.expectedResult, result); diff != "" {
+				t.Errorf("planMigrations expected vs actual results don't match: %v", diff)
+			}
+		})
+	}
+}
+
+func TestMongoMigrations_Registry(t *testing.T) {
+	var versions []int
+	for _, m := range mongoMigrations {
+		if m.Up == nil || m.Down == nil {
+			t.Errorf("migration %d must define both Up and Down", m.Version)
+		}
+		if m.Description == "" {
+			t.Errorf("migration %d is missing a description", m.Version)
+		}
+		versions = append(versions, m.Version)
+	}
+
+	sorted, err := checkMigrationVersions(versions)
+	if err != nil {
+		t.Fatalf("migration registry is invalid: %v", err)
+	}
+
+	for i, version := range sorted {
+		if version != i+1 {
+			t.Errorf("migration versions should be contiguous from 1; got %v", sorted)
+			break
+		}
+	}
+}
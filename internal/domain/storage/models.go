@@ -3,22 +3,85 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
 )
 
 var ErrNotFound = errors.New("not found")
 var ErrAlreadyInList = errors.New("todo already in list")
 
+// ErrVersionMismatch means a write expected a version of the todo that has since been replaced by another write
+var ErrVersionMismatch = errors.New("todo was changed by another write")
+
+// DB stores the todos of the tenant in ctx. EditTodo only applies if the todo still has todo.Version, unless that is
+// zero, and returns ErrVersionMismatch otherwise. DeleteTodos deletes the todos matching filter, all of them if it's
+// nil, and returns them as they were.
 type DB interface {
 	SaveTodo(ctx context.Context, name, description string) (Todo, error)
 	GetTodoList(ctx context.Context) ([]Todo, error)
+	ListTodos(ctx context.Context, opts ListOptions) (TodoPage, error)
+	SearchTodos(ctx context.Context, opts SearchOptions) ([]SearchHit, error)
 	GetTodoByName(ctx context.Context, name string) (Todo, error)
+	GetTodoByID(ctx context.Context, id string) (Todo, error)
 	EditTodo(ctx context.Context, id string, todo Todo) (Todo, error)
 	DeleteTodo(ctx context.Context, id string) error
+	DeleteTodos(ctx context.Context, filter query.Expr) ([]Todo, error)
 	ClearTodoList(ctx context.Context) error
 }
 
+// Pinger is implemented by DB backends that can report whether their connection to the database is alive
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Closer is implemented by DB backends that hold resources which must be released on shutdown
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// Transactor is implemented by DB backends that can run several operations as one all-or-nothing unit of work.
+// Every operation inside fn must go through tx; fn may be retried, so it shouldn't have side effects outside tx.
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(tx DB) error) error
+}
+
+// SupportsTransactions reports whether RunInTransaction runs fn all-or-nothing on db. A Transactor whose transactions
+// depend on the deployment, like MongoDB, which has none on a standalone server, says so with a SupportsTransactions
+// method of its own.
+func SupportsTransactions(db DB) bool {
+	transactor, ok := db.(Transactor)
+	if !ok {
+		return false
+	}
+	if checker, ok := transactor.(interface{ SupportsTransactions() bool }); ok {
+		return checker.SupportsTransactions()
+	}
+	return true
+}
+
+// RunInTransaction runs fn in a transaction if db supports them and directly against db otherwise
+func RunInTransaction(ctx context.Context, db DB, fn func(tx DB) error) error {
+	if transactor, ok := db.(Transactor); ok {
+		return transactor.WithTransaction(ctx, fn)
+	}
+	return fn(db)
+}
+
 type Todo struct {
-	ID          string
-	Name        string
-	Description string
+	ID string `bson:"id"`
+	// Tenant is the tenant the todo belongs to; backends fill it in from the ctx of the write
+	Tenant      string    `bson:"tenant"`
+	Name        string    `bson:"name"`
+	Description string    `bson:"description"`
+	Completed   bool      `bson:"completed"`
+	CreatedAt   time.Time `bson:"created_at"`
+	UpdatedAt   time.Time `bson:"updated_at"`
+	// Version counts the writes to the todo, starting at 1 when it's created
+	Version int64 `bson:"version"`
+}
+
+// now is the timestamp for writes, rounded to the millisecond precision MongoDB stores
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
 }
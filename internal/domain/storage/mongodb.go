@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -13,32 +15,94 @@ import (
 )
 
 type MongoDB struct {
-	collection *mongo.Collection
+	client                   *mongo.Client
+	collection               *mongo.Collection
+	migrationsCollectionName string
+	// transactions is whether the deployment has multi-document transactions: a standalone server doesn't
+	transactions bool
 }
 
-func NewMongoDB(hostName, databaseName, collectionName, userName, password string, timeout time.Duration) (*MongoDB, error) {
-	database, err := connect(hostName, databaseName, userName, password, timeout)
+// mongoTodo is a Todo as it's stored: keyed by its ID, so that change events for deletes, which only carry the key,
+// still say which todo went, and with the stems SearchTodos looks it up by
+type mongoTodo struct {
+	DocumentID  string `bson:"_id"`
+	Todo        `bson:",inline"`
+	SearchTerms []string `bson:"search_terms"`
+}
+
+func newMongoTodo(todo Todo) mongoTodo {
+	return mongoTodo{DocumentID: todo.ID, Todo: todo, SearchTerms: searchTerms(todo)}
+}
+
+func NewMongoDB(cfg MongoConfig) (*MongoDB, error) {
+	if err := cfg.Validate(); err != nil {
+		return &MongoDB{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	client, err := connect(ctx, cfg)
 	if err != nil {
 		return &MongoDB{}, err
 	}
 
+	transactions, err := hasTransactions(ctx, client)
+	if err != nil {
+		_ = client.Disconnect(context.Background())
+		return &MongoDB{}, err
+	}
+	if !transactions {
+		log.Printf("storage.NewMongoDB: the deployment is a standalone server, which has no transactions; writes that span several documents won't be atomic")
+	}
+
+	migrationsCollectionName := cfg.MigrationsCollection
+	if migrationsCollectionName == "" {
+		migrationsCollectionName = defaultMigrationsCollection
+	}
+
 	return &MongoDB{
-		collection: database.Collection(collectionName),
+		client:                   client,
+		collection:               client.Database(cfg.DatabaseName).Collection(cfg.CollectionName),
+		migrationsCollectionName: migrationsCollectionName,
+		transactions:             transactions,
 	}, nil
 }
 
+// hasTransactions asks the server what it's part of: replica set members and mongos routers have multi-document
+// transactions, standalone servers don't
+func hasTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	var reply struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&reply); err != nil {
+		return false, fmt.Errorf("storage.NewMongoDB failed to read the deployment's topology: %v", err)
+	}
+
+	return reply.SetName != "" || reply.Msg == "isdbgrid", nil
+}
+
 func (db *MongoDB) SaveTodo(ctx context.Context, name, description string) (Todo, error) {
 	if _, err := db.GetTodoByName(ctx, name); err != ErrNotFound {
 		return Todo{}, ErrAlreadyInList
 	}
 
+	createdAt := now()
 	todo := Todo{
-		ID: createID(),
-		Name: name,
+		ID:          createID(),
+		Tenant:      tenant.ID(ctx),
+		Name:        name,
 		Description: description,
+		CreatedAt:   createdAt,
+		UpdatedAt:   createdAt,
+		Version:     1,
 	}
 
-	if _, err := db.collection.InsertOne(ctx, todo); err != nil {
+	if _, err := db.collection.InsertOne(ctx, newMongoTodo(todo)); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return Todo{}, ErrAlreadyInList
+		}
 		return Todo{}, fmt.Errorf("storage.SaveTodo got error on insert: %v", err)
 	}
 
@@ -48,7 +112,7 @@ func (db *MongoDB) SaveTodo(ctx context.Context, name, description string) (Todo
 func (db *MongoDB) GetTodoList(ctx context.Context) ([]Todo, error) {
 	todos := []Todo{}
 
-	cursor, err := db.collection.Find(ctx, bson.M{})
+	cursor, err := db.collection.Find(ctx, scoped(ctx, bson.M{}))
 	if err != nil {
 		return todos, fmt.Errorf("storage.GetTodoList failed to find a collection cursor: %v", err)
 	}
@@ -64,11 +128,42 @@ func (db *MongoDB) GetTodoList(ctx context.Context) ([]Todo, error) {
 	return todos, nil
 }
 
+func (db *MongoDB) ListTodos(ctx context.Context, opts ListOptions) (TodoPage, error) {
+	afterID, err := opts.afterID()
+	if err != nil {
+		return TodoPage{}, err
+	}
+
+	filter := mongoFilter(opts.Filter)
+	if afterID != "" {
+		filter = bson.M{"$and": bson.A{filter, bson.M{"id": bson.M{"$gt": afterID}}}}
+	}
+
+	// fetch one more than the limit to tell whether there's a next page
+	limit := opts.limit()
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "id", Value: 1}}).
+		SetLimit(int64(limit + 1))
+
+	cursor, err := db.collection.Find(ctx, scoped(ctx, filter), findOpts)
+	if err != nil {
+		return TodoPage{}, fmt.Errorf("storage.ListTodos failed to find a collection cursor: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var todos []Todo
+	if err = cursor.All(ctx, &todos); err != nil {
+		return TodoPage{}, fmt.Errorf("storage.ListTodos: cursor failed to decode todos: %v", err)
+	}
+
+	return newPage(todos, limit), nil
+}
+
 func (db *MongoDB) GetTodoByName(ctx context.Context, name string) (Todo, error) {
 	log.Printf("storage.GetTodoByName starting to search to todo with name %v", name)
 	var todo Todo
 
-	if err := db.collection.FindOne(ctx, bson.M{"name": name}).Decode(&todo); err != nil {
+	if err := db.collection.FindOne(ctx, scoped(ctx, bson.M{"name": name})).Decode(&todo); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return Todo{}, ErrNotFound
 		}
@@ -79,66 +174,299 @@ func (db *MongoDB) GetTodoByName(ctx context.Context, name string) (Todo, error)
 	return todo, nil
 }
 
+func (db *MongoDB) GetTodoByID(ctx context.Context, id string) (Todo, error) {
+	var todo Todo
+
+	if err := db.collection.FindOne(ctx, scoped(ctx, bson.M{"id": id})).Decode(&todo); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Todo{}, ErrNotFound
+		}
+		return Todo{}, fmt.Errorf("storage.GetTodoByID got unexpected error on FindOne: %v", err)
+	}
+
+	return todo, nil
+}
+
 func (db *MongoDB) EditTodo(ctx context.Context, id string, todo Todo) (Todo, error) {
+	nameTaken := bson.M{
+		"name": todo.Name,
+		"id":   bson.M{"$ne": id},
+	}
+	if err := db.collection.FindOne(ctx, scoped(ctx, nameTaken)).Err(); err == nil {
+		return Todo{}, ErrAlreadyInList
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return Todo{}, fmt.Errorf("storage.EditTodo got unexpected error on FindOne: %v", err)
+	}
+
 	todoUpdate := bson.M{
 		"$set": bson.M{
-			"name":        todo.Name,
-			"description": todo.Description,
+			"name":         todo.Name,
+			"description":  todo.Description,
+			"completed":    todo.Completed,
+			"updated_at":   now(),
+			"search_terms": searchTerms(todo),
 		},
+		"$inc": bson.M{"version": 1},
 	}
 
-	if _, err := db.collection.UpdateOne(ctx, bson.M{"id": id}, todoUpdate); err != nil {
-		return Todo{}, fmt.Errorf("storage.EditTodo got error from UpdateOne: %v", err)
+	// the version is part of the filter, so a write that lands after the caller read the todo doesn't get overwritten
+	filter := bson.M{"id": id}
+	if todo.Version != 0 {
+		filter["version"] = todo.Version
 	}
 
-	todo.ID = id
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
-	return todo, nil
+	var updated Todo
+	if err := db.collection.FindOneAndUpdate(ctx, scoped(ctx, filter), todoUpdate, opts).Decode(&updated); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if todo.Version == 0 {
+				return Todo{}, ErrNotFound
+			}
+			if _, err = db.GetTodoByID(ctx, id); err != nil {
+				return Todo{}, err
+			}
+			return Todo{}, ErrVersionMismatch
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return Todo{}, ErrAlreadyInList
+		}
+		return Todo{}, fmt.Errorf("storage.EditTodo got error from FindOneAndUpdate: %v", err)
+	}
+
+	return updated, nil
 }
 
 func (db *MongoDB) DeleteTodo(ctx context.Context, id string) error {
-	if _, err := db.collection.DeleteOne(ctx, bson.M{"id": id}); err != nil {
+	result, err := db.collection.DeleteOne(ctx, scoped(ctx, bson.M{"id": id}))
+	if err != nil {
 		return fmt.Errorf("storage.DeleteTodo got error from DeleteOne: %v", err)
 	}
 
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
 	return nil
 }
 
+// DeleteTodos finds the todos matching filter, then deletes those of them that still match, so a todo that's added
+// or changed to match in between isn't deleted without being returned
+func (db *MongoDB) DeleteTodos(ctx context.Context, filter query.Expr) ([]Todo, error) {
+	cursor, err := db.collection.Find(ctx, scoped(ctx, mongoFilter(filter)))
+	if err != nil {
+		return nil, fmt.Errorf("storage.DeleteTodos failed to find a collection cursor: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var todos []Todo
+	if err = cursor.All(ctx, &todos); err != nil {
+		return nil, fmt.Errorf("storage.DeleteTodos: cursor failed to decode todos: %v", err)
+	}
+	if len(todos) == 0 {
+		return nil, nil
+	}
+
+	var ids []string
+	for _, todo := range todos {
+		ids = append(ids, todo.ID)
+	}
+	matching := bson.M{"$and": bson.A{mongoFilter(filter), bson.M{"id": bson.M{"$in": ids}}}}
+	if _, err = db.collection.DeleteMany(ctx, scoped(ctx, matching)); err != nil {
+		return nil, fmt.Errorf("storage.DeleteTodos got error from DeleteMany: %v", err)
+	}
+
+	return todos, nil
+}
+
+// ClearTodoList deletes the tenant's todos; the collection is shared with other tenants, so it can't just be dropped
 func (db *MongoDB) ClearTodoList(ctx context.Context) error {
-	database := db.collection.Database()
-	collectionName := db.collection.Name()
+	if _, err := db.collection.DeleteMany(ctx, scoped(ctx, bson.M{})); err != nil {
+		return fmt.Errorf("storage.ClearTodoList got error from DeleteMany: %v", err)
+	}
+
+	return nil
+}
+
+// ReplaceTodos swaps every tenant's list for todos, keeping their IDs, tenants and timestamps, in one transaction.
+// On a standalone server, which has none, a failure part way leaves some of the todos replaced.
+func (db *MongoDB) ReplaceTodos(ctx context.Context, todos []Todo) error {
+	return db.transaction(ctx, "ReplaceTodos", func(ctx context.Context) error {
+		if _, err := db.collection.DeleteMany(ctx, bson.M{}); err != nil {
+			return fmt.Errorf("storage.ReplaceTodos got error from DeleteMany: %v", err)
+		}
+		if len(todos) == 0 {
+			return nil
+		}
 
-	if err := db.collection.Drop(ctx); err != nil {
-		return fmt.Errorf("storage.ClearTodoList got error from collection.Drop: %v", err)
+		var docs []interface{}
+		for _, todo := range todos {
+			todo.Tenant = partitionKey(todo.Tenant)
+			docs = append(docs, newMongoTodo(todo))
+		}
+		if _, err := db.collection.InsertMany(ctx, docs); err != nil {
+			return fmt.Errorf("storage.ReplaceTodos got error on insert: %v", err)
+		}
+		return nil
+	})
+}
+
+// EachTodo calls fn with every tenant's todos as the cursor delivers them
+func (db *MongoDB) EachTodo(ctx context.Context, fn func(todo Todo) error) error {
+	cursor, err := db.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("storage.EachTodo failed to find a collection cursor: %v", err)
 	}
+	defer cursor.Close(ctx)
 
-	if err := database.CreateCollection(ctx, collectionName); err != nil {
-		return fmt.Errorf("storage.ClearTodoList got error from database.CreateCollection: %v", err)
+	for cursor.Next(ctx) {
+		var todo Todo
+		if err = cursor.Decode(&todo); err != nil {
+			return fmt.Errorf("storage.EachTodo: cursor failed to decode next todo in collection: %v", err)
+		}
+		if err = fn(todo); err != nil {
+			return err
+		}
+	}
+
+	if err = cursor.Err(); err != nil {
+		return fmt.Errorf("storage.EachTodo got cursor error: %v", err)
 	}
 
 	return nil
 }
 
-func connect(hostName, databaseName, userName, password string, timeout time.Duration) (*mongo.Database, error) {
-	connectionString := fmt.Sprintf("mongodb+srv://%s:%s@%s/%s?retryWrites=true&w=majority", userName, password, hostName, databaseName)
+// ImportTodos removes and stores the todos in one transaction, which bounds an import to what a MongoDB transaction
+// can hold: about 16MB of todos. On a standalone server, like ReplaceTodos, it isn't atomic.
+func (db *MongoDB) ImportTodos(ctx context.Context, upserts, deletes []Todo) error {
+	return db.transaction(ctx, "ImportTodos", func(ctx context.Context) error {
+		if len(deletes) > 0 {
+			var ids []string
+			for _, todo := range deletes {
+				ids = append(ids, todo.ID)
+			}
+			if _, err := db.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+				return fmt.Errorf("storage.ImportTodos got error from DeleteMany: %v", err)
+			}
+		}
+		if len(upserts) == 0 {
+			return nil
+		}
 
-	clientOptions := options.Client().ApplyURI(connectionString)
+		var writes []mongo.WriteModel
+		for _, todo := range upserts {
+			todo.Tenant = partitionKey(todo.Tenant)
+			writes = append(writes, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"_id": todo.ID}).
+				SetReplacement(newMongoTodo(todo)).
+				SetUpsert(true))
+		}
+		if _, err := db.collection.BulkWrite(ctx, writes); err != nil {
+			return fmt.Errorf("storage.ImportTodos got error from BulkWrite: %v", err)
+		}
+		return nil
+	})
+}
 
-	client, err := mongo.NewClient(clientOptions)
+// RewriteDescriptions walks every tenant's todos and stores the descriptions rewrite returns. Each update only
+// applies if the description is still the one rewrite was given, so an edit made in the meantime wins.
+func (db *MongoDB) RewriteDescriptions(ctx context.Context, rewrite func(todo Todo) (string, bool, error)) (int, error) {
+	cursor, err := db.collection.Find(ctx, bson.M{})
 	if err != nil {
-		return &mongo.Database{}, fmt.Errorf("storage.connect failed to create mongo client: %v", err)
+		return 0, fmt.Errorf("storage.RewriteDescriptions failed to find a collection cursor: %v", err)
 	}
+	defer cursor.Close(ctx)
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	rewritten := 0
+	for cursor.Next(ctx) {
+		var todo Todo
+		if err = cursor.Decode(&todo); err != nil {
+			return rewritten, fmt.Errorf("storage.RewriteDescriptions: cursor failed to decode next todo in collection: %v", err)
+		}
 
-	if err = client.Connect(ctx); err != nil {
-		return &mongo.Database{}, fmt.Errorf("storage.connect failed to connect to mongo client: %v", err)
+		description, ok, err := rewrite(todo)
+		if err != nil {
+			return rewritten, err
+		}
+		if !ok {
+			continue
+		}
+
+		filter := bson.M{"id": todo.ID, "description": todo.Description}
+		update := bson.M{"$set": bson.M{
+			"description":  description,
+			"search_terms": searchTerms(Todo{Name: todo.Name, Description: description}),
+		}}
+		result, err := db.collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return rewritten, fmt.Errorf("storage.RewriteDescriptions got error from UpdateOne: %v", err)
+		}
+		rewritten += int(result.ModifiedCount)
+	}
+
+	if err = cursor.Err(); err != nil {
+		return rewritten, fmt.Errorf("storage.RewriteDescriptions got cursor error: %v", err)
+	}
+
+	return rewritten, nil
+}
+
+// Ping checks that a server matching the configured read preference is reachable, so an instance reading from
+// secondaries stays ready while the replica set elects a primary. The driver re-establishes dropped connections on
+// its own, so a failing Ping means the database is currently unavailable rather than that the MongoDB needs to be
+// recreated.
+func (db *MongoDB) Ping(ctx context.Context) error {
+	// a nil read preference is the client's own
+	if err := db.client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("storage.Ping failed to ping mongo client: %v", err)
+	}
+
+	return nil
+}
+
+// Close disconnects the client, waiting for in-use connections to be returned to the pool until ctx expires
+func (db *MongoDB) Close(ctx context.Context) error {
+	if err := db.client.Disconnect(ctx); err != nil {
+		return fmt.Errorf("storage.Close failed to disconnect mongo client: %v", err)
+	}
+
+	return nil
+}
+
+// connect creates a client and waits for the primary to answer a ping, retrying with backoff until ctx expires
+func connect(ctx context.Context, cfg MongoConfig) (*mongo.Client, error) {
+	clientOptions, err := cfg.clientOptions()
+	if err != nil {
+		return nil, fmt.Errorf("storage.connect got invalid client options: %v", err)
 	}
 
-	if err = client.Ping(context.Background(), readpref.Primary()); err != nil {
-		return &mongo.Database{}, fmt.Errorf("storage.connect failed to ping mongo client: %v", err)
+	client, err := mongo.NewClient(clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("storage.connect failed to create mongo client: %v", err)
+	}
+
+	if err = client.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("storage.connect failed to connect to mongo client: %v", err)
 	}
 
-	return client.Database(databaseName), nil
-}
\ No newline at end of file
+	backoff := 250 * time.Millisecond
+	for {
+		err = client.Ping(ctx, readpref.Primary())
+		if err == nil {
+			return client, nil
+		}
+
+		log.Printf("storage.connect failed to ping mongo client, retrying in %v: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			_ = client.Disconnect(context.Background())
+			return nil, fmt.Errorf("storage.connect failed to ping mongo client: %v", err)
+		case <-time.After(backoff):
+		}
+
+		if backoff < 4*time.Second {
+			backoff *= 2
+		}
+	}
+}
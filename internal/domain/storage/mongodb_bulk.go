@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BulkWrite reads every todo the ops touch in one query, works out each op's result against them, then sends the
+// writes that succeed in one BulkWrite. Updates and deletes are filtered on the version that was read, so if another
+// write gets in between, the bulk write fails with ErrVersionMismatch rather than overwrite it; inside a transaction
+// the driver retries instead.
+func (db *MongoDB) BulkWrite(ctx context.Context, ops []BulkOp) ([]BulkResult, error) {
+	// $in needs an array, even an empty one
+	ids, names := []string{}, []string{}
+	for _, op := range ops {
+		if op.Kind == BulkCreate || op.Kind == BulkUpdate {
+			names = append(names, op.Todo.Name)
+		}
+		if op.ID != "" {
+			ids = append(ids, op.ID)
+		}
+	}
+
+	touched := bson.M{"$or": bson.A{
+		bson.M{"id": bson.M{"$in": ids}},
+		bson.M{"name": bson.M{"$in": names}},
+	}}
+	cursor, err := db.collection.Find(ctx, scoped(ctx, touched))
+	if err != nil {
+		return nil, fmt.Errorf("storage.BulkWrite failed to find a collection cursor: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var existing []Todo
+	if err = cursor.All(ctx, &existing); err != nil {
+		return nil, fmt.Errorf("storage.BulkWrite: cursor failed to decode todos: %v", err)
+	}
+
+	plan := newBulkPlan(existing)
+	results := make([]BulkResult, len(ops))
+	var writes []mongo.WriteModel
+	var updates, deletes int64
+	for i, op := range ops {
+		result, before := plan.apply(ctx, op)
+		if result.Err != nil {
+			if !isBulkOpError(result.Err) {
+				return nil, result.Err
+			}
+			results[i] = result
+			continue
+		}
+		results[i] = result
+
+		todo := result.Todo
+		switch op.Kind {
+		case BulkCreate:
+			writes = append(writes, mongo.NewInsertOneModel().SetDocument(newMongoTodo(todo)))
+		case BulkUpdate:
+			updates++
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(scoped(ctx, bson.M{"id": todo.ID, "version": before.Version})).
+				SetUpdate(bson.M{"$set": bson.M{
+					"name":         todo.Name,
+					"description":  todo.Description,
+					"completed":    todo.Completed,
+					"updated_at":   todo.UpdatedAt,
+					"version":      todo.Version,
+					"search_terms": searchTerms(todo),
+				}}))
+		case BulkDelete:
+			deletes++
+			writes = append(writes, mongo.NewDeleteOneModel().
+				SetFilter(scoped(ctx, bson.M{"id": todo.ID, "version": before.Version})))
+		}
+	}
+	if len(writes) == 0 {
+		return results, nil
+	}
+
+	written, err := db.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(true))
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAlreadyInList
+		}
+		return nil, fmt.Errorf("storage.BulkWrite got error from BulkWrite: %v", err)
+	}
+	if written.MatchedCount != updates || written.DeletedCount != deletes {
+		return nil, ErrVersionMismatch
+	}
+
+	return results, nil
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	SchemeMongoDB    = "mongodb"
+	SchemeMongoDBSRV = "mongodb+srv"
+)
+
+var supportedCompressors = map[string]bool{
+	"snappy": true,
+	"zlib":   true,
+	"zstd":   true,
+}
+
+// MongoConfig holds everything needed to connect to a MongoDB deployment.
+// Either URI is set to a complete connection string, or the discrete
+// Scheme/HostName settings are used to build one.
+type MongoConfig struct {
+	URI            string
+	Scheme         string
+	HostName       string
+	DatabaseName   string
+	CollectionName string
+
+	// MigrationsCollection records applied schema migrations; it defaults to "schema_migrations"
+	MigrationsCollection string
+
+	// UserName and Password are passed to the driver as a credential rather than
+	// being embedded in the URI, so they never need escaping. When set, they
+	// replace any credentials in URI.
+	UserName   string
+	Password   string
+	AuthSource string
+
+	ReplicaSet     string
+	ReadPreference string
+	AppName        string
+	Compressors    []string
+	MaxPoolSize    uint64
+	MinPoolSize    uint64
+
+	// TLSCAFile is a PEM bundle of CAs used to verify the server; TLSCertKeyFile is
+	// a PEM file holding the client certificate followed by its private key
+	TLSCAFile      string
+	TLSCertKeyFile string
+
+	Timeout time.Duration
+}
+
+// Validate checks the config for settings that would otherwise only fail once the driver tries to connect.
+func (c MongoConfig) Validate() error {
+	var problems []string
+
+	if c.URI == "" {
+		switch c.Scheme {
+		case SchemeMongoDB:
+			if c.HostName == "" {
+				problems = append(problems, "a host name is required")
+			}
+		case SchemeMongoDBSRV:
+			if c.HostName == "" {
+				problems = append(problems, "a host name is required")
+			}
+			if strings.ContainsAny(c.HostName, ",:") {
+				problems = append(problems, fmt.Sprintf("%s host name %q must be a single host without a port", SchemeMongoDBSRV, c.HostName))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("unsupported scheme %q (want %q or %q)", c.Scheme, SchemeMongoDB, SchemeMongoDBSRV))
+		}
+	} else if !strings.HasPrefix(c.URI, SchemeMongoDB+"://") && !strings.HasPrefix(c.URI, SchemeMongoDBSRV+"://") {
+		problems = append(problems, fmt.Sprintf("connection URI must start with %s:// or %s://", SchemeMongoDB, SchemeMongoDBSRV))
+	}
+
+	if c.DatabaseName == "" {
+		problems = append(problems, "a database name is required")
+	}
+
+	if c.CollectionName == "" {
+		problems = append(problems, "a collection name is required")
+	}
+
+	if c.MigrationsCollection != "" && c.MigrationsCollection == c.CollectionName {
+		problems = append(problems, "the migrations collection must differ from the todos collection")
+	}
+
+	if c.Password != "" && c.UserName == "" {
+		problems = append(problems, "a password was given without a user name")
+	}
+
+	if c.ReadPreference != "" {
+		if _, err := readpref.ModeFromString(c.ReadPreference); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	for _, compressor := range c.Compressors {
+		if !supportedCompressors[compressor] {
+			problems = append(problems, fmt.Sprintf("unsupported compressor %q", compressor))
+		}
+	}
+
+	if c.MaxPoolSize != 0 && c.MinPoolSize > c.MaxPoolSize {
+		problems = append(problems, fmt.Sprintf("min pool size %d is larger than max pool size %d", c.MinPoolSize, c.MaxPoolSize))
+	}
+
+	if c.Timeout <= 0 {
+		problems = append(problems, "timeout must be positive")
+	}
+
+	for _, fpath := range []string{c.TLSCAFile, c.TLSCertKeyFile} {
+		if fpath == "" {
+			continue
+		}
+		if _, err := ioutil.ReadFile(fpath); err != nil {
+			problems = append(problems, fmt.Sprintf("cannot read TLS file: %v", err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("storage.MongoConfig is invalid: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// ConnectionURI returns the connection string the client will be created from. It never contains credentials.
+func (c MongoConfig) ConnectionURI() string {
+	if c.URI != "" {
+		return c.URI
+	}
+
+	query := url.Values{}
+	query.Set("retryWrites", "true")
+	query.Set("w", "majority")
+
+	u := url.URL{
+		Scheme:   c.Scheme,
+		Host:     c.HostName,
+		Path:     "/" + c.DatabaseName,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
+
+// URIHasCredentials reports whether the connection URI uri names a user
+func URIHasCredentials(uri string) bool {
+	i := strings.Index(uri, "://")
+	if i < 0 {
+		return false
+	}
+	hosts := uri[i+len("://"):]
+	if end := strings.IndexAny(hosts, "/?"); end >= 0 {
+		hosts = hosts[:end]
+	}
+	return strings.Contains(hosts, "@")
+}
+
+func (c MongoConfig) clientOptions() (*options.ClientOptions, error) {
+	clientOptions := options.Client().ApplyURI(c.ConnectionURI())
+
+	if c.UserName != "" {
+		clientOptions.SetAuth(options.Credential{
+			Username:   c.UserName,
+			Password:   c.Password,
+			AuthSource: c.AuthSource,
+		})
+	}
+
+	if c.ReplicaSet != "" {
+		clientOptions.SetReplicaSet(c.ReplicaSet)
+	}
+
+	if c.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(c.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetReadPreference(rp)
+	}
+
+	if c.AppName != "" {
+		clientOptions.SetAppName(c.AppName)
+	}
+
+	if len(c.Compressors) > 0 {
+		clientOptions.SetCompressors(c.Compressors)
+	}
+
+	if c.MaxPoolSize != 0 {
+		clientOptions.SetMaxPoolSize(c.MaxPoolSize)
+	}
+
+	if c.MinPoolSize != 0 {
+		clientOptions.SetMinPoolSize(c.MinPoolSize)
+	}
+
+	clientOptions.SetConnectTimeout(c.Timeout)
+	clientOptions.SetServerSelectionTimeout(c.Timeout)
+
+	if c.TLSCAFile != "" || c.TLSCertKeyFile != "" {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+
+	if err := clientOptions.Validate(); err != nil {
+		return nil, err
+	}
+
+	return clientOptions, nil
+}
+
+func (c MongoConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if c.TLSCAFile != "" {
+		caPEM, err := ioutil.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no CA certificates found in " + c.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.TLSCertKeyFile != "" {
+		certKeyPEM, err := ioutil.ReadFile(c.TLSCertKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair(certKeyPEM, certKeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate from %s: %v", c.TLSCertKeyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
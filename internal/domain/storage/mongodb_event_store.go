@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoEventStore keeps the event stream of a MongoDB's todo list next to it, in the <todos>_events and
+// <todos>_snapshots collections. Commits are keyed by Seq, so the _id index is what turns a second commit with the
+// same Seq into ErrCommitConflict. The outbox events of a commit are stored in its document, so a write and its
+// events are stored atomically even on a standalone server.
+type MongoEventStore struct {
+	db *MongoDB
+}
+
+// mongoCommit is how a Commit is stored. OutboxPending is set until every outbox event in it is published, which
+// MarkPublished records on the event itself.
+type mongoCommit struct {
+	Commit        `bson:",inline"`
+	OutboxPending bool `bson:"outbox_pending,omitempty"`
+}
+
+// mongoCommitOutbox is the outbox of a stored commit
+type mongoCommitOutbox struct {
+	Outbox []struct {
+		OutboxEvent `bson:",inline"`
+		PublishedAt time.Time `bson:"published_at"`
+	} `bson:"outbox"`
+}
+
+func NewMongoEventStore(db *MongoDB) *MongoEventStore {
+	return &MongoEventStore{db: db}
+}
+
+func (s *MongoEventStore) Append(ctx context.Context, commit Commit) error {
+	// commits are only ever appended right after the last one, so the previous commit must exist
+	if commit.Seq > 1 {
+		err := s.commits().FindOne(ctx, bson.M{"_id": commit.Seq - 1}).Err()
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrCommitConflict
+		}
+		if err != nil {
+			return fmt.Errorf("storage.Append got unexpected error on FindOne: %v", err)
+		}
+	}
+
+	if _, err := s.commits().InsertOne(ctx, mongoCommit{Commit: commit, OutboxPending: len(commit.Outbox) > 0}); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrCommitConflict
+		}
+		return fmt.Errorf("storage.Append got error on insert: %v", err)
+	}
+
+	return nil
+}
+
+func (s *MongoEventStore) Commits(ctx context.Context, afterSeq int64) ([]Commit, error) {
+	findOpts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := s.commits().Find(ctx, bson.M{"_id": bson.M{"$gt": afterSeq}}, findOpts)
+	if err != nil {
+		return nil, fmt.Errorf("storage.Commits failed to find a collection cursor: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var commits []Commit
+	if err = cursor.All(ctx, &commits); err != nil {
+		return nil, fmt.Errorf("storage.Commits: cursor failed to decode commits: %v", err)
+	}
+
+	return commits, nil
+}
+
+func (s *MongoEventStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) error {
+	opts := options.Replace().SetUpsert(true)
+	if _, err := s.snapshots().ReplaceOne(ctx, bson.M{"_id": snapshot.Seq}, snapshot, opts); err != nil {
+		return fmt.Errorf("storage.SaveSnapshot got error from ReplaceOne: %v", err)
+	}
+
+	return nil
+}
+
+func (s *MongoEventStore) LatestSnapshot(ctx context.Context) (Snapshot, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})
+
+	var snapshot Snapshot
+	if err := s.snapshots().FindOne(ctx, bson.M{}, opts).Decode(&snapshot); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Snapshot{}, nil
+		}
+		return Snapshot{}, fmt.Errorf("storage.LatestSnapshot got unexpected error on FindOne: %v", err)
+	}
+
+	return snapshot, nil
+}
+
+func (s *MongoEventStore) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
+}
+
+func (s *MongoEventStore) Close(ctx context.Context) error {
+	return s.db.Close(ctx)
+}
+
+// ClaimIdempotencyKey and the other idempotency methods keep the keys next to the todos collection, as the MongoDB
+// backend does
+func (s *MongoEventStore) ClaimIdempotencyKey(ctx context.Context, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	return s.db.ClaimIdempotencyKey(ctx, record)
+}
+
+func (s *MongoEventStore) CompleteIdempotencyKey(ctx context.Context, key string, response IdempotentResponse) error {
+	return s.db.CompleteIdempotencyKey(ctx, key, response)
+}
+
+func (s *MongoEventStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return s.db.ReleaseIdempotencyKey(ctx, key)
+}
+
+// PendingEvents returns the unpublished outbox events of the commits, in commit order
+func (s *MongoEventStore) PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetProjection(bson.M{"outbox": 1})
+
+	cursor, err := s.commits().Find(ctx, bson.M{"outbox_pending": true}, findOpts)
+	if err != nil {
+		return nil, fmt.Errorf("storage.PendingEvents failed to find a collection cursor: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var events []OutboxEvent
+	for (limit <= 0 || len(events) < limit) && cursor.Next(ctx) {
+		var commit mongoCommitOutbox
+		if err = cursor.Decode(&commit); err != nil {
+			return nil, fmt.Errorf("storage.PendingEvents: cursor failed to decode commit: %v", err)
+		}
+		for _, event := range commit.Outbox {
+			if event.PublishedAt.IsZero() && (limit <= 0 || len(events) < limit) {
+				events = append(events, event.OutboxEvent)
+			}
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, fmt.Errorf("storage.PendingEvents got cursor error: %v", err)
+	}
+
+	return events, nil
+}
+
+// MarkPublished stamps the events in their commits, then clears outbox_pending on the commits left with none to
+// publish
+func (s *MongoEventStore) MarkPublished(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	filter := bson.M{"outbox._id": bson.M{"$in": ids}}
+	update := bson.M{"$set": bson.M{"outbox.$[event].published_at": now()}}
+	updateOpts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"event._id": bson.M{"$in": ids}}},
+	})
+	if _, err := s.commits().UpdateMany(ctx, filter, update, updateOpts); err != nil {
+		return fmt.Errorf("storage.MarkPublished got error from UpdateMany: %v", err)
+	}
+
+	filter = bson.M{
+		"outbox._id":     bson.M{"$in": ids},
+		"outbox_pending": true,
+		"outbox":         bson.M{"$not": bson.M{"$elemMatch": bson.M{"published_at": bson.M{"$exists": false}}}},
+	}
+	update = bson.M{"$unset": bson.M{"outbox_pending": ""}}
+	if _, err := s.commits().UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("storage.MarkPublished got error from UpdateMany: %v", err)
+	}
+
+	return nil
+}
+
+func (s *MongoEventStore) commits() *mongo.Collection {
+	return commitsCollection(s.db.collection)
+}
+
+func (s *MongoEventStore) snapshots() *mongo.Collection {
+	return s.db.collection.Database().Collection(s.db.collection.Name() + "_snapshots")
+}
+
+// commitsCollection is where the event stream of the todos collection goes
+func commitsCollection(todos *mongo.Collection) *mongo.Collection {
+	return todos.Database().Collection(todos.Name() + "_events")
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var mongoComparisons = map[query.Op]string{
+	query.OpEqual:          "$eq",
+	query.OpLess:           "$lt",
+	query.OpLessOrEqual:    "$lte",
+	query.OpGreater:        "$gt",
+	query.OpGreaterOrEqual: "$gte",
+}
+
+// mongoFilter translates a query AST into a MongoDB filter document; a nil expr matches everything. User text only
+// ever ends up as a quoted regex or an $eq value, so a query can't inject operators.
+func mongoFilter(expr query.Expr) bson.M {
+	switch e := expr.(type) {
+	case nil:
+		return bson.M{}
+	case query.And:
+		return bson.M{"$and": mongoFilters(e.Exprs)}
+	case query.Or:
+		return bson.M{"$or": mongoFilters(e.Exprs)}
+	case query.Not:
+		// $not can't wrap a whole document, but $nor of one clause negates it
+		return bson.M{"$nor": bson.A{mongoFilter(e.Expr)}}
+	case query.Term:
+		return mongoTermFilter(e)
+	default:
+		return bson.M{"_id": bson.M{"$exists": false}}
+	}
+}
+
+// scoped restricts filter to the todos of the tenant in ctx. Every query on the todos collection goes through it, so
+// no operation can reach another tenant's todos.
+func scoped(ctx context.Context, filter bson.M) bson.M {
+	restricted := make(bson.M, len(filter)+1)
+	for key, value := range filter {
+		restricted[key] = value
+	}
+	restricted["tenant"] = tenant.ID(ctx)
+	return restricted
+}
+
+func mongoFilters(exprs []query.Expr) bson.A {
+	filters := bson.A{}
+	for _, e := range exprs {
+		filters = append(filters, mongoFilter(e))
+	}
+	return filters
+}
+
+func mongoTermFilter(t query.Term) bson.M {
+	switch t.Field {
+	case query.FieldText:
+		return bson.M{"$or": bson.A{
+			mongoTextFilter("name", t),
+			mongoTextFilter("description", t),
+		}}
+	case query.FieldName:
+		return mongoTextFilter("name", t)
+	case query.FieldDescription:
+		return mongoTextFilter("description", t)
+	case query.FieldID:
+		return bson.M{"id": bson.M{"$eq": t.Text}}
+	case query.FieldStatus:
+		return bson.M{"completed": bson.M{"$eq": t.Completed}}
+	case query.FieldCreated:
+		return bson.M{"created_at": bson.M{mongoComparisons[t.Op]: t.Time}}
+	case query.FieldUpdated:
+		return bson.M{"updated_at": bson.M{mongoComparisons[t.Op]: t.Time}}
+	default:
+		return bson.M{"_id": bson.M{"$exists": false}}
+	}
+}
+
+func mongoTextFilter(field string, t query.Term) bson.M {
+	if t.Op == query.OpEqual {
+		return bson.M{field: bson.M{"$eq": t.Text}}
+	}
+	return bson.M{field: primitive.Regex{Pattern: regexp.QuoteMeta(t.Text), Options: "i"}}
+}
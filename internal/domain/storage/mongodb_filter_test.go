@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMongoFilter(t *testing.T) {
+	testData := []struct {
+		testName       string
+		query          string
+		expectedResult bson.M
+	}{
+		{
+			testName:       "success: empty query matches everything",
+			query:          "",
+			expectedResult: bson.M{},
+		},
+		{
+			testName: "success: text searches name and description as a quoted regex",
+			query:    `"a.b*"`,
+			expectedResult: bson.M{"$or": bson.A{
+				bson.M{"name": primitive.Regex{Pattern: `a\.b\*`, Options: "i"}},
+				bson.M{"description": primitive.Regex{Pattern: `a\.b\*`, Options: "i"}},
+			}},
+		},
+		{
+			testName: "success: operators in values stay values",
+			query:    `name="$where"`,
+			expectedResult: bson.M{
+				"name": bson.M{"$eq": "$where"},
+			},
+		},
+		{
+			testName: "success: negated status and date range",
+			query:    `-status:done created<2026-11-01`,
+			expectedResult: bson.M{"$and": bson.A{
+				bson.M{"$nor": bson.A{bson.M{"completed": bson.M{"$eq": true}}}},
+				bson.M{"created_at": bson.M{"$lt": time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)}},
+			}},
+		},
+		{
+			testName: "success: or",
+			query:    `id=abc OR updated>=2026-10-19`,
+			expectedResult: bson.M{"$or": bson.A{
+				bson.M{"id": bson.M{"$eq": "abc"}},
+				bson.M{"updated_at": bson.M{"$gte": time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)}},
+			}},
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			expr, err := query.Parse(td.query)
+			if err != nil {
+				t.Fatalf("query.Parse got unexpected error: %v", err)
+			}
+
+			result := mongoFilter(expr)
+
+			if diff := cmp.Diff(td.expectedResult, result); diff != "" {
+				t.Errorf("mongoFilter expected vs actual results don't match: %v", diff)
+			}
+		})
+	}
+}
+
+func TestScoped(t *testing.T) {
+	ctx := tenant.WithID(context.Background(), "team-a")
+	filter := bson.M{"id": "abc", "tenant": "team-b"}
+
+	result := scoped(ctx, filter)
+
+	// a filter can't widen or move the scope, and the caller's filter is left alone
+	if diff := cmp.Diff(bson.M{"id": "abc", "tenant": "team-a"}, result); diff != "" {
+		t.Errorf("scoped expected vs actual results don't match: %v", diff)
+	}
+	if filter["tenant"] != "team-b" {
+		t.Errorf("scoped changed its argument: %v", filter)
+	}
+
+	if result = scoped(context.Background(), bson.M{}); result["tenant"] != tenant.Default {
+		t.Errorf("scoped expected the default tenant without one in ctx; got %v", result)
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// idempotencyCollection is where the idempotency keys for the todos collection go, named after it like the outbox
+func idempotencyCollection(todos *mongo.Collection) *mongo.Collection {
+	return todos.Database().Collection(todos.Name() + "_idempotency")
+}
+
+func (db *MongoDB) ClaimIdempotencyKey(ctx context.Context, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	keys := idempotencyCollection(db.collection)
+	record.Tenant = tenant.ID(ctx)
+
+	_, err := keys.InsertOne(ctx, record)
+	if err == nil {
+		return record, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return IdempotencyRecord{}, false, fmt.Errorf("storage.ClaimIdempotencyKey got error on insert: %v", err)
+	}
+
+	// the TTL monitor only runs every minute, so an expired record may still hold the key
+	expired := bson.M{"tenant": record.Tenant, "key": record.Key, "expires_at": bson.M{"$lte": now()}}
+	err = keys.FindOneAndReplace(ctx, expired, record).Err()
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return IdempotencyRecord{}, false, fmt.Errorf("storage.ClaimIdempotencyKey got error from FindOneAndReplace: %v", err)
+	}
+
+	var existing IdempotencyRecord
+	if err = keys.FindOne(ctx, bson.M{"tenant": record.Tenant, "key": record.Key}).Decode(&existing); err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("storage.ClaimIdempotencyKey got error on FindOne: %v", err)
+	}
+
+	return existing, false, nil
+}
+
+func (db *MongoDB) CompleteIdempotencyKey(ctx context.Context, key string, response IdempotentResponse) error {
+	filter := bson.M{"tenant": tenant.ID(ctx), "key": key}
+	result, err := idempotencyCollection(db.collection).UpdateOne(ctx, filter, bson.M{"$set": bson.M{"response": response}})
+	if err != nil {
+		return fmt.Errorf("storage.CompleteIdempotencyKey got error from UpdateOne: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (db *MongoDB) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	filter := bson.M{"tenant": tenant.ID(ctx), "key": key, "response": bson.M{"$exists": false}}
+	if _, err := idempotencyCollection(db.collection).DeleteOne(ctx, filter); err != nil {
+		return fmt.Errorf("storage.ReleaseIdempotencyKey got error from DeleteOne: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const outboxLeaseID = "outbox_relay"
+
+// errLeaseHeld is what takeLease fails with while another owner holds the lease
+var errLeaseHeld = errors.New("the lease is held by another owner")
+
+// takeLease takes or extends the lease document id in collection for owner until ttl from now. It fails with
+// errLeaseHeld if another owner holds a lease that hasn't expired, so a holder that died without releasing its lease
+// only blocks the others for ttl.
+func takeLease(ctx context.Context, collection *mongo.Collection, id, owner string, ttl time.Duration) error {
+	leaseTime := time.Now().UTC()
+
+	filter := bson.M{
+		"_id": id,
+		"$or": []bson.M{
+			{"owner": owner},
+			{"expires_at": bson.M{"$lt": leaseTime}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"owner":      owner,
+			"expires_at": leaseTime.Add(ttl),
+		},
+	}
+
+	// when another owner holds a live lease the filter matches nothing and the upsert collides with its _id
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return errLeaseHeld
+	}
+	return err
+}
+
+// releaseLease gives up owner's lease on id, if it still holds it
+func releaseLease(ctx context.Context, collection *mongo.Collection, id, owner string) error {
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": id, "owner": owner})
+	return err
+}
+
+// leasesCollection holds the leases of the instances sharing the todos collection
+func leasesCollection(todos *mongo.Collection) *mongo.Collection {
+	return todos.Database().Collection(todos.Name() + "_leases")
+}
+
+func (db *MongoDB) AcquireOutboxLease(ctx context.Context, owner string, ttl time.Duration) error {
+	err := takeLease(ctx, leasesCollection(db.collection), outboxLeaseID, owner, ttl)
+	if err == errLeaseHeld {
+		return ErrOutboxLeased
+	}
+	if err != nil {
+		return fmt.Errorf("storage.AcquireOutboxLease failed to take the lease: %v", err)
+	}
+	return nil
+}
+
+func (db *MongoDB) ReleaseOutboxLease(ctx context.Context, owner string) error {
+	if err := releaseLease(ctx, leasesCollection(db.collection), outboxLeaseID, owner); err != nil {
+		return fmt.Errorf("storage.ReleaseOutboxLease got error from DeleteOne: %v", err)
+	}
+	return nil
+}
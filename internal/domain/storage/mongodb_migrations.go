@@ -0,0 +1,504 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultMigrationsCollection = "schema_migrations"
+	migrationLockID             = "lock"
+	migrationLockTTL            = 5 * time.Minute
+	migrationLockPollInterval   = time.Second
+)
+
+// MongoMigration is one versioned, reversible change to the stored todo documents. Up and Down are given the todos
+// collection; migrations needing other collections can reach them through todos.Database().
+type MongoMigration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, todos *mongo.Collection) error
+	Down        func(ctx context.Context, todos *mongo.Collection) error
+}
+
+// mongoMigrations is the registry of todo schema migrations. Append new migrations with the next version number;
+// never renumber or remove a migration that has been released.
+var mongoMigrations = []MongoMigration{
+	{
+		Version:     1,
+		Description: "add unique indexes on todo id and name",
+		Up: func(ctx context.Context, todos *mongo.Collection) error {
+			_, err := todos.Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "id", Value: 1}},
+					Options: options.Index().SetName("id_unique").SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "name", Value: 1}},
+					Options: options.Index().SetName("name_unique").SetUnique(true),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, todos *mongo.Collection) error {
+			return dropIndexes(ctx, todos, "id_unique", "name_unique")
+		},
+	},
+	{
+		Version:     2,
+		Description: "backfill completed, created_at and updated_at",
+		Up: func(ctx context.Context, todos *mongo.Collection) error {
+			// todos that predate timestamps get the migration time, which is the best we know
+			migratedAt := now()
+			for field, value := range map[string]interface{}{
+				"completed":  false,
+				"created_at": migratedAt,
+				"updated_at": migratedAt,
+			} {
+				filter := bson.M{field: bson.M{"$exists": false}}
+				if _, err := todos.UpdateMany(ctx, filter, bson.M{"$set": bson.M{field: value}}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, todos *mongo.Collection) error {
+			unset := bson.M{"$unset": bson.M{"completed": "", "created_at": "", "updated_at": ""}}
+			_, err := todos.UpdateMany(ctx, bson.M{}, unset)
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "add text index on todo name and description",
+		Up: func(ctx context.Context, todos *mongo.Collection) error {
+			_, err := todos.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+				Options: options.Index().
+					SetName("todo_text").
+					SetDefaultLanguage("english").
+					SetWeights(bson.M{"name": nameSearchWeight, "description": descriptionSearchWeight}),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, todos *mongo.Collection) error {
+			return dropIndexes(ctx, todos, "todo_text")
+		},
+	},
+	{
+		Version:     4,
+		Description: "key todo documents by todo id",
+		Up: func(ctx context.Context, todos *mongo.Collection) error {
+			// _id can't be updated in place, so rewrite the collection; $out swaps the result in atomically and keeps
+			// the collection's indexes
+			pipeline := mongo.Pipeline{
+				{{Key: "$addFields", Value: bson.M{"_id": "$id"}}},
+				{{Key: "$out", Value: todos.Name()}},
+			}
+			cursor, err := todos.Aggregate(ctx, pipeline)
+			if err != nil {
+				return err
+			}
+			return cursor.Close(ctx)
+		},
+		Down: func(ctx context.Context, todos *mongo.Collection) error {
+			// nothing before this version reads _id, so the rewritten documents work as they are
+			return nil
+		},
+	},
+	{
+		Version:     5,
+		Description: "add todo event outbox",
+		Up: func(ctx context.Context, todos *mongo.Collection) error {
+			outbox := outboxCollection(todos)
+			// collections can't be created inside the transactions that append events, so create it up front
+			if err := todos.Database().CreateCollection(ctx, outbox.Name()); err != nil {
+				return err
+			}
+			_, err := outbox.Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "occurred_at", Value: 1}, {Key: "_id", Value: 1}},
+					Options: options.Index().SetName("pending").SetPartialFilterExpression(bson.M{"published_at": bson.M{"$exists": false}}),
+				},
+				{
+					Keys:    bson.D{{Key: "published_at", Value: 1}},
+					Options: options.Index().SetName("published_ttl").SetExpireAfterSeconds(outboxRetention),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, todos *mongo.Collection) error {
+			return outboxCollection(todos).Drop(ctx)
+		},
+	},
+	{
+		Version:     6,
+		Description: "partition todos by tenant",
+		Up: func(ctx context.Context, todos *mongo.Collection) error {
+			// todos that predate tenants belong to the default one
+			filter := bson.M{"tenant": bson.M{"$exists": false}}
+			if _, err := todos.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"tenant": tenant.Default}}); err != nil {
+				return err
+			}
+
+			// names only need to be unique within a tenant, and a text index can only be queried for one tenant
+			// if tenant prefixes it
+			if err := dropIndexes(ctx, todos, "name_unique", "todo_text"); err != nil {
+				return err
+			}
+			_, err := todos.Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "name", Value: 1}},
+					Options: options.Index().SetName("tenant_name_unique").SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "id", Value: 1}},
+					Options: options.Index().SetName("tenant_id"),
+				},
+				{
+					Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+					Options: options.Index().
+						SetName("todo_text").
+						SetDefaultLanguage("english").
+						SetWeights(bson.M{"name": nameSearchWeight, "description": descriptionSearchWeight}),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, todos *mongo.Collection) error {
+			// fails if two tenants have todos of the same name, which can't be merged back into one list
+			if err := dropIndexes(ctx, todos, "tenant_name_unique", "tenant_id", "todo_text"); err != nil {
+				return err
+			}
+			_, err := todos.Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "name", Value: 1}},
+					Options: options.Index().SetName("name_unique").SetUnique(true),
+				},
+				{
+					Keys: bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+					Options: options.Index().
+						SetName("todo_text").
+						SetDefaultLanguage("english").
+						SetWeights(bson.M{"name": nameSearchWeight, "description": descriptionSearchWeight}),
+				},
+			})
+			if err != nil {
+				return err
+			}
+			_, err = todos.UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"tenant": ""}})
+			return err
+		},
+	},
+	{
+		Version:     7,
+		Description: "backfill todo versions",
+		Up: func(ctx context.Context, todos *mongo.Collection) error {
+			_, err := todos.UpdateMany(ctx, bson.M{"version": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"version": 1}})
+			return err
+		},
+		Down: func(ctx context.Context, todos *mongo.Collection) error {
+			_, err := todos.UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"version": ""}})
+			return err
+		},
+	},
+	{
+		Version:     8,
+		Description: "add idempotency keys",
+		Up: func(ctx context.Context, todos *mongo.Collection) error {
+			_, err := idempotencyCollection(todos).Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "key", Value: 1}},
+					Options: options.Index().SetName("tenant_key_unique").SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetName("expires_ttl").SetExpireAfterSeconds(0),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, todos *mongo.Collection) error {
+			return idempotencyCollection(todos).Drop(ctx)
+		},
+	},
+	{
+		Version:     9,
+		Description: "search todos by their own stems instead of a text index",
+		Up: func(ctx context.Context, todos *mongo.Collection) error {
+			// MongoDB's text index stems and scores its own way, so it didn't find and rank what InMemoryDB did
+			cursor, err := todos.Find(ctx, bson.M{})
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+			for cursor.Next(ctx) {
+				var doc mongoTodo
+				if err = cursor.Decode(&doc); err != nil {
+					return err
+				}
+				update := bson.M{"$set": bson.M{"search_terms": searchTerms(doc.Todo)}}
+				if _, err = todos.UpdateOne(ctx, bson.M{"_id": doc.DocumentID}, update); err != nil {
+					return err
+				}
+			}
+			if err = cursor.Err(); err != nil {
+				return err
+			}
+
+			_, err = todos.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "search_terms", Value: 1}},
+				Options: options.Index().SetName("tenant_search_terms"),
+			})
+			if err != nil {
+				return err
+			}
+			return dropIndexes(ctx, todos, "todo_text")
+		},
+		Down: func(ctx context.Context, todos *mongo.Collection) error {
+			_, err := todos.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+				Options: options.Index().
+					SetName("todo_text").
+					SetDefaultLanguage("english").
+					SetWeights(bson.M{"name": nameSearchWeight, "description": descriptionSearchWeight}),
+			})
+			if err != nil {
+				return err
+			}
+			if err = dropIndexes(ctx, todos, "tenant_search_terms"); err != nil {
+				return err
+			}
+			_, err = todos.UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"search_terms": ""}})
+			return err
+		},
+	},
+	{
+		Version:     10,
+		Description: "expire outbox events no relay published",
+		Up: func(ctx context.Context, todos *mongo.Collection) error {
+			// every write appends an event, but with OUTBOX_SINK=none on every instance nothing ever publishes them
+			_, err := outboxCollection(todos).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "occurred_at", Value: 1}},
+				Options: options.Index().SetName("occurred_ttl").SetExpireAfterSeconds(unpublishedOutboxRetention),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, todos *mongo.Collection) error {
+			return dropIndexes(ctx, outboxCollection(todos), "occurred_ttl")
+		},
+	},
+	{
+		Version:     11,
+		Description: "index event commits with outbox events to publish",
+		Up: func(ctx context.Context, todos *mongo.Collection) error {
+			// the event-sourced backend stores the outbox events of a write in its commit; the relay looks them up here
+			_, err := commitsCollection(todos).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "outbox_pending", Value: 1}, {Key: "_id", Value: 1}},
+				Options: options.Index().SetName("outbox_pending").SetPartialFilterExpression(bson.M{"outbox_pending": true}),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, todos *mongo.Collection) error {
+			return dropIndexes(ctx, commitsCollection(todos), "outbox_pending")
+		},
+	},
+}
+
+type migrationRecord struct {
+	Version     int       `bson:"version"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// MigrationStatus lists every registered migration and whether it has been applied to the database
+func (db *MongoDB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range mongoMigrations {
+		statuses = append(statuses, MigrationStatus{
+			Version:     m.Version,
+			Description: m.Description,
+			Applied:     applied[m.Version],
+		})
+	}
+
+	return statuses, nil
+}
+
+// Migrate brings the todo schema to the target version (MigrateLatest for all registered migrations) and returns the
+// steps it took. With dryRun it only reports the steps. Only one process migrates at a time: others wait for the lock
+// until ctx expires, then re-plan against whatever the lock holder applied.
+func (db *MongoDB) Migrate(ctx context.Context, target int, dryRun bool) ([]MigrationStep, error) {
+	if dryRun {
+		return db.planMigrations(ctx, target)
+	}
+
+	owner, err := db.acquireMigrationLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.releaseMigrationLock(owner)
+
+	steps, err := db.planMigrations(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]MongoMigration, len(mongoMigrations))
+	for _, m := range mongoMigrations {
+		byVersion[m.Version] = m
+	}
+
+	for i, step := range steps {
+		if err := db.refreshMigrationLock(ctx, owner); err != nil {
+			return steps[:i], err
+		}
+
+		log.Printf("storage.Migrate applying %s", step)
+		if err := db.applyMigration(ctx, byVersion[step.Version], step.Down); err != nil {
+			return steps[:i], fmt.Errorf("storage.Migrate failed on %s: %v", step, err)
+		}
+	}
+
+	return steps, nil
+}
+
+func (db *MongoDB) applyMigration(ctx context.Context, m MongoMigration, down bool) error {
+	migrations := db.migrationsCollection()
+
+	if down {
+		if err := m.Down(ctx, db.collection); err != nil {
+			return err
+		}
+		_, err := migrations.DeleteOne(ctx, bson.M{"version": m.Version})
+		return err
+	}
+
+	if err := m.Up(ctx, db.collection); err != nil {
+		return err
+	}
+	_, err := migrations.InsertOne(ctx, migrationRecord{
+		Version:     m.Version,
+		Description: m.Description,
+		AppliedAt:   time.Now().UTC(),
+	})
+	return err
+}
+
+func (db *MongoDB) planMigrations(ctx context.Context, target int) ([]MigrationStep, error) {
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int
+	descriptions := make(map[int]string, len(mongoMigrations))
+	for _, m := range mongoMigrations {
+		versions = append(versions, m.Version)
+		descriptions[m.Version] = m.Description
+	}
+
+	versions, err = checkMigrationVersions(versions)
+	if err != nil {
+		return nil, err
+	}
+
+	return planMigrations(versions, descriptions, applied, target)
+}
+
+func (db *MongoDB) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	cursor, err := db.migrationsCollection().Find(ctx, bson.M{"version": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, fmt.Errorf("storage.appliedMigrations failed to find a collection cursor: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	applied := make(map[int]bool)
+	for cursor.Next(ctx) {
+		var record migrationRecord
+		if err = cursor.Decode(&record); err != nil {
+			return nil, fmt.Errorf("storage.appliedMigrations: cursor failed to decode migration record: %v", err)
+		}
+		applied[record.Version] = true
+	}
+
+	if err = cursor.Err(); err != nil {
+		return nil, fmt.Errorf("storage.appliedMigrations got cursor error: %v", err)
+	}
+
+	return applied, nil
+}
+
+// acquireMigrationLock takes the lock document, polling until it's free or ctx expires. A lock whose holder died
+// without releasing it expires after migrationLockTTL.
+func (db *MongoDB) acquireMigrationLock(ctx context.Context) (string, error) {
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%s/%d/%s", hostname, os.Getpid(), uuid.NewString())
+
+	for {
+		err := db.refreshMigrationLock(ctx, owner)
+		if err == nil {
+			return owner, nil
+		}
+		if err != ErrMigrationLocked {
+			return "", err
+		}
+
+		log.Printf("storage.Migrate waiting for another process to finish migrating")
+
+		select {
+		case <-ctx.Done():
+			return "", ErrMigrationLocked
+		case <-time.After(migrationLockPollInterval):
+		}
+	}
+}
+
+// refreshMigrationLock takes or extends the lock for owner; it fails with ErrMigrationLocked if someone else holds it
+func (db *MongoDB) refreshMigrationLock(ctx context.Context, owner string) error {
+	err := takeLease(ctx, db.migrationsCollection(), migrationLockID, owner, migrationLockTTL)
+	if err == errLeaseHeld {
+		return ErrMigrationLocked
+	}
+	if err != nil {
+		return fmt.Errorf("storage.Migrate failed to take migration lock: %v", err)
+	}
+
+	return nil
+}
+
+func (db *MongoDB) releaseMigrationLock(owner string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := releaseLease(ctx, db.migrationsCollection(), migrationLockID, owner); err != nil {
+		log.Printf("storage.Migrate failed to release migration lock, it will expire in %v: %v", migrationLockTTL, err)
+	}
+}
+
+func (db *MongoDB) migrationsCollection() *mongo.Collection {
+	return db.collection.Database().Collection(db.migrationsCollectionName)
+}
+
+func dropIndexes(ctx context.Context, collection *mongo.Collection, names ...string) error {
+	for _, name := range names {
+		if _, err := collection.Indexes().DropOne(ctx, name); err != nil {
+			return fmt.Errorf("failed to drop index %s: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// outboxRetention is how long published events are kept, for debugging, before MongoDB expires them
+	outboxRetention = 7 * 24 * 60 * 60
+	// unpublishedOutboxRetention is how long events are kept at all, so an outbox no relay reads doesn't grow
+	// without bound
+	unpublishedOutboxRetention = 30 * 24 * 60 * 60
+)
+
+// outboxCollection is where the events for the todos collection go. It's named after it, so several todo lists in
+// one database each get their own outbox.
+func outboxCollection(todos *mongo.Collection) *mongo.Collection {
+	return todos.Database().Collection(todos.Name() + "_outbox")
+}
+
+func (db *MongoDB) AppendEvents(ctx context.Context, events ...OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var docs []interface{}
+	for _, event := range newOutboxEvents(ctx, events) {
+		docs = append(docs, event)
+	}
+
+	if _, err := outboxCollection(db.collection).InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("storage.AppendEvents got error on insert: %v", err)
+	}
+
+	return nil
+}
+
+func (db *MongoDB) PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	findOpts := options.Find().SetSort(bson.D{{Key: "occurred_at", Value: 1}, {Key: "_id", Value: 1}})
+	if limit > 0 {
+		findOpts.SetLimit(int64(limit))
+	}
+
+	cursor, err := outboxCollection(db.collection).Find(ctx, bson.M{"published_at": bson.M{"$exists": false}}, findOpts)
+	if err != nil {
+		return nil, fmt.Errorf("storage.PendingEvents failed to find a collection cursor: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var events []OutboxEvent
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("storage.PendingEvents: cursor failed to decode events: %v", err)
+	}
+
+	return events, nil
+}
+
+// MarkPublished stamps the events, which the outbox's TTL index then expires after a week
+func (db *MongoDB) MarkPublished(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": ids}}
+	update := bson.M{"$set": bson.M{"published_at": now()}}
+	if _, err := outboxCollection(db.collection).UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("storage.MarkPublished got error from UpdateMany: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SearchTodos finds the tenant's todos posted under any of the query's stems in the tenant_search_terms index and
+// ranks them with rankHits, like InMemoryDB does, so both backends stem, match and score the same way. Every
+// candidate is read to be scored, which is fine for a todo list but would want a server-side score for big ones.
+func (db *MongoDB) SearchTodos(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	terms, err := opts.terms()
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := db.collection.Find(ctx, scoped(ctx, bson.M{"search_terms": bson.M{"$in": terms}}))
+	if err != nil {
+		return nil, fmt.Errorf("storage.SearchTodos failed to find a collection cursor: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var candidates []Todo
+	if err = cursor.All(ctx, &candidates); err != nil {
+		return nil, fmt.Errorf("storage.SearchTodos: cursor failed to decode todos: %v", err)
+	}
+
+	return rankHits(candidates, terms, opts.limit()), nil
+}
@@ -27,3 +27,26 @@ func TestMongoDB_DeleteTodo(t *testing.T) {
 func TestMongoDB_ClearTodoList(t *testing.T) {
 	//Todo
 }
+
+func TestSupportsTransactions(t *testing.T) {
+	keys := newTestKeyRing(t, "k1", "k1")
+	testData := []struct {
+		testName string
+		db       DB
+		expected bool
+	}{
+		{testName: "in memory", db: NewInMemoryDB(), expected: true},
+		{testName: "replica set", db: &MongoDB{transactions: true}, expected: true},
+		{testName: "standalone", db: &MongoDB{}, expected: false},
+		{testName: "cached standalone", db: NewCachedDB(&MongoDB{}, CacheConfig{Size: 1}), expected: false},
+		{testName: "encrypted replica set", db: NewEncryptedDB(&MongoDB{transactions: true}, keys), expected: true},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			if actual := SupportsTransactions(td.db); actual != td.expected {
+				t.Errorf("expected %v; got %v", td.expected, actual)
+			}
+		})
+	}
+}
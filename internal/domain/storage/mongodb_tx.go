@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// WithTransaction runs fn in a MongoDB multi-document transaction. The driver retries fn on transient transaction
+// errors and retries the commit when its outcome is unknown. Transactions need a replica set or sharded cluster; on a
+// standalone server fn runs directly against db, so its writes apply one by one.
+func (db *MongoDB) WithTransaction(ctx context.Context, fn func(tx DB) error) error {
+	if !db.transactions {
+		return fn(db)
+	}
+
+	session, err := db.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("storage.WithTransaction failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(&mongoTx{db: db, session: session})
+	})
+
+	return err
+}
+
+// SupportsTransactions reports whether WithTransaction is all-or-nothing, which it isn't on a standalone server
+func (db *MongoDB) SupportsTransactions() bool {
+	return db.transactions
+}
+
+// transaction runs fn with a ctx bound to a session transaction, or with ctx itself on a standalone server
+func (db *MongoDB) transaction(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	if !db.transactions {
+		return fn(ctx)
+	}
+
+	session, err := db.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("storage.%s failed to start session: %v", op, err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+
+	return err
+}
+
+// mongoTx is the DB handed to a transaction function. It binds every call to the transaction's session, whatever
+// context the caller passes in.
+type mongoTx struct {
+	db      *MongoDB
+	session mongo.Session
+}
+
+func (tx *mongoTx) bind(ctx context.Context) context.Context {
+	return mongo.NewSessionContext(ctx, tx.session)
+}
+
+func (tx *mongoTx) SaveTodo(ctx context.Context, name, description string) (Todo, error) {
+	return tx.db.SaveTodo(tx.bind(ctx), name, description)
+}
+
+func (tx *mongoTx) GetTodoList(ctx context.Context) ([]Todo, error) {
+	return tx.db.GetTodoList(tx.bind(ctx))
+}
+
+func (tx *mongoTx) ListTodos(ctx context.Context, opts ListOptions) (TodoPage, error) {
+	return tx.db.ListTodos(tx.bind(ctx), opts)
+}
+
+func (tx *mongoTx) SearchTodos(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	return tx.db.SearchTodos(tx.bind(ctx), opts)
+}
+
+func (tx *mongoTx) GetTodoByName(ctx context.Context, name string) (Todo, error) {
+	return tx.db.GetTodoByName(tx.bind(ctx), name)
+}
+
+func (tx *mongoTx) GetTodoByID(ctx context.Context, id string) (Todo, error) {
+	return tx.db.GetTodoByID(tx.bind(ctx), id)
+}
+
+func (tx *mongoTx) EditTodo(ctx context.Context, id string, todo Todo) (Todo, error) {
+	return tx.db.EditTodo(tx.bind(ctx), id, todo)
+}
+
+func (tx *mongoTx) DeleteTodo(ctx context.Context, id string) error {
+	return tx.db.DeleteTodo(tx.bind(ctx), id)
+}
+
+func (tx *mongoTx) DeleteTodos(ctx context.Context, filter query.Expr) ([]Todo, error) {
+	return tx.db.DeleteTodos(tx.bind(ctx), filter)
+}
+
+func (tx *mongoTx) ClearTodoList(ctx context.Context) error {
+	return tx.db.ClearTodoList(tx.bind(ctx))
+}
+
+func (tx *mongoTx) AppendEvents(ctx context.Context, events ...OutboxEvent) error {
+	return tx.db.AppendEvents(tx.bind(ctx), events...)
+}
+
+func (tx *mongoTx) PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	return tx.db.PendingEvents(tx.bind(ctx), limit)
+}
+
+func (tx *mongoTx) MarkPublished(ctx context.Context, ids ...string) error {
+	return tx.db.MarkPublished(tx.bind(ctx), ids...)
+}
+
+func (tx *mongoTx) BulkWrite(ctx context.Context, ops []BulkOp) ([]BulkResult, error) {
+	return tx.db.BulkWrite(tx.bind(ctx), ops)
+}
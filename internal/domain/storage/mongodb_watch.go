@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoChange is the part of a change stream event Watch needs
+type mongoChange struct {
+	ID struct {
+		Data string `bson:"_data"`
+	} `bson:"_id"`
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	FullDocument  *Todo               `bson:"fullDocument"`
+	DocumentKey   struct {
+		ID interface{} `bson:"_id"`
+	} `bson:"documentKey"`
+}
+
+// Watch follows a change stream, which needs a replica set or sharded cluster. It reports every tenant's writes. It
+// watches the database rather than the collection, since dropping the collection would end a collection stream.
+// Resuming works as far back as the oplog goes.
+func (db *MongoDB) Watch(ctx context.Context, opts WatchOptions) (<-chan ChangeEvent, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"ns.coll": db.collection.Name()}}},
+	}
+
+	streamOpts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if opts.ResumeAfter != "" {
+		streamOpts.SetResumeAfter(bson.M{"_data": opts.ResumeAfter})
+	}
+
+	stream, err := db.collection.Database().Watch(ctx, pipeline, streamOpts)
+	if err != nil {
+		return nil, fmt.Errorf("storage.Watch failed to open change stream: %v", err)
+	}
+
+	events := make(chan ChangeEvent)
+	go func() {
+		defer close(events)
+		defer stream.Close(context.Background())
+
+		for stream.Next(ctx) {
+			var change mongoChange
+			if err := stream.Decode(&change); err != nil {
+				log.Printf("storage.Watch failed to decode change event: %v", err)
+				return
+			}
+
+			event, ok := change.event()
+			if !ok {
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			log.Printf("storage.Watch change stream failed: %v", err)
+		}
+	}()
+
+	return events, nil
+}
+
+// event translates a change stream event, reporting false for operations that aren't todo writes
+func (c mongoChange) event() (ChangeEvent, bool) {
+	event := ChangeEvent{
+		Time:        time.Unix(int64(c.ClusterTime.T), 0).UTC(),
+		ResumeToken: c.ID.Data,
+	}
+
+	switch c.OperationType {
+	case "insert":
+		event.Type = ChangeCreated
+	case "update", "replace":
+		event.Type = ChangeUpdated
+	case "delete":
+		event.Type = ChangeDeleted
+		// documents are keyed by todo ID since migration 4
+		id, ok := c.DocumentKey.ID.(string)
+		if !ok {
+			return ChangeEvent{}, false
+		}
+		event.TodoID = id
+		return event, true
+	case "drop":
+		event.Type = ChangeCleared
+		return event, true
+	default:
+		return ChangeEvent{}, false
+	}
+
+	// an update's document is looked up after the fact, so it's gone if the todo was deleted in the meantime
+	if c.FullDocument == nil {
+		return ChangeEvent{}, false
+	}
+	event.Tenant = c.FullDocument.Tenant
+	event.TodoID = c.FullDocument.ID
+	event.Todo = *c.FullDocument
+
+	return event, true
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMongoChange_Event(t *testing.T) {
+	clusterTime := primitive.Timestamp{T: uint32(time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC).Unix())}
+
+	testData := []struct {
+		testName       string
+		change         bson.M
+		expectedResult ChangeEvent
+		expectedOK     bool
+	}{
+		{
+			testName: "success: insert",
+			change: bson.M{
+				"_id":           bson.M{"_data": "8263"},
+				"operationType": "insert",
+				"clusterTime":   clusterTime,
+				"fullDocument":  bson.M{"_id": "abc", "id": "abc", "tenant": "team-a", "name": "shopping"},
+				"documentKey":   bson.M{"_id": "abc"},
+			},
+			expectedResult: ChangeEvent{
+				Type:        ChangeCreated,
+				Tenant:      "team-a",
+				TodoID:      "abc",
+				Todo:        Todo{ID: "abc", Tenant: "team-a", Name: "shopping"},
+				Time:        time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC),
+				ResumeToken: "8263",
+			},
+			expectedOK: true,
+		},
+		{
+			testName: "success: delete",
+			change: bson.M{
+				"_id":           bson.M{"_data": "8264"},
+				"operationType": "delete",
+				"clusterTime":   clusterTime,
+				"documentKey":   bson.M{"_id": "abc"},
+			},
+			expectedResult: ChangeEvent{
+				Type:        ChangeDeleted,
+				TodoID:      "abc",
+				Time:        time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC),
+				ResumeToken: "8264",
+			},
+			expectedOK: true,
+		},
+		{
+			testName: "success: update of a todo deleted since",
+			change: bson.M{
+				"_id":           bson.M{"_data": "8265"},
+				"operationType": "update",
+				"clusterTime":   clusterTime,
+				"documentKey":   bson.M{"_id": "abc"},
+			},
+		},
+		{
+			testName: "success: delete of a document not keyed by todo ID",
+			change: bson.M{
+				"_id":           bson.M{"_data": "8266"},
+				"operationType": "delete",
+				"clusterTime":   clusterTime,
+				"documentKey":   bson.M{"_id": primitive.NewObjectID()},
+			},
+		},
+		{
+			testName: "success: other operations are skipped",
+			change: bson.M{
+				"_id":           bson.M{"_data": "8267"},
+				"operationType": "rename",
+				"clusterTime":   clusterTime,
+			},
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			data, err := bson.Marshal(td.change)
+			if err != nil {
+				t.Fatalf("bson.Marshal got unexpected error: %v", err)
+			}
+
+			var change mongoChange
+			if err = bson.Unmarshal(data, &change); err != nil {
+				t.Fatalf("bson.Unmarshal got unexpected error: %v", err)
+			}
+
+			result, ok := change.event()
+
+			if ok != td.expectedOK {
+				t.Fatalf("event expected ok %v; got %v", td.expectedOK, ok)
+			}
+
+			if diff := cmp.Diff(td.expectedResult, result); diff != "" {
+				t.Errorf("event expected vs actual results don't match: %v", diff)
+			}
+		})
+	}
+}
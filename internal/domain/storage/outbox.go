@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+// ErrNoOutbox is returned by DBs that can't store events, rather than dropping them
+var ErrNoOutbox = errors.New("the backend has no outbox to store events in")
+
+var ErrOutboxLeased = errors.New("another instance holds the outbox relay lease")
+
+// OutboxEvent is a domain event waiting in the outbox to be relayed. ID is unique per event, so consumers can drop
+// the duplicates at-least-once delivery brings.
+type OutboxEvent struct {
+	ID         string    `bson:"_id"`
+	Type       string    `bson:"type"`
+	Tenant     string    `bson:"tenant"`
+	TodoID     string    `bson:"todo_id,omitempty"`
+	Todo       Todo      `bson:"todo"`
+	OccurredAt time.Time `bson:"occurred_at"`
+}
+
+// Outbox is implemented by DB backends that can store events with the writes they describe. Events appended through
+// a transaction's tx are committed or rolled back with it, so there's an event for every write and none for writes
+// that didn't happen.
+type Outbox interface {
+	// AppendEvents stores events, filling in ID and OccurredAt when they're empty and Tenant from ctx
+	AppendEvents(ctx context.Context, events ...OutboxEvent) error
+	// PendingEvents returns up to limit events not yet marked published, oldest first
+	PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error)
+	MarkPublished(ctx context.Context, ids ...string) error
+}
+
+// OutboxLease is implemented by outboxes several instances can relay from. Only the instance holding the lease relays,
+// so the others neither deliver every event again nor race it to mark them published.
+type OutboxLease interface {
+	// AcquireOutboxLease takes or extends the lease for owner until ttl from now. It fails with ErrOutboxLeased if
+	// another owner holds it; a lease its owner didn't release expires after ttl.
+	AcquireOutboxLease(ctx context.Context, owner string, ttl time.Duration) error
+	ReleaseOutboxLease(ctx context.Context, owner string) error
+}
+
+// newOutboxEvents fills in the IDs, times and tenants of events about to be appended
+func newOutboxEvents(ctx context.Context, events []OutboxEvent) []OutboxEvent {
+	occurredAt := now()
+
+	filled := make([]OutboxEvent, len(events))
+	for i, event := range events {
+		if event.ID == "" {
+			event.ID = createID()
+		}
+		if event.Tenant == "" {
+			event.Tenant = tenant.ID(ctx)
+		}
+		if event.OccurredAt.IsZero() {
+			event.OccurredAt = occurredAt
+		}
+		filled[i] = event
+	}
+
+	return filled
+}
+
+// pendingEvents is PendingEvents for backends that keep their unpublished events in a slice
+func pendingEvents(outbox []OutboxEvent, limit int) []OutboxEvent {
+	if limit > 0 && len(outbox) > limit {
+		outbox = outbox[:limit]
+	}
+	return append([]OutboxEvent(nil), outbox...)
+}
+
+// unpublishedEvents drops the events with the given IDs: nothing needs them once they're published
+func unpublishedEvents(outbox []OutboxEvent, ids []string) []OutboxEvent {
+	published := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		published[id] = true
+	}
+
+	var pending []OutboxEvent
+	for _, event := range outbox {
+		if !published[event.ID] {
+			pending = append(pending, event)
+		}
+	}
+	return pending
+}
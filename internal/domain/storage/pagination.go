@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
+)
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions selects one page of todos. Todos are ordered by ID, which never changes, so a cursor stays valid
+// however the list is edited between requests.
+type ListOptions struct {
+	// Filter restricts the list to matching todos; nil matches every todo
+	Filter query.Expr
+
+	// Limit is the maximum page size; zero means DefaultPageLimit and values above MaxPageLimit are capped
+	Limit int
+	// Cursor is the NextCursor of the previous page, or empty for the first page
+	Cursor string
+}
+
+// TodoPage is one page of todos; NextCursor is empty on the last page
+type TodoPage struct {
+	Todos      []Todo
+	NextCursor string
+}
+
+type cursor struct {
+	AfterID string `json:"a"`
+}
+
+func (o ListOptions) limit() int {
+	switch {
+	case o.Limit <= 0:
+		return DefaultPageLimit
+	case o.Limit > MaxPageLimit:
+		return MaxPageLimit
+	default:
+		return o.Limit
+	}
+}
+
+// afterID decodes the cursor into the ID the page starts after
+func (o ListOptions) afterID() (string, error) {
+	if o.Cursor == "" {
+		return "", nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(o.Cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+
+	var c cursor
+	if err = json.Unmarshal(data, &c); err != nil || c.AfterID == "" {
+		return "", ErrInvalidCursor
+	}
+
+	return c.AfterID, nil
+}
+
+// newPage trims todos, fetched as limit+1 items sorted by ID, to a page and sets its cursor when there are more
+func newPage(todos []Todo, limit int) TodoPage {
+	if len(todos) <= limit {
+		return TodoPage{Todos: todos}
+	}
+
+	todos = todos[:limit]
+	data, _ := json.Marshal(cursor{AfterID: todos[limit-1].ID})
+
+	return TodoPage{
+		Todos:      todos,
+		NextCursor: base64.RawURLEncoding.EncodeToString(data),
+	}
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/textsearch"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+
+	// a word in the name counts for more than the same word in the description
+	nameSearchWeight        = 3
+	descriptionSearchWeight = 1
+)
+
+var ErrEmptySearch = errors.New("search query has no searchable words")
+
+// SearchOptions is a full-text search: todos whose name or description contains any of the words of Query, however
+// inflected, best matches first. Stop words like "the" are ignored, so a query of only stop words is ErrEmptySearch.
+type SearchOptions struct {
+	Query string
+
+	// Limit is the maximum number of hits; zero means DefaultSearchLimit and values above MaxSearchLimit are capped
+	Limit int
+}
+
+// SearchHit is a todo found by a search. Scores only rank hits within one search.
+type SearchHit struct {
+	Todo  Todo
+	Score float64
+}
+
+func (o SearchOptions) limit() int {
+	switch {
+	case o.Limit <= 0:
+		return DefaultSearchLimit
+	case o.Limit > MaxSearchLimit:
+		return MaxSearchLimit
+	default:
+		return o.Limit
+	}
+}
+
+// terms are the stems the search looks up
+func (o SearchOptions) terms() ([]string, error) {
+	terms := textsearch.Terms(o.Query)
+	if len(terms) == 0 {
+		return nil, ErrEmptySearch
+	}
+	return terms, nil
+}
+
+// searchWeights are what a todo is found by: the stems of its name and description, each weighted by where and how
+// often it occurs. Every backend searches and scores with these, so they find and rank the same todos.
+func searchWeights(todo Todo) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, term := range textsearch.Terms(todo.Name) {
+		weights[term] += nameSearchWeight
+	}
+	for _, term := range textsearch.Terms(todo.Description) {
+		weights[term] += descriptionSearchWeight
+	}
+	return weights
+}
+
+// searchTerms are the stems of searchWeights, sorted
+func searchTerms(todo Todo) []string {
+	terms := make([]string, 0)
+	for term := range searchWeights(todo) {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+// rankHits scores each todo by the summed weights of the distinct terms it contains, drops the ones containing none,
+// and returns the best limit of them, best first. Ties go to the lower ID.
+func rankHits(todos []Todo, terms []string, limit int) []SearchHit {
+	hits := make([]SearchHit, 0, len(todos))
+	for _, todo := range todos {
+		weights := searchWeights(todo)
+		score, seen := 0.0, make(map[string]bool, len(terms))
+		for _, term := range terms {
+			if !seen[term] {
+				seen[term] = true
+				score += weights[term]
+			}
+		}
+		if score > 0 {
+			hits = append(hits, SearchHit{Todo: todo, Score: score})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Todo.ID < hits[j].Todo.ID
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestSearch_Backends runs the same searches through InMemoryDB and through what MongoDB does with the documents it
+// stores: look candidates up by their search_terms, then rank them
+func TestSearch_Backends(t *testing.T) {
+	ctx := context.Background()
+
+	todos := []Todo{
+		{Name: "shopping", Description: "get milk and eggs"},
+		{Name: "wash car", Description: "before the shops close"},
+		{Name: "milk run", Description: "semi-skimmed milk"},
+		{Name: "dentist", Description: "check-up"},
+		{Name: "boxes", Description: "pack the boxes for moving"},
+		{Name: "call mum", Description: "she called yesterday"},
+	}
+
+	memory := NewInMemoryDB()
+	var docs []mongoTodo
+	for _, todo := range todos {
+		saved, err := memory.SaveTodo(ctx, todo.Name, todo.Description)
+		if err != nil {
+			t.Fatalf("SaveTodo got unexpected error: %+v", err)
+		}
+		docs = append(docs, newMongoTodo(saved))
+	}
+
+	mongoSearch := func(opts SearchOptions) ([]SearchHit, error) {
+		terms, err := opts.terms()
+		if err != nil {
+			return nil, err
+		}
+		var candidates []Todo
+		for _, doc := range docs {
+			if containsAny(doc.SearchTerms, terms) {
+				candidates = append(candidates, doc.Todo)
+			}
+		}
+		return rankHits(candidates, terms, opts.limit()), nil
+	}
+
+	testData := []struct {
+		query    string
+		limit    int
+		expected []string
+	}{
+		{query: "milk", expected: []string{"milk run", "shopping"}},
+		{query: "shops", expected: []string{"shopping", "wash car"}},
+		{query: "box", expected: []string{"boxes"}},
+		{query: "calling", expected: []string{"call mum"}},
+		{query: "milk car", limit: 2, expected: []string{"milk run", "wash car"}},
+		{query: "the milk milk", limit: 1, expected: []string{"milk run"}},
+		{query: "holiday"},
+	}
+
+	for _, td := range testData {
+		t.Run(td.query, func(t *testing.T) {
+			opts := SearchOptions{Query: td.query, Limit: td.limit}
+			for name, search := range map[string]func(SearchOptions) ([]SearchHit, error){
+				"InMemoryDB": func(opts SearchOptions) ([]SearchHit, error) { return memory.SearchTodos(ctx, opts) },
+				"MongoDB":    mongoSearch,
+			} {
+				hits, err := search(opts)
+				if err != nil {
+					t.Fatalf("%s search got unexpected error: %+v", name, err)
+				}
+				var names []string
+				for _, hit := range hits {
+					names = append(names, hit.Todo.Name)
+				}
+				if diff := cmp.Diff(td.expected, names); diff != "" {
+					t.Errorf("%s search expected vs actual results don't match: %v", name, diff)
+				}
+			}
+		})
+	}
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
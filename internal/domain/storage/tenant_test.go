@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/tenant"
+)
+
+// TestTenantIsolation runs every DB operation as one tenant against another tenant's todos
+func TestTenantIsolation(t *testing.T) {
+	testData := []struct {
+		testName string
+		newDB    func(t *testing.T) DB
+	}{
+		{
+			testName: "in-memory",
+			newDB:    func(t *testing.T) DB { return NewInMemoryDB() },
+		},
+		{
+			testName: "in-memory without indexes",
+			newDB:    func(t *testing.T) DB { return &InMemoryDB{} },
+		},
+		{
+			testName: "event-sourced",
+			newDB: func(t *testing.T) DB {
+				return newTestEventSourcedDB(t, NewInMemoryEventStore(), EventSourcedConfig{})
+			},
+		},
+		{
+			testName: "cached",
+			newDB:    func(t *testing.T) DB { return NewCachedDB(NewInMemoryDB(), CacheConfig{}) },
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			db := td.newDB(t)
+			teamA := tenant.WithID(context.Background(), "team-a")
+			teamB := tenant.WithID(context.Background(), "team-b")
+
+			secret, err := db.SaveTodo(teamA, "shopping", "get milk")
+			if err != nil {
+				t.Fatalf("SaveTodo got unexpected error: %+v", err)
+			}
+			if secret.Tenant != "team-a" {
+				t.Errorf("SaveTodo expected tenant team-a; got %q", secret.Tenant)
+			}
+
+			// warm any cache with team-b's view before team-b writes
+			if list, _ := db.GetTodoList(teamB); len(list) != 0 {
+				t.Errorf("GetTodoList leaked another tenant's todos: %+v", list)
+			}
+
+			// names are only unique within a tenant
+			own, err := db.SaveTodo(teamB, "shopping", "get bread")
+			if err != nil {
+				t.Fatalf("SaveTodo of a name another tenant uses got unexpected error: %+v", err)
+			}
+
+			if list, _ := db.GetTodoList(teamB); !cmp.Equal([]Todo{own}, list) {
+				t.Errorf("GetTodoList expected only the tenant's own todo; got %+v", list)
+			}
+			if page, _ := db.ListTodos(teamB, ListOptions{}); !cmp.Equal([]Todo{own}, page.Todos) {
+				t.Errorf("ListTodos expected only the tenant's own todo; got %+v", page.Todos)
+			}
+			if hits, _ := db.SearchTodos(teamB, SearchOptions{Query: "milk"}); len(hits) != 0 {
+				t.Errorf("SearchTodos leaked another tenant's todos: %+v", hits)
+			}
+			if found, _ := db.GetTodoByName(teamB, "shopping"); found.ID != own.ID {
+				t.Errorf("GetTodoByName expected the tenant's own todo; got %+v", found)
+			}
+
+			if _, err = db.EditTodo(teamB, secret.ID, Todo{Name: "hijacked"}); err != ErrNotFound {
+				t.Errorf("EditTodo of another tenant's todo expected error %v; got %v", ErrNotFound, err)
+			}
+			if err = db.DeleteTodo(teamB, secret.ID); err != ErrNotFound {
+				t.Errorf("DeleteTodo of another tenant's todo expected error %v; got %v", ErrNotFound, err)
+			}
+			if err = db.ClearTodoList(teamB); err != nil {
+				t.Fatalf("ClearTodoList got unexpected error: %+v", err)
+			}
+
+			// team-a's todo came through all of that untouched
+			if list, _ := db.GetTodoList(teamA); !cmp.Equal([]Todo{secret}, list) {
+				t.Errorf("GetTodoList expected the tenant's todo untouched; got %+v", list)
+			}
+			if hits, _ := db.SearchTodos(teamA, SearchOptions{Query: "milk"}); len(hits) != 1 {
+				t.Errorf("SearchTodos expected the tenant's todo; got %+v", hits)
+			}
+			if list, _ := db.GetTodoList(context.Background()); len(list) != 0 {
+				t.Errorf("GetTodoList of the default tenant leaked other tenants' todos: %+v", list)
+			}
+		})
+	}
+}
+
+func TestTenantIsolation_Events(t *testing.T) {
+	ctx := context.Background()
+	teamA := tenant.WithID(ctx, "team-a")
+
+	db := NewInMemoryDB()
+	events, err := db.Watch(ctx, WatchOptions{})
+	if err != nil {
+		t.Fatalf("Watch got unexpected error: %+v", err)
+	}
+
+	err = db.WithTransaction(teamA, func(tx DB) error {
+		todo, err := tx.SaveTodo(teamA, "shopping", "")
+		if err != nil {
+			return err
+		}
+		return tx.(Outbox).AppendEvents(teamA, OutboxEvent{Type: "todo.created", TodoID: todo.ID, Todo: todo})
+	})
+	if err != nil {
+		t.Fatalf("WithTransaction got unexpected error: %+v", err)
+	}
+
+	select {
+	case event := <-events:
+		if event.Tenant != "team-a" {
+			t.Errorf("Watch expected an event for team-a; got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the event")
+	}
+
+	pending, _ := db.PendingEvents(ctx, 0)
+	if len(pending) != 1 || pending[0].Tenant != "team-a" {
+		t.Errorf("PendingEvents expected an event for team-a; got %+v", pending)
+	}
+}
+
+func TestReplayEvents_Tenants(t *testing.T) {
+	store := NewInMemoryEventStore()
+	es := newTestEventSourcedDB(t, store, EventSourcedConfig{SnapshotEvery: 1})
+
+	for _, id := range []string{"team-b", "team-a"} {
+		if _, err := es.SaveTodo(tenant.WithID(context.Background(), id), "shopping", ""); err != nil {
+			t.Fatalf("SaveTodo got unexpected error: %+v", err)
+		}
+	}
+
+	todos, _, err := ReplayEvents(context.Background(), store)
+	if err != nil {
+		t.Fatalf("ReplayEvents got unexpected error: %+v", err)
+	}
+
+	var tenants []string
+	for _, todo := range todos {
+		tenants = append(tenants, todo.Tenant)
+	}
+	if diff := cmp.Diff([]string{"team-a", "team-b"}, tenants); diff != "" {
+		t.Errorf("ReplayEvents expected every tenant's todos: %v", diff)
+	}
+
+	// a restart from the snapshot keeps the todos apart too
+	restarted := newTestEventSourcedDB(t, store, EventSourcedConfig{})
+	if list, _ := restarted.GetTodoList(tenant.WithID(context.Background(), "team-a")); len(list) != 1 {
+		t.Errorf("GetTodoList after a restart expected one todo; got %+v", list)
+	}
+}
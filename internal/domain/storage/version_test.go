@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+// TestVersions checks that every backend counts a todo's writes and only applies an edit that expects a version if
+// the todo is still at it
+func TestVersions(t *testing.T) {
+	testData := []struct {
+		testName string
+		newDB    func(t *testing.T) DB
+	}{
+		{
+			testName: "in-memory",
+			newDB:    func(t *testing.T) DB { return NewInMemoryDB() },
+		},
+		{
+			testName: "event-sourced",
+			newDB: func(t *testing.T) DB {
+				return newTestEventSourcedDB(t, NewInMemoryEventStore(), EventSourcedConfig{})
+			},
+		},
+		{
+			testName: "cached",
+			newDB:    func(t *testing.T) DB { return NewCachedDB(NewInMemoryDB(), CacheConfig{}) },
+		},
+		{
+			testName: "encrypted",
+			newDB:    func(t *testing.T) DB { return NewEncryptedDB(NewInMemoryDB(), newTestKeyRing(t, "k1", "k1")) },
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			ctx := context.Background()
+			db := td.newDB(t)
+
+			created, err := db.SaveTodo(ctx, "shopping", "get milk")
+			if err != nil {
+				t.Fatalf("SaveTodo got unexpected error: %+v", err)
+			}
+			if created.Version != 1 {
+				t.Errorf("SaveTodo expected version 1; got %d", created.Version)
+			}
+
+			// an edit that doesn't expect a version always applies
+			edited, err := db.EditTodo(ctx, created.ID, Todo{Name: "groceries", Description: "get milk"})
+			if err != nil {
+				t.Fatalf("EditTodo got unexpected error: %+v", err)
+			}
+			if edited.Version != 2 {
+				t.Errorf("EditTodo expected version 2; got %d", edited.Version)
+			}
+
+			// an edit of several fields is still one write
+			edited, err = db.EditTodo(ctx, created.ID, Todo{Name: "shopping", Completed: true, Version: 2})
+			if err != nil {
+				t.Fatalf("EditTodo got unexpected error: %+v", err)
+			}
+			if edited.Version != 3 {
+				t.Errorf("EditTodo expected version 3; got %d", edited.Version)
+			}
+
+			if _, err = db.EditTodo(ctx, created.ID, Todo{Name: "stale", Version: 2}); err != ErrVersionMismatch {
+				t.Errorf("EditTodo expected error %v; got %v", ErrVersionMismatch, err)
+			}
+			if _, err = db.EditTodo(ctx, "missing", Todo{Name: "stale", Version: 2}); err != ErrNotFound {
+				t.Errorf("EditTodo expected error %v; got %v", ErrNotFound, err)
+			}
+
+			stored, err := db.GetTodoByID(ctx, created.ID)
+			if err != nil {
+				t.Fatalf("GetTodoByID got unexpected error: %+v", err)
+			}
+			if stored.Name != "shopping" || stored.Version != 3 {
+				t.Errorf("expected the rejected edit to change nothing; got %+v", stored)
+			}
+		})
+	}
+}
+
+func TestVersions_Replay(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryEventStore()
+	es := newTestEventSourcedDB(t, store, EventSourcedConfig{})
+
+	created, _ := es.SaveTodo(ctx, "shopping", "")
+	_, _ = es.EditTodo(ctx, created.ID, Todo{Name: "groceries", Description: "get milk", Completed: true})
+
+	// legacy events have no version and count as one write each
+	err := store.Append(ctx, Commit{Seq: 3, Events: []TodoEvent{
+		{Type: TodoCreated, Tenant: "team-a", TodoID: "legacy", Name: "wash car"},
+		{Type: TodoRenamed, Tenant: "team-a", TodoID: "legacy", Name: "wash bike"},
+		{Type: TodoCompleted, Tenant: "team-a", TodoID: "legacy"},
+	}})
+	if err != nil {
+		t.Fatalf("Append got unexpected error: %+v", err)
+	}
+
+	todos, _, err := ReplayEvents(ctx, store)
+	if err != nil {
+		t.Fatalf("ReplayEvents got unexpected error: %+v", err)
+	}
+
+	versions := map[string]int64{}
+	for _, todo := range todos {
+		versions[todo.ID] = todo.Version
+	}
+	if versions[created.ID] != 2 || versions["legacy"] != 3 {
+		t.Errorf("expected versions 2 and 3; got %v", versions)
+	}
+}
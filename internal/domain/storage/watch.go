@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ChangeType is the kind of write a ChangeEvent reports
+type ChangeType string
+
+const (
+	ChangeCreated ChangeType = "created"
+	ChangeUpdated ChangeType = "updated"
+	ChangeDeleted ChangeType = "deleted"
+	// ChangeCleared reports that a tenant's whole list was cleared; it has no TodoID
+	ChangeCleared ChangeType = "cleared"
+)
+
+var ErrInvalidResumeToken = errors.New("invalid or expired resume token")
+
+// ChangeEvent is one write to the todo list
+type ChangeEvent struct {
+	Type ChangeType
+	// Tenant is the tenant whose list was written to. MongoDB's delete events don't say, but TodoID is unique across
+	// tenants anyway; a cleared event without a Tenant means the whole collection was dropped.
+	Tenant string
+	TodoID string
+	// Todo is the todo as it was after the write, for created and updated events
+	Todo Todo
+	Time time.Time
+	// ResumeToken can be passed as WatchOptions.ResumeAfter to pick the feed up again right after this event
+	ResumeToken string
+}
+
+// WatchOptions says where a change feed starts
+type WatchOptions struct {
+	// ResumeAfter is the ResumeToken of the last event a previous watch saw; empty means only new writes are reported
+	ResumeAfter string
+}
+
+// Watcher is implemented by DB backends that can push every write to interested subsystems as it happens. Events
+// come in the order the writes were made, and writes inside a transaction are only reported once it commits. The
+// channel is closed when ctx is done, or when the feed breaks or the watcher falls too far behind, in which case a
+// new watch can resume from the last event received.
+type Watcher interface {
+	Watch(ctx context.Context, opts WatchOptions) (<-chan ChangeEvent, error)
+}
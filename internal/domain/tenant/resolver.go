@@ -0,0 +1,100 @@
+package tenant
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrMissing means the request doesn't name a tenant
+	ErrMissing = errors.New("request doesn't name a tenant")
+	// ErrInvalid means the request names a tenant in a way that can't be accepted
+	ErrInvalid = errors.New("invalid tenant")
+	// ErrUnauthenticated means the request's token couldn't be verified
+	ErrUnauthenticated = errors.New("invalid or missing tenant token")
+)
+
+// Resolver works out which tenant a request acts for
+type Resolver interface {
+	Resolve(r *http.Request) (string, error)
+}
+
+// StaticResolver puts every request in the same tenant, for single-tenant deployments
+type StaticResolver string
+
+func (s StaticResolver) Resolve(r *http.Request) (string, error) {
+	return string(s), nil
+}
+
+// HeaderResolver takes the tenant from a request header. Clients can claim any tenant this way, so it's only for
+// deployments where a trusted proxy sets the header.
+type HeaderResolver struct {
+	Header string
+}
+
+func (h HeaderResolver) Resolve(r *http.Request) (string, error) {
+	value := strings.TrimSpace(r.Header.Get(h.Header))
+	if value == "" {
+		return "", fmt.Errorf("%w: missing %s header", ErrMissing, h.Header)
+	}
+	return checkID(strings.ToLower(value))
+}
+
+// SubdomainResolver takes the tenant from the label in front of BaseDomain, so team-a.todo.example.com is tenant
+// team-a when BaseDomain is todo.example.com
+type SubdomainResolver struct {
+	BaseDomain string
+}
+
+func (s SubdomainResolver) Resolve(r *http.Request) (string, error) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	suffix := "." + strings.ToLower(strings.Trim(s.BaseDomain, "."))
+	if !strings.HasSuffix(host, suffix) {
+		return "", fmt.Errorf("%w: host %s isn't a subdomain of %s", ErrMissing, host, s.BaseDomain)
+	}
+
+	label := strings.TrimSuffix(host, suffix)
+	if strings.Contains(label, ".") {
+		return "", fmt.Errorf("%w: host %s has more than one label in front of %s", ErrInvalid, host, s.BaseDomain)
+	}
+	return checkID(label)
+}
+
+// TokenResolver takes the tenant from a claim of the HS256 JWT in the Authorization: Bearer header
+type TokenResolver struct {
+	Secret []byte
+	Claim  string
+}
+
+func (t TokenResolver) Resolve(r *http.Request) (string, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", fmt.Errorf("%w: missing bearer token", ErrUnauthenticated)
+	}
+
+	claims, err := verifyHS256(strings.TrimPrefix(auth, "Bearer "), t.Secret)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	id, ok := claims[t.Claim].(string)
+	if !ok || id == "" {
+		return "", fmt.Errorf("%w: token has no %q claim", ErrUnauthenticated, t.Claim)
+	}
+	return checkID(id)
+}
+
+func checkID(id string) (string, error) {
+	if !Valid(id) {
+		return "", fmt.Errorf("%w: %q; want up to 63 lower-case letters, digits and hyphens", ErrInvalid, id)
+	}
+	return id, nil
+}
@@ -0,0 +1,157 @@
+package tenant
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sign builds a compact JWT with the given header and claims, signed with secret
+func sign(header, claims string, secret []byte) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	unsigned := encode([]byte(header)) + "." + encode([]byte(claims))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + encode(mac.Sum(nil))
+}
+
+func TestResolvers(t *testing.T) {
+	now = func() time.Time { return time.Unix(1800000000, 0) }
+	defer func() { now = time.Now }()
+
+	secret := []byte("s3cret")
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+	tokens := TokenResolver{Secret: secret, Claim: "tenant"}
+
+	testData := []struct {
+		testName       string
+		resolver       Resolver
+		host           string
+		headers        map[string]string
+		expectedResult string
+		expectedErr    error
+	}{
+		{
+			testName:       "success: static",
+			resolver:       StaticResolver(Default),
+			expectedResult: Default,
+		},
+		{
+			testName:       "success: header, case folded",
+			resolver:       HeaderResolver{Header: "X-Tenant-ID"},
+			headers:        map[string]string{"X-Tenant-ID": " Team-A "},
+			expectedResult: "team-a",
+		},
+		{
+			testName:    "failure: missing header",
+			resolver:    HeaderResolver{Header: "X-Tenant-ID"},
+			expectedErr: ErrMissing,
+		},
+		{
+			testName:    "failure: header isn't a tenant id",
+			resolver:    HeaderResolver{Header: "X-Tenant-ID"},
+			headers:     map[string]string{"X-Tenant-ID": "team/a"},
+			expectedErr: ErrInvalid,
+		},
+		{
+			testName:       "success: subdomain with port",
+			resolver:       SubdomainResolver{BaseDomain: "todo.example.com"},
+			host:           "Team-A.todo.example.com:8080",
+			expectedResult: "team-a",
+		},
+		{
+			testName:    "failure: bare base domain",
+			resolver:    SubdomainResolver{BaseDomain: "todo.example.com"},
+			host:        "todo.example.com",
+			expectedErr: ErrMissing,
+		},
+		{
+			testName:    "failure: nested subdomain",
+			resolver:    SubdomainResolver{BaseDomain: "todo.example.com"},
+			host:        "a.team-a.todo.example.com",
+			expectedErr: ErrInvalid,
+		},
+		{
+			testName:    "failure: lookalike domain",
+			resolver:    SubdomainResolver{BaseDomain: "todo.example.com"},
+			host:        "team-a.eviltodo.example.com",
+			expectedErr: ErrMissing,
+		},
+		{
+			testName:       "success: token claim",
+			resolver:       tokens,
+			headers:        map[string]string{"Authorization": "Bearer " + sign(hs256, `{"tenant":"team-a","exp":1900000000}`, secret)},
+			expectedResult: "team-a",
+		},
+		{
+			testName:    "failure: no token",
+			resolver:    tokens,
+			expectedErr: ErrUnauthenticated,
+		},
+		{
+			testName:    "failure: token signed with another secret",
+			resolver:    tokens,
+			headers:     map[string]string{"Authorization": "Bearer " + sign(hs256, `{"tenant":"team-a"}`, []byte("guess"))},
+			expectedErr: ErrUnauthenticated,
+		},
+		{
+			testName:    "failure: unsigned token",
+			resolver:    tokens,
+			headers:     map[string]string{"Authorization": "Bearer " + sign(`{"alg":"none"}`, `{"tenant":"team-a"}`, secret)},
+			expectedErr: ErrUnauthenticated,
+		},
+		{
+			testName:    "failure: expired token",
+			resolver:    tokens,
+			headers:     map[string]string{"Authorization": "Bearer " + sign(hs256, `{"tenant":"team-a","exp":1700000000}`, secret)},
+			expectedErr: ErrUnauthenticated,
+		},
+		{
+			testName:    "failure: token not valid yet",
+			resolver:    tokens,
+			headers:     map[string]string{"Authorization": "Bearer " + sign(hs256, `{"tenant":"team-a","nbf":1900000000}`, secret)},
+			expectedErr: ErrUnauthenticated,
+		},
+		{
+			testName:    "failure: token without the claim",
+			resolver:    tokens,
+			headers:     map[string]string{"Authorization": "Bearer " + sign(hs256, `{"sub":"someone"}`, secret)},
+			expectedErr: ErrUnauthenticated,
+		},
+		{
+			testName:    "failure: claim isn't a tenant id",
+			resolver:    tokens,
+			headers:     map[string]string{"Authorization": "Bearer " + sign(hs256, `{"tenant":"Team A"}`, secret)},
+			expectedErr: ErrInvalid,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/list", nil)
+			if td.host != "" {
+				r.Host = td.host
+			}
+			for name, value := range td.headers {
+				r.Header.Set(name, value)
+			}
+
+			result, err := td.resolver.Resolve(r)
+
+			if td.expectedErr == nil && err != nil {
+				t.Fatalf("Resolve got unexpected error: %v", err)
+			}
+			if td.expectedErr != nil && !errors.Is(err, td.expectedErr) {
+				t.Fatalf("Resolve expected error '%v'; got %v", td.expectedErr, err)
+			}
+
+			if result != td.expectedResult {
+				t.Errorf("Resolve expected %q; got %q", td.expectedResult, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+// Package tenant carries the team a request acts for through a context.Context. Storage backends read it back with
+// ID to keep each tenant's todos apart, so a tenant can never see or change another tenant's todos.
+package tenant
+
+import (
+	"context"
+	"regexp"
+)
+
+// Default is the tenant of contexts that don't carry one, such as single-tenant deployments and the data that
+// predates tenants
+const Default = "default"
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type contextKey struct{}
+
+// WithID returns a copy of ctx carrying the tenant id
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// ID returns the tenant ctx carries, or Default
+func ID(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKey{}).(string); ok && id != "" {
+		return id
+	}
+	return Default
+}
+
+// Valid reports whether id can name a tenant: up to 63 lower-case letters, digits and hyphens, not starting with a
+// hyphen, so it's also usable as a DNS label
+func Valid(id string) bool {
+	return validID.MatchString(id)
+}
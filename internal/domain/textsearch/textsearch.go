@@ -0,0 +1,188 @@
+// Package textsearch holds the text analysis shared by the full-text search backends and the snippets shown with
+// search results: splitting text into words, dropping stop words and reducing words to a common stem, so "shopping",
+// "shops" and "shop" all find each other.
+package textsearch
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// SnippetLength is the maximum number of characters of source text in a snippet, not counting markup
+const SnippetLength = 60
+
+const (
+	highlightStart = "<mark>"
+	highlightEnd   = "</mark>"
+	ellipsis       = "…"
+)
+
+var stopWords = map[string]bool{
+	"a": true, "about": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "from": true, "has": true, "have": true, "i": true, "in": true, "into": true, "is": true,
+	"it": true, "its": true, "me": true, "my": true, "of": true, "on": true, "or": true, "our": true, "so": true,
+	"that": true, "the": true, "their": true, "then": true, "there": true, "these": true, "this": true, "to": true,
+	"was": true, "we": true, "were": true, "will": true, "with": true, "you": true, "your": true,
+}
+
+// word is a word of some text and where it is, as rune offsets
+type word struct {
+	text       string
+	start, end int
+}
+
+// Words splits text into lower-case words without stop words, in order
+func Words(text string) []string {
+	var words []string
+	for _, w := range split([]rune(text)) {
+		if !stopWords[w.text] {
+			words = append(words, w.text)
+		}
+	}
+	return words
+}
+
+// Terms is Words reduced to their stems: what gets indexed and looked up
+func Terms(text string) []string {
+	words := Words(text)
+	for i, w := range words {
+		words[i] = Stem(w)
+	}
+	return words
+}
+
+// Stem strips common English suffixes from a lower-case word. It's deliberately crude: it only needs to map a word
+// and its usual inflections to the same string, not to produce a real word.
+func Stem(w string) string {
+	switch {
+	case len(w) > 4 && strings.HasSuffix(w, "ies"):
+		return w[:len(w)-3] + "y"
+	case len(w) > 4 && hasAnySuffix(w, "sses", "ches", "shes", "xes", "zes"):
+		return w[:len(w)-2]
+	case len(w) > 5 && strings.HasSuffix(w, "ing"):
+		return undouble(w[:len(w)-3])
+	case len(w) > 4 && strings.HasSuffix(w, "ed"):
+		return undouble(w[:len(w)-2])
+	case len(w) > 4 && strings.HasSuffix(w, "ly"):
+		return w[:len(w)-2]
+	case len(w) > 3 && strings.HasSuffix(w, "s") && !hasAnySuffix(w, "ss", "us", "is"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// Highlight wraps the words of text whose stems are in terms with <mark> tags and trims it to a snippet of about
+// SnippetLength characters around the first match. The rest of the text is HTML-escaped, so the snippet is safe to
+// render as HTML. It reports whether anything matched; if not, the snippet is just the start of text.
+func Highlight(text string, terms []string) (string, bool) {
+	wanted := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		wanted[t] = true
+	}
+
+	src := []rune(text)
+
+	var matches []word
+	for _, w := range split(src) {
+		if !stopWords[w.text] && wanted[Stem(w.text)] {
+			matches = append(matches, w)
+		}
+	}
+
+	// centre the snippet on the first match, without cutting it
+	start, end := 0, len(src)
+	if len(src) > SnippetLength {
+		if len(matches) > 0 {
+			start = matches[0].start - (SnippetLength-(matches[0].end-matches[0].start))/2
+		}
+		start = clamp(start, 0, len(src)-SnippetLength)
+		end = start + SnippetLength
+		start, end = wordBoundary(src, start, -1), wordBoundary(src, end, 1)
+		for start < end && unicode.IsSpace(src[start]) {
+			start++
+		}
+		for end > start && unicode.IsSpace(src[end-1]) {
+			end--
+		}
+	}
+
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString(ellipsis)
+	}
+	pos := start
+	for _, m := range matches {
+		if m.start < start || m.end > end {
+			continue
+		}
+		sb.WriteString(html.EscapeString(string(src[pos:m.start])))
+		sb.WriteString(highlightStart)
+		sb.WriteString(html.EscapeString(string(src[m.start:m.end])))
+		sb.WriteString(highlightEnd)
+		pos = m.end
+	}
+	sb.WriteString(html.EscapeString(string(src[pos:end])))
+	if end < len(src) {
+		sb.WriteString(ellipsis)
+	}
+
+	return sb.String(), len(matches) > 0
+}
+
+// split finds the runs of letters and digits in text and lower-cases them
+func split(src []rune) []word {
+	var words []word
+	for i := 0; i < len(src); {
+		if !isWordRune(src[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(src) && isWordRune(src[i]) {
+			i++
+		}
+		words = append(words, word{text: strings.ToLower(string(src[start:i])), start: start, end: i})
+	}
+	return words
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func hasAnySuffix(w string, suffixes ...string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(w, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// undouble turns the "shopp" left over from "shopping" into "shop"
+func undouble(w string) string {
+	n := len(w)
+	if n > 2 && w[n-1] == w[n-2] && !strings.ContainsRune("aeiouls", rune(w[n-1])) {
+		return w[:n-1]
+	}
+	return w
+}
+
+// wordBoundary moves the offset i in direction dir (-1 or 1) until it no longer splits a word
+func wordBoundary(src []rune, i, dir int) int {
+	for i > 0 && i < len(src) && isWordRune(src[i-1]) && isWordRune(src[i]) {
+		i += dir
+	}
+	return i
+}
+
+func clamp(i, lo, hi int) int {
+	if i < lo {
+		return lo
+	}
+	if i > hi {
+		return hi
+	}
+	return i
+}
@@ -0,0 +1,96 @@
+package textsearch
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTerms(t *testing.T) {
+	testData := []struct {
+		testName       string
+		text           string
+		expectedResult []string
+	}{
+		{
+			testName:       "success: stop words and punctuation are dropped",
+			text:           "Get the MILK, and some eggs!",
+			expectedResult: []string{"get", "milk", "some", "egg"},
+		},
+		{
+			testName:       "success: inflections share a stem",
+			text:           "shopping shops shop washed washes stories hurriedly",
+			expectedResult: []string{"shop", "shop", "shop", "wash", "wash", "story", "hurried"},
+		},
+		{
+			testName:       "success: short words and -ss are left alone",
+			text:           "bus glass is its 2026-10-19",
+			expectedResult: []string{"bus", "glass", "2026", "10", "19"},
+		},
+		{
+			testName: "success: nothing searchable",
+			text:     "the and of ...",
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			result := Terms(td.text)
+
+			if diff := cmp.Diff(td.expectedResult, result); diff != "" {
+				t.Errorf("Terms expected vs actual results don't match: %v", diff)
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	testData := []struct {
+		testName        string
+		text            string
+		terms           []string
+		expectedResult  string
+		expectedMatched bool
+	}{
+		{
+			testName:        "success: every matching word is marked",
+			text:            "Shopping: get milk & more milk",
+			terms:           []string{"shop", "milk"},
+			expectedResult:  "<mark>Shopping</mark>: get <mark>milk</mark> &amp; more <mark>milk</mark>",
+			expectedMatched: true,
+		},
+		{
+			testName:        "success: long text is trimmed around the first match",
+			text:            "first pick up the dry cleaning, then go to the bank, then buy milk at the corner shop on the way home",
+			terms:           []string{"milk"},
+			expectedResult:  "…then go to the bank, then buy <mark>milk</mark> at the corner shop on the way…",
+			expectedMatched: true,
+		},
+		{
+			testName:       "success: no match gives the start of the text",
+			text:           "first pick up the dry cleaning, then go to the bank, then buy milk at the corner shop on the way home",
+			terms:          []string{"eggs"},
+			expectedResult: "first pick up the dry cleaning, then go to the bank, then buy…",
+		},
+		{
+			testName:       "success: stop words are never marked",
+			text:           "the thing",
+			terms:          []string{"the"},
+			expectedResult: "the thing",
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			result, matched := Highlight(td.text, td.terms)
+
+			if result != td.expectedResult {
+				t.Errorf("Highlight expected %q; got %q", td.expectedResult, result)
+			}
+
+			if matched != td.expectedMatched {
+				t.Errorf("Highlight expected matched %v; got %v", td.expectedMatched, matched)
+			}
+		})
+	}
+}
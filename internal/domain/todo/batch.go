@@ -0,0 +1,114 @@
+package todo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+// ErrBatchAborted is the result of the ops of an atomic batch that would have succeeded, had another op not failed
+var ErrBatchAborted = errors.New("batch rolled back because another operation failed")
+
+// ErrAtomicUnsupported is returned for an atomic batch on a backend without transactions, e.g. a standalone MongoDB
+var ErrAtomicUnsupported = errors.New("atomic batches need a backend with transactions")
+
+// OpKind says what a BatchOp does
+type OpKind string
+
+const (
+	OpCreate OpKind = "create"
+	OpUpdate OpKind = "update"
+	OpDelete OpKind = "delete"
+)
+
+// BatchOp is one write of a batch. A create saves Todo's name and description, an update replaces the name,
+// description and completion of the todo with ID, and a delete removes it. An update or delete with a Todo.Version
+// only applies to that version of the todo.
+type BatchOp struct {
+	Kind OpKind
+	ID   string
+	Todo Todo
+}
+
+// BatchResult is the outcome of one BatchOp: the todo as written, or as it was for a delete, or why the op failed
+type BatchResult struct {
+	Todo Todo
+	Err  error
+}
+
+var batchEvents = map[OpKind]string{
+	OpCreate: EventCreated,
+	OpUpdate: EventUpdated,
+	OpDelete: EventDeleted,
+}
+
+// errRollback makes an atomic batch's transaction roll back after its results are known
+var errRollback = errors.New("todo.Batch rollback")
+
+// Batch applies ops in order, with the backend's bulk write where it has one, and records an event for every write,
+// all in one transaction. Every op gets a result. Ops that fail are skipped and the others are committed, unless the
+// batch is atomic: then one failed op rolls the whole batch back and the others get ErrBatchAborted. Atomic batches
+// need a backend with transactions.
+func Batch(ctx context.Context, db storage.DB, ops []BatchOp, atomic bool) ([]BatchResult, error) {
+	if atomic && !storage.SupportsTransactions(db) {
+		return nil, ErrAtomicUnsupported
+	}
+
+	bulkOps := make([]storage.BulkOp, len(ops))
+	for i, op := range ops {
+		bulkOps[i] = storage.BulkOp{
+			Kind: storage.BulkOpKind(op.Kind),
+			ID:   op.ID,
+			Todo: storage.Todo{
+				Name:        op.Todo.Name,
+				Description: op.Todo.Description,
+				Completed:   op.Todo.Completed,
+				Version:     op.Todo.Version,
+			},
+		}
+	}
+
+	var results []BatchResult
+	err := storage.RunInTransaction(ctx, db, func(tx storage.DB) error {
+		written, err := storage.BulkWrite(ctx, tx, bulkOps)
+		if err != nil {
+			return err
+		}
+
+		results = make([]BatchResult, len(written))
+		var events []storage.OutboxEvent
+		failed := false
+		for i, result := range written {
+			if result.Err != nil {
+				results[i].Err = result.Err
+				failed = true
+				continue
+			}
+			results[i].Todo = fromStorage(result.Todo)
+			events = append(events, storage.OutboxEvent{
+				Type:   batchEvents[ops[i].Kind],
+				TodoID: result.Todo.ID,
+				Todo:   result.Todo,
+			})
+		}
+		if atomic && failed {
+			return errRollback
+		}
+
+		return recordAll(ctx, tx, events)
+	})
+	if errors.Is(err, errRollback) {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i] = BatchResult{Err: ErrBatchAborted}
+			}
+		}
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,84 @@
+package todo
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+func TestBatch(t *testing.T) {
+	testData := []struct {
+		testName       string
+		atomic         bool
+		expectedErrs   []error
+		expectedTodos  []string
+		expectedEvents []string
+	}{
+		{
+			testName:       "partial",
+			expectedErrs:   []error{nil, storage.ErrAlreadyInList, nil, nil},
+			expectedTodos:  []string{"dentist", "groceries"},
+			expectedEvents: []string{EventCreated, EventUpdated, EventDeleted},
+		},
+		{
+			testName:      "atomic",
+			atomic:        true,
+			expectedErrs:  []error{ErrBatchAborted, storage.ErrAlreadyInList, ErrBatchAborted, ErrBatchAborted},
+			expectedTodos: []string{"shopping", "wash car"},
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			ctx := context.Background()
+			db := storage.NewInMemoryDB()
+			shopping, _ := db.SaveTodo(ctx, "shopping", "get milk")
+			washCar, _ := db.SaveTodo(ctx, "wash car", "")
+
+			results, err := Batch(ctx, db, []BatchOp{
+				{Kind: OpCreate, Todo: Todo{Name: "dentist"}},
+				{Kind: OpCreate, Todo: Todo{Name: "wash car"}},
+				{Kind: OpUpdate, ID: shopping.ID, Todo: Todo{Name: "groceries", Description: "get milk", Version: 1}},
+				{Kind: OpDelete, ID: washCar.ID},
+			}, td.atomic)
+			if err != nil {
+				t.Fatalf("Batch got unexpected error: %+v", err)
+			}
+
+			var errs []error
+			for _, result := range results {
+				errs = append(errs, result.Err)
+			}
+			if diff := cmp.Diff(td.expectedErrs, errs, cmp.Comparer(func(expected, actual error) bool {
+				return expected == actual
+			})); diff != "" {
+				t.Errorf("Batch expected vs actual errors don't match: %v", diff)
+			}
+			if !td.atomic && (results[0].Todo.Name != "dentist" || results[2].Todo.Version != 2 || results[3].Todo.Name != "wash car") {
+				t.Errorf("Batch expected the written todos; got %+v", results)
+			}
+
+			list, _ := db.GetTodoList(ctx)
+			var names []string
+			for _, todo := range list {
+				names = append(names, todo.Name)
+			}
+			sort.Strings(names)
+			if diff := cmp.Diff(td.expectedTodos, names); diff != "" {
+				t.Errorf("expected vs actual todos don't match: %v", diff)
+			}
+
+			events, _ := db.PendingEvents(ctx, 0)
+			var eventTypes []string
+			for _, event := range events {
+				eventTypes = append(eventTypes, event.Type)
+			}
+			if diff := cmp.Diff(td.expectedEvents, eventTypes); diff != "" {
+				t.Errorf("expected vs actual events don't match: %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package todo
+
+import (
+	"context"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+// Event types recorded in the outbox for every change to the list
+const (
+	EventCreated = "todo.created"
+	EventUpdated = "todo.updated"
+	EventDeleted = "todo.deleted"
+	EventCleared = "todo.cleared"
+)
+
+// record appends an event to the outbox through tx, so it's committed with the write it describes. A backend
+// without an outbox fails the write with storage.ErrNoOutbox rather than lose its event.
+func record(ctx context.Context, tx storage.DB, eventType string, todo storage.Todo) error {
+	outbox, ok := tx.(storage.Outbox)
+	if !ok {
+		return storage.ErrNoOutbox
+	}
+
+	return outbox.AppendEvents(ctx, storage.OutboxEvent{
+		Type:   eventType,
+		TodoID: todo.ID,
+		Todo:   todo,
+	})
+}
+
+// recordAll appends the events of several writes through tx in one go
+func recordAll(ctx context.Context, tx storage.DB, events []storage.OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	outbox, ok := tx.(storage.Outbox)
+	if !ok {
+		return storage.ErrNoOutbox
+	}
+
+	return outbox.AppendEvents(ctx, events...)
+}
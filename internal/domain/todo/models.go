@@ -1,7 +1,32 @@
 package todo
 
+import "time"
+
 type Todo struct {
 	ID          string
 	Name        string
 	Description string
+	Completed   bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Version     int64
+}
+
+// Precondition decides from the current version of a todo whether a write to it may go ahead, e.g. because it's the
+// version the client last read. A nil Precondition lets every write through.
+type Precondition func(version int64) bool
+
+// Page is one page of todos; NextCursor is empty on the last page
+type Page struct {
+	Todos      []Todo
+	NextCursor string
+}
+
+// SearchResult is a todo found by Search, with snippets of its fields where the search words appear, marked up with
+// <mark> tags. A snippet is empty if the field didn't match.
+type SearchResult struct {
+	Todo               Todo
+	Score              float64
+	NameSnippet        string
+	DescriptionSnippet string
 }
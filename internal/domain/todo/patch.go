@@ -0,0 +1,125 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/pkg/jsonpatch"
+)
+
+// Patcher changes a todo. Patch applies one inside the transaction that saves its result.
+type Patcher interface {
+	Apply(todo Todo) (Todo, error)
+}
+
+// MergePatch is a JSON Merge Patch (RFC 7396) of the todo's document, e.g. {"completed": true}
+type MergePatch []byte
+
+// JSONPatch is a JSON Patch (RFC 6902) of the todo's document, e.g. [{"op": "replace", "path": "/name", "value": "x"}]
+type JSONPatch []byte
+
+// document is what a patch sees of a todo. The ID is there for test operations, but can't be changed.
+type document struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Completed   bool   `json:"completed"`
+}
+
+func (p MergePatch) Apply(todo Todo) (Todo, error) {
+	return patchDocument(todo, func(doc []byte) ([]byte, error) {
+		return jsonpatch.MergePatch(doc, p)
+	})
+}
+
+func (p JSONPatch) Apply(todo Todo) (Todo, error) {
+	return patchDocument(todo, func(doc []byte) ([]byte, error) {
+		return jsonpatch.Apply(doc, p)
+	})
+}
+
+// patchDocument runs patch over the todo's document and reads the result back, rejecting results that aren't a todo
+func patchDocument(todo Todo, patch func(doc []byte) ([]byte, error)) (Todo, error) {
+	doc, err := json.Marshal(document{
+		ID:          todo.ID,
+		Name:        todo.Name,
+		Description: todo.Description,
+		Completed:   todo.Completed,
+	})
+	if err != nil {
+		return Todo{}, err
+	}
+
+	if doc, err = patch(doc); err != nil {
+		return Todo{}, err
+	}
+
+	var patched document
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&patched); err != nil {
+		return Todo{}, fmt.Errorf("%w: the patched todo isn't valid: %v", jsonpatch.ErrInvalidPatch, err)
+	}
+	if patched.ID != todo.ID {
+		return Todo{}, fmt.Errorf("%w: a todo's id can't be changed", jsonpatch.ErrInvalidPatch)
+	}
+
+	todo.Name = patched.Name
+	todo.Description = patched.Description
+	todo.Completed = patched.Completed
+	return todo, nil
+}
+
+// Patch applies patch to the todo with the given ID and saves the result in one transaction, recording an
+// EventUpdated. validate, if not nil, checks the patched todo before it's saved, so a patch can be held to the same
+// rules as a create. A patch that changes nothing saves nothing. Like Edit, it only goes ahead if the todo's version
+// satisfies ifMatch.
+func Patch(ctx context.Context, db storage.DB, id string, patch Patcher, validate func(Todo) error, ifMatch Precondition) (Todo, error) {
+	var patchedTodo Todo
+
+	err := storage.RunInTransaction(ctx, db, func(tx storage.DB) error {
+		current, err := tx.GetTodoByID(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		version, err := expectedVersion(current, ifMatch)
+		if err != nil {
+			return err
+		}
+
+		original := fromStorage(current)
+		if patchedTodo, err = patch.Apply(original); err != nil {
+			return err
+		}
+		if validate != nil {
+			if err = validate(patchedTodo); err != nil {
+				return err
+			}
+		}
+		if patchedTodo == original {
+			return nil
+		}
+
+		edited, err := tx.EditTodo(ctx, id, storage.Todo{
+			Name:        patchedTodo.Name,
+			Description: patchedTodo.Description,
+			Completed:   patchedTodo.Completed,
+			Version:     version,
+		})
+		if err != nil {
+			return err
+		}
+		patchedTodo = fromStorage(edited)
+
+		return record(ctx, tx, EventUpdated, edited)
+	})
+	if err != nil {
+		return Todo{}, err
+	}
+
+	return patchedTodo, nil
+}
@@ -0,0 +1,125 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/pkg/jsonpatch"
+)
+
+func TestPatch(t *testing.T) {
+	errNameRequired := errors.New("name is required")
+	requireName := func(todo Todo) error {
+		if todo.Name == "" {
+			return errNameRequired
+		}
+		return nil
+	}
+
+	testData := []struct {
+		testName       string
+		patch          Patcher
+		ifMatch        Precondition
+		expectedResult Todo
+		expectedErr    error
+	}{
+		{
+			testName:       "merge patch",
+			patch:          MergePatch(`{"completed": true, "description": null}`),
+			expectedResult: Todo{Name: "shopping", Completed: true, Version: 2},
+		},
+		{
+			testName:       "json patch",
+			patch:          JSONPatch(`[{"op": "test", "path": "/name", "value": "shopping"}, {"op": "replace", "path": "/name", "value": "groceries"}]`),
+			expectedResult: Todo{Name: "groceries", Description: "get milk", Version: 2},
+		},
+		{
+			testName:       "no change",
+			patch:          JSONPatch(`[{"op": "test", "path": "/completed", "value": false}]`),
+			expectedResult: Todo{Name: "shopping", Description: "get milk", Version: 1},
+		},
+		{
+			testName:    "failure: test fails",
+			patch:       JSONPatch(`[{"op": "test", "path": "/name", "value": "groceries"}, {"op": "remove", "path": "/description"}]`),
+			expectedErr: jsonpatch.ErrTestFailed,
+		},
+		{
+			testName:    "failure: unknown field",
+			patch:       MergePatch(`{"priority": 1}`),
+			expectedErr: jsonpatch.ErrInvalidPatch,
+		},
+		{
+			testName:    "failure: wrong type",
+			patch:       MergePatch(`{"completed": "yes"}`),
+			expectedErr: jsonpatch.ErrInvalidPatch,
+		},
+		{
+			testName:    "failure: id changed",
+			patch:       JSONPatch(`[{"op": "replace", "path": "/id", "value": "other"}]`),
+			expectedErr: jsonpatch.ErrInvalidPatch,
+		},
+		{
+			testName:    "failure: invalid result",
+			patch:       MergePatch(`{"name": null}`),
+			expectedErr: errNameRequired,
+		},
+		{
+			testName:    "failure: version changed",
+			patch:       MergePatch(`{"completed": true}`),
+			ifMatch:     func(version int64) bool { return version == 2 },
+			expectedErr: storage.ErrVersionMismatch,
+		},
+		{
+			testName:       "matching version",
+			patch:          MergePatch(`{"completed": true}`),
+			ifMatch:        func(version int64) bool { return version == 1 },
+			expectedResult: Todo{Name: "shopping", Description: "get milk", Completed: true, Version: 2},
+		},
+		{
+			testName:    "failure: name taken",
+			patch:       MergePatch(`{"name": "wash car"}`),
+			expectedErr: storage.ErrAlreadyInList,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			ctx := context.Background()
+			db := storage.NewInMemoryDB()
+			shopping, _ := db.SaveTodo(ctx, "shopping", "get milk")
+			_, _ = db.SaveTodo(ctx, "wash car", "")
+
+			result, err := Patch(ctx, db, shopping.ID, td.patch, requireName, td.ifMatch)
+			if !errors.Is(err, td.expectedErr) {
+				t.Fatalf("Patch expected error '%v'; got %v", td.expectedErr, err)
+			}
+
+			expected := Todo{}
+			if td.expectedErr == nil {
+				expected = td.expectedResult
+				expected.ID = shopping.ID
+			}
+			resultsCmp := cmp.Comparer(func(expected, actual Todo) bool {
+				return expected.ID == actual.ID && expected.Name == actual.Name &&
+					expected.Description == actual.Description && expected.Completed == actual.Completed &&
+					expected.Version == actual.Version
+			})
+			if diff := cmp.Diff(expected, result, resultsCmp); diff != "" {
+				t.Errorf("Patch expected vs actual results don't match: %v", diff)
+			}
+
+			// a failed patch leaves the todo as it was
+			stored, _ := db.GetTodoByID(ctx, shopping.ID)
+			if td.expectedErr != nil && stored != shopping {
+				t.Errorf("expected the todo unchanged; got %+v", stored)
+			}
+		})
+	}
+
+	if _, err := Patch(context.Background(), storage.NewInMemoryDB(), "missing", MergePatch(`{}`), nil, nil); err != storage.ErrNotFound {
+		t.Errorf("Patch expected error %v; got %v", storage.ErrNotFound, err)
+	}
+}
@@ -2,21 +2,36 @@ package todo
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
 	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/textsearch"
 )
 
+// Save creates a todo and records an EventCreated for it in the same transaction
 func Save(ctx context.Context, db storage.DB, name, desc string) (Todo, error) {
-	todo, err := db.SaveTodo(ctx, name, desc)
+	var savedTodo storage.Todo
+
+	err := storage.RunInTransaction(ctx, db, func(tx storage.DB) error {
+		var err error
+		savedTodo, err = tx.SaveTodo(ctx, name, desc)
+		if err != nil {
+			return err
+		}
+
+		return record(ctx, tx, EventCreated, savedTodo)
+	})
 	if err != nil {
 		return Todo{}, err
 	}
 
-	return Todo{
-		ID:          todo.ID,
-		Name:        todo.Name,
-		Description: todo.Description,
-	}, nil
+	return fromStorage(savedTodo), nil
 }
 
 func GetAll(ctx context.Context, db storage.DB) ([]Todo, error) {
@@ -27,51 +42,269 @@ func GetAll(ctx context.Context, db storage.DB) ([]Todo, error) {
 
 	var returnList []Todo
 	for _, todo := range list {
-		returnList = append(returnList, Todo{
-			ID:          todo.ID,
-			Name:        todo.Name,
-			Description: todo.Description,
-		})
+		returnList = append(returnList, fromStorage(todo))
 	}
 
 	return returnList, nil
 }
 
-func Edit(ctx context.Context, db storage.DB, name string, todo Todo) (Todo, error) {
-	match, err := db.GetTodoByName(ctx, name)
+// List returns up to limit todos matching filter, starting after cursor, which is empty for the first page. The
+// filter is written in the query package's language; an invalid one gets a *query.SyntaxError.
+func List(ctx context.Context, db storage.DB, filter string, limit int, cursor string) (Page, error) {
+	expr, err := query.Parse(filter)
+	if err != nil {
+		return Page{}, err
+	}
+
+	page, err := db.ListTodos(ctx, storage.ListOptions{
+		Filter: expr,
+		Limit:  limit,
+		Cursor: cursor,
+	})
+	if err != nil {
+		return Page{}, err
+	}
+
+	var todos []Todo
+	for _, todo := range page.Todos {
+		todos = append(todos, fromStorage(todo))
+	}
+
+	return Page{
+		Todos:      todos,
+		NextCursor: page.NextCursor,
+	}, nil
+}
+
+// Search finds up to limit todos whose name or description contains any word of q, best matches first. A q with
+// nothing to search for, e.g. only stop words, gets storage.ErrEmptySearch.
+func Search(ctx context.Context, db storage.DB, q string, limit int) ([]SearchResult, error) {
+	hits, err := db.SearchTodos(ctx, storage.SearchOptions{
+		Query: q,
+		Limit: limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// snippets are built here rather than by the backends, so they look the same whichever backend found the todo
+	terms := textsearch.Terms(q)
+
+	var results []SearchResult
+	for _, hit := range hits {
+		result := SearchResult{
+			Todo:  fromStorage(hit.Todo),
+			Score: hit.Score,
+		}
+		if snippet, ok := textsearch.Highlight(hit.Todo.Name, terms); ok {
+			result.NameSnippet = snippet
+		}
+		if snippet, ok := textsearch.Highlight(hit.Todo.Description, terms); ok {
+			result.DescriptionSnippet = snippet
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// GetByID returns the todo with the given ID
+func GetByID(ctx context.Context, db storage.DB, id string) (Todo, error) {
+	todo, err := db.GetTodoByID(ctx, id)
 	if err != nil {
 		return Todo{}, err
 	}
 
-	editedTodo, err := db.EditTodo(ctx, match.ID, storage.Todo{
-		Name:        todo.Name,
-		Description: todo.Description,
+	return fromStorage(todo), nil
+}
+
+// Edit looks up the todo by name and updates it in one transaction, so a concurrent rename or delete can't slip in
+// between the lookup and the write. The transaction also records an EventUpdated. If the todo's version doesn't
+// satisfy ifMatch, nothing is written and the error is storage.ErrVersionMismatch. An edit keeps the todo's
+// completion: todo.Completed is ignored, and Patch is what completes a todo.
+func Edit(ctx context.Context, db storage.DB, name string, todo Todo, ifMatch Precondition) (Todo, error) {
+	return edit(ctx, db, func(tx storage.DB) (storage.Todo, error) {
+		return tx.GetTodoByName(ctx, name)
+	}, todo, ifMatch)
+}
+
+// EditByID is Edit for the todo with the given ID
+func EditByID(ctx context.Context, db storage.DB, id string, todo Todo, ifMatch Precondition) (Todo, error) {
+	return edit(ctx, db, func(tx storage.DB) (storage.Todo, error) {
+		return tx.GetTodoByID(ctx, id)
+	}, todo, ifMatch)
+}
+
+func edit(ctx context.Context, db storage.DB, lookup func(tx storage.DB) (storage.Todo, error), todo Todo, ifMatch Precondition) (Todo, error) {
+	var editedTodo storage.Todo
+
+	err := storage.RunInTransaction(ctx, db, func(tx storage.DB) error {
+		match, err := lookup(tx)
+		if err != nil {
+			return err
+		}
+
+		version, err := expectedVersion(match, ifMatch)
+		if err != nil {
+			return err
+		}
+
+		editedTodo, err = tx.EditTodo(ctx, match.ID, storage.Todo{
+			Name:        todo.Name,
+			Description: todo.Description,
+			Completed:   match.Completed,
+			Version:     version,
+		})
+		if err != nil {
+			return err
+		}
+
+		return record(ctx, tx, EventUpdated, editedTodo)
 	})
 	if err != nil {
 		return Todo{}, err
 	}
 
-	return Todo{
-		ID:          editedTodo.ID,
-		Name:        editedTodo.Name,
-		Description: editedTodo.Description,
-	}, nil
+	return fromStorage(editedTodo), nil
+}
+
+// Delete removes the todo with the given name and records an EventDeleted carrying its last state. Like Edit, it
+// only goes ahead if the todo's version satisfies ifMatch.
+func Delete(ctx context.Context, db storage.DB, name string, ifMatch Precondition) error {
+	return remove(ctx, db, func(tx storage.DB) (storage.Todo, error) {
+		return tx.GetTodoByName(ctx, name)
+	}, ifMatch)
 }
 
-func Delete(ctx context.Context, db storage.DB, name string) error {
-	match, err := db.GetTodoByName(ctx, name)
+// DeleteByID is Delete for the todo with the given ID
+func DeleteByID(ctx context.Context, db storage.DB, id string, ifMatch Precondition) error {
+	return remove(ctx, db, func(tx storage.DB) (storage.Todo, error) {
+		return tx.GetTodoByID(ctx, id)
+	}, ifMatch)
+}
+
+func remove(ctx context.Context, db storage.DB, lookup func(tx storage.DB) (storage.Todo, error), ifMatch Precondition) error {
+	return storage.RunInTransaction(ctx, db, func(tx storage.DB) error {
+		match, err := lookup(tx)
+		if err != nil {
+			return err
+		}
+
+		if _, err = expectedVersion(match, ifMatch); err != nil {
+			return err
+		}
+
+		if err = tx.DeleteTodo(ctx, match.ID); err != nil {
+			return err
+		}
+
+		return record(ctx, tx, EventDeleted, match)
+	})
+}
+
+// ErrConfirmationRequired means a write that deletes every todo came without the list's current confirmation token
+var ErrConfirmationRequired = errors.New("deleting every todo needs the list's current confirmation token")
+
+// ConfirmationToken identifies the list as it is now, by the IDs and versions of its todos. DeleteAll only wipes a
+// list whose token it's given, so a client can only wipe the list it has seen: the token changes with every write.
+func ConfirmationToken(ctx context.Context, db storage.DB) (string, error) {
+	list, err := db.GetTodoList(ctx)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	err = db.DeleteTodo(ctx, match.ID)
+	return listToken(list), nil
+}
+
+func listToken(list []storage.Todo) string {
+	lines := make([]string, len(list))
+	for i, todo := range list {
+		lines[i] = todo.ID + ":" + strconv.FormatInt(todo.Version, 10) + "\n"
+	}
+	sort.Strings(lines)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// DeleteAll clears the list and records an EventCleared, if token is the list's current ConfirmationToken; otherwise
+// nothing is deleted and the error is ErrConfirmationRequired. It returns how many todos were deleted.
+func DeleteAll(ctx context.Context, db storage.DB, token string) (int, error) {
+	var deleted int
+
+	err := storage.RunInTransaction(ctx, db, func(tx storage.DB) error {
+		list, err := tx.GetTodoList(ctx)
+		if err != nil {
+			return err
+		}
+		if listToken(list) != token {
+			return ErrConfirmationRequired
+		}
+
+		if err = tx.ClearTodoList(ctx); err != nil {
+			return err
+		}
+		deleted = len(list)
+
+		return record(ctx, tx, EventCleared, storage.Todo{})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// DeleteMatching deletes the todos matching filter and records an EventDeleted for each, in one transaction, and
+// returns how many it deleted. A nil filter, which would match every todo, gets ErrConfirmationRequired: deleting
+// everything is DeleteAll's job.
+func DeleteMatching(ctx context.Context, db storage.DB, filter query.Expr) (int, error) {
+	if filter == nil {
+		return 0, ErrConfirmationRequired
+	}
+
+	var deleted int
+
+	err := storage.RunInTransaction(ctx, db, func(tx storage.DB) error {
+		todos, err := tx.DeleteTodos(ctx, filter)
+		if err != nil {
+			return err
+		}
+		deleted = len(todos)
+
+		var events []storage.OutboxEvent
+		for _, todo := range todos {
+			events = append(events, storage.OutboxEvent{Type: EventDeleted, TodoID: todo.ID, Todo: todo})
+		}
+		return recordAll(ctx, tx, events)
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return deleted, nil
 }
 
-func DeleteAll(ctx context.Context, db storage.DB) error {
-	return db.ClearTodoList(ctx)
+func fromStorage(todo storage.Todo) Todo {
+	return Todo{
+		ID:          todo.ID,
+		Name:        todo.Name,
+		Description: todo.Description,
+		Completed:   todo.Completed,
+		CreatedAt:   todo.CreatedAt,
+		UpdatedAt:   todo.UpdatedAt,
+		Version:     todo.Version,
+	}
+}
+
+// expectedVersion checks current against ifMatch and returns the version a conditional write must still find, so a
+// write that lands after the check can't be overwritten. An unconditional write expects no particular version.
+func expectedVersion(current storage.Todo, ifMatch Precondition) (int64, error) {
+	if ifMatch == nil {
+		return 0, nil
+	}
+	if !ifMatch(current.Version) {
+		return 0, storage.ErrVersionMismatch
+	}
+	return current.Version, nil
 }
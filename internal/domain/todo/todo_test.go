@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
 	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
 	"github.com/us-learn-and-devops/todoapi/test/stubs"
 )
@@ -170,12 +171,205 @@ func TestGetAll(t *testing.T) {
 	}
 }
 
+func TestList(t *testing.T) {
+	testData := []struct {
+		testName       string
+		db             storage.DB
+		filter         string
+		limit          int
+		cursor         string
+		expectedResult Page
+		wantErr        bool
+		expectedErr    error
+	}{
+		{
+			testName: "success",
+			db: stubs.DBStub{
+				ListTodosFunc: func(ctx context.Context, opts storage.ListOptions) (storage.TodoPage, error) {
+					if opts.Limit != 2 || opts.Cursor != "abc" || opts.Filter.String() != "status=open" {
+						return storage.TodoPage{}, simulatedDBError
+					}
+					return storage.TodoPage{
+						Todos: []storage.Todo{
+							{
+								ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+								Name:        "shopping",
+								Description: "get milk and eggs",
+							},
+							{
+								ID:   "22222bbb-bbbb-2222-b2bb-111aa1a11a1a",
+								Name: "wash car",
+							},
+						},
+						NextCursor: "def",
+					}, nil
+				},
+			},
+			filter: "status:open",
+			limit:  2,
+			cursor: "abc",
+			expectedResult: Page{
+				Todos: []Todo{
+					{
+						ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+						Name:        "shopping",
+						Description: "get milk and eggs",
+					},
+					{
+						ID:   "22222bbb-bbbb-2222-b2bb-111aa1a11a1a",
+						Name: "wash car",
+					},
+				},
+				NextCursor: "def",
+			},
+		},
+		{
+			testName: "failure: invalid cursor",
+			db: stubs.DBStub{
+				ListTodosFunc: func(ctx context.Context, opts storage.ListOptions) (storage.TodoPage, error) {
+					return storage.TodoPage{}, storage.ErrInvalidCursor
+				},
+			},
+			cursor:      "abc",
+			wantErr:     true,
+			expectedErr: storage.ErrInvalidCursor,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			result, err := List(context.Background(), td.db, td.filter, td.limit, td.cursor)
+
+			if !td.wantErr && err != nil {
+				t.Fatalf("List got unexpected error: %+v", err)
+			}
+
+			if td.wantErr && !errors.Is(err, td.expectedErr) {
+				t.Fatalf("List expected error '%v'; got %v", td.expectedErr, err)
+			}
+
+			if diff := cmp.Diff(td.expectedResult, result); diff != "" {
+				t.Errorf("List expected vs actual results don't match: %v", diff)
+			}
+		})
+	}
+
+	t.Run("failure: invalid filter", func(t *testing.T) {
+		db := stubs.DBStub{
+			ListTodosFunc: func(ctx context.Context, opts storage.ListOptions) (storage.TodoPage, error) {
+				t.Fatal("List queried the DB with an invalid filter")
+				return storage.TodoPage{}, nil
+			},
+		}
+
+		_, err := List(context.Background(), db, "name:shop OR", 0, "")
+
+		var syntaxErr *query.SyntaxError
+		if !errors.As(err, &syntaxErr) || syntaxErr.Pos != 11 {
+			t.Fatalf("List expected a syntax error at position 11; got %v", err)
+		}
+	})
+}
+
+func TestSearch(t *testing.T) {
+	testData := []struct {
+		testName       string
+		db             storage.DB
+		query          string
+		limit          int
+		expectedResult []SearchResult
+		wantErr        bool
+		expectedErr    error
+	}{
+		{
+			testName: "success",
+			db: stubs.DBStub{
+				SearchTodosFunc: func(ctx context.Context, opts storage.SearchOptions) ([]storage.SearchHit, error) {
+					if opts.Query != "shopping" || opts.Limit != 5 {
+						return nil, simulatedDBError
+					}
+					return []storage.SearchHit{
+						{
+							Todo: storage.Todo{
+								ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+								Name:        "shopping",
+								Description: "get milk and eggs",
+							},
+							Score: 3,
+						},
+						{
+							Todo: storage.Todo{
+								ID:          "22222bbb-bbbb-2222-b2bb-111aa1a11a1a",
+								Name:        "wash car",
+								Description: "before the shops close",
+							},
+							Score: 1,
+						},
+					}, nil
+				},
+			},
+			query: "shopping",
+			limit: 5,
+			expectedResult: []SearchResult{
+				{
+					Todo: Todo{
+						ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+						Name:        "shopping",
+						Description: "get milk and eggs",
+					},
+					Score:       3,
+					NameSnippet: "<mark>shopping</mark>",
+				},
+				{
+					Todo: Todo{
+						ID:          "22222bbb-bbbb-2222-b2bb-111aa1a11a1a",
+						Name:        "wash car",
+						Description: "before the shops close",
+					},
+					Score:              1,
+					DescriptionSnippet: "before the <mark>shops</mark> close",
+				},
+			},
+		},
+		{
+			testName: "failure: nothing to search for",
+			db: stubs.DBStub{
+				SearchTodosFunc: func(ctx context.Context, opts storage.SearchOptions) ([]storage.SearchHit, error) {
+					return nil, storage.ErrEmptySearch
+				},
+			},
+			query:       "the",
+			wantErr:     true,
+			expectedErr: storage.ErrEmptySearch,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			result, err := Search(context.Background(), td.db, td.query, td.limit)
+
+			if !td.wantErr && err != nil {
+				t.Fatalf("Search got unexpected error: %+v", err)
+			}
+
+			if td.wantErr && !errors.Is(err, td.expectedErr) {
+				t.Fatalf("Search expected error '%v'; got %v", td.expectedErr, err)
+			}
+
+			if diff := cmp.Diff(td.expectedResult, result); diff != "" {
+				t.Errorf("Search expected vs actual results don't match: %v", diff)
+			}
+		})
+	}
+}
+
 func TestEdit(t *testing.T) {
 	testData := []struct {
 		testName       string
 		db             storage.DB
 		todoName       string
 		todoEdit       Todo
+		ifMatch        Precondition
 		expectedResult Todo
 		expectedErr    error
 		wantErr        bool
@@ -225,12 +419,34 @@ func TestEdit(t *testing.T) {
 			wantErr:        true,
 			expectedErr:    storage.ErrNotFound,
 		},
+		{
+			testName: "failure: todo changed since the client read it",
+			db: stubs.DBStub{
+				GetTodoByNameFunc: func(ctx context.Context, name string) (storage.Todo, error) {
+					return storage.Todo{
+						ID:          "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+						Name:        "shopping",
+						Description: "get milk and eggs",
+						Version:     3,
+					}, nil
+				},
+			},
+			todoName: "shopping",
+			todoEdit: Todo{
+				Name:        "go shopping",
+				Description: "milk, eggs",
+			},
+			ifMatch:        func(version int64) bool { return version == 2 },
+			expectedResult: Todo{},
+			wantErr:        true,
+			expectedErr:    storage.ErrVersionMismatch,
+		},
 	}
 
 	for _, td := range testData {
 		t.Run(td.testName, func(t *testing.T) {
 			db := td.db
-			result, err := Edit(context.Background(), db, td.todoName, td.todoEdit)
+			result, err := Edit(context.Background(), db, td.todoName, td.todoEdit, td.ifMatch)
 
 			if !td.wantErr && err != nil {
 				t.Fatalf("Edit got unexpected error: %+v", err)
@@ -293,7 +509,7 @@ func TestDelete(t *testing.T) {
 	for _, td := range testData {
 		t.Run(td.testName, func(t *testing.T) {
 			db := td.db
-			err := Delete(context.Background(), db, td.todoName)
+			err := Delete(context.Background(), db, td.todoName, nil)
 
 			if !td.wantErr && err != nil {
 				t.Fatalf("EditTodo got unexpected error: %+v", err)
@@ -306,38 +522,130 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestEditAndDeleteByID(t *testing.T) {
+	ctx := context.Background()
+	db := storage.NewInMemoryDB()
+	shopping, _ := Save(ctx, db, "shopping", "get milk")
+
+	edited, err := EditByID(ctx, db, shopping.ID, Todo{Name: "groceries", Description: "get milk"}, nil)
+	if err != nil || edited.Name != "groceries" || edited.Version != 2 {
+		t.Fatalf("EditByID expected the renamed todo at version 2; got %+v, %v", edited, err)
+	}
+
+	// an edit leaves completing to Patch
+	_, _ = Patch(ctx, db, shopping.ID, MergePatch(`{"completed": true}`), nil, nil)
+	edited, err = EditByID(ctx, db, shopping.ID, Todo{Name: "groceries", Description: "get bread"}, nil)
+	if err != nil || !edited.Completed || edited.Version != 4 {
+		t.Fatalf("EditByID expected the todo to stay completed at version 4; got %+v, %v", edited, err)
+	}
+
+	stale := func(version int64) bool { return version == 1 }
+	if err = DeleteByID(ctx, db, shopping.ID, stale); err != storage.ErrVersionMismatch {
+		t.Errorf("DeleteByID expected error '%v' for a stale version; got %v", storage.ErrVersionMismatch, err)
+	}
+	if err = DeleteByID(ctx, db, shopping.ID, nil); err != nil {
+		t.Fatalf("DeleteByID got unexpected error: %+v", err)
+	}
+	if _, err = EditByID(ctx, db, shopping.ID, Todo{Name: "groceries"}, nil); err != storage.ErrNotFound {
+		t.Errorf("EditByID expected error '%v' after the delete; got %v", storage.ErrNotFound, err)
+	}
+
+	events, _ := db.PendingEvents(ctx, 0)
+	if last := events[len(events)-1]; last.Type != EventDeleted || last.TodoID != shopping.ID {
+		t.Errorf("expected an EventDeleted for the deleted todo; got %+v", last)
+	}
+}
+
 func TestDeleteAll(t *testing.T) {
+	list := []storage.Todo{{ID: "a", Version: 1}, {ID: "b", Version: 3}}
+	token := listToken(list)
+
 	testData := []struct {
-		testName string
-		db       storage.DB
-		wantErr  bool
+		testName        string
+		token           string
+		clearErr        error
+		expectedDeleted int
+		expectedErr     error
 	}{
 		{
-			testName: "success",
-			db: stubs.DBStub{
-				ClearTodoListFunc: func(ctx context.Context) error {
-					return nil
-				},
-			},
+			testName:        "success",
+			token:           token,
+			expectedDeleted: 2,
+		},
+		{
+			testName:    "failure: no token",
+			expectedErr: ErrConfirmationRequired,
+		},
+		{
+			testName:    "failure: stale token",
+			token:       listToken(list[:1]),
+			expectedErr: ErrConfirmationRequired,
+		},
+		{
+			testName:    "failure: DB error",
+			token:       token,
+			clearErr:    simulatedDBError,
+			expectedErr: simulatedDBError,
 		},
 	}
 
 	for _, td := range testData {
 		t.Run(td.testName, func(t *testing.T) {
-			db := td.db
-			err := DeleteAll(context.Background(), db)
-
-			if !td.wantErr && err != nil {
-				t.Fatalf("EditTodo got unexpected error: %+v", err)
+			cleared := false
+			db := stubs.DBStub{
+				GetTodoListFunc: func(ctx context.Context) ([]storage.Todo, error) {
+					// the token doesn't depend on the order of the list
+					return []storage.Todo{list[1], list[0]}, nil
+				},
+				ClearTodoListFunc: func(ctx context.Context) error {
+					cleared = true
+					return td.clearErr
+				},
 			}
 
-			if td.wantErr && err == nil {
-				t.Fatal("GetTodoList expected an error; got none")
+			deleted, err := DeleteAll(context.Background(), db, td.token)
+			if !errors.Is(err, td.expectedErr) {
+				t.Fatalf("DeleteAll expected error '%v'; got %v", td.expectedErr, err)
+			}
+			if deleted != td.expectedDeleted {
+				t.Errorf("DeleteAll expected %d deleted; got %d", td.expectedDeleted, deleted)
+			}
+			if td.expectedErr == ErrConfirmationRequired && cleared {
+				t.Error("expected the list not to be cleared without its token")
 			}
 		})
 	}
 }
 
+func TestDeleteMatching(t *testing.T) {
+	ctx := context.Background()
+	db := storage.NewInMemoryDB()
+	shopping, _ := Save(ctx, db, "shopping", "get milk")
+	_, _ = Save(ctx, db, "wash car", "")
+	_, _ = Patch(ctx, db, shopping.ID, MergePatch(`{"completed": true}`), nil, nil)
+
+	filter, _ := query.Parse("status:completed")
+	deleted, err := DeleteMatching(ctx, db, filter)
+	if err != nil || deleted != 1 {
+		t.Fatalf("DeleteMatching expected 1 deleted; got %d, %v", deleted, err)
+	}
+	if _, err = db.GetTodoByID(ctx, shopping.ID); err != storage.ErrNotFound {
+		t.Errorf("expected the completed todo to be deleted; got %v", err)
+	}
+	if list, _ := db.GetTodoList(ctx); len(list) != 1 {
+		t.Errorf("expected 1 todo left; got %+v", list)
+	}
+
+	events, _ := db.PendingEvents(ctx, 0)
+	if last := events[len(events)-1]; last.Type != EventDeleted || last.TodoID != shopping.ID {
+		t.Errorf("expected an EventDeleted for the deleted todo; got %+v", last)
+	}
+
+	if _, err = DeleteMatching(ctx, db, nil); err != ErrConfirmationRequired {
+		t.Errorf("DeleteMatching expected error '%v' without a filter; got %v", ErrConfirmationRequired, err)
+	}
+}
+
 // listContains returns true if the given []Todo list contains a Todo item matching the one given as the 2nd argument
 func listContains(list []Todo, match Todo) bool {
 	for _, todo := range list {
@@ -347,3 +655,84 @@ func listContains(list []Todo, match Todo) bool {
 	}
 	return false
 }
+
+func TestEvents(t *testing.T) {
+	backends := []struct {
+		name string
+		open func() (storage.DB, error)
+	}{
+		{name: "InMemoryDB", open: func() (storage.DB, error) { return storage.NewInMemoryDB(), nil }},
+		{name: "EventSourcedDB", open: func() (storage.DB, error) {
+			return storage.NewEventSourcedDB(context.Background(), storage.NewInMemoryEventStore(), storage.EventSourcedConfig{})
+		}},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			ctx := context.Background()
+			db, err := backend.open()
+			if err != nil {
+				t.Fatalf("opening the backend got unexpected error: %+v", err)
+			}
+
+			saved, err := Save(ctx, db, "shopping", "get milk")
+			if err != nil {
+				t.Fatalf("Save got unexpected error: %+v", err)
+			}
+			if _, err = Save(ctx, db, "shopping", "again"); !errors.Is(err, storage.ErrAlreadyInList) {
+				t.Fatalf("Save expected error '%v'; got %v", storage.ErrAlreadyInList, err)
+			}
+			if _, err = Edit(ctx, db, "shopping", Todo{Name: "groceries"}, nil); err != nil {
+				t.Fatalf("Edit got unexpected error: %+v", err)
+			}
+			if err = Delete(ctx, db, "missing", nil); !errors.Is(err, storage.ErrNotFound) {
+				t.Fatalf("Delete expected error '%v'; got %v", storage.ErrNotFound, err)
+			}
+			if err = Delete(ctx, db, "groceries", nil); err != nil {
+				t.Fatalf("Delete got unexpected error: %+v", err)
+			}
+			token, _ := ConfirmationToken(ctx, db)
+			if _, err = DeleteAll(ctx, db, token); err != nil {
+				t.Fatalf("DeleteAll got unexpected error: %+v", err)
+			}
+
+			events, err := db.(storage.Outbox).PendingEvents(ctx, 0)
+			if err != nil {
+				t.Fatalf("PendingEvents got unexpected error: %+v", err)
+			}
+
+			type recorded struct {
+				Type, TodoID, Name string
+			}
+			var actual []recorded
+			ids := make(map[string]bool)
+			for _, event := range events {
+				actual = append(actual, recorded{Type: event.Type, TodoID: event.TodoID, Name: event.Todo.Name})
+				ids[event.ID] = true
+			}
+
+			// failed writes leave no events behind
+			expected := []recorded{
+				{Type: EventCreated, TodoID: saved.ID, Name: "shopping"},
+				{Type: EventUpdated, TodoID: saved.ID, Name: "groceries"},
+				{Type: EventDeleted, TodoID: saved.ID, Name: "groceries"},
+				{Type: EventCleared},
+			}
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Errorf("recorded events expected vs actual don't match: %v", diff)
+			}
+
+			if len(ids) != len(events) {
+				t.Errorf("expected every event to have its own ID; got %+v", events)
+			}
+		})
+	}
+
+	t.Run("backend without an outbox", func(t *testing.T) {
+		// embedding the interface hides InMemoryDB's outbox
+		db := struct{ storage.DB }{storage.NewInMemoryDB()}
+		if _, err := Save(context.Background(), db, "shopping", "get milk"); !errors.Is(err, storage.ErrNoOutbox) {
+			t.Errorf("Save expected error '%v'; got %v", storage.ErrNoOutbox, err)
+		}
+	})
+}
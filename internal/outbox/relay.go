@@ -0,0 +1,183 @@
+// Package outbox relays the domain events stored in a storage.Outbox to a Sink. Delivery is at least once: an event
+// is only marked published after the sink accepted it, so a crash in between sends it again, and consumers should
+// drop events whose ID they've already seen.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+const (
+	DefaultBatchSize    = 100
+	DefaultPollInterval = time.Second
+	DefaultLeaseTTL     = 30 * time.Second
+
+	maxBackoff = time.Minute
+)
+
+// Sink publishes events somewhere outside the service. Publish must only return nil once every event is delivered;
+// on error the whole batch is retried.
+type Sink interface {
+	Publish(ctx context.Context, events []Event) error
+}
+
+// RelayConfig tunes a Relay; zero values mean the defaults
+type RelayConfig struct {
+	BatchSize    int
+	PollInterval time.Duration
+
+	// Lease, when set, is taken before every batch, so of the relays sharing it only one publishes at a time. Its
+	// holder refreshes it every poll; LeaseTTL is how long the others wait for one that stopped without releasing it.
+	Lease    storage.OutboxLease
+	LeaseTTL time.Duration
+}
+
+// Relay moves events from an outbox to a sink
+type Relay struct {
+	store storage.Outbox
+	sink  Sink
+	cfg   RelayConfig
+
+	// owner names this relay to the lease, and leased is whether it held the lease at its last batch
+	owner  string
+	leased bool
+
+	// unmarked are events the sink accepted but that couldn't be marked published yet. They're marked before
+	// anything else is relayed, so a flaky outbox doesn't make this relay send them twice.
+	unmarked []string
+}
+
+func NewRelay(store storage.Outbox, sink Sink, cfg RelayConfig) *Relay {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultPollInterval
+	}
+	if cfg.LeaseTTL <= 0 {
+		cfg.LeaseTTL = DefaultLeaseTTL
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &Relay{
+		store: store,
+		sink:  sink,
+		cfg:   cfg,
+		owner: fmt.Sprintf("%s/%d/%s", hostname, os.Getpid(), uuid.NewString()),
+	}
+}
+
+// Run relays events until ctx is done, draining full batches back to back and backing off while the outbox or the
+// sink is failing. It releases the lease on the way out, so another relay can take over straight away.
+func (r *Relay) Run(ctx context.Context) {
+	defer r.releaseLease()
+
+	backoff := r.cfg.PollInterval
+
+	for {
+		relayed, err := r.RelayBatch(ctx)
+
+		wait := r.cfg.PollInterval
+		switch {
+		case err != nil:
+			log.Printf("outbox.Relay failed, retrying in %v: %v", backoff, err)
+			wait = backoff
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		case relayed == r.cfg.BatchSize:
+			backoff = r.cfg.PollInterval
+			wait = 0
+		default:
+			backoff = r.cfg.PollInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RelayBatch publishes the oldest pending events, up to the batch size, and returns how many it published. While
+// another relay holds the lease it publishes nothing.
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	if leased, err := r.takeLease(ctx); !leased {
+		return 0, err
+	}
+
+	if len(r.unmarked) > 0 {
+		if err := r.store.MarkPublished(ctx, r.unmarked...); err != nil {
+			return 0, err
+		}
+		r.unmarked = nil
+	}
+
+	pending, err := r.store.PendingEvents(ctx, r.cfg.BatchSize)
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	events := make([]Event, 0, len(pending))
+	ids := make([]string, 0, len(pending))
+	for _, event := range pending {
+		events = append(events, newEvent(event))
+		ids = append(ids, event.ID)
+	}
+
+	if err = r.sink.Publish(ctx, events); err != nil {
+		return 0, err
+	}
+
+	if err = r.store.MarkPublished(ctx, ids...); err != nil {
+		r.unmarked = ids
+		return 0, err
+	}
+
+	return len(events), nil
+}
+
+// takeLease takes or extends the lease, if the relay has one, and reports whether the relay may publish
+func (r *Relay) takeLease(ctx context.Context) (bool, error) {
+	if r.cfg.Lease == nil {
+		return true, nil
+	}
+
+	err := r.cfg.Lease.AcquireOutboxLease(ctx, r.owner, r.cfg.LeaseTTL)
+	if err != nil && err != storage.ErrOutboxLeased {
+		return false, err
+	}
+
+	if leased := err == nil; leased != r.leased {
+		r.leased = leased
+		if leased {
+			log.Printf("outbox.Relay took the lease, relaying as %s", r.owner)
+		} else {
+			log.Printf("outbox.Relay %s lost the lease, another instance is relaying", r.owner)
+		}
+	}
+	return r.leased, nil
+}
+
+func (r *Relay) releaseLease() {
+	if r.cfg.Lease == nil || !r.leased {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := r.cfg.Lease.ReleaseOutboxLease(ctx, r.owner); err != nil {
+		log.Printf("outbox.Relay failed to release the lease, it will expire in %v: %v", r.cfg.LeaseTTL, err)
+	}
+	r.leased = false
+}
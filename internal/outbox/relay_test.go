@@ -0,0 +1,191 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+var simulatedError = errors.New("simulated error")
+
+type sinkFunc func(ctx context.Context, events []Event) error
+
+func (f sinkFunc) Publish(ctx context.Context, events []Event) error {
+	return f(ctx, events)
+}
+
+// flakyOutbox fails MarkPublished while failMarks is set
+type flakyOutbox struct {
+	*storage.InMemoryDB
+	failMarks bool
+}
+
+func (o *flakyOutbox) MarkPublished(ctx context.Context, ids ...string) error {
+	if o.failMarks {
+		return simulatedError
+	}
+	return o.InMemoryDB.MarkPublished(ctx, ids...)
+}
+
+func appendEvents(t *testing.T, store storage.Outbox, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		err := store.AppendEvents(context.Background(), storage.OutboxEvent{
+			ID:     id,
+			Type:   "todo.created",
+			TodoID: "todo-" + id,
+			Todo:   storage.Todo{ID: "todo-" + id, Name: id},
+		})
+		if err != nil {
+			t.Fatalf("AppendEvents got unexpected error: %v", err)
+		}
+	}
+}
+
+func TestRelay_RelayBatch(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success: batches in order until drained", func(t *testing.T) {
+		store := storage.NewInMemoryDB()
+		appendEvents(t, store, "a", "b", "c")
+
+		var published []string
+		relay := NewRelay(store, sinkFunc(func(ctx context.Context, events []Event) error {
+			for _, event := range events {
+				published = append(published, event.ID)
+			}
+			return nil
+		}), RelayConfig{BatchSize: 2})
+
+		for _, expected := range []int{2, 1, 0} {
+			n, err := relay.RelayBatch(ctx)
+			if err != nil || n != expected {
+				t.Fatalf("RelayBatch expected %d events; got %d, %v", expected, n, err)
+			}
+		}
+
+		if diff := cmp.Diff([]string{"a", "b", "c"}, published); diff != "" {
+			t.Errorf("RelayBatch expected vs actual published events don't match: %v", diff)
+		}
+	})
+
+	t.Run("failure: sink error keeps events pending", func(t *testing.T) {
+		store := storage.NewInMemoryDB()
+		appendEvents(t, store, "a")
+
+		relay := NewRelay(store, sinkFunc(func(ctx context.Context, events []Event) error {
+			return simulatedError
+		}), RelayConfig{})
+
+		if _, err := relay.RelayBatch(ctx); !errors.Is(err, simulatedError) {
+			t.Fatalf("RelayBatch expected error %v; got %v", simulatedError, err)
+		}
+
+		pending, _ := store.PendingEvents(ctx, 0)
+		if len(pending) != 1 {
+			t.Errorf("expected the event to stay pending; got %v", pending)
+		}
+	})
+
+	t.Run("failure: events the sink accepted aren't sent again when marking fails", func(t *testing.T) {
+		store := &flakyOutbox{InMemoryDB: storage.NewInMemoryDB(), failMarks: true}
+		appendEvents(t, store, "a")
+
+		deliveries := 0
+		relay := NewRelay(store, sinkFunc(func(ctx context.Context, events []Event) error {
+			deliveries += len(events)
+			return nil
+		}), RelayConfig{})
+
+		if _, err := relay.RelayBatch(ctx); !errors.Is(err, simulatedError) {
+			t.Fatalf("RelayBatch expected error %v; got %v", simulatedError, err)
+		}
+		if _, err := relay.RelayBatch(ctx); !errors.Is(err, simulatedError) {
+			t.Fatalf("RelayBatch expected error %v; got %v", simulatedError, err)
+		}
+
+		store.failMarks = false
+		if n, err := relay.RelayBatch(ctx); err != nil || n != 0 {
+			t.Fatalf("RelayBatch expected nothing left to relay; got %d, %v", n, err)
+		}
+
+		if deliveries != 1 {
+			t.Errorf("expected the event to be delivered once; got %d deliveries", deliveries)
+		}
+	})
+}
+
+// memoryLease is a storage.OutboxLease for one process; expire stands in for the TTL running out
+type memoryLease struct {
+	owner string
+}
+
+func (l *memoryLease) AcquireOutboxLease(ctx context.Context, owner string, ttl time.Duration) error {
+	if l.owner != "" && l.owner != owner {
+		return storage.ErrOutboxLeased
+	}
+	l.owner = owner
+	return nil
+}
+
+func (l *memoryLease) ReleaseOutboxLease(ctx context.Context, owner string) error {
+	if l.owner == owner {
+		l.owner = ""
+	}
+	return nil
+}
+
+func (l *memoryLease) expire() {
+	l.owner = ""
+}
+
+func TestRelay_Lease(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewInMemoryDB()
+	lease := &memoryLease{}
+
+	published := map[string][]string{}
+	newRelay := func(name string) *Relay {
+		return NewRelay(store, sinkFunc(func(ctx context.Context, events []Event) error {
+			for _, event := range events {
+				published[name] = append(published[name], event.ID)
+			}
+			return nil
+		}), RelayConfig{Lease: lease})
+	}
+	first, second := newRelay("first"), newRelay("second")
+
+	steps := []struct {
+		events   []string
+		relay    *Relay
+		expected int
+		then     func()
+	}{
+		{events: []string{"a"}, relay: first, expected: 1},
+		{events: []string{"b"}, relay: second, expected: 0},
+		{relay: first, expected: 1, then: lease.expire},
+		{events: []string{"c"}, relay: second, expected: 1},
+		{events: []string{"d"}, relay: first, expected: 0, then: second.releaseLease},
+		{relay: first, expected: 1},
+	}
+
+	for i, step := range steps {
+		appendEvents(t, store, step.events...)
+		n, err := step.relay.RelayBatch(ctx)
+		if err != nil || n != step.expected {
+			t.Fatalf("step %d: RelayBatch expected %d events; got %d, %v", i, step.expected, n, err)
+		}
+		if step.then != nil {
+			step.then()
+		}
+	}
+
+	expected := map[string][]string{"first": {"a", "b", "d"}, "second": {"c"}}
+	if diff := cmp.Diff(expected, published); diff != "" {
+		t.Errorf("expected vs actual published events don't match: %v", diff)
+	}
+}
@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
+)
+
+// Event is the published form of an outbox event
+type Event struct {
+	// ID is the dedupe ID: an event delivered more than once always has the same ID
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Tenant     string    `json:"tenant"`
+	TodoID     string    `json:"todo_id,omitempty"`
+	Todo       *Todo     `json:"todo,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+type Todo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Completed   bool      `json:"completed"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func newEvent(event storage.OutboxEvent) Event {
+	published := Event{
+		ID:         event.ID,
+		Type:       event.Type,
+		Tenant:     event.Tenant,
+		TodoID:     event.TodoID,
+		OccurredAt: event.OccurredAt,
+	}
+
+	if event.Todo.ID != "" {
+		published.Todo = &Todo{
+			ID:          event.Todo.ID,
+			Name:        event.Todo.Name,
+			Description: event.Todo.Description,
+			Completed:   event.Todo.Completed,
+			CreatedAt:   event.Todo.CreatedAt,
+			UpdatedAt:   event.Todo.UpdatedAt,
+		}
+	}
+
+	return published
+}
+
+// LogSink writes each event to the standard logger as JSON
+type LogSink struct{}
+
+func (LogSink) Publish(ctx context.Context, events []Event) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("outbox.LogSink failed to marshal event %s: %v", event.ID, err)
+		}
+		log.Printf("outbox event: %s", data)
+	}
+
+	return nil
+}
+
+// WebhookSink POSTs each event as JSON to URL. The event ID is also sent as the Idempotency-Key header, so receivers
+// can dedupe without parsing the body. Any response other than 2xx is a failed delivery.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookSink(url string, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{
+		URL:    url,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *WebhookSink) Publish(ctx context.Context, events []Event) error {
+	for _, event := range events {
+		if err := s.post(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *WebhookSink) post(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("outbox.WebhookSink failed to marshal event %s: %v", event.ID, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("outbox.WebhookSink failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", event.ID)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("outbox.WebhookSink failed to deliver event %s: %v", event.ID, err)
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("outbox.WebhookSink: delivering event %s got status %s", event.ID, resp.Status)
+	}
+
+	return nil
+}
+
+// FileSink appends events as JSON lines to the file at Path, syncing after each batch
+type FileSink struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (s *FileSink) Publish(ctx context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("outbox.FileSink failed to open %s: %v", s.Path, err)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err = enc.Encode(event); err != nil {
+			f.Close()
+			return fmt.Errorf("outbox.FileSink failed to marshal event %s: %v", event.ID, err)
+		}
+	}
+
+	if _, err = f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("outbox.FileSink failed to write to %s: %v", s.Path, err)
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("outbox.FileSink failed to sync %s: %v", s.Path, err)
+	}
+
+	return f.Close()
+}
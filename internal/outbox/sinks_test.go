@@ -0,0 +1,97 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testEvents = []Event{
+	{
+		ID:         "11111aaa-aaaa-1111-a1aa-111aa1a11a1a",
+		Type:       "todo.created",
+		TodoID:     "22222bbb-bbbb-2222-b2bb-111aa1a11a1a",
+		Todo:       &Todo{ID: "22222bbb-bbbb-2222-b2bb-111aa1a11a1a", Name: "shopping"},
+		OccurredAt: time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC),
+	},
+	{
+		ID:         "33333ccc-cccc-3333-c3cc-111aa1a11a1a",
+		Type:       "todo.cleared",
+		OccurredAt: time.Date(2026, 10, 19, 9, 1, 0, 0, time.UTC),
+	},
+}
+
+func TestWebhookSink_Publish(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		var received []Event
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event Event
+			if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+				t.Errorf("webhook got an undecodable body: %v", err)
+			}
+			if key := r.Header.Get("Idempotency-Key"); key != event.ID {
+				t.Errorf("webhook expected Idempotency-Key %s; got %s", event.ID, key)
+			}
+			received = append(received, event)
+		}))
+		defer server.Close()
+
+		sink := NewWebhookSink(server.URL, time.Second)
+		if err := sink.Publish(context.Background(), testEvents); err != nil {
+			t.Fatalf("Publish got unexpected error: %v", err)
+		}
+
+		if diff := cmp.Diff(testEvents, received); diff != "" {
+			t.Errorf("Publish expected vs actual delivered events don't match: %v", diff)
+		}
+	})
+
+	t.Run("failure: non-2xx response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		sink := NewWebhookSink(server.URL, time.Second)
+		if err := sink.Publish(context.Background(), testEvents); err == nil || !strings.Contains(err.Error(), "503") {
+			t.Errorf("Publish expected a 503 error; got %v", err)
+		}
+	})
+}
+
+func TestFileSink_Publish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	sink := &FileSink{Path: path}
+
+	for _, event := range testEvents {
+		if err := sink.Publish(context.Background(), []Event{event}); err != nil {
+			t.Fatalf("Publish got unexpected error: %v", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read sink file: %v", err)
+	}
+
+	var written []Event
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var event Event
+		if err = json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("sink file has an undecodable line %q: %v", line, err)
+		}
+		written = append(written, event)
+	}
+
+	if diff := cmp.Diff(testEvents, written); diff != "" {
+		t.Errorf("Publish expected vs actual written events don't match: %v", diff)
+	}
+}
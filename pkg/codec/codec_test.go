@@ -0,0 +1,217 @@
+package codec
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestYAML(t *testing.T) {
+	testData := []struct {
+		testName string
+		json     string
+		yaml     string
+	}{
+		{
+			testName: "todo",
+			json:     `{"id":"1","name":"shopping","description":"get milk","completed":false}`,
+			yaml:     "id: \"1\"\nname: shopping\ndescription: get milk\ncompleted: false\n",
+		},
+		{
+			testName: "list",
+			json:     `{"todos":[{"id":"1","tags":["home","errands"]},{"id":"2","tags":[]}],"next":null}`,
+			yaml:     "todos:\n  - id: \"1\"\n    tags:\n      - home\n      - errands\n  - id: \"2\"\n    tags: []\nnext: null\n",
+		},
+		{
+			testName: "strings that need quotes",
+			json:     `["","yes","-1"," padded","a: b","line\nbreak","it's"]`,
+			yaml:     "- \"\"\n- \"yes\"\n- \"-1\"\n- \" padded\"\n- \"a: b\"\n- \"line\\nbreak\"\n- it's\n",
+		},
+		{
+			testName: "numbers",
+			json:     `{"count":3,"ratio":0.5,"big":18446744073709551615}`,
+			yaml:     "count: 3\nratio: 0.5\nbig: 18446744073709551615\n",
+		},
+		{
+			testName: "empty object",
+			json:     `{}`,
+			yaml:     "{}\n",
+		},
+		{
+			testName: "scalar",
+			json:     `"hello"`,
+			yaml:     "hello\n",
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			yaml, err := JSONToYAML([]byte(td.json))
+			if err != nil {
+				t.Fatalf("JSONToYAML got unexpected error: %+v", err)
+			}
+			if diff := cmp.Diff(td.yaml, string(yaml)); diff != "" {
+				t.Errorf("expected vs actual YAML don't match: %v", diff)
+			}
+
+			json, err := YAMLToJSON(yaml)
+			if err != nil {
+				t.Fatalf("YAMLToJSON got unexpected error: %+v", err)
+			}
+			if diff := cmp.Diff(td.json, string(json)); diff != "" {
+				t.Errorf("expected vs actual JSON don't match: %v", diff)
+			}
+		})
+	}
+}
+
+func TestYAMLToJSON(t *testing.T) {
+	testData := []struct {
+		testName    string
+		yaml        string
+		expected    string
+		expectedErr error
+	}{
+		{
+			testName: "hand written",
+			yaml: `---
+# a todo
+name: 'dentist''s'   # trailing comment
+description: "book a \x41ppointment"
+completed: no
+tags:
+- health
+- {priority: 1, due: ~}
+notes: |
+  first
+  second
+summary: >-
+  folded
+  lines
+`,
+			expected: `{"name":"dentist's","description":"book a Appointment","completed":"no","tags":["health",{"priority":1,"due":null}],"notes":"first\nsecond\n","summary":"folded lines"}`,
+		},
+		{
+			testName: "nested sequences",
+			yaml:     "- - 1\n  - 0x10\n- [a, 'b c', +2.50]\n",
+			expected: `[[1,16],["a","b c",2.5]]`,
+		},
+		{
+			testName: "empty document",
+			yaml:     "# nothing here\n",
+			expected: `null`,
+		},
+		{testName: "duplicate key", yaml: "a: 1\na: 2\n", expectedErr: ErrMalformed},
+		{testName: "bad indentation", yaml: "a:\n    b: 1\n  c: 2\n", expectedErr: ErrMalformed},
+		{testName: "anchor", yaml: "a: &x 1\n", expectedErr: ErrMalformed},
+		{testName: "infinity", yaml: "a: .inf\n", expectedErr: ErrMalformed},
+		{testName: "unterminated flow", yaml: "a: [1, 2\n", expectedErr: ErrMalformed},
+		{testName: "unterminated string", yaml: "a: \"b\n", expectedErr: ErrMalformed},
+		{testName: "two documents", yaml: "a: 1\n---\nb: 2\n", expectedErr: ErrMalformed},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			json, err := YAMLToJSON([]byte(td.yaml))
+			if !errors.Is(err, td.expectedErr) {
+				t.Fatalf("YAMLToJSON expected error %v; got %v", td.expectedErr, err)
+			}
+			if diff := cmp.Diff(td.expected, string(json)); diff != "" {
+				t.Errorf("expected vs actual JSON don't match: %v", diff)
+			}
+		})
+	}
+}
+
+func TestMessagePack(t *testing.T) {
+	testData := []struct {
+		testName string
+		json     string
+		msgpack  string
+	}{
+		{testName: "fixed map", json: `{"a":1,"b":[true,null]}`, msgpack: "\x82\xa1a\x01\xa1b\x92\xc3\xc0"},
+		{testName: "integers", json: `[-1,-33,200,70000,-2147483649]`, msgpack: "\x95\xff\xd0\xdf\xcc\xc8\xce\x00\x01\x11\x70\xd3\xff\xff\xff\xff\x7f\xff\xff\xff"},
+		{testName: "float", json: `0.5`, msgpack: "\xcb\x3f\xe0\x00\x00\x00\x00\x00\x00"},
+		{testName: "string 8", json: `"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"`, msgpack: "\xd9\x21aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			msgpack, err := JSONToMessagePack([]byte(td.json))
+			if err != nil {
+				t.Fatalf("JSONToMessagePack got unexpected error: %+v", err)
+			}
+			if diff := cmp.Diff([]byte(td.msgpack), msgpack); diff != "" {
+				t.Errorf("expected vs actual MessagePack don't match: %v", diff)
+			}
+
+			json, err := MessagePackToJSON(msgpack)
+			if err != nil {
+				t.Fatalf("MessagePackToJSON got unexpected error: %+v", err)
+			}
+			if diff := cmp.Diff(td.json, string(json)); diff != "" {
+				t.Errorf("expected vs actual JSON don't match: %v", diff)
+			}
+		})
+	}
+
+	for name, msgpack := range map[string]string{
+		"truncated":      "\x92\x01",
+		"binary":         "\xc4\x01a",
+		"integer key":    "\x81\x01\x01",
+		"huge array":     "\xdd\xff\xff\xff\xff",
+		"trailing bytes": "\x01\x02",
+		"NaN":            "\xcb\x7f\xf8\x00\x00\x00\x00\x00\x01",
+	} {
+		if _, err := MessagePackToJSON([]byte(msgpack)); !errors.Is(err, ErrMalformed) {
+			t.Errorf("MessagePackToJSON %s expected error %v; got %v", name, ErrMalformed, err)
+		}
+	}
+}
+
+func TestJSONToCSV(t *testing.T) {
+	testData := []struct {
+		testName string
+		json     string
+		expected string
+	}{
+		{
+			testName: "page of todos",
+			json:     `{"todos":[{"id":"1","name":"shopping","completed":false},{"id":"2","name":"dentist, 9am","completed":true}],"next":null}`,
+			expected: "id,name,completed\n1,shopping,false\n2,\"dentist, 9am\",true\n",
+		},
+		{
+			testName: "nested",
+			json:     `[{"todo":{"id":"1"},"score":2,"highlights":["milk"]},{"todo":{"id":"2"},"extra":null}]`,
+			expected: "todo.id,score,highlights,extra\n1,2,\"[\"\"milk\"\"]\",\n2,,,\n",
+		},
+		{
+			testName: "single object",
+			json:     `{"id":"1","name":"shopping"}`,
+			expected: "id,name\n1,shopping\n",
+		},
+		{
+			testName: "scalars",
+			json:     `["a","b"]`,
+			expected: "value\na\nb\n",
+		},
+		{
+			testName: "empty list",
+			json:     `[]`,
+			expected: "",
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			csv, err := JSONToCSV([]byte(td.json))
+			if err != nil {
+				t.Fatalf("JSONToCSV got unexpected error: %+v", err)
+			}
+			if diff := cmp.Diff(td.expected, string(csv)); diff != "" {
+				t.Errorf("expected vs actual CSV don't match: %v", diff)
+			}
+		})
+	}
+}
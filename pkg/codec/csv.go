@@ -0,0 +1,111 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+)
+
+// JSONToCSV converts a JSON document to CSV with a header row. The rows are the objects of the document's list: the
+// document itself if it's an array, or its only array member if it's an object with one, like a page of todos with
+// its next link; any other object is a single row. Nested objects are flattened into columns named by their path,
+// e.g. todo.name, and nested arrays are written as JSON. Columns are in the order they first appear.
+func JSONToCSV(data []byte) ([]byte, error) {
+	value, err := fromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []object
+	for _, item := range csvRows(value) {
+		row := object{}
+		flatten(&row, "", item)
+		rows = append(rows, row)
+	}
+
+	var columns []string
+	index := map[string]int{}
+	for _, row := range rows {
+		for _, m := range row {
+			if _, ok := index[m.key]; !ok {
+				index[m.key] = len(columns)
+				columns = append(columns, m.key)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if len(columns) == 0 {
+		return buf.Bytes(), nil
+	}
+	w := csv.NewWriter(&buf)
+	_ = w.Write(columns)
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for _, m := range row {
+			record[index[m.key]] = csvCell(m.value)
+		}
+		_ = w.Write(record)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func csvRows(value interface{}) []interface{} {
+	switch value := value.(type) {
+	case []interface{}:
+		return value
+	case object:
+		var list []interface{}
+		lists := 0
+		for _, m := range value {
+			if items, ok := m.value.([]interface{}); ok {
+				list = items
+				lists++
+			}
+		}
+		if lists == 1 {
+			return list
+		}
+	}
+	return []interface{}{value}
+}
+
+// flatten adds the scalar members of value to row, under their path from prefix
+func flatten(row *object, prefix string, value interface{}) {
+	obj, ok := value.(object)
+	if !ok {
+		if prefix == "" {
+			prefix = "value"
+		}
+		*row = append(*row, member{key: prefix, value: value})
+		return
+	}
+
+	for _, m := range obj {
+		key := m.key
+		if prefix != "" {
+			key = prefix + "." + m.key
+		}
+		flatten(row, key, m.value)
+	}
+}
+
+func csvCell(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return string(value)
+	case bool:
+		if value {
+			return "true"
+		}
+		return "false"
+	default:
+		data, _ := toJSON(value)
+		return string(data)
+	}
+}
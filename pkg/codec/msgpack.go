@@ -0,0 +1,291 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// JSONToMessagePack converts a JSON document to MessagePack. Whole numbers become the smallest integer type that
+// holds them and other numbers float 64s.
+func JSONToMessagePack(data []byte) ([]byte, error) {
+	value, err := fromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err = writeMessagePack(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MessagePackToJSON converts a MessagePack document to JSON. Maps must have string keys, and binary and extension
+// values, which JSON has no equivalent for, are malformed.
+func MessagePackToJSON(data []byte) ([]byte, error) {
+	r := &msgpackReader{data: data}
+	value, err := r.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, fmt.Errorf("%w: data after the MessagePack value", ErrMalformed)
+	}
+	return toJSON(value)
+}
+
+func writeMessagePack(buf *bytes.Buffer, value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if value {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			writeMessagePackInt(buf, n)
+			return nil
+		}
+		if n, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			buf.WriteByte(0xcf)
+			_ = binary.Write(buf, binary.BigEndian, n)
+			return nil
+		}
+		f, err := strconv.ParseFloat(string(value), 64)
+		if err != nil {
+			return fmt.Errorf("%w: number %s out of range", ErrMalformed, value)
+		}
+		buf.WriteByte(0xcb)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		writeMessagePackHeader(buf, len(value), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		buf.WriteString(value)
+	case []interface{}:
+		writeMessagePackHeader(buf, len(value), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range value {
+			if err := writeMessagePack(buf, item); err != nil {
+				return err
+			}
+		}
+	case object:
+		writeMessagePackHeader(buf, len(value), 0x80, 15, 0, 0xde, 0xdf)
+		for _, m := range value {
+			_ = writeMessagePack(buf, m.key)
+			if err := writeMessagePack(buf, m.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeMessagePackInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0 && n <= 127:
+		buf.WriteByte(byte(n))
+	case n < 0 && n >= -32:
+		buf.WriteByte(byte(int8(n)))
+	case n >= 0 && n <= math.MaxUint8:
+		buf.Write([]byte{0xcc, byte(n)})
+	case n >= 0 && n <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		_ = binary.Write(buf, binary.BigEndian, uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		_ = binary.Write(buf, binary.BigEndian, uint32(n))
+	case n >= 0:
+		buf.WriteByte(0xcf)
+		_ = binary.Write(buf, binary.BigEndian, uint64(n))
+	case n >= math.MinInt8:
+		buf.Write([]byte{0xd0, byte(int8(n))})
+	case n >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		_ = binary.Write(buf, binary.BigEndian, int16(n))
+	case n >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		_ = binary.Write(buf, binary.BigEndian, int32(n))
+	default:
+		buf.WriteByte(0xd3)
+		_ = binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// writeMessagePackHeader writes the type and length of a string, array or map: fixed if the length fits in fixMax,
+// otherwise the 8 (strings only), 16 or 32 bit form
+func writeMessagePackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		buf.Write([]byte{code8, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		_ = binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code32)
+		_ = binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// maxDepth bounds how deeply decoded arrays and maps may nest, so a hostile document can't exhaust the stack
+const maxDepth = 64
+
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, fmt.Errorf("%w: MessagePack ends early", ErrMalformed)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// uint reads an n byte big endian unsigned integer
+func (r *msgpackReader) uint(n int) (uint64, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (r *msgpackReader) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: MessagePack nests too deeply", ErrMalformed)
+	}
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch code := b[0]; {
+	case code <= 0x7f:
+		return json.Number(strconv.Itoa(int(code))), nil
+	case code >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(code)))), nil
+	case code >= 0xa0 && code <= 0xbf:
+		return r.str(int(code & 0x1f))
+	case code >= 0x90 && code <= 0x9f:
+		return r.array(int(code&0x0f), depth)
+	case code >= 0x80 && code <= 0x8f:
+		return r.object(int(code&0x0f), depth)
+	case code == 0xc0:
+		return nil, nil
+	case code == 0xc2 || code == 0xc3:
+		return code == 0xc3, nil
+	case code >= 0xcc && code <= 0xcf:
+		u, err := r.uint(1 << (code - 0xcc))
+		return json.Number(strconv.FormatUint(u, 10)), err
+	case code >= 0xd0 && code <= 0xd3:
+		size := 1 << (code - 0xd0)
+		u, err := r.uint(size)
+		// sign-extend from the integer's own width
+		shift := 64 - 8*uint(size)
+		return json.Number(strconv.FormatInt(int64(u<<shift)>>shift, 10)), err
+	case code == 0xca || code == 0xcb:
+		var f float64
+		if code == 0xca {
+			u, err := r.uint(4)
+			if err != nil {
+				return nil, err
+			}
+			f = float64(math.Float32frombits(uint32(u)))
+		} else {
+			u, err := r.uint(8)
+			if err != nil {
+				return nil, err
+			}
+			f = math.Float64frombits(u)
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%w: JSON has no NaN or infinite numbers", ErrMalformed)
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	case code == 0xd9 || code == 0xda || code == 0xdb:
+		n, err := r.uint(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return r.str(int(n))
+	case code == 0xdc || code == 0xdd:
+		n, err := r.uint(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.array(int(n), depth)
+	case code == 0xde || code == 0xdf:
+		n, err := r.uint(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.object(int(n), depth)
+	default:
+		return nil, fmt.Errorf("%w: MessagePack type 0x%x has no JSON equivalent", ErrMalformed, code)
+	}
+}
+
+func (r *msgpackReader) str(n int) (interface{}, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(b) {
+		return nil, fmt.Errorf("%w: MessagePack string isn't UTF-8", ErrMalformed)
+	}
+	return string(b), nil
+}
+
+func (r *msgpackReader) array(n int, depth int) (interface{}, error) {
+	// every item takes at least a byte, which stops a huge length from allocating before the data runs out
+	if n > len(r.data)-r.pos {
+		return nil, fmt.Errorf("%w: MessagePack ends early", ErrMalformed)
+	}
+	list := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		item, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func (r *msgpackReader) object(n int, depth int) (interface{}, error) {
+	if 2*n > len(r.data)-r.pos {
+		return nil, fmt.Errorf("%w: MessagePack ends early", ErrMalformed)
+	}
+	obj := make(object, 0, n)
+	for i := 0; i < n; i++ {
+		key, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: MessagePack map keys must be strings", ErrMalformed)
+		}
+		value, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		obj = append(obj, member{key: name, value: value})
+	}
+	return obj, nil
+}
@@ -0,0 +1,617 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONToYAML converts a JSON document to a block style YAML document. Strings that YAML would read as something
+// else, like "true" or "10", are quoted.
+func JSONToYAML(data []byte) ([]byte, error) {
+	value, err := fromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	switch value := value.(type) {
+	case object:
+		if len(value) == 0 {
+			buf.WriteString("{}\n")
+		}
+		writeYAMLMembers(&buf, value, 0)
+	case []interface{}:
+		if len(value) == 0 {
+			buf.WriteString("[]\n")
+		}
+		writeYAMLItems(&buf, value, 0)
+	default:
+		buf.WriteString(yamlScalar(value) + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// YAMLToJSON converts a YAML document to JSON. It reads the YAML that configs and scripts are written in: block
+// mappings and sequences, single line flow collections, plain and quoted scalars, literal and folded block scalars,
+// and comments. Scalars resolve by the YAML 1.2 core schema. Anchors, aliases, tags, multiple documents and scalars
+// JSON can't hold, like .inf, are malformed.
+func YAMLToJSON(data []byte) ([]byte, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("%w: YAML isn't UTF-8", ErrMalformed)
+	}
+	p := &yamlParser{lines: strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")}
+
+	// a document start marker is optional; an end marker ends the document
+	if indent, text, ok := p.peek(); ok && indent == 0 && (text == "---" || strings.HasPrefix(text, "--- ")) {
+		p.lines[p.pos] = "    " + strings.TrimPrefix(text[3:], " ")
+		if strings.TrimSpace(p.lines[p.pos]) == "" {
+			p.pos++
+		}
+	}
+	for i, line := range p.lines {
+		if line == "..." {
+			p.lines = p.lines[:i]
+			break
+		}
+	}
+
+	var value interface{}
+	if _, _, ok := p.peek(); ok {
+		var err error
+		if value, err = p.block(-1, 0); err != nil {
+			return nil, err
+		}
+	}
+	if _, _, ok := p.peek(); ok {
+		return nil, p.errorf("unexpected content; only one document is allowed")
+	}
+	return toJSON(value)
+}
+
+func writeYAMLMembers(buf *bytes.Buffer, obj object, indent int) {
+	for _, m := range obj {
+		buf.WriteString(strings.Repeat(" ", indent))
+		writeYAMLMember(buf, m, indent)
+	}
+}
+
+// writeYAMLMember writes a member whose line is already indented
+func writeYAMLMember(buf *bytes.Buffer, m member, indent int) {
+	buf.WriteString(yamlScalar(m.key) + ":")
+	writeYAMLValue(buf, m.value, indent+2)
+}
+
+func writeYAMLItems(buf *bytes.Buffer, list []interface{}, indent int) {
+	for _, item := range list {
+		buf.WriteString(strings.Repeat(" ", indent) + "-")
+		// an object item starts on the dash's line
+		if obj, ok := item.(object); ok && len(obj) > 0 {
+			buf.WriteString(" ")
+			writeYAMLMember(buf, obj[0], indent+2)
+			writeYAMLMembers(buf, obj[1:], indent+2)
+			continue
+		}
+		writeYAMLValue(buf, item, indent+2)
+	}
+}
+
+// writeYAMLValue writes value after the key or dash it belongs to, with indent the indentation of its members or items
+func writeYAMLValue(buf *bytes.Buffer, value interface{}, indent int) {
+	switch value := value.(type) {
+	case object:
+		if len(value) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+		buf.WriteString("\n")
+		writeYAMLMembers(buf, value, indent)
+	case []interface{}:
+		if len(value) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+		buf.WriteString("\n")
+		writeYAMLItems(buf, value, indent)
+	default:
+		buf.WriteString(" " + yamlScalar(value) + "\n")
+	}
+}
+
+var (
+	yamlNull  = regexp.MustCompile(`^(null|Null|NULL|~)$`)
+	yamlBool  = regexp.MustCompile(`^(true|True|TRUE|false|False|FALSE)$`)
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlOct   = regexp.MustCompile(`^0o[0-7]+$`)
+	yamlHex   = regexp.MustCompile(`^0x[0-9a-fA-F]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+	yamlInf   = regexp.MustCompile(`^[-+]?\.(inf|Inf|INF)$|^\.(nan|NaN|NAN)$`)
+	// YAML 1.1 booleans, which older readers still resolve
+	yamlOldBool = regexp.MustCompile(`^(?i:y|n|yes|no|on|off)$`)
+)
+
+func yamlScalar(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(value)
+	case json.Number:
+		return string(value)
+	case string:
+		if yamlPlainSafe(value) {
+			return value
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		_ = enc.Encode(value)
+		return strings.TrimSuffix(buf.String(), "\n")
+	default:
+		return ""
+	}
+}
+
+// yamlPlainSafe reports whether s reads back as the same string without quotes
+func yamlPlainSafe(s string) bool {
+	if s == "" || s != strings.TrimSpace(s) || strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`.+0123456789") {
+		return false
+	}
+	if yamlNull.MatchString(s) || yamlBool.MatchString(s) || yamlOldBool.MatchString(s) || yamlInf.MatchString(s) {
+		return false
+	}
+	if strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return false
+	}
+	for _, r := range s {
+		if r < ' ' || r == 0x7f || r == '\u0085' || r == '\u2028' || r == '\u2029' || r == '\ufeff' {
+			return false
+		}
+	}
+	return true
+}
+
+type yamlParser struct {
+	lines []string
+	pos   int
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: YAML line %d: %s", ErrMalformed, p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// peek skips blank and comment lines and returns the indentation and content of the next line, if there is one
+func (p *yamlParser) peek() (int, string, bool) {
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		text := strings.TrimLeft(line, " ")
+		if text = strings.TrimRight(text, " \t"); text != "" && !strings.HasPrefix(text, "#") {
+			return len(line) - len(strings.TrimLeft(line, " ")), text, true
+		}
+	}
+	return 0, "", false
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// block parses the mapping or sequence that starts on the next line, which must be indented more than parent
+func (p *yamlParser) block(parent, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, p.errorf("nests too deeply")
+	}
+	indent, text, _ := p.peek()
+	if strings.HasPrefix(strings.TrimLeft(p.lines[p.pos], " "), "\t") {
+		return nil, p.errorf("tabs can't indent YAML")
+	}
+	if indent <= parent {
+		return nil, p.errorf("expected an indented block")
+	}
+	if isYAMLItem(text) {
+		return p.sequence(indent, depth)
+	}
+	// a flow collection or scalar on a line of its own, like a document that's just a string
+	if _, _, err := p.splitKey(text); err != nil || text[0] == '[' || text[0] == '{' {
+		p.pos++
+		return p.value(text, indent, false, depth)
+	}
+	return p.mapping(indent, depth)
+}
+
+func (p *yamlParser) mapping(indent, depth int) (interface{}, error) {
+	obj := object{}
+	seen := map[string]bool{}
+	for {
+		lineIndent, text, ok := p.peek()
+		if !ok || lineIndent < indent {
+			return obj, nil
+		}
+		if lineIndent > indent || isYAMLItem(text) {
+			return nil, p.errorf("expected a key at indentation %d", indent)
+		}
+
+		key, rest, err := p.splitKey(text)
+		if err != nil {
+			return nil, err
+		}
+		if seen[key] {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		seen[key] = true
+		p.pos++
+
+		value, err := p.value(rest, indent, true, depth)
+		if err != nil {
+			return nil, err
+		}
+		obj = append(obj, member{key: key, value: value})
+	}
+}
+
+func (p *yamlParser) sequence(indent, depth int) (interface{}, error) {
+	list := []interface{}{}
+	for {
+		lineIndent, text, ok := p.peek()
+		if !ok || lineIndent < indent || (lineIndent == indent && !isYAMLItem(text)) {
+			return list, nil
+		}
+		if lineIndent > indent {
+			return nil, p.errorf("expected an item at indentation %d", indent)
+		}
+
+		rest := strings.TrimLeft(text[1:], " ")
+		// an item that's itself a mapping or sequence is parsed as a block indented to where it starts
+		if _, _, err := p.splitKey(rest); (err == nil && !strings.HasPrefix(rest, "{") && !strings.HasPrefix(rest, "[")) || isYAMLItem(rest) {
+			childIndent := indent + len(text) - len(rest)
+			p.lines[p.pos] = strings.Repeat(" ", childIndent) + rest
+			item, err := p.block(indent, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			continue
+		}
+
+		p.pos++
+		item, err := p.value(rest, indent, false, depth)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+}
+
+// splitKey splits a mapping entry into its key and the rest of the line after the colon
+func (p *yamlParser) splitKey(text string) (string, string, error) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		key, n, err := p.quoted(text)
+		if err != nil {
+			return "", "", err
+		}
+		rest := strings.TrimLeft(text[n:], " ")
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", p.errorf("expected a colon after the key")
+		}
+		return key, strings.TrimSpace(rest[1:]), nil
+	}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == '#' && i > 0 && text[i-1] == ' ' {
+			break
+		}
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), nil
+		}
+	}
+	return "", "", p.errorf("expected key: value")
+}
+
+// value parses the value after a key or dash: rest of the line, or the block under it. A mapping's value may be a
+// sequence at the mapping's own indentation.
+func (p *yamlParser) value(rest string, indent int, sameIndentItems bool, depth int) (interface{}, error) {
+	switch {
+	case rest == "" || strings.HasPrefix(rest, "#"):
+		lineIndent, text, ok := p.peek()
+		if ok && (lineIndent > indent || (sameIndentItems && lineIndent == indent && isYAMLItem(text))) {
+			if lineIndent == indent {
+				return p.sequence(indent, depth+1)
+			}
+			return p.block(indent, depth+1)
+		}
+		return nil, nil
+	case rest[0] == '|' || rest[0] == '>':
+		return p.blockScalar(rest, indent)
+	case rest[0] == '[' || rest[0] == '{':
+		f := &yamlFlow{p: p, text: rest}
+		value, err := f.value(depth)
+		if err != nil {
+			return nil, err
+		}
+		if trailing := strings.TrimSpace(f.text[f.pos:]); trailing != "" && !strings.HasPrefix(trailing, "#") {
+			return nil, p.errorf("unexpected %q after a flow collection", trailing)
+		}
+		return value, nil
+	case rest[0] == '"' || rest[0] == '\'':
+		s, n, err := p.quoted(rest)
+		if err != nil {
+			return nil, err
+		}
+		if trailing := strings.TrimSpace(rest[n:]); trailing != "" && !strings.HasPrefix(trailing, "#") {
+			return nil, p.errorf("unexpected %q after a quoted string", trailing)
+		}
+		return s, nil
+	case rest[0] == '&' || rest[0] == '*' || rest[0] == '!':
+		return nil, p.errorf("anchors, aliases and tags aren't supported")
+	default:
+		if i := strings.Index(rest, " #"); i >= 0 {
+			rest = strings.TrimSpace(rest[:i])
+		}
+		return p.resolve(rest)
+	}
+}
+
+// blockScalar parses a literal (|) or folded (>) block scalar, whose lines follow, indented more than indent
+func (p *yamlParser) blockScalar(header string, indent int) (interface{}, error) {
+	if i := strings.Index(header, " #"); i >= 0 {
+		header = strings.TrimSpace(header[:i])
+	}
+	chomping := ""
+	switch header[1:] {
+	case "":
+	case "-", "+":
+		chomping = header[1:]
+	default:
+		return nil, p.errorf("unsupported block scalar header %q", header)
+	}
+
+	var lines []string
+	contentIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		text := strings.TrimLeft(line, " ")
+		lineIndent := len(line) - len(text)
+		if strings.TrimSpace(line) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if contentIndent < 0 {
+			contentIndent = lineIndent
+		}
+		if lineIndent <= indent || lineIndent < contentIndent {
+			break
+		}
+		lines = append(lines, line[contentIndent:])
+	}
+
+	// trailing blank lines belong to the chomping, not the content
+	trailing := 0
+	for trailing < len(lines) && lines[len(lines)-1-trailing] == "" {
+		trailing++
+	}
+	content := lines[:len(lines)-trailing]
+
+	var s string
+	if header[0] == '|' {
+		s = strings.Join(content, "\n")
+	} else {
+		for i, line := range content {
+			switch {
+			case i == 0:
+			case line == "" || content[i-1] == "":
+				s += "\n"
+			default:
+				s += " "
+			}
+			s += line
+		}
+	}
+
+	switch {
+	case len(content) == 0:
+		return "", nil
+	case chomping == "-":
+		return s, nil
+	case chomping == "+":
+		return s + strings.Repeat("\n", trailing+1), nil
+	default:
+		return s + "\n", nil
+	}
+}
+
+// quoted parses the single or double quoted string text starts with and returns it and the length it took up
+func (p *yamlParser) quoted(text string) (string, int, error) {
+	quote := text[0]
+	var sb strings.Builder
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			sb.WriteByte('\'')
+			i++
+		case c == quote:
+			return sb.String(), i + 1, nil
+		case c == '\\' && quote == '"':
+			n, err := p.escape(&sb, text[i+1:])
+			if err != nil {
+				return "", 0, err
+			}
+			i += n
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", 0, p.errorf("unterminated quoted string; multi-line strings must be block scalars")
+}
+
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n", 'v': "\v", 'f': "\f", 'r': "\r", 'e': "\x1b",
+	' ': " ", '"': "\"", '/': "/", '\\': "\\", 'N': "\u0085", '_': "\u00a0", 'L': "\u2028", 'P': "\u2029",
+}
+
+// escape writes the character escaped by the start of text, which follows a backslash, and returns its length
+func (p *yamlParser) escape(sb *strings.Builder, text string) (int, error) {
+	if text == "" {
+		return 0, p.errorf("unterminated escape")
+	}
+	if s, ok := yamlEscapes[text[0]]; ok {
+		sb.WriteString(s)
+		return 1, nil
+	}
+
+	digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[text[0]]
+	if digits == 0 || len(text) < 1+digits {
+		return 0, p.errorf("invalid escape \\%c", text[0])
+	}
+	code, err := strconv.ParseUint(text[1:1+digits], 16, 32)
+	if err != nil || !utf8.ValidRune(rune(code)) {
+		return 0, p.errorf("invalid escape \\%s", text[:1+digits])
+	}
+	sb.WriteRune(rune(code))
+	return 1 + digits, nil
+}
+
+// resolve turns a plain scalar into null, a boolean, a number or a string, as the YAML 1.2 core schema does
+func (p *yamlParser) resolve(s string) (interface{}, error) {
+	switch {
+	case s == "" || yamlNull.MatchString(s):
+		return nil, nil
+	case yamlBool.MatchString(s):
+		return strings.ToLower(s) == "true", nil
+	case yamlInf.MatchString(s):
+		return nil, p.errorf("JSON has no %s", s)
+	case yamlInt.MatchString(s):
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.Number(strconv.FormatInt(n, 10)), nil
+		}
+		// JSON numbers can be as big as they like, so one past int64 is kept exactly, without its sign or leading zeros
+		digits := strings.TrimLeft(strings.TrimLeft(s, "+-"), "0")
+		if s[0] == '-' {
+			digits = "-" + digits
+		}
+		return json.Number(digits), nil
+	case yamlOct.MatchString(s), yamlHex.MatchString(s):
+		n, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return nil, p.errorf("integer %s out of range", s)
+		}
+		return json.Number(strconv.FormatInt(n, 10)), nil
+	case yamlFloat.MatchString(s):
+		return p.float(s)
+	default:
+		return s, nil
+	}
+}
+
+func (p *yamlParser) float(s string) (interface{}, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) {
+		return nil, p.errorf("number %s out of range", s)
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+// yamlFlow parses a flow collection, like [a, b] or {a: 1}, which must fit on one line
+type yamlFlow struct {
+	p    *yamlParser
+	text string
+	pos  int
+}
+
+func (f *yamlFlow) skipSpaces() {
+	for f.pos < len(f.text) && f.text[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, f.p.errorf("nests too deeply")
+	}
+	f.skipSpaces()
+	if f.pos >= len(f.text) {
+		return nil, f.p.errorf("unterminated flow collection; flow collections must fit on one line")
+	}
+
+	switch f.text[f.pos] {
+	case '[':
+		f.pos++
+		list := []interface{}{}
+		for {
+			f.skipSpaces()
+			if f.pos < len(f.text) && f.text[f.pos] == ']' {
+				f.pos++
+				return list, nil
+			}
+			item, err := f.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			if err = f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.pos++
+		obj := object{}
+		for {
+			f.skipSpaces()
+			if f.pos < len(f.text) && f.text[f.pos] == '}' {
+				f.pos++
+				return obj, nil
+			}
+			key, err := f.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				name = fmt.Sprint(key)
+			}
+			f.skipSpaces()
+			if f.pos >= len(f.text) || f.text[f.pos] != ':' {
+				return nil, f.p.errorf("expected a colon after the key %q", name)
+			}
+			f.pos++
+			value, err := f.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, member{key: name, value: value})
+			if err = f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		s, n, err := f.p.quoted(f.text[f.pos:])
+		f.pos += n
+		return s, err
+	default:
+		start := f.pos
+		for f.pos < len(f.text) && !strings.ContainsRune(",[]{}", rune(f.text[f.pos])) &&
+			!(f.text[f.pos] == ':' && (f.pos+1 == len(f.text) || f.text[f.pos+1] == ' ')) {
+			f.pos++
+		}
+		return f.p.resolve(strings.TrimSpace(f.text[start:f.pos]))
+	}
+}
+
+// separator consumes the comma after an item, or leaves the closing bracket for the caller
+func (f *yamlFlow) separator(closing byte) error {
+	f.skipSpaces()
+	switch {
+	case f.pos < len(f.text) && f.text[f.pos] == ',':
+		f.pos++
+		return nil
+	case f.pos < len(f.text) && f.text[f.pos] == closing:
+		return nil
+	default:
+		return f.p.errorf("expected , or %c in a flow collection", closing)
+	}
+}
@@ -0,0 +1,216 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergePatch(t *testing.T) {
+	// the examples from RFC 7396 appendix A
+	testData := []struct {
+		doc      string
+		patch    string
+		expected string
+	}{
+		{doc: `{"a":"b"}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+		{doc: `{"a":"b"}`, patch: `{"b":"c"}`, expected: `{"a":"b","b":"c"}`},
+		{doc: `{"a":"b"}`, patch: `{"a":null}`, expected: `{}`},
+		{doc: `{"a":"b","b":"c"}`, patch: `{"a":null}`, expected: `{"b":"c"}`},
+		{doc: `{"a":["b"]}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+		{doc: `{"a":"c"}`, patch: `{"a":["b"]}`, expected: `{"a":["b"]}`},
+		{doc: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, expected: `{"a":{"b":"d"}}`},
+		{doc: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, expected: `{"a":[1]}`},
+		{doc: `["a","b"]`, patch: `["c","d"]`, expected: `["c","d"]`},
+		{doc: `{"a":"b"}`, patch: `["c"]`, expected: `["c"]`},
+		{doc: `{"a":"foo"}`, patch: `null`, expected: `null`},
+		{doc: `{"a":"foo"}`, patch: `"bar"`, expected: `"bar"`},
+		{doc: `{"e":null}`, patch: `{"a":1}`, expected: `{"a":1,"e":null}`},
+		{doc: `[1,2]`, patch: `{"a":"b","c":null}`, expected: `{"a":"b"}`},
+		{doc: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, expected: `{"a":{"bb":{}}}`},
+	}
+
+	for _, td := range testData {
+		t.Run(td.patch, func(t *testing.T) {
+			result, err := MergePatch([]byte(td.doc), []byte(td.patch))
+			if err != nil {
+				t.Fatalf("MergePatch got unexpected error: %+v", err)
+			}
+			assertJSONEqual(t, td.expected, result)
+		})
+	}
+
+	if _, err := MergePatch([]byte(`{}`), []byte(`{`)); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("MergePatch expected error %v; got %v", ErrInvalidPatch, err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	testData := []struct {
+		testName    string
+		doc         string
+		patch       string
+		expected    string
+		expectedErr error
+	}{
+		{
+			testName: "add member",
+			doc:      `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/baz","value":"qux"}]`,
+			expected: `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			testName: "add array element",
+			doc:      `{"foo":["bar","baz"]}`,
+			patch:    `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			expected: `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			testName: "add to end of array",
+			doc:      `{"foo":["bar"]}`,
+			patch:    `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			expected: `{"foo":["bar",["abc","def"]]}`,
+		},
+		{
+			testName: "add null value",
+			doc:      `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/baz","value":null}]`,
+			expected: `{"baz":null,"foo":"bar"}`,
+		},
+		{
+			testName: "remove member",
+			doc:      `{"baz":"qux","foo":"bar"}`,
+			patch:    `[{"op":"remove","path":"/baz"}]`,
+			expected: `{"foo":"bar"}`,
+		},
+		{
+			testName: "remove array element",
+			doc:      `{"foo":["bar","qux","baz"]}`,
+			patch:    `[{"op":"remove","path":"/foo/1"}]`,
+			expected: `{"foo":["bar","baz"]}`,
+		},
+		{
+			testName: "replace",
+			doc:      `{"baz":"qux","foo":"bar"}`,
+			patch:    `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			expected: `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			testName: "move member",
+			doc:      `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch:    `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			expected: `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			testName: "move array element",
+			doc:      `{"foo":["all","grass","cows","eat"]}`,
+			patch:    `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			expected: `{"foo":["all","cows","eat","grass"]}`,
+		},
+		{
+			testName: "copy",
+			doc:      `{"foo":{"bar":[1]}}`,
+			patch:    `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"add","path":"/baz/bar/-","value":2}]`,
+			expected: `{"baz":{"bar":[1,2]},"foo":{"bar":[1]}}`,
+		},
+		{
+			testName: "escaped pointer",
+			doc:      `{"a/b":1,"m~n":2}`,
+			patch:    `[{"op":"test","path":"/a~1b","value":1},{"op":"remove","path":"/m~0n"}]`,
+			expected: `{"a/b":1}`,
+		},
+		{
+			testName: "test passes",
+			doc:      `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch:    `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			expected: `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			testName:    "failure: test fails",
+			doc:         `{"baz":"qux"}`,
+			patch:       `[{"op":"test","path":"/baz","value":"bar"}]`,
+			expectedErr: ErrTestFailed,
+		},
+		{
+			testName:    "failure: remove missing member",
+			doc:         `{"foo":"bar"}`,
+			patch:       `[{"op":"remove","path":"/baz"}]`,
+			expectedErr: ErrInvalidPatch,
+		},
+		{
+			testName:    "failure: add to missing parent",
+			doc:         `{"foo":"bar"}`,
+			patch:       `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			expectedErr: ErrInvalidPatch,
+		},
+		{
+			testName:    "failure: array index out of bounds",
+			doc:         `{"foo":["bar"]}`,
+			patch:       `[{"op":"add","path":"/foo/2","value":"qux"}]`,
+			expectedErr: ErrInvalidPatch,
+		},
+		{
+			testName:    "failure: leading zero index",
+			doc:         `{"foo":["bar","baz"]}`,
+			patch:       `[{"op":"replace","path":"/foo/01","value":"qux"}]`,
+			expectedErr: ErrInvalidPatch,
+		},
+		{
+			testName:    "failure: move into own child",
+			doc:         `{"foo":{"bar":1}}`,
+			patch:       `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`,
+			expectedErr: ErrInvalidPatch,
+		},
+		{
+			testName:    "failure: missing value",
+			doc:         `{"foo":"bar"}`,
+			patch:       `[{"op":"replace","path":"/foo"}]`,
+			expectedErr: ErrInvalidPatch,
+		},
+		{
+			testName:    "failure: unknown op",
+			doc:         `{"foo":"bar"}`,
+			patch:       `[{"op":"increment","path":"/foo"}]`,
+			expectedErr: ErrInvalidPatch,
+		},
+		{
+			testName:    "failure: not an array",
+			doc:         `{"foo":"bar"}`,
+			patch:       `{"op":"remove","path":"/foo"}`,
+			expectedErr: ErrInvalidPatch,
+		},
+	}
+
+	for _, td := range testData {
+		t.Run(td.testName, func(t *testing.T) {
+			result, err := Apply([]byte(td.doc), []byte(td.patch))
+			if td.expectedErr != nil {
+				if !errors.Is(err, td.expectedErr) {
+					t.Fatalf("Apply expected error %v; got %v", td.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply got unexpected error: %+v", err)
+			}
+			assertJSONEqual(t, td.expected, result)
+		})
+	}
+}
+
+func assertJSONEqual(t *testing.T, expected string, actual []byte) {
+	t.Helper()
+
+	var expectedValue, actualValue interface{}
+	if err := json.Unmarshal([]byte(expected), &expectedValue); err != nil {
+		t.Fatalf("invalid expected JSON %s: %v", expected, err)
+	}
+	if err := json.Unmarshal(actual, &actualValue); err != nil {
+		t.Fatalf("invalid actual JSON %s: %v", actual, err)
+	}
+	if diff := cmp.Diff(expectedValue, actualValue); diff != "" {
+		t.Errorf("expected vs actual documents don't match: %v", diff)
+	}
+}
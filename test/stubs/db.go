@@ -3,16 +3,23 @@ package stubs
 import (
 	"context"
 
+	"github.com/us-learn-and-devops/todoapi/internal/domain/query"
 	"github.com/us-learn-and-devops/todoapi/internal/domain/storage"
 )
 
 type DBStub struct {
 	SaveTodoFunc      func(ctx context.Context, name, description string) (storage.Todo, error)
 	GetTodoListFunc   func(ctx context.Context) ([]storage.Todo, error)
+	ListTodosFunc     func(ctx context.Context, opts storage.ListOptions) (storage.TodoPage, error)
+	SearchTodosFunc   func(ctx context.Context, opts storage.SearchOptions) ([]storage.SearchHit, error)
 	GetTodoByNameFunc func(ctx context.Context, name string) (storage.Todo, error)
+	GetTodoByIDFunc   func(ctx context.Context, id string) (storage.Todo, error)
 	EditTodoFunc      func(ctx context.Context, id string, todo storage.Todo) (storage.Todo, error)
 	DeleteTodoFunc    func(ctx context.Context, id string) error
+	DeleteTodosFunc   func(ctx context.Context, filter query.Expr) ([]storage.Todo, error)
 	ClearTodoListFunc func(ctx context.Context) error
+	// AppendEventsFunc, if set, gets the events the stub is asked to store; by default they're accepted and dropped
+	AppendEventsFunc func(ctx context.Context, events ...storage.OutboxEvent) error
 }
 
 func (s DBStub) SaveTodo(ctx context.Context, name, description string) (storage.Todo, error) {
@@ -23,10 +30,22 @@ func (s DBStub) GetTodoList(ctx context.Context) ([]storage.Todo, error) {
 	return s.GetTodoListFunc(ctx)
 }
 
+func (s DBStub) ListTodos(ctx context.Context, opts storage.ListOptions) (storage.TodoPage, error) {
+	return s.ListTodosFunc(ctx, opts)
+}
+
+func (s DBStub) SearchTodos(ctx context.Context, opts storage.SearchOptions) ([]storage.SearchHit, error) {
+	return s.SearchTodosFunc(ctx, opts)
+}
+
 func (s DBStub) GetTodoByName(ctx context.Context, name string) (storage.Todo, error) {
 	return s.GetTodoByNameFunc(ctx, name)
 }
 
+func (s DBStub) GetTodoByID(ctx context.Context, id string) (storage.Todo, error) {
+	return s.GetTodoByIDFunc(ctx, id)
+}
+
 func (s DBStub) EditTodo(ctx context.Context, id string, todo storage.Todo) (storage.Todo, error) {
 	return s.EditTodoFunc(ctx, id, todo)
 }
@@ -35,6 +54,25 @@ func (s DBStub) DeleteTodo(ctx context.Context, id string) error {
 	return s.DeleteTodoFunc(ctx, id)
 }
 
+func (s DBStub) DeleteTodos(ctx context.Context, filter query.Expr) ([]storage.Todo, error) {
+	return s.DeleteTodosFunc(ctx, filter)
+}
+
 func (s DBStub) ClearTodoList(ctx context.Context) error {
 	return s.ClearTodoListFunc(ctx)
 }
+
+func (s DBStub) AppendEvents(ctx context.Context, events ...storage.OutboxEvent) error {
+	if s.AppendEventsFunc == nil {
+		return nil
+	}
+	return s.AppendEventsFunc(ctx, events...)
+}
+
+func (s DBStub) PendingEvents(ctx context.Context, limit int) ([]storage.OutboxEvent, error) {
+	return nil, nil
+}
+
+func (s DBStub) MarkPublished(ctx context.Context, ids ...string) error {
+	return nil
+}